@@ -18,7 +18,8 @@ export interface Detection {
 	path: string;
 	detected_objects?: DetectedObject[];
 	objects?: DetectedObject[];  // New MQTT format
-	image_base64?: string;
+	image_url?: string; // signed, short-lived
+	thumbnail_url?: string;
 	mime_type?: string;
 	camera?: Camera;
 }
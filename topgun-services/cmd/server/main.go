@@ -17,12 +17,12 @@ var (
 )
 
 func init() {
-	// read running flag
+	// read running flag, flags are parsed for subcommands even when ENV is set
+	flagEnv := flag.String("env", "dev", "A config file name without .env")
+	flag.Parse()
 	if len(os.Getenv("ENV")) != 0 {
 		runEnv = os.Getenv("ENV")
 	} else {
-		flagEnv := flag.String("env", "dev", "A config file name without .env")
-		flag.Parse()
 		runEnv = *flagEnv
 	}
 
@@ -38,6 +38,13 @@ func init() {
 // @in header
 // @name Authorization
 func main() {
+	// replay recorded MQTT messages instead of serving
+	if flag.Arg(0) == "replay" {
+		if err := runReplay(flag.Args()[1:]); err != nil {
+			log.Fatalf("error while replaying MQTT messages:\n %+v", err)
+		}
+		return
+	}
 
 	// init server
 	server, err := server.NewServer(version, build, runEnv)
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	server "topgun-services/internal/infrastructure"
+)
+
+// runReplay runs the replay subcommand:
+//
+//	server -env dev replay -file ./log/mqtt/mqtt-2025-01-31.jsonl -speed 10 -target handlers
+func runReplay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	file := flags.String("file", "", "Journal file to replay, the log database journal when empty")
+	from := flags.String("from", "", "Replay messages received from this RFC3339 time")
+	to := flags.String("to", "", "Replay messages received until this RFC3339 time")
+	topic := flags.String("topic", "", "Replay only topics matching this filter")
+	speed := flags.Float64("speed", 1, "Pace multiplier, 1 keeps the original pace and 0 replays without waiting")
+	target := flags.String("target", server.ReplayTargetHandlers, "Send messages to the local handlers or publish them to the broker")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	options := server.ReplayOptions{
+		File:   *file,
+		Topic:  *topic,
+		Speed:  *speed,
+		Target: *target,
+	}
+	var err error
+	if *from != "" {
+		if options.From, err = time.Parse(time.RFC3339, *from); err != nil {
+			return fmt.Errorf("invalid -from: %w", err)
+		}
+	}
+	if *to != "" {
+		if options.To, err = time.Parse(time.RFC3339, *to); err != nil {
+			return fmt.Errorf("invalid -to: %w", err)
+		}
+	}
+	if options.Speed < 0 {
+		return fmt.Errorf("-speed must not be negative")
+	}
+	return server.Replay(version, build, runEnv, options)
+}
@@ -82,6 +82,19 @@ const docTemplate = `{
                 "responses": {}
             }
         },
+        "/api/v1/attack/live": {
+            "get": {
+                "description": "Retrieve the latest state reported by each drone, ordered by drone ID.\nDrones without an update within the stale timeout are marked stale.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Attacks"
+                ],
+                "summary": "Get Live Attacks",
+                "responses": {}
+            }
+        },
         "/api/v1/attack/{id}": {
             "get": {
                 "description": "Retrieve a single attack record by ID.",
@@ -352,7 +365,7 @@ const docTemplate = `{
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Create a new camera",
+                "description": "Create a new camera. The response includes the camera's MQTT broker token, which is not shown again.",
                 "consumes": [
                     "application/json"
                 ],
@@ -472,6 +485,173 @@ const docTemplate = `{
                 "responses": {}
             }
         },
+        "/api/v1/camera/{id}/playback.mjpeg": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Download the archived frames of a camera between two times as an MJPEG file (concatenated JPEGs)",
+                "produces": [
+                    "video/x-motion-jpeg"
+                ],
+                "tags": [
+                    "Camera"
+                ],
+                "summary": "ExportPlayback",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Camera ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Start as RFC 3339 time or Unix timestamp",
+                        "name": "from",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "End as RFC 3339 time or Unix timestamp",
+                        "name": "to",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/api/v1/camera/{id}/snapshot": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get the most recent frame of a camera as a JPEG",
+                "produces": [
+                    "image/jpeg"
+                ],
+                "tags": [
+                    "Camera"
+                ],
+                "summary": "GetSnapshot",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Camera ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "number",
+                        "description": "Maximum frame age in seconds, older frames return 404",
+                        "name": "max_age",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum width in pixels",
+                        "name": "width",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum height in pixels",
+                        "name": "height",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Rendition preset: low, medium, high or original",
+                        "name": "quality",
+                        "in": "query"
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/api/v1/camera/{id}/stream.mjpg": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Stream live frames of a camera as multipart/x-mixed-replace (MJPEG)",
+                "produces": [
+                    "multipart/x-mixed-replace"
+                ],
+                "tags": [
+                    "Camera"
+                ],
+                "summary": "GetMJPEGStream",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Camera ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "number",
+                        "description": "Maximum frames per second (0 = unlimited)",
+                        "name": "fps",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Maximum resolution as WIDTHxHEIGHT or WIDTH, e.g. 640x360",
+                        "name": "resolution",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Rendition preset: low, medium, high or original",
+                        "name": "quality",
+                        "in": "query"
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/api/v1/camera/{id}/token": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Issue a new MQTT broker token for a camera and return it, the previous token stops working",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Camera"
+                ],
+                "summary": "RotateCameraToken",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Camera ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {}
+            }
+        },
         "/api/v1/detect/": {
             "get": {
                 "security": [
@@ -514,6 +694,18 @@ const docTemplate = `{
                         "description": "Search column",
                         "name": "column",
                         "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Start date (YYYY-MM-DD)",
+                        "name": "start_date",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "End date (YYYY-MM-DD)",
+                        "name": "end_date",
+                        "in": "query"
                     }
                 ],
                 "responses": {}
@@ -560,6 +752,38 @@ const docTemplate = `{
                 "responses": {}
             }
         },
+        "/api/v1/detect/attack-events": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Stream attack data as Server-Sent Events, with the same payload as the attack WebSocket.\nReconnecting with the Last-Event-ID header (or last_event_id query) replays missed events.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "Detect"
+                ],
+                "summary": "HandleAttackEvents",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only send attacks from this drone",
+                        "name": "drone_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Resume after this event ID",
+                        "name": "last_event_id",
+                        "in": "query"
+                    }
+                ],
+                "responses": {}
+            }
+        },
         "/api/v1/detect/by-cameras": {
             "post": {
                 "security": [
@@ -606,6 +830,44 @@ const docTemplate = `{
                 "responses": {}
             }
         },
+        "/api/v1/detect/events": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Stream detections as Server-Sent Events, with the same payload as the detection WebSocket.\nReconnecting with the Last-Event-ID header (or last_event_id query) replays missed events.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "Detect"
+                ],
+                "summary": "HandleDetectionEvents",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only send detections from this camera",
+                        "name": "camera_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "url (default) for signed image URLs or inline for base64 images",
+                        "name": "image_mode",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Resume after this event ID",
+                        "name": "last_event_id",
+                        "in": "query"
+                    }
+                ],
+                "responses": {}
+            }
+        },
         "/api/v1/detect/{id}": {
             "get": {
                 "security": [
@@ -701,6 +963,33 @@ const docTemplate = `{
                 "responses": {}
             }
         },
+        "/api/v1/detect/{id}/clip": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Download the motion clip (zip of JPEG frames with metadata.json) recorded around a detect",
+                "produces": [
+                    "application/zip"
+                ],
+                "tags": [
+                    "Detect"
+                ],
+                "summary": "GetDetectClip",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Detect ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {}
+            }
+        },
         "/api/v1/detect/{id}/file": {
             "get": {
                 "security": [
@@ -728,37 +1017,419 @@ const docTemplate = `{
                 "responses": {}
             }
         },
-        "/api/v1/mqtt/publish": {
-            "post": {
-                "description": "Publish a message to the configured MQTT topic (topgun/ai)",
-                "consumes": [
-                    "application/json"
-                ],
+        "/api/v1/detect/{id}/image": {
+            "get": {
+                "description": "Get a detect image through the signed, short-lived URL sent in detection broadcasts",
                 "produces": [
-                    "application/json"
+                    "image/jpeg"
                 ],
                 "tags": [
-                    "MQTT"
+                    "Detect"
                 ],
-                "summary": "Publish message to MQTT",
+                "summary": "GetDetectImage",
                 "parameters": [
                     {
-                        "description": "Message to publish",
-                        "name": "message",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/mqtt.PublishRequest"
-                        }
-                    }
-                ],
-                "responses": {
-                    "200": {
-                        "description": "OK",
-                        "schema": {
-                            "type": "object",
-                            "additionalProperties": true
-                        }
+                        "type": "string",
+                        "description": "Detect ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "original or thumbnail",
+                        "name": "variant",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Expiry as a Unix timestamp",
+                        "name": "expires",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "URL signature",
+                        "name": "sig",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/api/v1/devices/": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "List edge devices with their online status and latest telemetry",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Device"
+                ],
+                "summary": "GetDevices",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Page number",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Items per page",
+                        "name": "per_page",
+                        "in": "query"
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/api/v1/devices/ws": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "WebSocket stream of device status and telemetry. A snapshot of the devices is sent first,\nthen status events when a device comes online or goes offline and telemetry events for each heartbeat.",
+                "tags": [
+                    "Device"
+                ],
+                "summary": "DeviceEvents",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only events of this device",
+                        "name": "device_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "JWT access token, when the Authorization header cannot be set",
+                        "name": "access_token",
+                        "in": "query"
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/api/v1/devices/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get an edge device with its attached cameras, online status and latest telemetry",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Device"
+                ],
+                "summary": "GetDevice",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {}
+            },
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Rename an edge device, everything else is reported by its heartbeats",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Device"
+                ],
+                "summary": "UpdateDevice",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Device name",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "name": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    }
+                ],
+                "responses": {}
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Remove an edge device and its telemetry history, it registers again with its next heartbeat",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Device"
+                ],
+                "summary": "DeleteDevice",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/api/v1/devices/{id}/telemetry": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get the telemetry history of an edge device, newest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Device"
+                ],
+                "summary": "GetDeviceTelemetry",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Start time (RFC3339)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "End time (RFC3339)",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page number",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Items per page",
+                        "name": "per_page",
+                        "in": "query"
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/api/v1/mqtt/dead-letters": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "List MQTT detection messages that could not be stored, newest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "MQTT"
+                ],
+                "summary": "GetDeadLetters",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Page number",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Items per page",
+                        "name": "per_page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "pending, reprocessing or reprocessed",
+                        "name": "status",
+                        "in": "query"
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/api/v1/mqtt/dead-letters/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get a rejected MQTT detection message with its raw payload and reason",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "MQTT"
+                ],
+                "summary": "GetDeadLetter",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Dead letter ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {}
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Discard a rejected MQTT detection message",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "MQTT"
+                ],
+                "summary": "DeleteDeadLetter",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Dead letter ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/api/v1/mqtt/dead-letters/{id}/reprocess": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Retry a rejected MQTT detection message. An optional body replaces the stored payload, e.g. to fix a bad field.\nLive frames are not attached to retried messages. A dead letter that is reprocessed or being retried answers 409.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "MQTT"
+                ],
+                "summary": "ReprocessDeadLetter",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Dead letter ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Corrected message",
+                        "name": "request",
+                        "in": "body",
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "payload": {
+                                    "type": "object"
+                                }
+                            }
+                        }
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/api/v1/mqtt/publish": {
+            "post": {
+                "description": "Publish a message to the configured MQTT topic (topgun/ai)",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "MQTT"
+                ],
+                "summary": "Publish message to MQTT",
+                "parameters": [
+                    {
+                        "description": "Message to publish",
+                        "name": "message",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/mqtt.PublishRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
                     },
                     "400": {
                         "description": "Bad Request",
@@ -829,7 +1500,7 @@ const docTemplate = `{
         },
         "/api/v1/mqtt/send-file": {
             "post": {
-                "description": "Send a file from the server filesystem through MQTT topic",
+                "description": "Send a file from the server filesystem through MQTT topic.\nFiles above the one-shot limit are sent as a chunked transfer, answered with 202 and the transfer progress.",
                 "consumes": [
                     "application/json"
                 ],
@@ -859,6 +1530,13 @@ const docTemplate = `{
                             "additionalProperties": true
                         }
                     },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
@@ -878,7 +1556,7 @@ const docTemplate = `{
         },
         "/api/v1/mqtt/status": {
             "get": {
-                "description": "Check if the MQTT client is connected to the broker",
+                "description": "Check if the MQTT client is connected to the broker and list every topic subscription with its state",
                 "produces": [
                     "application/json"
                 ],
@@ -897,9 +1575,66 @@ const docTemplate = `{
                 }
             }
         },
+        "/api/v1/mqtt/transfers": {
+            "get": {
+                "description": "List the progress of recent chunked file transfers, newest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "MQTT"
+                ],
+                "summary": "List chunked file transfers",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/mqtt/transfers/{id}": {
+            "get": {
+                "description": "Get the chunks sent and resent and the progress acknowledged by each receiver of a transfer",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "MQTT"
+                ],
+                "summary": "Get chunked file transfer progress",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Transfer ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/mqtt.TransferProgress"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
         "/api/v1/mqtt/upload-file": {
             "post": {
-                "description": "Upload a file (e.g., .pt model file) and send it through MQTT topic",
+                "description": "Upload a file (e.g., .pt model file) and send it through MQTT topic.\nFiles above the one-shot limit are sent as a chunked transfer, answered with 202 and the transfer progress.",
                 "consumes": [
                     "multipart/form-data"
                 ],
@@ -920,9 +1655,27 @@ const docTemplate = `{
                     },
                     {
                         "type": "boolean",
-                        "description": "Encode file as base64 (default: true)",
+                        "description": "Encode file as base64 (default: true), one-shot mode only",
                         "name": "encode_base64",
                         "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "auto (default), one_shot or chunked",
+                        "name": "mode",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated receiver IDs expected to confirm a chunked transfer",
+                        "name": "receivers",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Bytes per chunk of a chunked transfer",
+                        "name": "chunk_size",
+                        "in": "formData"
                     }
                 ],
                 "responses": {
@@ -933,6 +1686,13 @@ const docTemplate = `{
                             "additionalProperties": true
                         }
                     },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
@@ -950,6 +1710,83 @@ const docTemplate = `{
                 }
             }
         },
+        "/api/v1/realtime/connections": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "List WebSocket and MJPEG clients connected to the detection, video and attack hubs of this instance",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Realtime"
+                ],
+                "summary": "GetConnections",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Page number",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Items per page",
+                        "name": "per_page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "detection, video or attack",
+                        "name": "hub",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only clients subscribed to this camera",
+                        "name": "camera_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only clients of this user",
+                        "name": "user_id",
+                        "in": "query"
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/api/v1/realtime/connections/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Forcibly disconnect a realtime client",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Realtime"
+                ],
+                "summary": "DeleteConnection",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Connection ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {}
+            }
+        },
         "/api/v1/users/": {
             "get": {
                 "security": [
@@ -1145,9 +1982,137 @@ const docTemplate = `{
                 ],
                 "responses": {}
             }
+        },
+        "/api/v1/video/source-events": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Stream video source status changes as Server-Sent Events.\nReconnecting with the Last-Event-ID header (or last_event_id query) replays missed events.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "Video"
+                ],
+                "summary": "HandleSourceEvents",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only send status changes of this camera",
+                        "name": "camera_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Resume after this event ID",
+                        "name": "last_event_id",
+                        "in": "query"
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/api/v1/video/sources": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "List video sources with their liveness status (online, degraded or offline), last frame time, measured fps, resolution and model",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Video"
+                ],
+                "summary": "GetVideoSources",
+                "responses": {}
+            }
+        },
+        "/api/v1/video/viewers": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "List connected video viewers with delivered and dropped frame counts",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Video"
+                ],
+                "summary": "GetVideoViewers",
+                "responses": {}
+            }
+        },
+        "/api/v1/video/webrtc": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Answer a WebRTC offer for a camera's live video. The offer must receive video with H.264\n(Constrained Baseline, packetization-mode 1), the camera is published as one video track playable\nin a \u003cvideo\u003e element. On failure, or if the connection does not come up, use fallback_url, the\nequivalent /ws/video-stream subscription. Builds without the openh264 encoder answer 501.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Video"
+                ],
+                "summary": "CreateWebRTCSession",
+                "parameters": [
+                    {
+                        "description": "SDP offer and viewer options",
+                        "name": "offer",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/detect.WebRTCOffer"
+                        }
+                    }
+                ],
+                "responses": {}
+            }
         }
     },
     "definitions": {
+        "detect.WebRTCOffer": {
+            "type": "object",
+            "properties": {
+                "camera_id": {
+                    "type": "string"
+                },
+                "fps": {
+                    "type": "number"
+                },
+                "height": {
+                    "type": "string"
+                },
+                "quality": {
+                    "description": "low, medium, high or original",
+                    "type": "string"
+                },
+                "sdp": {
+                    "type": "string"
+                },
+                "type": {
+                    "description": "always offer",
+                    "type": "string"
+                },
+                "width": {
+                    "type": "string"
+                }
+            }
+        },
         "models.Attack": {
             "type": "object",
             "properties": {
@@ -1203,9 +2168,6 @@ const docTemplate = `{
                 },
                 "name": {
                     "type": "string"
-                },
-                "token": {
-                    "type": "string"
                 }
             }
         },
@@ -1218,6 +2180,17 @@ const docTemplate = `{
                 "camera_id": {
                     "type": "string"
                 },
+                "clip_path": {
+                    "type": "string"
+                },
+                "frame_missing": {
+                    "description": "No frame was captured within the match tolerance of the detection time",
+                    "type": "boolean"
+                },
+                "frame_offset_ms": {
+                    "description": "Capture time of the saved frame minus the detection time, nil when no frame was matched",
+                    "type": "integer"
+                },
                 "id": {
                     "type": "integer"
                 },
@@ -1324,6 +2297,11 @@ const docTemplate = `{
         "mqtt.FilePathRequest": {
             "type": "object",
             "properties": {
+                "chunk_size": {
+                    "description": "bytes per chunk of a chunked transfer",
+                    "type": "integer",
+                    "example": 65536
+                },
                 "encode_base64": {
                     "type": "boolean",
                     "example": true
@@ -1331,6 +2309,21 @@ const docTemplate = `{
                 "file_path": {
                     "type": "string",
                     "example": "./models/best.pt"
+                },
+                "mode": {
+                    "description": "auto, one_shot or chunked",
+                    "type": "string",
+                    "example": "auto"
+                },
+                "receivers": {
+                    "description": "expected to confirm a chunked transfer",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "pi-north"
+                    ]
                 }
             }
         },
@@ -1342,6 +2335,104 @@ const docTemplate = `{
                     "example": "Hello from Go server"
                 }
             }
+        },
+        "mqtt.ReceiverProgress": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "missing": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "received": {
+                    "type": "integer"
+                },
+                "retransmissions": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "updated_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "mqtt.TransferManifest": {
+            "type": "object",
+            "properties": {
+                "ack_topic": {
+                    "description": "with {receiver_id} in place of the receiver ID",
+                    "type": "string"
+                },
+                "chunk_count": {
+                    "type": "integer"
+                },
+                "chunk_size": {
+                    "type": "integer"
+                },
+                "chunk_topic": {
+                    "description": "with {index} in place of the chunk index",
+                    "type": "string"
+                },
+                "ext": {
+                    "type": "string"
+                },
+                "filename": {
+                    "type": "string"
+                },
+                "sha256": {
+                    "type": "string"
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "transfer_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "mqtt.TransferProgress": {
+            "type": "object",
+            "properties": {
+                "chunks_resent": {
+                    "type": "integer"
+                },
+                "chunks_sent": {
+                    "type": "integer"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "finished_at": {
+                    "type": "string"
+                },
+                "manifest": {
+                    "$ref": "#/definitions/mqtt.TransferManifest"
+                },
+                "manifests_sent": {
+                    "type": "integer"
+                },
+                "receivers": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/mqtt.ReceiverProgress"
+                    }
+                },
+                "started_at": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
         }
     },
     "securityDefinitions": {
@@ -60,10 +60,14 @@ func ExtractLevel(aud []string) (level int, err error) {
 }
 
 // ReqAuthHandler check session
+// WebSocket upgrades may pass the token as the access_token query since browsers can't set headers on them.
 func (r *RouterResources) ReqAuthHandler(scopes ...string) fiber.Handler {
 	// Return new handler
 	return func(c *fiber.Ctx) (err error) {
 		tokenStr, err := ExtractBearerToken(c.Get(fiber.HeaderAuthorization))
+		if err != nil && strings.EqualFold(c.Get(fiber.HeaderUpgrade), "websocket") && c.Query("access_token") != "" {
+			tokenStr, err = c.Query("access_token"), nil
+		}
 		if err != nil {
 			return helpers.NewError(http.StatusUnauthorized, helpers.WhereAmI(), err.Error())
 		}
@@ -87,3 +91,26 @@ func (r *RouterResources) ReqAuthHandler(scopes ...string) fiber.Handler {
 		// return helpers.NewError(http.StatusUnauthorized, helpers.WhereAmI(), "Unauthorized")
 	}
 }
+
+// OptAuthHandler identifies the user when a valid token is present but never rejects the request.
+// Realtime connections may pass the token as the access_token query since browsers can't set headers on WebSockets.
+func (r *RouterResources) OptAuthHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tokenStr, err := ExtractBearerToken(c.Get(fiber.HeaderAuthorization))
+		if err != nil {
+			tokenStr = c.Query("access_token")
+		}
+		if tokenStr == "" || r.JwtKeyfunc == nil {
+			return c.Next()
+		}
+
+		claims := new(jwt.RegisteredClaims)
+		jwtToken, err := jwt.ParseWithClaims(tokenStr, claims, r.JwtKeyfunc)
+		if err == nil && jwtToken.Valid {
+			c.Locals("claims", claims)
+			c.Locals("token", jwtToken)
+			c.Locals("user_id", claims.Subject)
+		}
+		return c.Next()
+	}
+}
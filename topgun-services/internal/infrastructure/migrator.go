@@ -1,6 +1,9 @@
 package infrastructure
 
-import "topgun-services/pkg/models"
+import (
+	"topgun-services/pkg/models"
+	"topgun-services/pkg/mqtt"
+)
 
 func (s *Server) AutoMigrate() (err error) {
 	if err = s.MainDbConn.AutoMigrate(
@@ -8,11 +11,15 @@ func (s *Server) AutoMigrate() (err error) {
 		models.Camera{},
 		models.Detect{},
 		models.Attack{},
+		models.DeadLetter{},
+		models.Device{},
+		models.DeviceTelemetry{},
 	); err != nil {
 		return
 	}
 	if err = s.LogDbConn.AutoMigrate(
 		models.Log{},
+		mqtt.JournalRecord{},
 	); err != nil {
 		return
 	}
@@ -0,0 +1,145 @@
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"topgun-services/internal/datasources"
+	"topgun-services/pkg/attack"
+	"topgun-services/pkg/camera"
+	"topgun-services/pkg/detect"
+	"topgun-services/pkg/device"
+	"topgun-services/pkg/mqtt"
+
+	"github.com/spf13/viper"
+)
+
+// Where a replayed journal is sent
+const (
+	ReplayTargetHandlers = "handlers" // the handlers of this service, without a broker
+	ReplayTargetBroker   = "broker"   // published to the configured broker
+)
+
+// ReplayOptions selects the journal entries to replay and where they go
+type ReplayOptions struct {
+	File   string // journal file, the log database journal when empty
+	From   time.Time
+	To     time.Time
+	Topic  string  // topic filter, every topic when empty
+	Speed  float64 // 1 keeps the original pace, 0 replays without waiting
+	Target string
+}
+
+// Replay sends recorded MQTT messages to the local handlers or the broker
+func Replay(version, buildTag, runEnv string, options ReplayOptions) error {
+	if options.Target != ReplayTargetHandlers && options.Target != ReplayTargetBroker {
+		return fmt.Errorf("unknown replay target %q, expected %s or %s", options.Target, ReplayTargetHandlers, ReplayTargetBroker)
+	}
+	entries, err := loadJournal(options)
+	if err != nil {
+		return fmt.Errorf("failed to read the MQTT journal: %w", err)
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("no journal entries to replay")
+	}
+	log.Printf("Replaying %d MQTT messages from %s to %s at speed %v",
+		len(entries), entries[0].At.Format(time.RFC3339), entries[len(entries)-1].At.Format(time.RFC3339), options.Speed)
+
+	// Replayed messages are not journaled again
+	viper.Set("mqtt.journal.enabled", false)
+
+	var deliver func(mqtt.JournalEntry) error
+	switch options.Target {
+	case ReplayTargetHandlers:
+		viper.Set("mqtt.enabled", false)
+		server, err := newReplayServer(version, buildTag, runEnv)
+		if err != nil {
+			return err
+		}
+		deliver = func(entry mqtt.JournalEntry) error {
+			if server.MQTT.Deliver(entry) == 0 {
+				log.Printf("No handler for MQTT topic %s, message skipped", entry.Topic)
+			}
+			return nil
+		}
+	default:
+		manager, err := connectToMQTT()
+		if err != nil {
+			return err
+		}
+		defer manager.Disconnect()
+		deliver = manager.Publish
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	replayed, err := mqtt.Replay(ctx, entries, options.Speed, deliver)
+	log.Printf("Replayed %d of %d MQTT messages", replayed, len(entries))
+	return err
+}
+
+// newReplayServer connects the main database and an offline MQTT manager, then registers the
+// MQTT handlers. Routes, background workers and migrations of a full server are left out.
+func newReplayServer(version, buildTag, runEnv string) (*Server, error) {
+	detect.SetConfig(detect.ConfigFromViper())
+	mainDbConn, err := connectMainDb()
+	if err != nil {
+		return nil, err
+	}
+	mqttManager, err := connectToMQTT()
+	if err != nil {
+		return nil, err
+	}
+	server := &Server{
+		Resources: NewResources(nil, mainDbConn, nil, nil, nil, mqttManager),
+		Version:   version,
+		Build:     buildTag,
+		RunEnv:    runEnv,
+	}
+
+	detectService := detect.NewDetectService(detect.NewDetectRepository(mainDbConn))
+	cameraService := camera.NewCameraService(camera.NewCameraRepository(mainDbConn))
+	attackService := attack.NewAttackService(attack.NewAttackRepository(mainDbConn))
+	deviceService := device.NewDeviceService(device.NewDeviceRepository(mainDbConn))
+	mqttDetectHandler := newMQTTDetectHandler(detectService, cameraService, detect.NewDeadLetterRepository(mainDbConn))
+	server.registerMQTTHandlers(mqttDetectHandler, attackService, deviceService)
+	return server, nil
+}
+
+// loadJournal reads the journal file, or the log database journal when no file is given
+func loadJournal(options ReplayOptions) ([]mqtt.JournalEntry, error) {
+	if options.File == "" {
+		logDbConn, err := datasources.ConnectDb(datasources.DbConfig{
+			DbDriver: "sqlite",
+			DbName:   viper.GetString("db.sqlite.db_name"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return mqtt.ReadJournalDB(logDbConn, options.From, options.To, options.Topic)
+	}
+
+	entries, err := mqtt.ReadJournalFile(options.File)
+	if err != nil {
+		return nil, err
+	}
+	selected := entries[:0]
+	for _, entry := range entries {
+		if !options.From.IsZero() && entry.At.Before(options.From) {
+			continue
+		}
+		if !options.To.IsZero() && entry.At.After(options.To) {
+			continue
+		}
+		if options.Topic != "" && !mqtt.TopicMatches(options.Topic, entry.Topic) {
+			continue
+		}
+		selected = append(selected, entry)
+	}
+	return selected, nil
+}
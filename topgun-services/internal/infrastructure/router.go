@@ -10,6 +10,8 @@ import (
 	"topgun-services/pkg/auth"
 	"topgun-services/pkg/camera"
 	"topgun-services/pkg/detect"
+	"topgun-services/pkg/device"
+	"topgun-services/pkg/domain"
 	"topgun-services/pkg/logs"
 	"topgun-services/pkg/models"
 	"topgun-services/pkg/mqtt"
@@ -18,7 +20,6 @@ import (
 	swagger "github.com/arsmn/fiber-swagger/v2"
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
-	"github.com/google/uuid"
 	"github.com/spf13/viper"
 )
 
@@ -46,6 +47,8 @@ func (s *Server) SetupRoutes(app *fiber.App) {
 	cameraRepository := camera.NewCameraRepository(s.MainDbConn)
 	detectRepository := detect.NewDetectRepository(s.MainDbConn)
 	attackRepository := attack.NewAttackRepository(s.MainDbConn)
+	deadLetterRepository := detect.NewDeadLetterRepository(s.MainDbConn)
+	deviceRepository := device.NewDeviceRepository(s.MainDbConn)
 
 	// auto migrate DB only on main process
 	if !fiber.IsChild() {
@@ -60,58 +63,37 @@ func (s *Server) SetupRoutes(app *fiber.App) {
 	cameraService := camera.NewCameraService(cameraRepository)
 	detectService := detect.NewDetectService(detectRepository)
 	attackService := attack.NewAttackService(attackRepository)
+	deviceService := device.NewDeviceService(deviceRepository)
+	go device.StartMonitor(deviceService, stop)
+
+	mqttDetectHandler := newMQTTDetectHandler(detectService, cameraService, deadLetterRepository)
 
 	// MQTT Service for sending commands to Raspberry PI
 	var mqttService *mqtt.Service
-	if s.MQTTClient != nil && s.MQTTClient.IsConnected() {
+	if s.MQTT != nil {
 		// Use separate topic for commands (topgun/command)
 		mqttCommandTopic := viper.GetString("mqtt.command_topic")
 		if mqttCommandTopic == "" {
 			mqttCommandTopic = "topgun/command"
 		}
-		mqttService = mqtt.NewService(s.MQTTClient, mqttCommandTopic)
+		mqttService = mqtt.NewService(s.MQTT.Client(), mqttCommandTopic)
 		// No need to subscribe to command topic (we only publish)
 		log.Printf("MQTT command service initialized on topic: %s", mqttCommandTopic)
 
-		// Start MQTT detection subscription for RaspberryPI data
-		// Get default camera ID from config or create one
-		detectMQTTTopic := viper.GetString("mqtt.detect_topic")
-		if detectMQTTTopic == "" {
-			detectMQTTTopic = "topgun/ai"
-		}
-		mqttBroker := viper.GetString("mqtt.broker")
-		if mqttBroker == "" {
-			mqttBroker = "tcp://localhost:1883"
-		}
-
-		// Get or create default camera for MQTT detections
-		defaultCameraID := viper.GetString("mqtt.camera_id")
-		var cameraUUID uuid.UUID
-		if defaultCameraID != "" {
-			if parsed, err := uuid.Parse(defaultCameraID); err == nil {
-				cameraUUID = parsed
-			}
-		}
-		// If no camera ID in config, use a fixed UUID for RaspberryPI camera
-		if cameraUUID == uuid.Nil {
-			// Fixed UUID for RaspberryPI MQTT camera
-			cameraUUID = uuid.MustParse("3a939700-7724-4dc8-a5d8-47130aa68213")
-		}
-
-		// Start MQTT subscription in background
-		go func() {
-			if err := detect.StartMQTTSubscription(mqttBroker, detectMQTTTopic, cameraUUID, detectService); err != nil {
-				fmt.Printf("Warning: failed to start MQTT detection subscription: %v\n", err)
-			}
-		}()
+		s.registerMQTTHandlers(mqttDetectHandler, attackService, deviceService)
 	}
 
 	// App Routes
 	auth.NewAuthHandler(groupApiV1.Group("/auth"), routerResource, authService, userService)
 	user.NewUserHandler(groupApiV1.Group("/users"), routerResource, userService, authService)
 	camera.NewCameraHandler(groupApiV1.Group("/camera"), routerResource, cameraService)
-	detect.NewDetectHandler(groupApiV1.Group("/detect"), detectService)
+	// Realtime routes identify the user when a token is sent, but stay open to anonymous viewers
+	detect.NewCameraStreamHandler(groupApiV1.Group("/camera", routerResource.OptAuthHandler()))
+	detect.NewVideoHandler(groupApiV1.Group("/video"))
+	detect.NewDetectHandler(groupApiV1.Group("/detect", routerResource.OptAuthHandler()), detectService)
+	detect.NewConnectionHandler(groupApiV1.Group("/realtime"), routerResource)
 	attack.NewAttackHandler(groupApiV1.Group("/attack"), attackService)
+	device.NewDeviceHandler(groupApiV1.Group("/devices"), routerResource, deviceService)
 
 	// WebSocket routes for video streaming
 	videoHandler := detect.NewDetectHandlerForWebSocket()
@@ -120,13 +102,19 @@ func (s *Server) SetupRoutes(app *fiber.App) {
 			return c.Next()
 		}
 		return fiber.ErrUpgradeRequired
-	})
+	}, routerResource.OptAuthHandler())
 	app.Get("/ws/video-input", videoHandler.HandleVideoInput())   // Python sends video here
 	app.Get("/ws/video-stream", videoHandler.HandleVideoStream()) // Clients view video here
 
 	// MQTT Routes
+	detect.NewDeadLetterHandler(groupApiV1.Group("/mqtt/dead-letters"), routerResource, mqttDetectHandler, deadLetterRepository)
 	if mqttService != nil {
-		mqttHandler := mqtt.NewHandler(mqttService)
+		// Chunked transfers of model files, acknowledged by each receiver
+		transfers, err := mqtt.NewTransfers(s.MQTT, mqttTransferConfig())
+		if err != nil {
+			log.Printf("Warning: chunked MQTT transfers disabled: %v", err)
+		}
+		mqttHandler := mqtt.NewHandler(mqttService, s.MQTT, transfers)
 		mqtt.SetupRoutes(groupApiV1.Group("/mqtt"), mqttHandler)
 	}
 
@@ -142,3 +130,30 @@ func (s *Server) SetupRoutes(app *fiber.App) {
 	// Prepare a fallback route to always serve the 'index.html', had there not be any matching routes.
 	app.Static("*", "./web/build/index.html")
 }
+
+// newMQTTDetectHandler builds the detection ingestion handler, each Raspberry PI publishes to its own topgun/ai/<camera_id> topic
+func newMQTTDetectHandler(detectService domain.DetectService, cameraService domain.CameraService, deadLetterRepository domain.DeadLetterRepository) *detect.MQTTDetectHandler {
+	detectMQTTTopic := viper.GetString("mqtt.detect_topic")
+	if detectMQTTTopic == "" {
+		detectMQTTTopic = "topgun/ai/+"
+	}
+	return detect.NewMQTTDetectHandler(detectService, detect.NewMQTTCameraResolver(detectMQTTTopic, cameraService), deadLetterRepository)
+}
+
+// registerMQTTHandlers subscribes the handlers of device messages, shared by the server and the journal replay
+func (s *Server) registerMQTTHandlers(mqttDetectHandler *detect.MQTTDetectHandler, attackService domain.AttackService, deviceService domain.DeviceService) {
+	// Register the MQTT detection subscription for RaspberryPI data
+	if err := detect.StartMQTTSubscription(s.MQTT, mqttDetectHandler); err != nil {
+		log.Printf("Warning: failed to start MQTT detection subscription: %v", err)
+	}
+
+	// Register the drone attack subscription, each drone publishes to its own topgun/attack/<drone_id> topic
+	if err := attack.StartMQTTSubscription(s.MQTT, attack.NewMQTTAttackHandler(attackService, attack.AttackTopic())); err != nil {
+		log.Printf("Warning: failed to start MQTT attack subscription: %v", err)
+	}
+
+	// Register the device heartbeat subscription, each device publishes to topgun/devices/<hardware_id>/heartbeat
+	if err := device.StartMQTTSubscription(s.MQTT, device.NewMQTTHeartbeatHandler(deviceService, device.HeartbeatTopic())); err != nil {
+		log.Printf("Warning: failed to start MQTT device heartbeat subscription: %v", err)
+	}
+}
@@ -2,18 +2,24 @@ package infrastructure
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
 	"topgun-services/internal/datasources"
+	"topgun-services/pkg/camera"
+	"topgun-services/pkg/detect"
 	"topgun-services/pkg/models"
+	"topgun-services/pkg/mqtt"
 	"topgun-services/pkg/utils"
 
-	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/goccy/go-json"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -22,6 +28,7 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/session"
 	"github.com/gofiber/storage/redis"
 	"github.com/google/uuid"
+	goredis "github.com/redis/go-redis/v9"
 	"github.com/spf13/viper"
 	"gorm.io/gorm"
 )
@@ -32,6 +39,12 @@ type Server struct {
 	Build   string
 	RunEnv  string
 	PrdMode bool
+	// Relays realtime events between instances, nil unless realtime.backplane.enabled is set
+	Backplane *detect.Backplane
+	// Redis connection of the backplane, sessions and auth do not use it
+	RealtimeRedis *redis.Storage
+	// In-process MQTT broker, nil unless mqtt.embedded.enabled is set
+	Broker *mqtt.Broker
 }
 
 func NewServer(version, buildTag, runEnv string) (server *Server, err error) {
@@ -44,16 +57,11 @@ func NewServer(version, buildTag, runEnv string) (server *Server, err error) {
 		RunEnv:  runEnv,
 	}
 
+	// The realtime hubs read their settings from this snapshot, not from viper
+	detect.SetConfig(detect.ConfigFromViper())
+
 	// connect to DB
-	mainDbConn, err := datasources.ConnectDb(datasources.DbConfig{
-		DbDriver: "postgres",
-		DbName:   viper.GetString("db.postgres.db_name"),
-		Host:     viper.GetString("db.postgres.host"),
-		Username: viper.GetString("db.postgres.username"),
-		Password: viper.GetString("db.postgres.password"),
-		Port:     viper.GetInt("db.postgres.port"),
-		Timezone: "Asia/Bangkok",
-	})
+	mainDbConn, err := connectMainDb()
 	if err != nil {
 		return
 	}
@@ -97,22 +105,62 @@ func NewServer(version, buildTag, runEnv string) (server *Server, err error) {
 	if err != nil {
 		return
 	}
-	// redis, err := connectToRedis()
-	// if err != nil {
-	// 	log.Printf("failed to connect to Redis: %v", err)
-	// }
+	backplane := viper.GetBool("realtime.backplane.enabled") && viper.GetString("db.redis.host") != ""
+	// Behind the backplane an image URL signed by one instance is verified by another, and with prefork
+	// by another child process, a per-process random key would reject it
+	if (backplane || server.PrdMode) && viper.GetString("realtime.image_url.secret") == "" {
+		err = fmt.Errorf("realtime.image_url.secret must be set when realtime.backplane.enabled or prefork is on")
+		return
+	}
+	// Redis is only connected for the backplane, sessions and auth keep their in-memory stores
+	if backplane {
+		server.RealtimeRedis, err = connectToRedis()
+		if err != nil {
+			log.Printf("Warning: %v", err)
+			// Don't return error, allow server to start without Redis
+			server.RealtimeRedis, err = nil, nil
+		}
+	}
+
+	// Run the MQTT broker in process, the service connects to it like any other broker
+	if viper.GetBool("mqtt.embedded.enabled") && !fiber.IsChild() {
+		server.Broker, err = startEmbeddedBroker(mainDbConn)
+		if err != nil {
+			return
+		}
+	}
 
 	// Connect to MQTT
-	mqttClient, err := connectToMQTT()
+	mqttManager, err := connectToMQTT()
+	if errors.Is(err, errInvalidMQTTConfig) {
+		// Unreadable TLS files or a certificate without its key fail on every retry as well
+		return
+	}
 	if err != nil {
-		log.Printf("Warning: failed to connect to MQTT: %v", err)
-		// Don't return error, allow server to start without MQTT
+		log.Printf("Warning: %v", err)
+		// Don't return error, allow server to start without MQTT, handlers subscribe once it connects
+		err = nil
+	}
+
+	// Record raw MQTT messages for debugging and replay
+	if journal := newMQTTJournal(logDbConn); journal != nil && mqttManager != nil {
+		mqttManager.SetJournal(journal)
 	}
 
 	// init app resources
-	server.Resources = NewResources(fastHTTPClient, mainDbConn, logDbConn, nil, jwtResources, mqttClient)
+	server.Resources = NewResources(fastHTTPClient, mainDbConn, logDbConn, nil, jwtResources, mqttManager)
 
 	// something that use resources place here
+	if server.RealtimeRedis != nil {
+		// Share the live drone state between instances
+		detect.UseRedisAttackState(server.RealtimeRedis.Conn())
+		server.Backplane, err = detect.StartBackplane(server.RealtimeRedis.Conn())
+		if err != nil {
+			log.Printf("Warning: failed to start realtime backplane: %v", err)
+			// Realtime events stay local to this instance
+			err = nil
+		}
+	}
 
 	// pre config server
 	err = server.configApp()
@@ -121,7 +169,34 @@ func NewServer(version, buildTag, runEnv string) (server *Server, err error) {
 	}
 	return
 }
+
+// connectMainDb connects to the postgres database of the application
+func connectMainDb() (*gorm.DB, error) {
+	return datasources.ConnectDb(datasources.DbConfig{
+		DbDriver: "postgres",
+		DbName:   viper.GetString("db.postgres.db_name"),
+		Host:     viper.GetString("db.postgres.host"),
+		Username: viper.GetString("db.postgres.username"),
+		Password: viper.GetString("db.postgres.password"),
+		Port:     viper.GetInt("db.postgres.port"),
+		Timezone: "Asia/Bangkok",
+	})
+}
+
 func connectToRedis() (redisStorage *redis.Storage, err error) {
+	// redis.New panics when its first ping fails, check the server is reachable beforehand
+	probe := goredis.NewClient(&goredis.Options{
+		Addr:     fmt.Sprintf("%s:%d", viper.GetString("db.redis.host"), viper.GetInt("db.redis.port")),
+		Username: viper.GetString("db.redis.username"),
+		Password: viper.GetString("db.redis.password"),
+		DB:       viper.GetInt("db.redis.db_name"),
+	})
+	err = probe.Ping(context.Background()).Err()
+	probe.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
 	store := redis.New(redis.Config{
 		Host:      viper.GetString("db.redis.host"),
 		Port:      viper.GetInt("db.redis.port"),
@@ -139,7 +214,12 @@ func connectToRedis() (redisStorage *redis.Storage, err error) {
 	return store, nil
 }
 
-func connectToMQTT() (mqtt.Client, error) {
+// connectToMQTT creates the shared MQTT connection, the manager is returned even when the
+// broker is not reachable yet so packages can register their topic handlers
+// errInvalidMQTTConfig marks MQTT settings that can never connect, unlike a broker that is not reachable yet
+var errInvalidMQTTConfig = errors.New("invalid MQTT configuration")
+
+func connectToMQTT() (*mqtt.Manager, error) {
 	broker := viper.GetString("mqtt.broker")
 	if broker == "" {
 		broker = "tcp://localhost:1883"
@@ -151,33 +231,176 @@ func connectToMQTT() (mqtt.Client, error) {
 	clientID := "topgun-services-" + uuid.String()
 	// }
 
-	opts := mqtt.NewClientOptions()
-	opts.AddBroker(broker)
-	opts.SetClientID(clientID)
-	opts.SetAutoReconnect(true)
-	opts.SetConnectRetry(true)
-	opts.SetConnectRetryInterval(5 * time.Second)
-	opts.SetMaxReconnectInterval(30 * time.Second)
-	opts.SetOnConnectHandler(func(client mqtt.Client) {
-		log.Println("Connected to MQTT broker")
+	// A token is sent as the password for brokers with token authentication
+	password := viper.GetString("mqtt.password")
+	if password == "" {
+		password = viper.GetString("mqtt.token")
+	}
+	// The app CA is used unless the broker has its own
+	caFile := viper.GetString("mqtt.tls.ca")
+	if caFile == "" {
+		caFile = viper.GetString("app.path.ca")
+	}
+	willTopic := viper.GetString("mqtt.will.topic")
+	if willTopic == "" && !viper.IsSet("mqtt.will.topic") {
+		willTopic = "topgun/services/status"
+	}
+
+	timeout := time.Duration(viper.GetFloat64("mqtt.connect_timeout_seconds") * float64(time.Second))
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	manager, err := mqtt.NewManager(mqtt.ConnectionConfig{
+		Broker:   broker,
+		ClientID: clientID,
+		Username: viper.GetString("mqtt.username"),
+		Password: password,
+		TLS: mqtt.TLSConfig{
+			Enabled:            viper.GetBool("mqtt.tls.enabled"),
+			CAFile:             caFile,
+			CertFile:           viper.GetString("mqtt.tls.cert"),
+			KeyFile:            viper.GetString("mqtt.tls.key"),
+			ServerName:         viper.GetString("mqtt.tls.server_name"),
+			InsecureSkipVerify: viper.GetBool("mqtt.tls.insecure_skip_verify"),
+		},
+		Will: mqtt.WillConfig{
+			Topic:    willTopic,
+			QoS:      1,
+			Retained: true,
+		},
 	})
-	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
-		log.Printf("MQTT connection lost: %v", err)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidMQTTConfig, err)
+	}
+	// Offline the handlers are registered but only receive replayed messages
+	if viper.IsSet("mqtt.enabled") && !viper.GetBool("mqtt.enabled") {
+		log.Println("MQTT disabled, not connecting to the broker")
+		return manager, nil
+	}
+	return manager, manager.Connect(timeout)
+}
+
+// embeddedListenerConfig is one entry of mqtt.embedded.listeners, TLS when cert is set
+type embeddedListenerConfig struct {
+	ID       string `mapstructure:"id"`
+	Type     string `mapstructure:"type"`
+	Address  string `mapstructure:"address"`
+	Cert     string `mapstructure:"cert"`
+	Key      string `mapstructure:"key"`
+	ClientCA string `mapstructure:"client_ca"`
+}
+
+// startEmbeddedBroker starts the in-process MQTT broker. Cameras authenticate with their ID and token,
+// the service with mqtt.username and mqtt.password, generated for this run when not configured.
+func startEmbeddedBroker(mainDbConn *gorm.DB) (*mqtt.Broker, error) {
+	var listenerConfigs []embeddedListenerConfig
+	if err := viper.UnmarshalKey("mqtt.embedded.listeners", &listenerConfigs); err != nil {
+		return nil, fmt.Errorf("invalid mqtt.embedded.listeners: %w", err)
+	}
+	if len(listenerConfigs) == 0 {
+		listenerConfigs = append(listenerConfigs, embeddedListenerConfig{ID: "tcp", Type: mqtt.ListenerTCP, Address: ":1883"})
+	}
+
+	listeners := make([]mqtt.ListenerConfig, 0, len(listenerConfigs))
+	for _, listenerConfig := range listenerConfigs {
+		listener := mqtt.ListenerConfig{ID: listenerConfig.ID, Type: listenerConfig.Type, Address: listenerConfig.Address}
+		if listenerConfig.Cert != "" {
+			tlsConfig, err := mqtt.ServerTLSConfig(listenerConfig.Cert, listenerConfig.Key, listenerConfig.ClientCA)
+			if err != nil {
+				return nil, err
+			}
+			listener.TLS = tlsConfig
+		}
+		listeners = append(listeners, listener)
+	}
+
+	if viper.GetString("mqtt.username") == "" {
+		password := make([]byte, 24)
+		if _, err := rand.Read(password); err != nil {
+			return nil, err
+		}
+		viper.Set("mqtt.username", "topgun-services")
+		viper.Set("mqtt.password", hex.EncodeToString(password))
+	}
+	password := viper.GetString("mqtt.password")
+	if password == "" {
+		password = viper.GetString("mqtt.token")
+	}
+
+	publish := viper.GetStringSlice("mqtt.embedded.acl.publish")
+	if len(publish) == 0 {
+		// Heartbeats and attacks are keyed by hardware and drone IDs that %c cannot scope, a camera
+		// allowed to publish them could speak for any device or drone, so they are opt-in
+		publish = []string{"topgun/ai/" + mqtt.CameraPlaceholder, "topgun/model/+/ack/" + mqtt.CameraPlaceholder}
+	}
+	subscribe := viper.GetStringSlice("mqtt.embedded.acl.subscribe")
+	if len(subscribe) == 0 {
+		subscribe = []string{"topgun/command", "topgun/command/" + mqtt.CameraPlaceholder, "topgun/model/+/manifest", "topgun/model/+/chunk/+"}
+	}
+
+	return mqtt.NewBroker(mqtt.BrokerConfig{
+		Listeners:       listeners,
+		ServiceUsername: viper.GetString("mqtt.username"),
+		ServicePassword: password,
+		Cameras:         camera.NewMQTTAuthenticator(camera.NewCameraService(camera.NewCameraRepository(mainDbConn))),
+		ACL:             mqtt.BrokerACL{Publish: publish, Subscribe: subscribe},
+		AllowAnonymous:  viper.GetBool("mqtt.embedded.allow_anonymous"),
 	})
+}
+
+// newMQTTJournal creates the raw MQTT message journal, nil unless mqtt.journal.enabled is set
+func newMQTTJournal(logDbConn *gorm.DB) *mqtt.Journal {
+	if !viper.GetBool("mqtt.journal.enabled") {
+		return nil
+	}
+
+	var store mqtt.JournalStore
+	if viper.GetString("mqtt.journal.store") == "db" {
+		store = mqtt.DBJournalStore{DB: logDbConn}
+		log.Println("Recording MQTT messages to the log database")
+	} else {
+		dir := viper.GetString("mqtt.journal.path")
+		if dir == "" {
+			dir = "./log/mqtt"
+		}
+		store = mqtt.FileJournalStore{Dir: dir}
+		log.Printf("Recording MQTT messages to %s", dir)
+	}
+	return mqtt.NewJournal(store, viper.GetStringSlice("mqtt.journal.topics"))
+}
 
-	client := mqtt.NewClient(opts)
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		return nil, fmt.Errorf("failed to connect to MQTT broker: %w", token.Error())
+// mqttTransferConfig reads the chunked file transfer settings, unset values use the package defaults
+func mqttTransferConfig() mqtt.TransferConfig {
+	dir := viper.GetString("mqtt.transfer.path")
+	if dir == "" && viper.GetString("app.path.file") != "" {
+		dir = filepath.Join(viper.GetString("app.path.file"), "transfers")
 	}
+	return mqtt.TransferConfig{
+		Topic:      viper.GetString("mqtt.transfer.topic"),
+		ChunkSize:  viper.GetInt("mqtt.transfer.chunk_size"),
+		AckTimeout: time.Duration(viper.GetFloat64("mqtt.transfer.ack_timeout_seconds") * float64(time.Second)),
+		MaxRetries: viper.GetInt("mqtt.transfer.max_retries"),
+		Dir:        dir,
+		OneShotMax: viper.GetInt64("mqtt.transfer.one_shot_max_bytes"),
+	}
+}
 
-	log.Printf("Connected to MQTT broker at %s", broker)
-	return client, nil
+// bodyLimit returns the largest request body accepted, model uploads need more than the fiber default
+func bodyLimit() int {
+	limit := viper.GetInt("app.body_limit_mb")
+	if limit <= 0 {
+		limit = 4
+	}
+	return limit * 1024 * 1024
 }
+
 func (s *Server) Run() (err error) {
 	app := fiber.New(fiber.Config{
 		ErrorHandler:      customErrorHandler,
 		IdleTimeout:       60 * time.Second,
 		ReadBufferSize:    8 * 1024,
+		BodyLimit:         bodyLimit(),
 		Prefork:           s.PrdMode,
 		StreamRequestBody: true,
 		// speed up json with goccy/go-json
@@ -232,12 +455,20 @@ func (s *Server) Run() (err error) {
 
 	fmt.Println("Running cleanup tasks...")
 	// Your cleanup tasks go here
+	if s.Backplane != nil {
+		s.Backplane.Close()
+	}
+	if s.RealtimeRedis != nil {
+		s.RealtimeRedis.Close()
+	}
 	if s.RedisStorage != nil {
 		s.RedisStorage.Close()
 	}
-	if s.MQTTClient != nil && s.MQTTClient.IsConnected() {
-		s.MQTTClient.Disconnect(250)
-		log.Println("Disconnected from MQTT broker")
+	if s.MQTT != nil {
+		s.MQTT.Disconnect()
+	}
+	if s.Broker != nil {
+		s.Broker.Close()
 	}
 	fmt.Println("Successful shutdown.")
 	return
@@ -1,6 +1,7 @@
 package attack
 
 import (
+	"topgun-services/pkg/detect"
 	"topgun-services/pkg/domain"
 	"topgun-services/pkg/models"
 
@@ -15,6 +16,7 @@ type attackHandler struct {
 func NewAttackHandler(router fiber.Router, service domain.AttackService) {
 	handler := &attackHandler{service: service}
 	router.Get("/", handler.GetAttacks())
+	router.Get("/live", handler.GetLiveAttacks())
 	router.Post("/", handler.CreateAttack())
 	router.Put("/:id", handler.UpdateAttack())
 	router.Delete("/:id", handler.DeleteAttack())
@@ -86,6 +88,37 @@ func (h *attackHandler) GetAttacks() fiber.Handler {
 	}
 }
 
+// @Summary Get Live Attacks
+// @Description Retrieve the latest state reported by each drone, ordered by drone ID.
+// @Description Drones without an update within the stale timeout are marked stale.
+// @Tags Attacks
+// @Produce json
+// @Router /api/v1/attack/live [get]
+func (h *attackHandler) GetLiveAttacks() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		drones, err := detect.GetLiveAttackStates()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusInternalServerError,
+						Title:   "Failed to get live attacks",
+						Message: err.Error(),
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+		return c.Status(fiber.StatusOK).JSON(helpers.ResponseForm{
+			Success: true,
+			Data: fiber.Map{
+				"drones": drones,
+			},
+		})
+	}
+}
+
 // @Summary Create Attack
 // @Description Create a new attack record.
 // @Tags Attacks
@@ -109,19 +142,7 @@ func (h *attackHandler) CreateAttack() fiber.Handler {
 				},
 			})
 		}
-		var attack models.Attack
-		attack.TimeLeft = int(attackR.TimeLeft)
-		attack.Acceleration = attackR.Acceleration
-		attack.Distance = attackR.Distance
-		attack.DroneID = attackR.DroneID
-		attack.Height = attackR.Height
-		attack.Lat = attackR.Lat
-		attack.Lng = attackR.Lng
-		attack.Status = attackR.Status
-		attack.Velocity = attackR.Velocity
-		attack.Target = attackR.Target
-		attack.Landing = attackR.Landing
-		createdAttack, err := h.service.CreateAttack(attack)
+		createdAttack, err := h.service.CreateAttack(attackFromRequest(attackR))
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(helpers.ResponseForm{
 				Success: false,
@@ -142,6 +163,23 @@ func (h *attackHandler) CreateAttack() fiber.Handler {
 	}
 }
 
+// attackFromRequest maps a reported attack to the stored model, time_left is truncated to whole seconds
+func attackFromRequest(attackR models.AttackRequest) models.Attack {
+	var attack models.Attack
+	attack.TimeLeft = int(attackR.TimeLeft)
+	attack.Acceleration = attackR.Acceleration
+	attack.Distance = attackR.Distance
+	attack.DroneID = attackR.DroneID
+	attack.Height = attackR.Height
+	attack.Lat = attackR.Lat
+	attack.Lng = attackR.Lng
+	attack.Status = attackR.Status
+	attack.Velocity = attackR.Velocity
+	attack.Target = attackR.Target
+	attack.Landing = attackR.Landing
+	return attack
+}
+
 // @Summary Update Attack
 // @Description Update an existing attack record by ID.
 // @Tags Attacks
@@ -0,0 +1,257 @@
+package attack
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"topgun-services/pkg/domain"
+	"topgun-services/pkg/models"
+	"topgun-services/pkg/mqtt"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/spf13/viper"
+)
+
+// AttackTopic returns the topic filter drones publish their state to, the + level is the drone ID
+func AttackTopic() string {
+	topic := viper.GetString("mqtt.attack_topic")
+	if topic == "" {
+		topic = "topgun/attack/+"
+	}
+	return topic
+}
+
+// attackMaxBatch returns how many attacks one message may carry
+func attackMaxBatch() int {
+	size := viper.GetInt("mqtt.attack.max_batch")
+	if size <= 0 {
+		size = 50
+	}
+	return size
+}
+
+// attackRate returns how many attacks per second each drone may report
+func attackRate() float64 {
+	rate := viper.GetFloat64("mqtt.attack.rate_per_second")
+	if rate <= 0 {
+		rate = 10
+	}
+	return rate
+}
+
+// attackBurst returns how many attacks a drone may report at once after being quiet
+func attackBurst() int {
+	burst := viper.GetInt("mqtt.attack.burst")
+	if burst <= 0 {
+		burst = 20
+	}
+	return burst
+}
+
+// Ingest errors of attack messages
+var (
+	ErrInvalidAttack = errors.New("invalid attack")
+	ErrRateLimited   = errors.New("drone rate limit exceeded")
+)
+
+// MQTTAttackHandler stores drone state reported over MQTT through the attack service,
+// so it is broadcast exactly like attacks posted to the REST API
+type MQTTAttackHandler struct {
+	service domain.AttackService
+	pattern []string
+	level   int // topic level holding the drone ID, -1 when the topic has none
+	limiter *droneLimiter
+}
+
+func NewMQTTAttackHandler(service domain.AttackService, topic string) *MQTTAttackHandler {
+	return &MQTTAttackHandler{
+		service: service,
+		pattern: strings.Split(topic, "/"),
+		level:   mqtt.WildcardLevel(topic),
+		limiter: &droneLimiter{buckets: make(map[string]*tokenBucket)},
+	}
+}
+
+// droneID takes the drone ID from the topic, empty when the topic carries none
+func (h *MQTTAttackHandler) droneID(topic string) string {
+	if levels := strings.Split(topic, "/"); h.level >= 0 && len(levels) == len(h.pattern) {
+		return levels[h.level]
+	}
+	return ""
+}
+
+func (h *MQTTAttackHandler) HandleMessage(client paho.Client, msg paho.Message) {
+	created, err := h.Ingest(msg.Topic(), msg.Payload(), time.Now())
+	if err != nil {
+		log.Printf("Rejected MQTT attacks on topic %s (%d stored): %v", msg.Topic(), len(created), err)
+	}
+}
+
+// Ingest stores the attacks of one message, a single attack, an array of attacks or {"attacks": [...]}.
+// Each attack is validated and rate limited on its own, the stored ones are returned with the
+// problems of the others.
+func (h *MQTTAttackHandler) Ingest(topic string, payload []byte, now time.Time) ([]models.Attack, error) {
+	requests, err := decodeAttacks(payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAttack, err)
+	}
+	if len(requests) == 0 {
+		return nil, fmt.Errorf("%w: no attacks in message", ErrInvalidAttack)
+	}
+	if len(requests) > attackMaxBatch() {
+		return nil, fmt.Errorf("%w: %d attacks exceed the batch limit of %d", ErrInvalidAttack, len(requests), attackMaxBatch())
+	}
+
+	topicDroneID := h.droneID(topic)
+	created := make([]models.Attack, 0, len(requests))
+	var problems []error
+	for i, request := range requests {
+		prefix := ""
+		if len(requests) > 1 {
+			prefix = fmt.Sprintf("attacks[%d]: ", i)
+		}
+
+		switch {
+		case request.DroneID == "":
+			request.DroneID = topicDroneID
+		case topicDroneID != "" && request.DroneID != topicDroneID:
+			problems = append(problems, fmt.Errorf("%s%w: drone_id %q does not match topic drone %q", prefix, ErrInvalidAttack, request.DroneID, topicDroneID))
+			continue
+		}
+		if err := validateAttack(request); err != nil {
+			problems = append(problems, fmt.Errorf("%s%w", prefix, err))
+			continue
+		}
+		if !h.limiter.allow(request.DroneID, now) {
+			problems = append(problems, fmt.Errorf("%s%w for %s", prefix, ErrRateLimited, request.DroneID))
+			continue
+		}
+
+		attack, err := h.service.CreateAttack(attackFromRequest(request))
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%sfailed to save attack: %w", prefix, err))
+			continue
+		}
+		created = append(created, *attack)
+	}
+	return created, errors.Join(problems...)
+}
+
+// decodeAttacks reads a single attack, an array of attacks or an object with an attacks array
+func decodeAttacks(payload []byte) ([]models.AttackRequest, error) {
+	payload = bytes.TrimSpace(payload)
+	if len(payload) > 0 && payload[0] == '[' {
+		var requests []models.AttackRequest
+		if err := json.Unmarshal(payload, &requests); err != nil {
+			return nil, err
+		}
+		return requests, nil
+	}
+
+	var batch struct {
+		Attacks *[]models.AttackRequest `json:"attacks"`
+	}
+	if err := json.Unmarshal(payload, &batch); err != nil {
+		return nil, err
+	}
+	if batch.Attacks != nil {
+		return *batch.Attacks, nil
+	}
+	var request models.AttackRequest
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return nil, err
+	}
+	return []models.AttackRequest{request}, nil
+}
+
+// validateAttack checks the identity, position and motion reported by a drone
+func validateAttack(request models.AttackRequest) error {
+	var problems []string
+	inRange := func(name string, value float32, low, high float64) {
+		if math.IsNaN(float64(value)) || float64(value) < low || float64(value) > high {
+			problems = append(problems, fmt.Sprintf("%s %v is outside %v to %v", name, value, low, high))
+		}
+	}
+
+	if request.DroneID == "" || len(request.DroneID) > 64 || strings.ContainsAny(request.DroneID, "/+#") {
+		problems = append(problems, fmt.Sprintf("drone_id %q must be 1 to 64 characters without / + #", request.DroneID))
+	}
+	if len(request.Status) > 32 {
+		problems = append(problems, "status is longer than 32 characters")
+	}
+	inRange("lat", request.Lat, -90, 90)
+	inRange("lng", request.Lng, -180, 180)
+	inRange("target.lat", request.Target.Lat, -90, 90)
+	inRange("target.lng", request.Target.Lng, -180, 180)
+	inRange("landing.lat", request.Landing.Lat, -90, 90)
+	inRange("landing.lng", request.Landing.Lng, -180, 180)
+	inRange("height", request.Height, -1000, 20000)
+	inRange("distance", request.Distance, 0, math.MaxFloat32)
+	inRange("time_left", request.TimeLeft, 0, math.MaxFloat32)
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidAttack, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// tokenBucket holds the attacks a drone may still report
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// droneLimiter rate limits attacks per drone with a token bucket each
+type droneLimiter struct {
+	mutex     sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// allow takes a token from the bucket of the drone, refilled at mqtt.attack.rate_per_second up to mqtt.attack.burst
+func (l *droneLimiter) allow(droneID string, now time.Time) bool {
+	rate, burst := attackRate(), float64(attackBurst())
+	refill := func(bucket *tokenBucket) float64 {
+		return math.Min(burst, bucket.tokens+math.Max(0, now.Sub(bucket.updated).Seconds())*rate)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// Forget drones whose bucket filled up again
+	if now.Sub(l.lastSweep) >= time.Minute {
+		for id, bucket := range l.buckets {
+			if refill(bucket) >= burst {
+				delete(l.buckets, id)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	bucket, ok := l.buckets[droneID]
+	if !ok {
+		bucket = &tokenBucket{tokens: burst, updated: now}
+		l.buckets[droneID] = bucket
+	}
+	bucket.tokens = refill(bucket)
+	if now.After(bucket.updated) {
+		bucket.updated = now
+	}
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// StartMQTTSubscription registers the attack topic on the shared MQTT connection
+func StartMQTTSubscription(subscriber mqtt.Subscriber, handler *MQTTAttackHandler) error {
+	return subscriber.Handle(strings.Join(handler.pattern, "/"), 1, handler.HandleMessage)
+}
@@ -0,0 +1,150 @@
+package attack_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"topgun-services/pkg/attack"
+	"topgun-services/pkg/models"
+
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+type Test struct {
+	TestName string
+	Func     func() error
+}
+
+// fakeAttackService keeps created attacks in memory
+type fakeAttackService struct {
+	attacks []models.Attack
+}
+
+func (s *fakeAttackService) GetAttacks(pagination models.Pagination, filter models.Search) ([]models.Attack, *models.Pagination, *models.Search, error) {
+	return s.attacks, &pagination, &filter, nil
+}
+func (s *fakeAttackService) CreateAttack(attack models.Attack) (*models.Attack, error) {
+	attack.ID = uint(len(s.attacks) + 1)
+	s.attacks = append(s.attacks, attack)
+	return &attack, nil
+}
+func (s *fakeAttackService) UpdateAttack(id uint, attack models.Attack) (*models.Attack, error) {
+	return nil, gorm.ErrRecordNotFound
+}
+func (s *fakeAttackService) DeleteAttack(id uint) error {
+	return gorm.ErrRecordNotFound
+}
+func (s *fakeAttackService) GetAttack(id uint) (*models.Attack, error) {
+	return nil, gorm.ErrRecordNotFound
+}
+
+func TestMQTTAttackIngest(t *testing.T) {
+	viper.Set("mqtt.attack.rate_per_second", 1)
+	viper.Set("mqtt.attack.burst", 3)
+	viper.Set("mqtt.attack.max_batch", 5)
+	defer func() {
+		viper.Set("mqtt.attack.rate_per_second", nil)
+		viper.Set("mqtt.attack.burst", nil)
+		viper.Set("mqtt.attack.max_batch", nil)
+	}()
+
+	attacks := &fakeAttackService{}
+	handler := attack.NewMQTTAttackHandler(attacks, "topgun/attack/+")
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	state := func(timeLeft float64) string {
+		return fmt.Sprintf(`{"status":"flying","lat":14.3,"lng":101.1,"height":120,"distance":850,"time_left":%v,`+
+			`"velocity":{"x":12,"y":3,"z":-1},"target":{"lat":14.31,"lng":101.12,"description":"hangar"}}`, timeLeft)
+	}
+
+	tests := []Test{
+		{
+			TestName: "SingleAttackTakesDroneFromTopic",
+			Func: func() error {
+				created, err := handler.Ingest("topgun/attack/drone-a", []byte(state(42.7)), now)
+				if err != nil {
+					return err
+				}
+				if len(created) != 1 || created[0].DroneID != "drone-a" || created[0].TimeLeft != 42 || created[0].Target.Description != "hangar" {
+					return fmt.Errorf("unexpected stored attacks %+v", created)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "BatchFormsAreAccepted",
+			Func: func() error {
+				for _, payload := range []string{
+					"[" + state(30) + "," + state(29) + "]",
+					`{"attacks":[` + state(28) + "]}",
+				} {
+					if _, err := handler.Ingest("topgun/attack/drone-b", []byte(payload), now); err != nil {
+						return err
+					}
+				}
+				if stored := len(attacks.attacks); stored != 4 {
+					return fmt.Errorf("expected 4 stored attacks, got %d", stored)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "InvalidAttacksAreRejectedOneByOne",
+			Func: func() error {
+				before := len(attacks.attacks)
+				payload := `[{"drone_id":"drone-x","lat":14.3},{"lat":95},{"distance":-1},` + state(10) + "]"
+				created, err := handler.Ingest("topgun/attack/drone-c", []byte(payload), now)
+				if !errors.Is(err, attack.ErrInvalidAttack) {
+					return fmt.Errorf("expected ErrInvalidAttack, got %v", err)
+				}
+				if len(created) != 1 || len(attacks.attacks) != before+1 {
+					return fmt.Errorf("expected only the valid attack stored, got %d", len(created))
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "MalformedAndOversizedMessagesAreRejected",
+			Func: func() error {
+				if _, err := handler.Ingest("topgun/attack/drone-d", []byte(`{"lat":`), now); !errors.Is(err, attack.ErrInvalidAttack) {
+					return fmt.Errorf("expected malformed JSON rejected, got %v", err)
+				}
+				batch := "[" + state(1) + "," + state(1) + "," + state(1) + "," + state(1) + "," + state(1) + "," + state(1) + "]"
+				if _, err := handler.Ingest("topgun/attack/drone-d", []byte(batch), now); !errors.Is(err, attack.ErrInvalidAttack) {
+					return fmt.Errorf("expected a batch over max_batch rejected, got %v", err)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "RateLimitIsPerDrone",
+			Func: func() error {
+				batch := "[" + state(5) + "," + state(4) + "," + state(3) + "," + state(2) + "]"
+				created, err := handler.Ingest("topgun/attack/drone-e", []byte(batch), now)
+				if !errors.Is(err, attack.ErrRateLimited) || len(created) != 3 {
+					return fmt.Errorf("expected the burst of 3 stored then rate limited, got %d stored: %v", len(created), err)
+				}
+				if _, err := handler.Ingest("topgun/attack/drone-f", []byte(state(5)), now); err != nil {
+					return fmt.Errorf("expected another drone unaffected, got %v", err)
+				}
+				if _, err := handler.Ingest("topgun/attack/drone-e", []byte(state(1)), now.Add(500*time.Millisecond)); !errors.Is(err, attack.ErrRateLimited) {
+					return fmt.Errorf("expected drone-e still limited after 0.5s, got %v", err)
+				}
+				if _, err := handler.Ingest("topgun/attack/drone-e", []byte(state(1)), now.Add(1100*time.Millisecond)); err != nil {
+					return fmt.Errorf("expected drone-e allowed again after 1.1s, got %v", err)
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			if err := test.Func(); err != nil {
+				t.Errorf("Test %s failed with error: %v", test.TestName, err)
+			}
+		})
+	}
+}
@@ -18,11 +18,13 @@ func NewCameraHandler(router fiber.Router, routerResource *handlers.RouterResour
 	handler := &cameraHandler{
 		service: cameraService,
 	}
+	// The list stays open for the dashboards, tokens are never part of it
 	router.Get("/", handler.GetCameras())
-	router.Get("/:id", handler.GetCamera())
-	router.Post("/", handler.CreateCamera())
-	router.Put("/:id", handler.UpdateCamera())
-	router.Delete("/:id", handler.DeleteCamera())
+	router.Get("/:id", routerResource.ReqAuthHandler(), handler.GetCamera())
+	router.Post("/", routerResource.ReqAuthHandler(), handler.CreateCamera())
+	router.Post("/:id/token", routerResource.ReqAuthHandler(), handler.RotateCameraToken())
+	router.Put("/:id", routerResource.ReqAuthHandler(), handler.UpdateCamera())
+	router.Delete("/:id", routerResource.ReqAuthHandler(), handler.DeleteCamera())
 }
 
 // @Summary GetCameras
@@ -139,7 +141,7 @@ func (h *cameraHandler) GetCamera() fiber.Handler {
 
 // @Summary CreateCamera
 // @Tags Camera
-// @Description Create a new camera
+// @Description Create a new camera. The response includes the camera's MQTT broker token, which is not shown again.
 // @Accept json
 // @Produce json
 // @Param camera body models.Camera true "Camera object"
@@ -161,6 +163,8 @@ func (h *cameraHandler) CreateCamera() fiber.Handler {
 				},
 			})
 		}
+		// IDs are assigned by the server
+		camera.ID = uuid.Nil
 		createdCamera, err := h.service.CreateCamera(camera)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(helpers.ResponseForm{
@@ -177,7 +181,52 @@ func (h *cameraHandler) CreateCamera() fiber.Handler {
 		}
 		return c.Status(fiber.StatusCreated).JSON(helpers.ResponseForm{
 			Success: true,
-			Data:    createdCamera,
+			Data:    models.CameraWithToken{Camera: *createdCamera, Token: createdCamera.Token},
+		})
+	}
+}
+
+// @Summary RotateCameraToken
+// @Tags Camera
+// @Description Issue a new MQTT broker token for a camera and return it, the previous token stops working
+// @Accept json
+// @Produce json
+// @Param id path string true "Camera ID"
+// @Router /api/v1/camera/{id}/token [post]
+// @Security ApiKeyAuth
+func (h *cameraHandler) RotateCameraToken() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusBadRequest,
+						Title:   "Invalid camera ID",
+						Message: err.Error(),
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+		camera, err := h.service.RotateCameraToken(id)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusInternalServerError,
+						Title:   "Failed to rotate camera token",
+						Message: err.Error(),
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+		return c.Status(fiber.StatusOK).JSON(helpers.ResponseForm{
+			Success: true,
+			Data:    models.CameraWithToken{Camera: *camera, Token: camera.Token},
 		})
 	}
 }
@@ -0,0 +1,203 @@
+package camera_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"topgun-services/internal/handlers"
+	"topgun-services/pkg/camera"
+	"topgun-services/pkg/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type Test struct {
+	TestName string
+	Func     func() error
+}
+
+func TestCameraToken(t *testing.T) {
+	secret := []byte("camera-test-secret")
+	routerResource := handlers.NewRouterResources(func(token *jwt.Token) (interface{}, error) {
+		return secret, nil
+	}, nil, nil)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Subject:   "user-1",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}).SignedString(secret)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.Camera{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	service := camera.NewCameraService(camera.NewCameraRepository(db))
+	authenticate := camera.NewMQTTAuthenticator(service)
+
+	app := fiber.New()
+	camera.NewCameraHandler(app.Group("/camera"), routerResource, service)
+
+	// request sends a JSON request and decodes the data of the response
+	request := func(method, path, authorization string, body interface{}) (int, map[string]interface{}, error) {
+		var reader io.Reader
+		if body != nil {
+			data, err := json.Marshal(body)
+			if err != nil {
+				return 0, nil, err
+			}
+			reader = bytes.NewReader(data)
+		}
+		req := httptest.NewRequest(method, path, reader)
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		if authorization != "" {
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer "+authorization)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			return 0, nil, err
+		}
+		defer resp.Body.Close()
+		var response struct {
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			return resp.StatusCode, nil, err
+		}
+		var data map[string]interface{}
+		if len(response.Data) > 0 && response.Data[0] == '{' {
+			if err := json.Unmarshal(response.Data, &data); err != nil {
+				return resp.StatusCode, nil, err
+			}
+		}
+		return resp.StatusCode, data, nil
+	}
+
+	// create adds a camera and returns its ID and issued token
+	create := func(name string) (string, string, error) {
+		status, data, err := request(http.MethodPost, "/camera/", token, fiber.Map{"name": name, "token": "chosen-by-client"})
+		if err != nil {
+			return "", "", err
+		}
+		if status != http.StatusCreated {
+			return "", "", fmt.Errorf("expected status 201, got %d", status)
+		}
+		id, _ := data["id"].(string)
+		issued, _ := data["token"].(string)
+		if id == "" || issued == "" {
+			return "", "", fmt.Errorf("expected an id and a token, got %v", data)
+		}
+		return id, issued, nil
+	}
+
+	tests := []Test{
+		{
+			TestName: "CreateRequiresAuth",
+			Func: func() error {
+				status, _, err := request(http.MethodPost, "/camera/", "", fiber.Map{"name": "anonymous"})
+				if err != nil && status == 0 {
+					return err
+				}
+				// Without the app's error handler the rejection surfaces as a 500
+				if status == http.StatusCreated {
+					return errors.New("created a camera without a token")
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "CreateIssuesToken",
+			Func: func() error {
+				id, issued, err := create("gate")
+				if err != nil {
+					return err
+				}
+				if issued == "chosen-by-client" {
+					return errors.New("the token must be generated by the server")
+				}
+				if !authenticate(id, issued) {
+					return errors.New("the issued token must log in to the broker")
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "ListHidesToken",
+			Func: func() error {
+				if _, _, err := create("roof"); err != nil {
+					return err
+				}
+				req := httptest.NewRequest(http.MethodGet, "/camera/", nil)
+				resp, err := app.Test(req)
+				if err != nil {
+					return err
+				}
+				defer resp.Body.Close()
+				body, err := io.ReadAll(resp.Body)
+				if err != nil {
+					return err
+				}
+				if resp.StatusCode != http.StatusOK {
+					return fmt.Errorf("expected status 200, got %d", resp.StatusCode)
+				}
+				if strings.Contains(string(body), `"token"`) {
+					return fmt.Errorf("the camera list must not include tokens: %s", body)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "RotateReplacesToken",
+			Func: func() error {
+				id, issued, err := create("yard")
+				if err != nil {
+					return err
+				}
+				if status, _, _ := request(http.MethodPost, "/camera/"+id+"/token", "", nil); status == http.StatusOK {
+					return errors.New("rotated a token without a login")
+				}
+				status, data, err := request(http.MethodPost, "/camera/"+id+"/token", token, nil)
+				if err != nil {
+					return err
+				}
+				if status != http.StatusOK {
+					return fmt.Errorf("expected status 200, got %d", status)
+				}
+				rotated, _ := data["token"].(string)
+				if rotated == "" || rotated == issued {
+					return fmt.Errorf("expected a new token, got %q", rotated)
+				}
+				if authenticate(id, issued) {
+					return errors.New("the previous token must stop working")
+				}
+				if !authenticate(id, rotated) {
+					return errors.New("the new token must log in to the broker")
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			if err := test.Func(); err != nil {
+				t.Errorf("Test %s failed with error: %v", test.TestName, err)
+			}
+		})
+	}
+}
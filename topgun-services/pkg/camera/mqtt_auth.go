@@ -0,0 +1,28 @@
+package camera
+
+import (
+	"crypto/subtle"
+	"log"
+
+	"topgun-services/pkg/domain"
+	"topgun-services/pkg/mqtt"
+
+	"github.com/google/uuid"
+)
+
+// NewMQTTAuthenticator lets cameras connect to the embedded MQTT broker with their ID and token.
+// Cameras without a token cannot connect.
+func NewMQTTAuthenticator(service domain.CameraService) mqtt.CameraAuthenticator {
+	return func(cameraID, token string) bool {
+		id, err := uuid.Parse(cameraID)
+		if err != nil || token == "" {
+			return false
+		}
+		camera, err := service.GetCamera(id)
+		if err != nil {
+			log.Printf("MQTT camera %s not authenticated: %v", cameraID, err)
+			return false
+		}
+		return camera.Token != "" && subtle.ConstantTimeCompare([]byte(camera.Token), []byte(token)) == 1
+	}
+}
@@ -1,6 +1,9 @@
 package camera
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+
 	"topgun-services/pkg/domain"
 	"topgun-services/pkg/models"
 
@@ -17,7 +20,14 @@ func NewCameraService(repo domain.CameraRepository) domain.CameraService {
 func (s *cameraService) GetCameras(pagination models.Pagination, filter models.Search) ([]models.Camera, *models.Pagination, *models.Search, error) {
 	return s.repository.GetCameras(pagination, filter)
 }
+
+// CreateCamera stores a camera with a new MQTT broker token
 func (s *cameraService) CreateCamera(camera models.Camera) (*models.Camera, error) {
+	token, err := newCameraToken()
+	if err != nil {
+		return nil, err
+	}
+	camera.Token = token
 	return s.repository.CreateCamera(camera)
 }
 func (s *cameraService) UpdateCamera(id uuid.UUID, camera models.Camera) (*models.Camera, error) {
@@ -29,3 +39,20 @@ func (s *cameraService) DeleteCamera(id uuid.UUID) error {
 func (s *cameraService) GetCamera(id uuid.UUID) (*models.Camera, error) {
 	return s.repository.GetCamera(id)
 }
+
+// RotateCameraToken replaces the MQTT broker token of a camera, the old one stops working at its next login
+func (s *cameraService) RotateCameraToken(id uuid.UUID) (*models.Camera, error) {
+	token, err := newCameraToken()
+	if err != nil {
+		return nil, err
+	}
+	return s.repository.UpdateCamera(id, models.Camera{Token: token})
+}
+
+func newCameraToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
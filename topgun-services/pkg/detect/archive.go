@@ -0,0 +1,174 @@
+package detect
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Archive partitions are <path>/<camera_id>/<YYYY-MM-DD>/<HH>/<unix nanos>.jpg in UTC
+const (
+	archiveDayLayout  = "2006-01-02"
+	archiveHourLayout = "15"
+)
+
+// ArchivedFrame is one JPEG kept in the frame archive
+type ArchivedFrame struct {
+	Path       string
+	ReceivedAt time.Time
+}
+
+// archiveWrite is a sampled frame waiting to be written
+type archiveWrite struct {
+	cameraID   uuid.UUID
+	data       []byte
+	receivedAt time.Time
+}
+
+// frameArchive persists sampled frames per camera so past footage can be played back
+type frameArchive struct {
+	lastSaved map[uuid.UUID]time.Time
+	queue     chan archiveWrite
+	startOnce sync.Once
+	mutex     sync.Mutex
+}
+
+// Global frame archive, fed from the video frame cache
+var videoArchive = &frameArchive{
+	lastSaved: make(map[uuid.UUID]time.Time),
+	queue:     make(chan archiveWrite, 64),
+}
+
+// add queues a frame for writing if the camera's sampling interval has elapsed
+func (a *frameArchive) add(cameraID uuid.UUID, data []byte, receivedAt time.Time) {
+	if !settings().ArchiveEnabled {
+		return
+	}
+	a.startOnce.Do(func() { go a.run() })
+
+	a.mutex.Lock()
+	if last, ok := a.lastSaved[cameraID]; ok && receivedAt.Sub(last) < settings().ArchiveSampleInterval {
+		a.mutex.Unlock()
+		return
+	}
+	a.lastSaved[cameraID] = receivedAt
+	a.mutex.Unlock()
+
+	select {
+	case a.queue <- archiveWrite{cameraID: cameraID, data: data, receivedAt: receivedAt}:
+	default:
+		log.Printf("Archive queue full, dropping frame of camera %s", cameraID)
+	}
+}
+
+// run writes queued frames and removes partitions past the retention once per hour
+func (a *frameArchive) run() {
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
+	a.prune(time.Now())
+
+	for {
+		select {
+		case write := <-a.queue:
+			if err := a.write(write); err != nil {
+				log.Printf("Failed to archive frame of camera %s: %v", write.cameraID, err)
+			}
+		case now := <-prune.C:
+			a.prune(now)
+		}
+	}
+}
+
+// partitionDir returns the hour directory holding frames of a camera received at t
+func partitionDir(cameraID uuid.UUID, t time.Time) string {
+	t = t.UTC()
+	return filepath.Join(settings().ArchiveDir, cameraID.String(), t.Format(archiveDayLayout), t.Format(archiveHourLayout))
+}
+
+func (a *frameArchive) write(write archiveWrite) error {
+	dir := partitionDir(write.cameraID, write.receivedAt)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%d.jpg", write.receivedAt.UnixNano()))
+	return os.WriteFile(path, write.data, 0644)
+}
+
+// prune removes hour partitions that ended before the retention cutoff
+func (a *frameArchive) prune(now time.Time) {
+	config := settings()
+	cutoff := now.Add(-config.ArchiveRetention)
+	cameras, err := os.ReadDir(config.ArchiveDir)
+	if err != nil {
+		return
+	}
+	for _, camera := range cameras {
+		cameraDir := filepath.Join(config.ArchiveDir, camera.Name())
+		days, err := os.ReadDir(cameraDir)
+		if err != nil {
+			continue
+		}
+		for _, day := range days {
+			dayDir := filepath.Join(cameraDir, day.Name())
+			hours, err := os.ReadDir(dayDir)
+			if err != nil {
+				continue
+			}
+			for _, hour := range hours {
+				start, err := time.Parse(archiveDayLayout+" "+archiveHourLayout, day.Name()+" "+hour.Name())
+				if err != nil || !start.Add(time.Hour).Before(cutoff) {
+					continue
+				}
+				if err := os.RemoveAll(filepath.Join(dayDir, hour.Name())); err != nil {
+					log.Printf("Failed to prune archive partition: %v", err)
+				}
+			}
+			// Drop the day once its last hour is gone
+			if remaining, err := os.ReadDir(dayDir); err == nil && len(remaining) == 0 {
+				os.Remove(dayDir)
+			}
+		}
+	}
+}
+
+// Range returns the archived frames of a camera received between from and to (inclusive), oldest first
+func (a *frameArchive) Range(cameraID uuid.UUID, from, to time.Time) ([]ArchivedFrame, error) {
+	var frames []ArchivedFrame
+	for hour := from.UTC().Truncate(time.Hour); !hour.After(to); hour = hour.Add(time.Hour) {
+		dir := partitionDir(cameraID, hour)
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive partition: %w", err)
+		}
+		for _, entry := range entries {
+			nanos, err := strconv.ParseInt(strings.TrimSuffix(entry.Name(), ".jpg"), 10, 64)
+			if err != nil {
+				continue
+			}
+			receivedAt := time.Unix(0, nanos)
+			if receivedAt.Before(from) || receivedAt.After(to) {
+				continue
+			}
+			frames = append(frames, ArchivedFrame{
+				Path:       filepath.Join(dir, entry.Name()),
+				ReceivedAt: receivedAt,
+			})
+		}
+	}
+
+	sort.Slice(frames, func(i, j int) bool {
+		return frames[i].ReceivedAt.Before(frames[j].ReceivedAt)
+	})
+	return frames, nil
+}
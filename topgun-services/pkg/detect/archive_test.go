@@ -0,0 +1,183 @@
+package detect_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"topgun-services/pkg/detect"
+
+	fastws "github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+func TestArchivePlayback(t *testing.T) {
+	config := detect.DefaultConfig()
+	config.ArchiveEnabled = true
+	config.ArchiveDir = t.TempDir()
+	config.ArchiveSampleInterval = 50 * time.Millisecond
+	detect.SetConfig(config)
+	defer detect.SetConfig(detect.DefaultConfig())
+
+	var source bytes.Buffer
+	if err := jpeg.Encode(&source, image.NewRGBA(image.Rect(0, 0, 32, 24)), nil); err != nil {
+		t.Fatalf("failed to encode test frame: %v", err)
+	}
+	frame := base64.StdEncoding.EncodeToString(source.Bytes())
+
+	// Frames closer than the sampling interval are skipped
+	cameraID := uuid.New()
+	from := time.Now().Add(-time.Second)
+	for i := 0; i < 6; i++ {
+		detect.UpdateVideoFrameCache(&detect.VideoFrameMessage{CameraID: cameraID, Frame: frame, FrameNumber: i})
+		time.Sleep(30 * time.Millisecond)
+	}
+	to := time.Now().Add(time.Second)
+
+	app := fiber.New()
+	detect.NewCameraStreamHandler(app.Group("/camera"))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go app.Listener(listener)
+	defer app.Shutdown()
+
+	rangeQuery := fmt.Sprintf("from=%d&to=%d", from.Unix(), to.Unix()+1)
+	var archivedFrames int
+
+	tests := []Test{
+		{
+			TestName: "ExportMJPEG",
+			Func: func() error {
+				// Frames are written in the background
+				var resp *http.Response
+				deadline := time.Now().Add(2 * time.Second)
+				for {
+					var err error
+					resp, err = http.Get(fmt.Sprintf("http://%s/camera/%s/playback.mjpeg?%s", listener.Addr(), cameraID, rangeQuery))
+					if err != nil {
+						return err
+					}
+					if count, _ := strconv.Atoi(resp.Header.Get("X-Frame-Count")); count >= 3 || time.Now().After(deadline) {
+						break
+					}
+					resp.Body.Close()
+					time.Sleep(20 * time.Millisecond)
+				}
+				defer resp.Body.Close()
+
+				if resp.StatusCode != fiber.StatusOK {
+					return fmt.Errorf("expected status 200, got %d", resp.StatusCode)
+				}
+				archivedFrames, _ = strconv.Atoi(resp.Header.Get("X-Frame-Count"))
+				if archivedFrames < 3 || archivedFrames > 5 {
+					return fmt.Errorf("expected 3 to 5 sampled frames, got %d", archivedFrames)
+				}
+				body, err := io.ReadAll(resp.Body)
+				if err != nil {
+					return err
+				}
+				if got := bytes.Count(body, []byte{0xFF, 0xD8}); got < archivedFrames {
+					return fmt.Errorf("expected %d JPEGs in the export, found %d", archivedFrames, got)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "ExportOutsideArchiveIsNotFound",
+			Func: func() error {
+				past := from.Add(-2 * time.Hour)
+				resp, err := http.Get(fmt.Sprintf("http://%s/camera/%s/playback.mjpeg?from=%d&to=%d", listener.Addr(), cameraID, past.Unix(), past.Add(time.Minute).Unix()))
+				if err != nil {
+					return err
+				}
+				resp.Body.Close()
+				if resp.StatusCode != fiber.StatusNotFound {
+					return fmt.Errorf("expected status 404, got %d", resp.StatusCode)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "WebSocketPlayback",
+			Func: func() error {
+				conn, _, err := fastws.DefaultDialer.Dial(fmt.Sprintf("ws://%s/camera/%s/playback?%s&speed=4", listener.Addr(), cameraID, rangeQuery), nil)
+				if err != nil {
+					return err
+				}
+				defer conn.Close()
+				conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+
+				var status map[string]interface{}
+				if err := conn.ReadJSON(&status); err != nil {
+					return err
+				}
+				if status["status"] != "playing" {
+					return fmt.Errorf("unexpected first message %v", status)
+				}
+
+				frames := 0
+				for {
+					var message struct {
+						Type     string    `json:"type"`
+						CameraID uuid.UUID `json:"camera_id"`
+						Frame    string    `json:"frame"`
+						Width    int       `json:"width"`
+					}
+					if err := conn.ReadJSON(&message); err != nil {
+						return err
+					}
+					if message.Type == "playback_end" {
+						break
+					}
+					if message.CameraID != cameraID || message.Frame == "" || message.Width != 32 {
+						return errors.New("unexpected playback frame")
+					}
+					frames++
+				}
+				if frames != archivedFrames {
+					return fmt.Errorf("expected %d frames, played %d", archivedFrames, frames)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "RejectInvalidSpeed",
+			Func: func() error {
+				conn, _, err := fastws.DefaultDialer.Dial(fmt.Sprintf("ws://%s/camera/%s/playback?%s&speed=3", listener.Addr(), cameraID, rangeQuery), nil)
+				if err != nil {
+					return err
+				}
+				defer conn.Close()
+
+				var message map[string]interface{}
+				if err := conn.ReadJSON(&message); err != nil {
+					return err
+				}
+				if _, ok := message["error"]; !ok {
+					return fmt.Errorf("expected an error, got %v", message)
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			if err := test.Func(); err != nil {
+				t.Errorf("Test %s failed with error: %v", test.TestName, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package detect
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"topgun-services/pkg/models"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/google/uuid"
+)
+
+// AttackSubscription selects which attack updates a client receives and how often
+type AttackSubscription struct {
+	DroneIDs []string `json:"drone_ids"` // empty receives every drone
+	Statuses []string `json:"statuses"`  // empty receives every status
+	MaxRate  float64  `json:"max_rate"`  // updates per second per drone, 0 = unlimited
+}
+
+// parseAttackSubscription reads the drone_id, status and max_rate query parameters, lists are comma separated
+func parseAttackSubscription(droneIDs, statuses, maxRate string) (AttackSubscription, error) {
+	subscription := AttackSubscription{
+		DroneIDs: splitList(droneIDs),
+		Statuses: splitList(statuses),
+	}
+	if maxRate != "" {
+		rate, err := strconv.ParseFloat(maxRate, 64)
+		if err != nil {
+			return AttackSubscription{}, fmt.Errorf("max_rate must be a number, got %q", maxRate)
+		}
+		subscription.MaxRate = rate
+	}
+	if err := subscription.validate(); err != nil {
+		return AttackSubscription{}, err
+	}
+	return subscription, nil
+}
+
+// splitList splits a comma separated query value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (s AttackSubscription) validate() error {
+	if s.MaxRate < 0 {
+		return fmt.Errorf("max_rate must be zero or a positive number")
+	}
+	return nil
+}
+
+// matches reports whether an attack update passes the drone and status filters
+func (s AttackSubscription) matches(attack *models.Attack) bool {
+	if len(s.DroneIDs) > 0 && !contains(s.DroneIDs, attack.DroneID, false) {
+		return false
+	}
+	if len(s.Statuses) > 0 && !contains(s.Statuses, attack.Status, true) {
+		return false
+	}
+	return true
+}
+
+func contains(items []string, value string, ignoreCase bool) bool {
+	for _, item := range items {
+		if item == value || ignoreCase && strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// pendingAttack is the newest undelivered update of one drone
+type pendingAttack struct {
+	attack   *models.Attack
+	data     []byte
+	queuedAt time.Time
+}
+
+// Attack client structure
+// Each client holds only the newest undelivered update per drone, older pending updates are coalesced.
+type AttackClient struct {
+	id          uuid.UUID
+	conn        *websocket.Conn
+	remoteAddr  string
+	userID      string
+	connectedAt time.Time
+	counters    connectionCounters
+
+	notify chan struct{} // signalled when an update is pending
+	done   chan struct{} // closed when the hub unregisters the client
+
+	mutex        sync.Mutex
+	subscription AttackSubscription
+	pending      map[string]*pendingAttack
+	lastSent     map[string]time.Time
+}
+
+func newAttackClient(conn *websocket.Conn, subscription AttackSubscription) *AttackClient {
+	return &AttackClient{
+		id:           uuid.New(),
+		conn:         conn,
+		remoteAddr:   conn.RemoteAddr().String(),
+		userID:       socketUserID(conn),
+		connectedAt:  time.Now(),
+		notify:       make(chan struct{}, 1),
+		done:         make(chan struct{}),
+		subscription: subscription,
+		pending:      make(map[string]*pendingAttack),
+		lastSent:     make(map[string]time.Time),
+	}
+}
+
+// offer replaces the pending update of the drone without blocking the hub
+func (client *AttackClient) offer(attack *models.Attack, data []byte, now time.Time) {
+	client.mutex.Lock()
+	if !client.subscription.matches(attack) {
+		client.mutex.Unlock()
+		return
+	}
+	if _, ok := client.pending[attack.DroneID]; ok {
+		client.counters.dropped.Add(1)
+	}
+	client.pending[attack.DroneID] = &pendingAttack{attack: attack, data: data, queuedAt: now}
+	client.mutex.Unlock()
+
+	select {
+	case client.notify <- struct{}{}:
+	default:
+	}
+}
+
+// subscribe replaces the client's filters, pending updates that no longer match are discarded
+func (client *AttackClient) subscribe(subscription AttackSubscription) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	client.subscription = subscription
+	for droneID, pending := range client.pending {
+		if !subscription.matches(pending.attack) {
+			delete(client.pending, droneID)
+		}
+	}
+}
+
+// currentSubscription returns the client's filters
+func (client *AttackClient) currentSubscription() AttackSubscription {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.subscription
+}
+
+// take returns the pending updates the per-drone rate limit allows sending now, oldest first,
+// and how long to wait before the next held back update may be sent
+func (client *AttackClient) take(now time.Time) ([][]byte, time.Duration) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	var interval time.Duration
+	if client.subscription.MaxRate > 0 {
+		interval = time.Duration(float64(time.Second) / client.subscription.MaxRate)
+	}
+
+	var ready []*pendingAttack
+	var wait time.Duration
+	for droneID, pending := range client.pending {
+		if lastSent, ok := client.lastSent[droneID]; ok && interval > 0 {
+			if remaining := lastSent.Add(interval).Sub(now); remaining > 0 {
+				if wait == 0 || remaining < wait {
+					wait = remaining
+				}
+				continue
+			}
+		}
+		ready = append(ready, pending)
+		delete(client.pending, droneID)
+		client.lastSent[droneID] = now
+	}
+
+	sort.Slice(ready, func(i, j int) bool {
+		return ready[i].queuedAt.Before(ready[j].queuedAt)
+	})
+	messages := make([][]byte, len(ready))
+	for i, pending := range ready {
+		messages[i] = pending.data
+	}
+	return messages, wait
+}
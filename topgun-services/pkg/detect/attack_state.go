@@ -0,0 +1,210 @@
+package detect
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+	"topgun-services/pkg/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// DroneState is the latest attack update reported by one drone
+type DroneState struct {
+	DroneID    string         `json:"drone_id"`
+	Attack     *models.Attack `json:"attack"`
+	LastSeenAt time.Time      `json:"last_seen_at"`
+	Stale      bool           `json:"stale"` // no update within attack.live.stale_after_seconds
+}
+
+// attackStateStore keeps the latest state per drone
+type attackStateStore interface {
+	set(state DroneState) error
+	list() ([]DroneState, error)
+	remove(droneIDs []string) error
+	shared() bool // every instance sees the same state
+}
+
+// Latest state per drone, in memory unless Redis is configured
+var (
+	attackStates      attackStateStore = newMemoryAttackStateStore()
+	attackStatesMutex sync.RWMutex
+)
+
+// UseRedisAttackState keeps the live drone state in Redis so every instance serves the same snapshot.
+// The hash key is attack.live.redis_key (default topgun:attack:live).
+func UseRedisAttackState(client redis.UniversalClient) {
+	attackStatesMutex.Lock()
+	defer attackStatesMutex.Unlock()
+	attackStates = &redisAttackStateStore{client: client, key: settings().AttackRedisKey}
+}
+
+func currentAttackStates() attackStateStore {
+	attackStatesMutex.RLock()
+	defer attackStatesMutex.RUnlock()
+	return attackStates
+}
+
+// recordAttackState stores an attack as the latest state of its drone.
+// Updates relayed by the backplane were already written to a shared store by the origin instance.
+func recordAttackState(attack *models.Attack, relayed bool) {
+	if attack.DroneID == "" {
+		return
+	}
+	store := currentAttackStates()
+	if relayed && store.shared() {
+		return
+	}
+	if err := store.set(DroneState{DroneID: attack.DroneID, Attack: attack, LastSeenAt: time.Now()}); err != nil {
+		log.Printf("Failed to store live state of drone %s: %v", attack.DroneID, err)
+	}
+}
+
+// GetLiveAttackStates returns the latest state of every drone ordered by drone ID,
+// drones silent for longer than the retention are dropped
+func GetLiveAttackStates() ([]DroneState, error) {
+	store := currentAttackStates()
+	states, err := store.list()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	staleAfter := settings().AttackStaleAfter
+	retention := settings().AttackRetention
+
+	live := make([]DroneState, 0, len(states))
+	var expired []string
+	for _, state := range states {
+		age := now.Sub(state.LastSeenAt)
+		if age > retention {
+			expired = append(expired, state.DroneID)
+			continue
+		}
+		state.Stale = age > staleAfter
+		live = append(live, state)
+	}
+	if len(expired) > 0 {
+		if err := store.remove(expired); err != nil {
+			log.Printf("Failed to remove expired drone states: %v", err)
+		}
+	}
+
+	sort.Slice(live, func(i, j int) bool {
+		return live[i].DroneID < live[j].DroneID
+	})
+	return live, nil
+}
+
+// memoryAttackStateStore keeps drone states in this process
+type memoryAttackStateStore struct {
+	states map[string]DroneState
+	mutex  sync.RWMutex
+}
+
+func newMemoryAttackStateStore() *memoryAttackStateStore {
+	return &memoryAttackStateStore{states: make(map[string]DroneState)}
+}
+
+func (s *memoryAttackStateStore) set(state DroneState) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.states[state.DroneID] = state
+	return nil
+}
+
+func (s *memoryAttackStateStore) list() ([]DroneState, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	states := make([]DroneState, 0, len(s.states))
+	for _, state := range s.states {
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+func (s *memoryAttackStateStore) remove(droneIDs []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, droneID := range droneIDs {
+		delete(s.states, droneID)
+	}
+	return nil
+}
+
+func (s *memoryAttackStateStore) shared() bool {
+	return false
+}
+
+// redisAttackStateStore keeps drone states in a Redis hash keyed by drone ID
+type redisAttackStateStore struct {
+	client redis.UniversalClient
+	key    string
+}
+
+// Timeout of a single Redis call, the attack path must not hang on an unreachable server
+const attackStateRedisTimeout = 2 * time.Second
+
+func (s *redisAttackStateStore) set(state DroneState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), attackStateRedisTimeout)
+	defer cancel()
+	return s.client.HSet(ctx, s.key, state.DroneID, data).Err()
+}
+
+func (s *redisAttackStateStore) list() ([]DroneState, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), attackStateRedisTimeout)
+	defer cancel()
+
+	values, err := s.client.HGetAll(ctx, s.key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read live drone states: %w", err)
+	}
+	states := make([]DroneState, 0, len(values))
+	for droneID, value := range values {
+		var state DroneState
+		if err := json.Unmarshal([]byte(value), &state); err != nil {
+			log.Printf("Invalid live state of drone %s: %v", droneID, err)
+			continue
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+func (s *redisAttackStateStore) remove(droneIDs []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), attackStateRedisTimeout)
+	defer cancel()
+	return s.client.HDel(ctx, s.key, droneIDs...).Err()
+}
+
+func (s *redisAttackStateStore) shared() bool {
+	return true
+}
+
+// attackSnapshot builds the message sent to an attack WebSocket client on connect
+func attackSnapshot(subscription AttackSubscription) fiber.Map {
+	drones := make([]DroneState, 0)
+	states, err := GetLiveAttackStates()
+	if err != nil {
+		log.Printf("Failed to load attack snapshot: %v", err)
+	}
+	for _, state := range states {
+		if subscription.matches(state.Attack) {
+			drones = append(drones, state)
+		}
+	}
+	return fiber.Map{
+		"type":   "snapshot",
+		"drones": drones,
+	}
+}
@@ -0,0 +1,115 @@
+package detect_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"topgun-services/pkg/detect"
+	"topgun-services/pkg/models"
+
+	fastws "github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestLiveAttackState(t *testing.T) {
+	config := detect.DefaultConfig()
+	config.AttackStaleAfter = 200 * time.Millisecond
+	detect.SetConfig(config)
+	defer detect.SetConfig(detect.DefaultConfig())
+
+	app := fiber.New()
+	detect.NewDetectHandler(app.Group("/detect"), nil)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go app.Listener(listener)
+	defer app.Shutdown()
+
+	findDrone := func(droneID string) (detect.DroneState, error) {
+		states, err := detect.GetLiveAttackStates()
+		if err != nil {
+			return detect.DroneState{}, err
+		}
+		for _, state := range states {
+			if state.DroneID == droneID {
+				return state, nil
+			}
+		}
+		return detect.DroneState{}, fmt.Errorf("drone %s is not in the live state", droneID)
+	}
+
+	tests := []Test{
+		{
+			TestName: "KeepsLatestStatePerDrone",
+			Func: func() error {
+				detect.BroadcastAttack(&models.Attack{DroneID: "live-drone-a", Status: "flying", TimeLeft: 10})
+				detect.BroadcastAttack(&models.Attack{DroneID: "live-drone-a", Status: "flying", TimeLeft: 9})
+				state, err := findDrone("live-drone-a")
+				if err != nil {
+					return err
+				}
+				if state.Attack.TimeLeft != 9 || state.Stale {
+					return fmt.Errorf("expected fresh state with time_left 9, got %d (stale %v)", state.Attack.TimeLeft, state.Stale)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "SnapshotOnConnect",
+			Func: func() error {
+				detect.BroadcastAttack(&models.Attack{DroneID: "live-drone-b", Status: "landed"})
+
+				conn, _, err := fastws.DefaultDialer.Dial(fmt.Sprintf("ws://%s/detect/attack-ws?drone_id=live-drone-a", listener.Addr()), nil)
+				if err != nil {
+					return err
+				}
+				defer conn.Close()
+				conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+				var confirmation map[string]interface{}
+				if err := conn.ReadJSON(&confirmation); err != nil {
+					return err
+				}
+				var snapshot struct {
+					Type   string              `json:"type"`
+					Drones []detect.DroneState `json:"drones"`
+				}
+				if err := conn.ReadJSON(&snapshot); err != nil {
+					return err
+				}
+				if snapshot.Type != "snapshot" {
+					return fmt.Errorf("expected a snapshot, got %q", snapshot.Type)
+				}
+				if len(snapshot.Drones) != 1 || snapshot.Drones[0].DroneID != "live-drone-a" {
+					return fmt.Errorf("expected only the subscribed drone in the snapshot, got %+v", snapshot.Drones)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "MarksSilentDroneStale",
+			Func: func() error {
+				time.Sleep(300 * time.Millisecond)
+				state, err := findDrone("live-drone-a")
+				if err != nil {
+					return err
+				}
+				if !state.Stale {
+					return fmt.Errorf("expected drone to be stale")
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			if err := test.Func(); err != nil {
+				t.Errorf("Test %s failed with error: %v", test.TestName, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package detect_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"topgun-services/pkg/detect"
+	"topgun-services/pkg/models"
+
+	fastws "github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestAttackWebSocketSubscription(t *testing.T) {
+	app := fiber.New()
+	detect.NewDetectHandler(app.Group("/detect"), nil)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go app.Listener(listener)
+	defer app.Shutdown()
+
+	connect := func(query string) (*fastws.Conn, error) {
+		conn, _, err := fastws.DefaultDialer.Dial(fmt.Sprintf("ws://%s/detect/attack-ws%s", listener.Addr(), query), nil)
+		if err != nil {
+			return nil, err
+		}
+		var confirmation map[string]interface{}
+		if err := conn.ReadJSON(&confirmation); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if _, ok := confirmation["error"]; ok {
+			conn.Close()
+			return nil, fmt.Errorf("subscription rejected: %v", confirmation["error"])
+		}
+		var snapshot map[string]interface{}
+		if err := conn.ReadJSON(&snapshot); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+
+	// readAttacks starts reading attack updates in the background, the returned function
+	// collects updates until none arrives for the idle period
+	readAttacks := func(conn *fastws.Conn) func(idle time.Duration) []models.Attack {
+		updates := make(chan models.Attack, 64)
+		go func() {
+			defer close(updates)
+			for {
+				var attack models.Attack
+				if err := conn.ReadJSON(&attack); err != nil {
+					return
+				}
+				updates <- attack
+			}
+		}()
+		return func(idle time.Duration) []models.Attack {
+			var attacks []models.Attack
+			for {
+				select {
+				case attack, ok := <-updates:
+					if !ok {
+						return attacks
+					}
+					attacks = append(attacks, attack)
+				case <-time.After(idle):
+					return attacks
+				}
+			}
+		}
+	}
+
+	tests := []Test{
+		{
+			TestName: "FiltersAndCoalescesPerDrone",
+			Func: func() error {
+				conn, err := connect("?drone_id=ws-drone-a,ws-drone-b&status=flying&max_rate=2")
+				if err != nil {
+					return err
+				}
+				defer conn.Close()
+
+				collect := readAttacks(conn)
+				detect.BroadcastAttack(&models.Attack{DroneID: "ws-drone-a", Status: "flying", TimeLeft: 1})
+				if first := collect(300 * time.Millisecond); len(first) != 1 {
+					return fmt.Errorf("expected the first update at once, got %d updates", len(first))
+				}
+
+				// Within the rate limit window the burst collapses into the latest state
+				for i := 2; i <= 20; i++ {
+					detect.BroadcastAttack(&models.Attack{DroneID: "ws-drone-a", Status: "flying", TimeLeft: i})
+				}
+				detect.BroadcastAttack(&models.Attack{DroneID: "ws-drone-b", Status: "landed"})
+				detect.BroadcastAttack(&models.Attack{DroneID: "ws-drone-c", Status: "flying"})
+
+				attacks := collect(time.Second)
+				if len(attacks) != 1 {
+					return fmt.Errorf("expected 1 coalesced update, got %d", len(attacks))
+				}
+				if attacks[0].DroneID != "ws-drone-a" || attacks[0].TimeLeft != 20 {
+					return fmt.Errorf("expected the latest state of ws-drone-a, got %q with time_left %d", attacks[0].DroneID, attacks[0].TimeLeft)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "ChangesSubscriptionAtRuntime",
+			Func: func() error {
+				conn, err := connect("?drone_id=ws-drone-d")
+				if err != nil {
+					return err
+				}
+				defer conn.Close()
+
+				if err := conn.WriteJSON(fiber.Map{"type": "subscribe", "drone_ids": []string{"ws-drone-e"}}); err != nil {
+					return err
+				}
+				var reply map[string]interface{}
+				if err := conn.ReadJSON(&reply); err != nil {
+					return err
+				}
+				if reply["status"] != "subscribed" {
+					return fmt.Errorf("unexpected reply %v", reply)
+				}
+
+				collect := readAttacks(conn)
+				detect.BroadcastAttack(&models.Attack{DroneID: "ws-drone-d", Status: "flying"})
+				detect.BroadcastAttack(&models.Attack{DroneID: "ws-drone-e", Status: "flying"})
+				attacks := collect(300 * time.Millisecond)
+				if len(attacks) != 1 || attacks[0].DroneID != "ws-drone-e" {
+					return fmt.Errorf("expected only ws-drone-e, got %+v", attacks)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "RejectsNegativeRate",
+			Func: func() error {
+				conn, err := connect("?max_rate=-1")
+				if err == nil {
+					conn.Close()
+					return fmt.Errorf("expected the subscription to be rejected")
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			if err := test.Func(); err != nil {
+				t.Errorf("Test %s failed with error: %v", test.TestName, err)
+			}
+		})
+	}
+}
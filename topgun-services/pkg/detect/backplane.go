@@ -0,0 +1,186 @@
+package detect
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"topgun-services/pkg/models"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Kinds of realtime events relayed between instances
+const (
+	backplaneKindDetection = "detection"
+	backplaneKindAttack    = "attack"
+	backplaneKindFrame     = "frame"
+)
+
+// backplaneEnvelope wraps a hub payload published to Redis.
+// Origin is the publishing instance, so an instance ignores its own events.
+type backplaneEnvelope struct {
+	Origin   string          `json:"origin"`
+	Kind     string          `json:"kind"`
+	CameraID uuid.UUID       `json:"camera_id"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+// Backplane relays realtime hub events between server instances through Redis pub/sub,
+// so prefork children and load balanced instances reach every connected viewer.
+type Backplane struct {
+	client      redis.UniversalClient
+	channel     string
+	instanceID  string
+	relayFrames bool
+	outbox      chan *backplaneEnvelope
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+}
+
+// Global backplane, nil when events stay in this process
+var (
+	backplane      *Backplane
+	backplaneMutex sync.RWMutex
+)
+
+// StartBackplane subscribes to the realtime channel and starts publishing local hub events.
+// Configured by realtime.backplane.channel (default topgun:realtime) and realtime.backplane.frames.
+func StartBackplane(client redis.UniversalClient) (*Backplane, error) {
+	channel := settings().BackplaneChannel
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pubsub := client.Subscribe(ctx, channel)
+	// Wait for the subscription so events published right after start are not missed
+	if _, err := pubsub.Receive(ctx); err != nil {
+		cancel()
+		pubsub.Close()
+		return nil, err
+	}
+
+	b := &Backplane{
+		client:      client,
+		channel:     channel,
+		instanceID:  uuid.NewString(),
+		relayFrames: settings().BackplaneFrames,
+		outbox:      make(chan *backplaneEnvelope, 256),
+		cancel:      cancel,
+	}
+
+	b.wg.Add(2)
+	go b.publishLoop(ctx)
+	go b.receiveLoop(ctx, pubsub)
+
+	backplaneMutex.Lock()
+	backplane = b
+	backplaneMutex.Unlock()
+
+	log.Printf("Realtime backplane started on Redis channel %s. Instance ID: %s", channel, b.instanceID)
+	return b, nil
+}
+
+// Close stops relaying events, the Redis client is left open for its owner
+func (b *Backplane) Close() {
+	backplaneMutex.Lock()
+	if backplane == b {
+		backplane = nil
+	}
+	backplaneMutex.Unlock()
+
+	b.cancel()
+	b.wg.Wait()
+}
+
+// publishToBackplane queues a local event for other instances, it never blocks the caller.
+// The payload is marshalled only when a backplane is running.
+func publishToBackplane(kind string, cameraID uuid.UUID, payload interface{}) {
+	backplaneMutex.RLock()
+	b := backplane
+	backplaneMutex.RUnlock()
+
+	if b == nil || (kind == backplaneKindFrame && !b.relayFrames) {
+		return
+	}
+
+	select {
+	case b.outbox <- &backplaneEnvelope{
+		Origin:   b.instanceID,
+		Kind:     kind,
+		CameraID: cameraID,
+		Payload:  mustMarshal(payload),
+	}:
+	default:
+		log.Printf("Backplane outbox full, dropping %s event", kind)
+	}
+}
+
+func (b *Backplane) publishLoop(ctx context.Context) {
+	defer b.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case envelope := <-b.outbox:
+			if err := b.client.Publish(ctx, b.channel, mustMarshal(envelope)).Err(); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to publish %s event to backplane: %v", envelope.Kind, err)
+			}
+		}
+	}
+}
+
+func (b *Backplane) receiveLoop(ctx context.Context, pubsub *redis.PubSub) {
+	defer b.wg.Done()
+	defer pubsub.Close()
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+			var envelope backplaneEnvelope
+			if err := json.Unmarshal([]byte(message.Payload), &envelope); err != nil {
+				log.Printf("Invalid backplane message: %v", err)
+				continue
+			}
+			if envelope.Origin == b.instanceID {
+				continue
+			}
+			b.deliver(&envelope)
+		}
+	}
+}
+
+// deliver hands a remote event to the local hubs without publishing it again
+func (b *Backplane) deliver(envelope *backplaneEnvelope) {
+	switch envelope.Kind {
+	case backplaneKindDetection:
+		var message BroadcastMessage
+		if err := json.Unmarshal(envelope.Payload, &message); err != nil {
+			log.Printf("Invalid backplane detection: %v", err)
+			return
+		}
+		broadcastDetectionLocal(&message)
+	case backplaneKindAttack:
+		var attack models.Attack
+		if err := json.Unmarshal(envelope.Payload, &attack); err != nil {
+			log.Printf("Invalid backplane attack: %v", err)
+			return
+		}
+		recordAttackState(&attack, true)
+		broadcastAttackLocal(&attack)
+	case backplaneKindFrame:
+		var frame VideoFrameMessage
+		if err := json.Unmarshal(envelope.Payload, &frame); err != nil {
+			log.Printf("Invalid backplane video frame: %v", err)
+			return
+		}
+		broadcastVideoFrameLocal(&frame)
+	default:
+		log.Printf("Unknown backplane event kind: %s", envelope.Kind)
+	}
+}
@@ -0,0 +1,141 @@
+package detect_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"topgun-services/pkg/detect"
+	"topgun-services/pkg/models"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+type testEnvelope struct {
+	Origin   string          `json:"origin"`
+	Kind     string          `json:"kind"`
+	CameraID uuid.UUID       `json:"camera_id"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+func TestBackplane(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	backplane, err := detect.StartBackplane(client)
+	if err != nil {
+		t.Fatalf("failed to start backplane: %v", err)
+	}
+	defer backplane.Close()
+
+	ctx := context.Background()
+	observer := client.Subscribe(ctx, "topgun:realtime")
+	defer observer.Close()
+	if _, err := observer.Receive(ctx); err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+
+	publishFrame := func(origin string, cameraID uuid.UUID) error {
+		frame := detect.VideoFrameMessage{
+			CameraID:  cameraID,
+			Frame:     base64.StdEncoding.EncodeToString([]byte("jpeg")),
+			Timestamp: float64(time.Now().Unix()),
+		}
+		payload, err := json.Marshal(frame)
+		if err != nil {
+			return err
+		}
+		envelope, err := json.Marshal(testEnvelope{Origin: origin, Kind: "frame", CameraID: cameraID, Payload: payload})
+		if err != nil {
+			return err
+		}
+		return client.Publish(ctx, "topgun:realtime", envelope).Err()
+	}
+
+	var instanceID string
+	tests := []Test{
+		{
+			TestName: "PublishesLocalEvents",
+			Func: func() error {
+				detect.BroadcastAttack(&models.Attack{DroneID: "drone-backplane"})
+
+				message, err := observer.ReceiveTimeout(ctx, 2*time.Second)
+				if err != nil {
+					return err
+				}
+				published, ok := message.(*redis.Message)
+				if !ok {
+					return fmt.Errorf("unexpected message %T", message)
+				}
+				var envelope testEnvelope
+				if err := json.Unmarshal([]byte(published.Payload), &envelope); err != nil {
+					return err
+				}
+				if envelope.Kind != "attack" || envelope.Origin == "" {
+					return fmt.Errorf("unexpected envelope kind %q origin %q", envelope.Kind, envelope.Origin)
+				}
+				var attack models.Attack
+				if err := json.Unmarshal(envelope.Payload, &attack); err != nil {
+					return err
+				}
+				if attack.DroneID != "drone-backplane" {
+					return fmt.Errorf("expected drone-backplane, got %q", attack.DroneID)
+				}
+				instanceID = envelope.Origin
+				return nil
+			},
+		},
+		{
+			TestName: "DeliversRemoteEvents",
+			Func: func() error {
+				cameraID := uuid.New()
+				if err := publishFrame(uuid.NewString(), cameraID); err != nil {
+					return err
+				}
+				deadline := time.Now().Add(2 * time.Second)
+				for time.Now().Before(deadline) {
+					if frame, err := detect.GetCameraLatestFrame(cameraID); err == nil {
+						if string(frame.Data) != "jpeg" {
+							return fmt.Errorf("unexpected frame data %q", frame.Data)
+						}
+						return nil
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+				return errors.New("remote frame was not delivered to the local hub")
+			},
+		},
+		{
+			TestName: "IgnoresOwnEvents",
+			Func: func() error {
+				if instanceID == "" {
+					return errors.New("instance ID unknown, PublishesLocalEvents failed")
+				}
+				cameraID := uuid.New()
+				if err := publishFrame(instanceID, cameraID); err != nil {
+					return err
+				}
+				time.Sleep(100 * time.Millisecond)
+				if _, err := detect.GetCameraLatestFrame(cameraID); err == nil {
+					return errors.New("own event was delivered again")
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			if err := test.Func(); err != nil {
+				t.Errorf("Test %s failed with error: %v", test.TestName, err)
+			}
+		})
+	}
+}
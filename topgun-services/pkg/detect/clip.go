@@ -0,0 +1,216 @@
+package detect
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"topgun-services/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+// ClipMetadata is stored as metadata.json inside every clip archive
+type ClipMetadata struct {
+	DetectID    uint            `json:"detect_id"`
+	CameraID    uuid.UUID       `json:"camera_id"`
+	TriggeredAt time.Time       `json:"triggered_at"`
+	PreRollMs   int64           `json:"pre_roll_ms"`
+	PostRollMs  int64           `json:"post_roll_ms"`
+	Frames      []ClipFrameInfo `json:"frames"`
+}
+
+// ClipFrameInfo describes one JPEG inside a clip archive
+type ClipFrameInfo struct {
+	File       string    `json:"file"`
+	Timestamp  float64   `json:"timestamp"`   // Source timestamp
+	ReceivedAt time.Time `json:"received_at"` // Server receive time
+	OffsetMs   int64     `json:"offset_ms"`   // Offset from the trigger time
+}
+
+type trackKey struct {
+	cameraID uuid.UUID
+	trackID  int
+}
+
+// clipRecorder saves pre-roll/post-roll clips from the frame buffer when a detection or track starts
+type clipRecorder struct {
+	enabled      bool
+	dir          string
+	preRoll      time.Duration
+	postRoll     time.Duration
+	trackTimeout time.Duration
+	onSaved      func(detectID uint, clipPath string) error
+	tracks       map[trackKey]time.Time
+	mutex        sync.Mutex
+}
+
+// insideClipDir reports whether path is a file inside the clip directory,
+// clip paths are only trusted to be served or removed there
+func insideClipDir(path string) bool {
+	dir, err := filepath.Abs(settings().ClipDir)
+	if err != nil {
+		return false
+	}
+	file, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(dir, file)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// newClipRecorder creates a recorder from the video.clip config section.
+// onSaved is called after a clip file is written so it can be linked to the detection.
+func newClipRecorder(onSaved func(detectID uint, clipPath string) error) *clipRecorder {
+	config := settings()
+	return &clipRecorder{
+		enabled:      config.ClipEnabled,
+		dir:          config.ClipDir,
+		preRoll:      config.ClipPreRoll,
+		postRoll:     config.ClipPostRoll,
+		trackTimeout: config.ClipTrackTimeout,
+		onSaved:      onSaved,
+		tracks:       make(map[trackKey]time.Time),
+	}
+}
+
+// Capture schedules a clip for the detection if it starts a new track.
+// The clip is written once the post-roll window has elapsed.
+func (r *clipRecorder) Capture(detect *models.Detect) {
+	if r == nil || !r.enabled || detect == nil {
+		return
+	}
+
+	triggeredAt := time.Now()
+	if !r.startsTrack(detect, triggeredAt) {
+		return
+	}
+
+	go func() {
+		time.Sleep(r.postRoll)
+
+		clipPath, err := r.save(detect, triggeredAt)
+		if err != nil {
+			log.Printf("Failed to save clip for detection ID=%d: %v", detect.ID, err)
+			return
+		}
+		if err := r.onSaved(detect.ID, clipPath); err != nil {
+			log.Printf("Failed to link clip to detection ID=%d: %v", detect.ID, err)
+			os.Remove(clipPath)
+			return
+		}
+		log.Printf("Saved clip for detection ID=%d to: %s", detect.ID, clipPath)
+	}()
+}
+
+// startsTrack reports whether the detection contains a track that was not seen recently.
+// Detections without track IDs always start a new clip.
+func (r *clipRecorder) startsTrack(detect *models.Detect, now time.Time) bool {
+	trackIDs := objectTrackIDs(detect.Objects)
+	if len(trackIDs) == 0 {
+		return true
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// Forget tracks that timed out
+	for key, lastSeen := range r.tracks {
+		if now.Sub(lastSeen) > r.trackTimeout {
+			delete(r.tracks, key)
+		}
+	}
+
+	isNew := false
+	for _, trackID := range trackIDs {
+		key := trackKey{cameraID: detect.CameraID, trackID: trackID}
+		if _, ok := r.tracks[key]; !ok {
+			isNew = true
+		}
+		r.tracks[key] = now
+	}
+	return isNew
+}
+
+// save writes the buffered frames around the trigger time into a zip of JPEGs with timing metadata
+func (r *clipRecorder) save(detect *models.Detect, triggeredAt time.Time) (string, error) {
+	frames := getFrameBuffer(detect.CameraID).Range(triggeredAt.Add(-r.preRoll), triggeredAt.Add(r.postRoll))
+	if len(frames) == 0 {
+		return "", fmt.Errorf("no buffered frames for camera %s", detect.CameraID)
+	}
+
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create clip directory: %w", err)
+	}
+
+	filename := fmt.Sprintf("clip_%d_%s.zip", detect.ID, triggeredAt.Format("20060102_150405"))
+	clipPath := filepath.Join(r.dir, filename)
+	outFile, err := os.Create(clipPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create clip file: %w", err)
+	}
+	defer outFile.Close()
+
+	metadata := ClipMetadata{
+		DetectID:    detect.ID,
+		CameraID:    detect.CameraID,
+		TriggeredAt: triggeredAt,
+		PreRollMs:   r.preRoll.Milliseconds(),
+		PostRollMs:  r.postRoll.Milliseconds(),
+	}
+
+	archive := zip.NewWriter(outFile)
+	for i, frame := range frames {
+		name := fmt.Sprintf("frame_%05d.jpg", i+1)
+		// JPEG data is already compressed, store it as-is
+		entry, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: frame.ReceivedAt})
+		if err != nil {
+			os.Remove(clipPath)
+			return "", fmt.Errorf("failed to add frame to clip: %w", err)
+		}
+		if _, err := entry.Write(frame.Data); err != nil {
+			os.Remove(clipPath)
+			return "", fmt.Errorf("failed to write frame to clip: %w", err)
+		}
+		metadata.Frames = append(metadata.Frames, ClipFrameInfo{
+			File:       name,
+			Timestamp:  frame.Timestamp,
+			ReceivedAt: frame.ReceivedAt,
+			OffsetMs:   frame.ReceivedAt.Sub(triggeredAt).Milliseconds(),
+		})
+	}
+
+	entry, err := archive.Create("metadata.json")
+	if err == nil {
+		err = json.NewEncoder(entry).Encode(metadata)
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil {
+		os.Remove(clipPath)
+		return "", fmt.Errorf("failed to write clip metadata: %w", err)
+	}
+
+	return clipPath, nil
+}
+
+// objectTrackIDs extracts track_id values from detection objects
+func objectTrackIDs(objects models.JSONRawMessageArray) []int {
+	var trackIDs []int
+	for _, object := range objects {
+		var fields struct {
+			TrackID *int `json:"track_id"`
+		}
+		if err := json.Unmarshal(object, &fields); err == nil && fields.TrackID != nil {
+			trackIDs = append(trackIDs, *fields.TrackID)
+		}
+	}
+	return trackIDs
+}
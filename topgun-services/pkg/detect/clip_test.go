@@ -0,0 +1,165 @@
+package detect_test
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"topgun-services/pkg/detect"
+	"topgun-services/pkg/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// fakeDetectRepository keeps detections in memory
+type fakeDetectRepository struct {
+	detects map[uint]models.Detect
+}
+
+func (r *fakeDetectRepository) CreateDetect(detect models.Detect) (*models.Detect, error) {
+	detect.ID = uint(len(r.detects) + 1)
+	r.detects[detect.ID] = detect
+	return &detect, nil
+}
+
+func (r *fakeDetectRepository) GetDetects(pagination models.Pagination, filter models.Search, startDate, endDate string) ([]models.Detect, *models.Pagination, *models.Search, error) {
+	return nil, &pagination, &filter, nil
+}
+
+func (r *fakeDetectRepository) GetDetectsByCameras(cameraIDs []string, pagination models.Pagination) ([]models.Detect, *models.Pagination, error) {
+	return nil, &pagination, nil
+}
+
+func (r *fakeDetectRepository) GetDetect(id uint) (*models.Detect, error) {
+	detect, ok := r.detects[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &detect, nil
+}
+
+func (r *fakeDetectRepository) GetDetectFile(id uint) (*models.Detect, error) {
+	return r.GetDetect(id)
+}
+
+func (r *fakeDetectRepository) UpdateDetect(id uint, update models.Detect) (*models.Detect, error) {
+	detect, ok := r.detects[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	// Only non-zero fields are written, like a GORM struct update
+	if update.Path != "" {
+		detect.Path = update.Path
+	}
+	if update.ClipPath != "" {
+		detect.ClipPath = update.ClipPath
+	}
+	r.detects[id] = detect
+	return &detect, nil
+}
+
+func (r *fakeDetectRepository) DeleteDetect(id uint) error {
+	delete(r.detects, id)
+	return nil
+}
+
+func TestDetectClipPath(t *testing.T) {
+	dir := t.TempDir()
+	clips := filepath.Join(dir, "clips")
+	if err := os.MkdirAll(clips, 0755); err != nil {
+		t.Fatal(err)
+	}
+	config := detect.DefaultConfig()
+	config.ClipEnabled = false
+	config.ClipDir = clips
+	detect.SetConfig(config)
+	defer detect.SetConfig(detect.DefaultConfig())
+
+	// A file outside the clip directory that must survive
+	outside := filepath.Join(dir, "secret.txt")
+	if err := os.WriteFile(outside, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	inside := filepath.Join(clips, "detect_2.zip")
+	if err := os.WriteFile(inside, []byte("clip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	repository := &fakeDetectRepository{detects: map[uint]models.Detect{
+		1: {ID: 1, ClipPath: outside},
+		2: {ID: 2, ClipPath: inside},
+		3: {ID: 3},
+	}}
+	service := detect.NewDetectService(repository)
+	app := fiber.New()
+	detect.NewDetectHandler(app.Group("/detect"), service)
+
+	clipStatus := func(id uint) (int, error) {
+		resp, err := app.Test(httptest.NewRequest("GET", fmt.Sprintf("/detect/%d/clip", id), nil))
+		if err != nil {
+			return 0, err
+		}
+		return resp.StatusCode, nil
+	}
+
+	tests := []Test{
+		{
+			TestName: "UpdateCannotSetClipPath",
+			Func: func() error {
+				updated, err := service.UpdateDetect(3, models.Detect{Path: "frame.jpg", ClipPath: outside})
+				if err != nil {
+					return err
+				}
+				if updated.ClipPath != "" || updated.Path != "frame.jpg" {
+					return fmt.Errorf("expected only path updated, got path %q clip_path %q", updated.Path, updated.ClipPath)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "ClipOutsideDirectoryIsNotServed",
+			Func: func() error {
+				status, err := clipStatus(1)
+				if err != nil {
+					return err
+				}
+				if status != fiber.StatusNotFound {
+					return fmt.Errorf("expected 404 for a clip outside the clip directory, got %d", status)
+				}
+				if status, err := clipStatus(2); err != nil || status != fiber.StatusOK {
+					return fmt.Errorf("expected the clip inside the clip directory served, got %d %v", status, err)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "DeleteRemovesOnlyClipsInsideDirectory",
+			Func: func() error {
+				if err := service.DeleteDetect(1); err != nil {
+					return err
+				}
+				if _, err := os.Stat(outside); err != nil {
+					return fmt.Errorf("expected the file outside the clip directory kept, got %v", err)
+				}
+				if err := service.DeleteDetect(2); err != nil {
+					return err
+				}
+				if _, err := os.Stat(inside); !os.IsNotExist(err) {
+					return fmt.Errorf("expected the clip removed with its detection, got %v", err)
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			if err := test.Func(); err != nil {
+				t.Errorf("Test %s failed with error: %v", test.TestName, err)
+			}
+		})
+	}
+}
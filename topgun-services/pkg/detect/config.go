@@ -0,0 +1,222 @@
+package detect
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+)
+
+// Config holds the settings of the detect package. The hubs and monitors run for the lifetime of the
+// process and read it on every tick, so it is loaded from viper once with ConfigFromViper and replaced
+// as a whole with SetConfig instead of being read from viper while the config may be written.
+type Config struct {
+	BufferWindow time.Duration // video.buffer_seconds, frames kept per camera, raised to hold a whole clip
+
+	ClipEnabled      bool          // video.clip
+	ClipDir          string        // where clip archives are written
+	ClipPreRoll      time.Duration // recorded before a detection
+	ClipPostRoll     time.Duration // recorded after a detection
+	ClipTrackTimeout time.Duration // a track unseen for this long starts a new clip
+
+	ViewerMaxFPS       float64       // video.viewer, default frame rate limit of a viewer, 0 is unlimited
+	ViewerStallTimeout time.Duration // a viewer leaving a frame undelivered this long is disconnected
+
+	SourceDegradedAfter time.Duration // video.source, a source without a frame this long is degraded
+	SourceOfflineAfter  time.Duration // and then offline
+	SourceMinFPS        float64       // an active source below this rate is degraded, 0 disables the check
+
+	CaptureMatchTolerance time.Duration // video.capture, how far a frame may be from the detection time to be attached
+	CaptureMaxFrameAge    time.Duration // the oldest frame an MQTT detection may be attached to
+
+	ArchiveEnabled        bool          // video.archive, off by default
+	ArchiveDir            string        // root directory of the frame archive
+	ArchiveSampleInterval time.Duration // minimum time between two archived frames of a camera
+	ArchiveRetention      time.Duration // how long archived frames are kept
+	PlaybackMaxRange      time.Duration // longest time range a single playback may cover
+
+	WebRTCICEServers     []string      // video.webrtc, STUN/TURN URLs, none are needed on a local network
+	WebRTCConnectTimeout time.Duration // how long a session may take to gather candidates, and then to connect
+	WebRTCBitrateKbps    int           // target of the original rendition
+
+	SSEHeartbeat   time.Duration // sse, how often an idle stream receives a comment line
+	SSEBacklogSize int           // events kept per stream for Last-Event-ID resumption
+
+	BackplaneChannel string // realtime.backplane
+	BackplaneFrames  bool   // also relay live video frames
+
+	ImageURLSecret  string        // realtime.image_url, empty for a random per-process key
+	ImageURLTTL     time.Duration // how long a signed image URL stays valid
+	ImageURLBaseURL string        // prefix of image URLs, without a trailing slash
+	ThumbnailSize   uint          // bounding box of detection thumbnails
+
+	AttackStaleAfter time.Duration // attack.live, a drone without an update this long is marked stale
+	AttackRetention  time.Duration // a silent drone is kept this long in the live state
+	AttackRedisKey   string        // hash holding the live state on Redis
+
+	DefaultCameraID     uuid.UUID     // mqtt.camera_id, camera of sources that do not identify themselves
+	UnknownCameras      string        // UnknownCamerasReject or UnknownCamerasRegister
+	RequireEnvelope     bool          // reject legacy detections without the schema_version envelope
+	DetectionClockSkew  time.Duration // how far in the future a detection timestamp may be
+	DetectionMaxObjects int           // objects one frame may carry
+}
+
+// DefaultConfig returns the settings used for everything that is not configured
+func DefaultConfig() Config {
+	return Config{
+		BufferWindow: 10 * time.Second,
+
+		ClipEnabled:      true,
+		ClipDir:          "./upload/clips",
+		ClipPreRoll:      3 * time.Second,
+		ClipPostRoll:     3 * time.Second,
+		ClipTrackTimeout: 10 * time.Second,
+
+		ViewerStallTimeout: 10 * time.Second,
+
+		SourceDegradedAfter: 2 * time.Second,
+		SourceOfflineAfter:  10 * time.Second,
+
+		CaptureMatchTolerance: 500 * time.Millisecond,
+		CaptureMaxFrameAge:    5 * time.Second,
+
+		ArchiveDir:            "./upload/archive",
+		ArchiveSampleInterval: time.Second,
+		ArchiveRetention:      24 * time.Hour,
+		PlaybackMaxRange:      time.Hour,
+
+		WebRTCConnectTimeout: 10 * time.Second,
+		WebRTCBitrateKbps:    2000,
+
+		SSEHeartbeat:   15 * time.Second,
+		SSEBacklogSize: 100,
+
+		BackplaneChannel: "topgun:realtime",
+		BackplaneFrames:  true,
+
+		ImageURLTTL:   5 * time.Minute,
+		ThumbnailSize: 320,
+
+		AttackStaleAfter: 30 * time.Second,
+		AttackRetention:  time.Hour,
+		AttackRedisKey:   "topgun:attack:live",
+
+		// Fixed UUID for RaspberryPI MQTT camera
+		DefaultCameraID:     uuid.MustParse("3a939700-7724-4dc8-a5d8-47130aa68213"),
+		UnknownCameras:      UnknownCamerasReject,
+		DetectionClockSkew:  5 * time.Minute,
+		DetectionMaxObjects: 100,
+	}
+}
+
+// ConfigFromViper reads the video, sse, realtime, attack.live and mqtt sections over the defaults.
+// Values that are not positive keep their default.
+func ConfigFromViper() Config {
+	config := DefaultConfig()
+
+	configDuration(&config.BufferWindow, "video.buffer_seconds", time.Second)
+
+	if viper.IsSet("video.clip.enabled") {
+		config.ClipEnabled = viper.GetBool("video.clip.enabled")
+	}
+	configString(&config.ClipDir, "video.clip.path")
+	// A clip may have no pre or post roll at all
+	if viper.IsSet("video.clip.pre_roll_seconds") {
+		config.ClipPreRoll = time.Duration(viper.GetFloat64("video.clip.pre_roll_seconds") * float64(time.Second))
+	}
+	if viper.IsSet("video.clip.post_roll_seconds") {
+		config.ClipPostRoll = time.Duration(viper.GetFloat64("video.clip.post_roll_seconds") * float64(time.Second))
+	}
+	configDuration(&config.ClipTrackTimeout, "video.clip.track_timeout_seconds", time.Second)
+
+	config.ViewerMaxFPS = viper.GetFloat64("video.viewer.max_fps")
+	configDuration(&config.ViewerStallTimeout, "video.viewer.stall_timeout_seconds", time.Second)
+
+	configDuration(&config.SourceDegradedAfter, "video.source.degraded_after_seconds", time.Second)
+	configDuration(&config.SourceOfflineAfter, "video.source.offline_after_seconds", time.Second)
+	config.SourceMinFPS = viper.GetFloat64("video.source.min_fps")
+
+	configDuration(&config.CaptureMatchTolerance, "video.capture.match_tolerance_ms", time.Millisecond)
+	configDuration(&config.CaptureMaxFrameAge, "video.capture.max_frame_age_seconds", time.Second)
+
+	config.ArchiveEnabled = viper.GetBool("video.archive.enabled")
+	configString(&config.ArchiveDir, "video.archive.path")
+	if fps := viper.GetFloat64("video.archive.sample_fps"); fps > 0 {
+		config.ArchiveSampleInterval = time.Duration(float64(time.Second) / fps)
+	}
+	configDuration(&config.ArchiveRetention, "video.archive.retention_hours", time.Hour)
+	configDuration(&config.PlaybackMaxRange, "video.archive.max_playback_minutes", time.Minute)
+
+	config.WebRTCICEServers = viper.GetStringSlice("video.webrtc.ice_servers")
+	configDuration(&config.WebRTCConnectTimeout, "video.webrtc.connect_timeout_seconds", time.Second)
+	if kbps := viper.GetInt("video.webrtc.bitrate_kbps"); kbps > 0 {
+		config.WebRTCBitrateKbps = kbps
+	}
+
+	configDuration(&config.SSEHeartbeat, "sse.heartbeat_seconds", time.Second)
+	if size := viper.GetInt("sse.backlog_size"); size > 0 {
+		config.SSEBacklogSize = size
+	}
+
+	configString(&config.BackplaneChannel, "realtime.backplane.channel")
+	if viper.IsSet("realtime.backplane.frames") {
+		config.BackplaneFrames = viper.GetBool("realtime.backplane.frames")
+	}
+
+	config.ImageURLSecret = viper.GetString("realtime.image_url.secret")
+	configDuration(&config.ImageURLTTL, "realtime.image_url.ttl_seconds", time.Second)
+	config.ImageURLBaseURL = strings.TrimSuffix(viper.GetString("realtime.image_url.base_url"), "/")
+	if size := viper.GetUint("realtime.image_url.thumbnail_size"); size > 0 {
+		config.ThumbnailSize = size
+	}
+
+	configDuration(&config.AttackStaleAfter, "attack.live.stale_after_seconds", time.Second)
+	configDuration(&config.AttackRetention, "attack.live.retention_minutes", time.Minute)
+	configString(&config.AttackRedisKey, "attack.live.redis_key")
+
+	if cameraID, err := uuid.Parse(viper.GetString("mqtt.camera_id")); err == nil {
+		config.DefaultCameraID = cameraID
+	}
+	if strings.EqualFold(viper.GetString("mqtt.unknown_cameras"), UnknownCamerasRegister) {
+		config.UnknownCameras = UnknownCamerasRegister
+	}
+	config.RequireEnvelope = viper.GetBool("mqtt.require_envelope")
+	configDuration(&config.DetectionClockSkew, "mqtt.validation.max_clock_skew_seconds", time.Second)
+	if maxObjects := viper.GetInt("mqtt.validation.max_objects"); maxObjects > 0 {
+		config.DetectionMaxObjects = maxObjects
+	}
+
+	return config
+}
+
+// configDuration overrides a duration with a positive number of units from key
+func configDuration(target *time.Duration, key string, unit time.Duration) {
+	if value := time.Duration(viper.GetFloat64(key) * float64(unit)); value > 0 {
+		*target = value
+	}
+}
+
+// configString overrides a string with a non-empty value from key
+func configString(target *string, key string) {
+	if value := viper.GetString(key); value != "" {
+		*target = value
+	}
+}
+
+var currentConfig atomic.Pointer[Config]
+
+func init() {
+	SetConfig(DefaultConfig())
+}
+
+// SetConfig replaces the settings of the detect package, the server sets ConfigFromViper at startup
+func SetConfig(config Config) {
+	currentConfig.Store(&config)
+}
+
+// settings returns the current settings, callers must not modify them
+func settings() *Config {
+	return currentConfig.Load()
+}
@@ -0,0 +1,162 @@
+package detect
+
+import (
+	"topgun-services/internal/handlers"
+	"topgun-services/pkg/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	helpers "github.com/zercle/gofiber-helpers"
+)
+
+type connectionHandler struct{}
+
+// NewConnectionHandler registers the realtime connection admin routes
+func NewConnectionHandler(router fiber.Router, routerResource *handlers.RouterResources) {
+	handler := &connectionHandler{}
+	router.Get("/connections", routerResource.ReqAuthHandler(), handler.GetConnections())
+	router.Delete("/connections/:id", routerResource.ReqAuthHandler(), handler.DeleteConnection())
+}
+
+// @Summary GetConnections
+// @Tags Realtime
+// @Description List WebSocket and MJPEG clients connected to the detection, video and attack hubs of this instance
+// @Produce json
+// @Param page query int false "Page number"
+// @Param per_page query int false "Items per page"
+// @Param hub query string false "detection, video or attack"
+// @Param camera_id query string false "Only clients subscribed to this camera"
+// @Param user_id query string false "Only clients of this user"
+// @Router /api/v1/realtime/connections [get]
+// @Security ApiKeyAuth
+func (h *connectionHandler) GetConnections() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var pagination models.Pagination
+		if err := c.QueryParser(&pagination); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusBadRequest,
+						Title:   "Invalid pagination parameters",
+						Message: err.Error(),
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+
+		var cameraID uuid.UUID
+		if cameraIDParam := c.Query("camera_id"); cameraIDParam != "" {
+			parsed, err := uuid.Parse(cameraIDParam)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(helpers.ResponseForm{
+					Success: false,
+					Errors: []helpers.ResponseError{
+						{
+							Code:    fiber.StatusBadRequest,
+							Title:   "Invalid camera ID",
+							Message: err.Error(),
+							Source:  helpers.WhereAmI(),
+						},
+					},
+				})
+			}
+			cameraID = parsed
+		}
+		hubName := c.Query("hub")
+		userID := c.Query("user_id")
+
+		connections := make([]ConnectionStats, 0)
+		for _, connection := range GetConnectionStats() {
+			if hubName != "" && connection.Hub != hubName {
+				continue
+			}
+			if userID != "" && connection.UserID != userID {
+				continue
+			}
+			if cameraID != uuid.Nil && !subscribedTo(connection, cameraID) {
+				continue
+			}
+			connections = append(connections, connection)
+		}
+
+		if pagination.Page < 1 {
+			pagination.Page = 1
+		}
+		if pagination.PerPage < 1 || pagination.PerPage > 50 {
+			pagination.PerPage = 10
+		}
+		pagination.Total = int64(len(connections))
+		start := min((pagination.Page-1)*pagination.PerPage, len(connections))
+		end := min(start+pagination.PerPage, len(connections))
+
+		return c.Status(fiber.StatusOK).JSON(helpers.ResponseForm{
+			Success: true,
+			Data: fiber.Map{
+				"connections": connections[start:end],
+				"pagination":  pagination,
+			},
+		})
+	}
+}
+
+// subscribedTo reports whether a connection receives events of the camera, an empty list means every camera
+func subscribedTo(connection ConnectionStats, cameraID uuid.UUID) bool {
+	if connection.Hub == connectionHubVideo && len(connection.CameraIDs) == 0 {
+		return true
+	}
+	for _, id := range connection.CameraIDs {
+		if id == cameraID {
+			return true
+		}
+	}
+	return false
+}
+
+// @Summary DeleteConnection
+// @Tags Realtime
+// @Description Forcibly disconnect a realtime client
+// @Produce json
+// @Param id path string true "Connection ID"
+// @Router /api/v1/realtime/connections/{id} [delete]
+// @Security ApiKeyAuth
+func (h *connectionHandler) DeleteConnection() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusBadRequest,
+						Title:   "Invalid connection ID",
+						Message: err.Error(),
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+
+		if !DisconnectConnection(id) {
+			return c.Status(fiber.StatusNotFound).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusNotFound,
+						Title:   "Connection not found",
+						Message: "No realtime client with this ID is connected to this instance",
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(helpers.ResponseForm{
+			Success: true,
+			Data: fiber.Map{
+				"id": id,
+			},
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package detect
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/google/uuid"
+)
+
+// Hubs a realtime connection can belong to
+const (
+	connectionHubDetection = "detection"
+	connectionHubVideo     = "video"
+	connectionHubAttack    = "attack"
+)
+
+// ConnectionStats describes one realtime client connected to this instance
+type ConnectionStats struct {
+	ID           uuid.UUID   `json:"id"`
+	Hub          string      `json:"hub"`       // detection, video or attack
+	Transport    string      `json:"transport"` // websocket, mjpeg or webrtc
+	RemoteAddr   string      `json:"remote_addr"`
+	UserID       string      `json:"user_id"` // empty for anonymous connections
+	CameraIDs    []uuid.UUID `json:"camera_ids"`
+	ConnectedAt  time.Time   `json:"connected_at"`
+	MessagesSent uint64      `json:"messages_sent"`
+	BytesSent    uint64      `json:"bytes_sent"`
+	Dropped      uint64      `json:"dropped"` // messages or frames that were never delivered
+}
+
+// connectionCounters tracks delivery for a hub client, safe for concurrent use
+type connectionCounters struct {
+	messagesSent atomic.Uint64
+	bytesSent    atomic.Uint64
+	dropped      atomic.Uint64
+}
+
+func (c *connectionCounters) recordSent(size int) {
+	c.messagesSent.Add(1)
+	c.bytesSent.Add(uint64(size))
+}
+
+// socketUserID returns the user identified by the optional auth middleware, empty for anonymous connections
+func socketUserID(c *websocket.Conn) string {
+	userID, _ := c.Locals("user_id").(string)
+	return userID
+}
+
+func (client *Client) connectionStats() ConnectionStats {
+	return ConnectionStats{
+		ID:           client.id,
+		Hub:          connectionHubDetection,
+		Transport:    "websocket",
+		RemoteAddr:   client.remoteAddr,
+		UserID:       client.userID,
+		CameraIDs:    []uuid.UUID{client.cameraID},
+		ConnectedAt:  client.connectedAt,
+		MessagesSent: client.counters.messagesSent.Load(),
+		BytesSent:    client.counters.bytesSent.Load(),
+		Dropped:      client.counters.dropped.Load(),
+	}
+}
+
+func (client *AttackClient) connectionStats() ConnectionStats {
+	return ConnectionStats{
+		ID:           client.id,
+		Hub:          connectionHubAttack,
+		Transport:    "websocket",
+		RemoteAddr:   client.remoteAddr,
+		UserID:       client.userID,
+		CameraIDs:    []uuid.UUID{},
+		ConnectedAt:  client.connectedAt,
+		MessagesSent: client.counters.messagesSent.Load(),
+		BytesSent:    client.counters.bytesSent.Load(),
+		Dropped:      client.counters.dropped.Load(),
+	}
+}
+
+func (vc *VideoClient) connectionStats() ConnectionStats {
+	vc.mutex.Lock()
+	defer vc.mutex.Unlock()
+
+	stats := ConnectionStats{
+		ID:           vc.id,
+		Hub:          connectionHubVideo,
+		Transport:    vc.transport,
+		RemoteAddr:   vc.remoteAddr,
+		UserID:       vc.userID,
+		CameraIDs:    []uuid.UUID{},
+		ConnectedAt:  vc.connectedAt,
+		MessagesSent: vc.framesSent,
+		BytesSent:    vc.bytesSent,
+		Dropped:      vc.framesDropped,
+	}
+	// An empty list means every camera
+	if vc.cameraID != uuid.Nil {
+		stats.CameraIDs = []uuid.UUID{vc.cameraID}
+	}
+	return stats
+}
+
+// closeSocket sends a close frame and unblocks the read loop of a hub client, which then cleans up the connection.
+// Closing the hijacked connection directly is a no-op, fasthttp closes it once the handler returns.
+func closeSocket(conn *websocket.Conn) {
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "disconnected by an administrator"),
+		time.Now().Add(time.Second))
+	conn.SetReadDeadline(time.Now())
+}
+
+// GetConnectionStats lists every client connected to the detection, video and attack hubs, oldest first
+func GetConnectionStats() []ConnectionStats {
+	var connections []ConnectionStats
+
+	hub.mutex.RLock()
+	for client := range hub.clients {
+		connections = append(connections, client.connectionStats())
+	}
+	hub.mutex.RUnlock()
+
+	videoHub.mutex.RLock()
+	for client := range videoHub.clients {
+		connections = append(connections, client.connectionStats())
+	}
+	videoHub.mutex.RUnlock()
+
+	attackHub.mutex.RLock()
+	for client := range attackHub.clients {
+		connections = append(connections, client.connectionStats())
+	}
+	attackHub.mutex.RUnlock()
+
+	sort.Slice(connections, func(i, j int) bool {
+		return connections[i].ConnectedAt.Before(connections[j].ConnectedAt)
+	})
+	return connections
+}
+
+// DisconnectConnection forcibly closes a realtime client, it returns false when no client has the ID
+func DisconnectConnection(id uuid.UUID) bool {
+	hub.mutex.RLock()
+	var detectionClient *Client
+	for client := range hub.clients {
+		if client.id == id {
+			detectionClient = client
+			break
+		}
+	}
+	hub.mutex.RUnlock()
+	if detectionClient != nil {
+		hub.removeClient(detectionClient)
+		closeSocket(detectionClient.conn)
+		return true
+	}
+
+	videoHub.mutex.RLock()
+	var videoClient *VideoClient
+	for client := range videoHub.clients {
+		if client.id == id {
+			videoClient = client
+			break
+		}
+	}
+	videoHub.mutex.RUnlock()
+	if videoClient != nil {
+		// The writer closes the WebSocket or ends the MJPEG response once done is closed
+		videoHub.removeClient(videoClient)
+		return true
+	}
+
+	attackHub.mutex.RLock()
+	var attackClient *AttackClient
+	for client := range attackHub.clients {
+		if client.id == id {
+			attackClient = client
+			break
+		}
+	}
+	attackHub.mutex.RUnlock()
+	if attackClient != nil {
+		attackHub.removeClient(attackClient)
+		closeSocket(attackClient.conn)
+		return true
+	}
+
+	return false
+}
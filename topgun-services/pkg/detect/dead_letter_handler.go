@@ -0,0 +1,234 @@
+package detect
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"topgun-services/internal/handlers"
+	"topgun-services/pkg/domain"
+	"topgun-services/pkg/models"
+
+	"github.com/gofiber/fiber/v2"
+	helpers "github.com/zercle/gofiber-helpers"
+	"gorm.io/gorm"
+)
+
+type deadLetterHandler struct {
+	ingest      *MQTTDetectHandler
+	deadLetters domain.DeadLetterRepository
+}
+
+// NewDeadLetterHandler registers the routes to inspect and retry rejected MQTT detections
+func NewDeadLetterHandler(router fiber.Router, routerResource *handlers.RouterResources, ingest *MQTTDetectHandler, deadLetters domain.DeadLetterRepository) {
+	handler := &deadLetterHandler{ingest: ingest, deadLetters: deadLetters}
+	router.Get("/", routerResource.ReqAuthHandler(), handler.GetDeadLetters())
+	router.Get("/:id", routerResource.ReqAuthHandler(), handler.GetDeadLetter())
+	router.Post("/:id/reprocess", routerResource.ReqAuthHandler(), handler.ReprocessDeadLetter())
+	router.Delete("/:id", routerResource.ReqAuthHandler(), handler.DeleteDeadLetter())
+}
+
+// deadLetterError answers with the status matching a repository error
+func deadLetterError(c *fiber.Ctx, title string, err error) error {
+	status := fiber.StatusInternalServerError
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		status = fiber.StatusNotFound
+	}
+	return c.Status(status).JSON(helpers.ResponseForm{
+		Success: false,
+		Errors: []helpers.ResponseError{
+			{
+				Code:    status,
+				Title:   title,
+				Message: err.Error(),
+				Source:  helpers.WhereAmI(),
+			},
+		},
+	})
+}
+
+// invalidDeadLetterID answers a malformed id path parameter
+func invalidDeadLetterID(c *fiber.Ctx, err error) error {
+	return c.Status(fiber.StatusBadRequest).JSON(helpers.ResponseForm{
+		Success: false,
+		Errors: []helpers.ResponseError{
+			{
+				Code:    fiber.StatusBadRequest,
+				Title:   "Invalid dead letter ID",
+				Message: err.Error(),
+				Source:  helpers.WhereAmI(),
+			},
+		},
+	})
+}
+
+// @Summary GetDeadLetters
+// @Tags MQTT
+// @Description List MQTT detection messages that could not be stored, newest first
+// @Produce json
+// @Param page query int false "Page number"
+// @Param per_page query int false "Items per page"
+// @Param status query string false "pending, reprocessing or reprocessed"
+// @Router /api/v1/mqtt/dead-letters [get]
+// @Security ApiKeyAuth
+func (h *deadLetterHandler) GetDeadLetters() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var pagination models.Pagination
+		if err := c.QueryParser(&pagination); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusBadRequest,
+						Title:   "Invalid pagination query parameters",
+						Message: err.Error(),
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+
+		deadLetters, p, err := h.deadLetters.GetDeadLetters(pagination, c.Query("status"))
+		if err != nil {
+			return deadLetterError(c, "Failed to retrieve dead letters", err)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(helpers.ResponseForm{
+			Success: true,
+			Data: fiber.Map{
+				"dead_letters": deadLetters,
+				"pagination":   p,
+			},
+		})
+	}
+}
+
+// @Summary GetDeadLetter
+// @Tags MQTT
+// @Description Get a rejected MQTT detection message with its raw payload and reason
+// @Produce json
+// @Param id path string true "Dead letter ID"
+// @Router /api/v1/mqtt/dead-letters/{id} [get]
+// @Security ApiKeyAuth
+func (h *deadLetterHandler) GetDeadLetter() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var id uint
+		if _, err := fmt.Sscan(c.Params("id"), &id); err != nil {
+			return invalidDeadLetterID(c, err)
+		}
+
+		deadLetter, err := h.deadLetters.GetDeadLetter(id)
+		if err != nil {
+			return deadLetterError(c, "Failed to retrieve dead letter", err)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(helpers.ResponseForm{
+			Success: true,
+			Data:    deadLetter,
+		})
+	}
+}
+
+// @Summary ReprocessDeadLetter
+// @Tags MQTT
+// @Description Retry a rejected MQTT detection message. An optional body replaces the stored payload, e.g. to fix a bad field.
+// @Description Live frames are not attached to retried messages. A dead letter that is reprocessed or being retried answers 409.
+// @Accept json
+// @Produce json
+// @Param id path string true "Dead letter ID"
+// @Param request body object{payload=object} false "Corrected message"
+// @Router /api/v1/mqtt/dead-letters/{id}/reprocess [post]
+// @Security ApiKeyAuth
+func (h *deadLetterHandler) ReprocessDeadLetter() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var id uint
+		if _, err := fmt.Sscan(c.Params("id"), &id); err != nil {
+			return invalidDeadLetterID(c, err)
+		}
+
+		var request struct {
+			Payload json.RawMessage `json:"payload"`
+		}
+		if len(c.Body()) > 0 {
+			if err := json.Unmarshal(c.Body(), &request); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(helpers.ResponseForm{
+					Success: false,
+					Errors: []helpers.ResponseError{
+						{
+							Code:    fiber.StatusBadRequest,
+							Title:   "Invalid request body",
+							Message: err.Error(),
+							Source:  helpers.WhereAmI(),
+						},
+					},
+				})
+			}
+		}
+
+		deadLetter, detect, err := h.ingest.Reprocess(id, request.Payload)
+		var ingestErr *IngestError
+		switch {
+		case errors.As(err, &ingestErr):
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(helpers.ResponseForm{
+				Success: false,
+				Data:    deadLetter,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusUnprocessableEntity,
+						Title:   "Dead letter rejected again",
+						Message: err.Error(),
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		case errors.Is(err, ErrDeadLetterReprocessed), errors.Is(err, ErrDeadLetterReprocessing):
+			return c.Status(fiber.StatusConflict).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusConflict,
+						Title:   "Dead letter is not pending",
+						Message: err.Error(),
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		case err != nil:
+			return deadLetterError(c, "Failed to reprocess dead letter", err)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(helpers.ResponseForm{
+			Success: true,
+			Data: fiber.Map{
+				"dead_letter": deadLetter,
+				"detect":      detect,
+			},
+		})
+	}
+}
+
+// @Summary DeleteDeadLetter
+// @Tags MQTT
+// @Description Discard a rejected MQTT detection message
+// @Produce json
+// @Param id path string true "Dead letter ID"
+// @Router /api/v1/mqtt/dead-letters/{id} [delete]
+// @Security ApiKeyAuth
+func (h *deadLetterHandler) DeleteDeadLetter() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var id uint
+		if _, err := fmt.Sscan(c.Params("id"), &id); err != nil {
+			return invalidDeadLetterID(c, err)
+		}
+
+		if err := h.deadLetters.DeleteDeadLetter(id); err != nil {
+			return deadLetterError(c, "Failed to delete dead letter", err)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(helpers.ResponseForm{
+			Success: true,
+			Data: fiber.Map{
+				"id": id,
+			},
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package detect
+
+import (
+	"topgun-services/pkg/domain"
+	"topgun-services/pkg/models"
+	"topgun-services/pkg/utils"
+
+	"gorm.io/gorm"
+)
+
+type deadLetterRepository struct {
+	DB *gorm.DB
+}
+
+func NewDeadLetterRepository(db *gorm.DB) domain.DeadLetterRepository {
+	return &deadLetterRepository{DB: db}
+}
+func (r *deadLetterRepository) CreateDeadLetter(deadLetter models.DeadLetter) (*models.DeadLetter, error) {
+	if r.DB == nil {
+		return nil, gorm.ErrInvalidDB
+	}
+	err := r.DB.Create(&deadLetter).Error
+	if err != nil {
+		return nil, err
+	}
+	return &deadLetter, nil
+}
+func (r *deadLetterRepository) GetDeadLetters(pagination models.Pagination, status string) ([]models.DeadLetter, *models.Pagination, error) {
+	if r.DB == nil {
+		return nil, nil, gorm.ErrInvalidDB
+	}
+	var deadLetters []models.DeadLetter
+	dbTx := r.DB
+	if status != "" {
+		dbTx = dbTx.Where("status = ?", status)
+	}
+	dbTx = utils.ApplyPagination(dbTx.Order("created_at DESC"), &pagination, &deadLetters)
+	err := dbTx.Limit(pagination.PerPage).Find(&deadLetters).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	return deadLetters, &pagination, nil
+}
+func (r *deadLetterRepository) GetDeadLetter(id uint) (*models.DeadLetter, error) {
+	if r.DB == nil {
+		return nil, gorm.ErrInvalidDB
+	}
+	var deadLetter models.DeadLetter
+	err := r.DB.First(&deadLetter, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &deadLetter, nil
+}
+func (r *deadLetterRepository) UpdateDeadLetter(id uint, deadLetter models.DeadLetter) (*models.DeadLetter, error) {
+	if r.DB == nil {
+		return nil, gorm.ErrInvalidDB
+	}
+	var existingDeadLetter models.DeadLetter
+	err := r.DB.First(&existingDeadLetter, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	err = r.DB.Model(&existingDeadLetter).Updates(deadLetter).Error
+	if err != nil {
+		return nil, err
+	}
+	return &existingDeadLetter, nil
+}
+
+// ClaimDeadLetter moves a pending dead letter to reprocessing in a single conditional update,
+// so of two concurrent retries only one claims it. It returns false when the dead letter was not pending.
+func (r *deadLetterRepository) ClaimDeadLetter(id uint) (bool, error) {
+	if r.DB == nil {
+		return false, gorm.ErrInvalidDB
+	}
+	result := r.DB.Model(&models.DeadLetter{}).
+		Where("id = ? AND status = ?", id, models.DeadLetterPending).
+		Update("status", models.DeadLetterReprocessing)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+func (r *deadLetterRepository) DeleteDeadLetter(id uint) error {
+	if r.DB == nil {
+		return gorm.ErrInvalidDB
+	}
+	err := r.DB.Where("id = ?", id).First(&models.DeadLetter{}).Error
+	if err != nil {
+		return err
+	}
+	err = r.DB.Delete(&models.DeadLetter{}, "id = ?", id).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package detect
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// streamEvent is one published payload kept in a stream's backlog
+type streamEvent struct {
+	ID         string
+	Seq        uint64
+	CameraID   uuid.UUID
+	DroneID    string
+	Data       []byte
+	InlineData []byte // detection variant with the image embedded, if one was built
+}
+
+// payload returns the event variant for a subscriber's image mode
+func (e streamEvent) payload(inlineImages bool) []byte {
+	if inlineImages && e.InlineData != nil {
+		return e.InlineData
+	}
+	return e.Data
+}
+
+// streamFilter selects which events a subscriber receives
+type streamFilter struct {
+	CameraID uuid.UUID // uuid.Nil matches every camera
+	DroneID  string    // empty matches every drone
+}
+
+func (f streamFilter) matches(event streamEvent) bool {
+	if f.CameraID != uuid.Nil && f.CameraID != event.CameraID {
+		return false
+	}
+	if f.DroneID != "" && f.DroneID != event.DroneID {
+		return false
+	}
+	return true
+}
+
+// eventSubscriber receives live events; events is closed when the subscriber falls behind
+type eventSubscriber struct {
+	filter       streamFilter
+	inlineImages bool
+	events       chan streamEvent
+}
+
+// eventStream fans out hub payloads to SSE subscribers and keeps a backlog for resumption.
+// Event IDs are "<epoch>-<seq>" so IDs from before a restart are recognised and not mistaken for newer ones.
+type eventStream struct {
+	epoch       string
+	seq         uint64
+	backlog     []streamEvent
+	subscribers map[*eventSubscriber]bool
+	mutex       sync.Mutex
+}
+
+// Streams mirrored from the detection hub and the attack hub
+var (
+	detectionEvents = newEventStream()
+	attackEvents    = newEventStream()
+)
+
+func newEventStream() *eventStream {
+	return &eventStream{
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		subscribers: make(map[*eventSubscriber]bool),
+	}
+}
+
+// publish assigns the next event ID, stores the event in the backlog and delivers it to matching subscribers
+func (s *eventStream) publish(data, inlineData []byte, cameraID uuid.UUID, droneID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.seq++
+	event := streamEvent{
+		ID:         fmt.Sprintf("%s-%d", s.epoch, s.seq),
+		Seq:        s.seq,
+		CameraID:   cameraID,
+		DroneID:    droneID,
+		Data:       data,
+		InlineData: inlineData,
+	}
+
+	s.backlog = append(s.backlog, event)
+	if size := settings().SSEBacklogSize; len(s.backlog) > size {
+		// Copy so the dropped events can be garbage collected
+		s.backlog = append([]streamEvent(nil), s.backlog[len(s.backlog)-size:]...)
+	}
+
+	for subscriber := range s.subscribers {
+		if !subscriber.filter.matches(event) {
+			continue
+		}
+		select {
+		case subscriber.events <- event:
+		default:
+			// Slow subscriber, close it so the client reconnects and resumes from the backlog
+			delete(s.subscribers, subscriber)
+			close(subscriber.events)
+		}
+	}
+}
+
+// subscribe registers a subscriber and returns the backlog events after lastEventID.
+// Registration and backlog lookup happen under one lock so no event is missed or repeated.
+func (s *eventStream) subscribe(filter streamFilter, inlineImages bool, lastEventID string) (*eventSubscriber, []streamEvent) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var replay []streamEvent
+	if lastEventID != "" {
+		after := uint64(0)
+		epoch, seq, found := strings.Cut(lastEventID, "-")
+		if found && epoch == s.epoch {
+			after, _ = strconv.ParseUint(seq, 10, 64)
+		}
+		// An ID from another epoch predates a restart, replay everything still held
+		for _, event := range s.backlog {
+			if event.Seq > after && filter.matches(event) {
+				replay = append(replay, event)
+			}
+		}
+	}
+
+	subscriber := &eventSubscriber{
+		filter:       filter,
+		inlineImages: inlineImages,
+		events:       make(chan streamEvent, 64),
+	}
+	s.subscribers[subscriber] = true
+	return subscriber, replay
+}
+
+// unsubscribe removes a subscriber if the stream has not already dropped it
+func (s *eventStream) unsubscribe(subscriber *eventSubscriber) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.subscribers[subscriber]; ok {
+		delete(s.subscribers, subscriber)
+		close(subscriber.events)
+	}
+}
+
+// hasInlineSubscribers reports whether any subscriber asked for embedded images
+func (s *eventStream) hasInlineSubscribers() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for subscriber := range s.subscribers {
+		if subscriber.inlineImages {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package detect
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// BufferedFrame is a decoded video frame kept in a camera's rolling buffer
+type BufferedFrame struct {
+	Data       []byte    // JPEG bytes
+	Timestamp  float64   // Unix timestamp reported by the source
+	ReceivedAt time.Time // Server time when the frame arrived
+}
+
+// FrameBuffer keeps the last few seconds of frames for one camera
+type FrameBuffer struct {
+	frames []BufferedFrame
+	window time.Duration
+	mutex  sync.RWMutex
+}
+
+// Per-camera rolling frame buffers
+var (
+	frameBuffers      = make(map[uuid.UUID]*FrameBuffer)
+	frameBuffersMutex sync.Mutex
+)
+
+// NewFrameBuffer creates a buffer that keeps frames received within the given window
+func NewFrameBuffer(window time.Duration) *FrameBuffer {
+	return &FrameBuffer{window: window}
+}
+
+// frameBufferWindow returns the configured buffer length, long enough to hold a whole clip
+func frameBufferWindow() time.Duration {
+	config := settings()
+	window := config.BufferWindow
+	if clipWindow := config.ClipPreRoll + config.ClipPostRoll + time.Second; window < clipWindow {
+		window = clipWindow
+	}
+	return window
+}
+
+// getFrameBuffer returns the frame buffer for a camera, creating it on first use
+func getFrameBuffer(cameraID uuid.UUID) *FrameBuffer {
+	frameBuffersMutex.Lock()
+	defer frameBuffersMutex.Unlock()
+
+	buffer, ok := frameBuffers[cameraID]
+	if !ok {
+		buffer = NewFrameBuffer(frameBufferWindow())
+		frameBuffers[cameraID] = buffer
+	}
+	return buffer
+}
+
+// lookupFrameBuffer returns the frame buffer for a camera without creating one
+func lookupFrameBuffer(cameraID uuid.UUID) (*FrameBuffer, bool) {
+	frameBuffersMutex.Lock()
+	defer frameBuffersMutex.Unlock()
+
+	buffer, ok := frameBuffers[cameraID]
+	return buffer, ok
+}
+
+// Add appends a frame and drops frames that fell out of the window
+func (b *FrameBuffer) Add(frame BufferedFrame) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.frames = append(b.frames, frame)
+
+	cutoff := frame.ReceivedAt.Add(-b.window)
+	drop := 0
+	for drop < len(b.frames) && b.frames[drop].ReceivedAt.Before(cutoff) {
+		drop++
+	}
+	if drop > 0 {
+		// Copy so the dropped frames can be garbage collected
+		b.frames = append([]BufferedFrame(nil), b.frames[drop:]...)
+	}
+}
+
+// Range returns the frames received between from and to (inclusive), oldest first
+func (b *FrameBuffer) Range(from, to time.Time) []BufferedFrame {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	var frames []BufferedFrame
+	for _, frame := range b.frames {
+		if frame.ReceivedAt.Before(from) || frame.ReceivedAt.After(to) {
+			continue
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+// Latest returns the newest frame in the buffer
+func (b *FrameBuffer) Latest() (BufferedFrame, bool) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if len(b.frames) == 0 {
+		return BufferedFrame{}, false
+	}
+	return b.frames[len(b.frames)-1], true
+}
+
+// Closest returns the frame captured nearest to at, by source timestamp when the source sent one
+func (b *FrameBuffer) Closest(at time.Time) (BufferedFrame, time.Duration, bool) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	var closest BufferedFrame
+	var offset time.Duration
+	found := false
+	for _, frame := range b.frames {
+		frameOffset := snapshotTime(frame).Sub(at)
+		if !found || frameOffset.Abs() < offset.Abs() {
+			closest, offset, found = frame, frameOffset, true
+		}
+	}
+	return closest, offset, found
+}
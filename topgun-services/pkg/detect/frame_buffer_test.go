@@ -0,0 +1,90 @@
+package detect_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"topgun-services/pkg/detect"
+)
+
+type Test struct {
+	TestName string
+	Func     func() error
+}
+
+func TestFrameBuffer(t *testing.T) {
+	start := time.Now()
+	buffer := detect.NewFrameBuffer(2 * time.Second)
+	for i := 0; i < 50; i++ {
+		buffer.Add(detect.BufferedFrame{
+			Data:       []byte{byte(i)},
+			Timestamp:  float64(start.Unix()) + float64(i)*0.1,
+			ReceivedAt: start.Add(time.Duration(i) * 100 * time.Millisecond),
+		})
+	}
+	newest := start.Add(49 * 100 * time.Millisecond)
+
+	tests := []Test{
+		{
+			TestName: "Latest",
+			Func: func() error {
+				frame, ok := buffer.Latest()
+				if !ok {
+					return errors.New("expected a latest frame")
+				}
+				if frame.Data[0] != 49 {
+					return fmt.Errorf("expected latest frame 49, got %d", frame.Data[0])
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "DropsFramesOutsideWindow",
+			Func: func() error {
+				frames := buffer.Range(start, newest)
+				// 2 seconds at 10 fps plus the frame on the boundary
+				if len(frames) != 21 {
+					return fmt.Errorf("expected 21 frames in window, got %d", len(frames))
+				}
+				if frames[0].Data[0] != 29 {
+					return fmt.Errorf("expected oldest frame 29, got %d", frames[0].Data[0])
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "RangeIsOrderedAndBounded",
+			Func: func() error {
+				frames := buffer.Range(newest.Add(-500*time.Millisecond), newest.Add(-200*time.Millisecond))
+				if len(frames) != 4 {
+					return fmt.Errorf("expected 4 frames, got %d", len(frames))
+				}
+				for i := 1; i < len(frames); i++ {
+					if frames[i].ReceivedAt.Before(frames[i-1].ReceivedAt) {
+						return errors.New("frames are not ordered oldest first")
+					}
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "EmptyBuffer",
+			Func: func() error {
+				if _, ok := detect.NewFrameBuffer(time.Second).Latest(); ok {
+					return errors.New("expected no frame in empty buffer")
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			if err := test.Func(); err != nil {
+				t.Errorf("Test %s failed with error: %v", test.TestName, err)
+			}
+		})
+	}
+}
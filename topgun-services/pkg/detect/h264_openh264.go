@@ -0,0 +1,121 @@
+//go:build openh264 && cgo
+
+package detect
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"sync/atomic"
+
+	"github.com/pion/mediadevices/pkg/codec"
+	"github.com/pion/mediadevices/pkg/codec/openh264"
+	"github.com/pion/mediadevices/pkg/io/video"
+	"github.com/pion/mediadevices/pkg/prop"
+)
+
+// h264Available reports whether this build can publish cameras as WebRTC video
+const h264Available = true
+
+// h264Encoder encodes the frames of one viewer with openh264. The encoder is created for the size of
+// the first frame and created again when the size changes, which restarts the stream with a key frame.
+type h264Encoder struct {
+	bitrate int
+	fps     float64
+
+	bounds   image.Rectangle
+	next     image.Image
+	encoder  codec.ReadCloser
+	keyFrame atomic.Bool
+}
+
+func newH264Encoder(bitrate int, fps float64) *h264Encoder {
+	return &h264Encoder{bitrate: bitrate, fps: fps}
+}
+
+// Encode returns the H.264 access unit of a frame in Annex B format, empty when the rate control skips it
+func (e *h264Encoder) Encode(img image.Image) ([]byte, error) {
+	img = evenFrame(img)
+	bounds := img.Bounds()
+	if bounds.Dx() < 16 || bounds.Dy() < 16 {
+		return nil, fmt.Errorf("frame of %dx%d is too small to encode", bounds.Dx(), bounds.Dy())
+	}
+
+	if e.encoder == nil || bounds != e.bounds {
+		if err := e.open(bounds); err != nil {
+			return nil, err
+		}
+	}
+	if e.keyFrame.Swap(false) {
+		if controller, ok := e.encoder.Controller().(codec.KeyFrameController); ok {
+			controller.ForceKeyFrame()
+		}
+	}
+
+	e.next = img
+	accessUnit, release, err := e.encoder.Read()
+	release()
+	e.next = nil
+	return accessUnit, err
+}
+
+// ForceKeyFrame makes the next encoded frame an IDR picture, safe to call from any goroutine
+func (e *h264Encoder) ForceKeyFrame() {
+	e.keyFrame.Store(true)
+}
+
+func (e *h264Encoder) Close() {
+	if e.encoder != nil {
+		e.encoder.Close()
+		e.encoder = nil
+	}
+}
+
+func (e *h264Encoder) open(bounds image.Rectangle) error {
+	e.Close()
+
+	params, err := openh264.NewParams()
+	if err != nil {
+		return err
+	}
+	params.BitRate = e.bitrate
+	fps := e.fps
+	if fps <= 0 {
+		fps = 30
+	}
+	frames := video.ReaderFunc(func() (image.Image, func(), error) {
+		if e.next == nil {
+			return nil, func() {}, errors.New("no frame to encode")
+		}
+		return e.next, func() {}, nil
+	})
+	encoder, err := params.BuildVideoEncoder(frames, prop.Media{
+		Video: prop.Video{Width: bounds.Dx(), Height: bounds.Dy(), FrameRate: float32(fps)},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create H.264 encoder: %w", err)
+	}
+	e.encoder = encoder
+	e.bounds = bounds
+	return nil
+}
+
+// evenFrame returns the frame as a YCbCr or RGBA image at the origin with an even width and height,
+// as I420 needs, dropping an odd last row or column
+func evenFrame(img image.Image) image.Image {
+	bounds := img.Bounds()
+	even := image.Rect(0, 0, bounds.Dx()&^1, bounds.Dy()&^1)
+	if ycbcr, ok := img.(*image.YCbCr); ok && bounds.Min == (image.Point{}) {
+		switch ycbcr.SubsampleRatio {
+		case image.YCbCrSubsampleRatio420, image.YCbCrSubsampleRatio422, image.YCbCrSubsampleRatio444:
+			if bounds == even {
+				return ycbcr
+			}
+			return ycbcr.SubImage(even)
+		}
+	}
+	rgba := image.NewRGBA(even)
+	draw.Draw(rgba, even, img, bounds.Min, draw.Src)
+	return rgba
+}
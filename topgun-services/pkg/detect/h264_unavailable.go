@@ -0,0 +1,24 @@
+//go:build !openh264 || !cgo
+
+package detect
+
+import "image"
+
+// h264Available reports whether this build can publish cameras as WebRTC video,
+// the encoder needs cgo and the openh264 build tag
+const h264Available = false
+
+// h264Encoder stands in for the openh264 encoder in builds without it
+type h264Encoder struct{}
+
+func newH264Encoder(bitrate int, fps float64) *h264Encoder {
+	return &h264Encoder{}
+}
+
+func (e *h264Encoder) Encode(img image.Image) ([]byte, error) {
+	return nil, errH264Unavailable
+}
+
+func (e *h264Encoder) ForceKeyFrame() {}
+
+func (e *h264Encoder) Close() {}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 	"topgun-services/pkg/domain"
 	"topgun-services/pkg/models"
 
@@ -24,12 +25,18 @@ func NewDetectHandler(router fiber.Router, service domain.DetectService) {
 	router.Get("/ws", WebSocketUpgrade(), h.HandleWebSocket())
 	router.Get("/attack-ws", WebSocketUpgrade(), h.HandleAttackWebSocket())
 
+	// Server-Sent Events routes
+	router.Get("/events", h.HandleDetectionEvents())
+	router.Get("/attack-events", h.HandleAttackEvents())
+
 	// HTTP routes
 	router.Post("/", h.CreateDetect())
 	router.Get("/", h.GetDetects())
 	router.Post("/by-cameras", h.GetDetectsByCameras())
 	router.Get("/:id", h.GetDetect())
 	router.Get("/:id/file", h.GetDetectFile())
+	router.Get("/:id/image", h.GetDetectImage())
+	router.Get("/:id/clip", h.GetDetectClip())
 	router.Put("/:id", h.UpdateDetect())
 	router.Delete("/:id", h.DeleteDetect())
 }
@@ -482,6 +489,183 @@ func (h *detectHandler) GetDetectFile() fiber.Handler {
 	}
 }
 
+// @Summary GetDetectImage
+// @Tags Detect
+// @Description Get a detect image through the signed, short-lived URL sent in detection broadcasts
+// @Produce image/jpeg
+// @Param id path string true "Detect ID"
+// @Param variant query string true "original or thumbnail"
+// @Param expires query int true "Expiry as a Unix timestamp"
+// @Param sig query string true "URL signature"
+// @Router /api/v1/detect/{id}/image [get]
+func (h *detectHandler) GetDetectImage() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		idParam := c.Params("id")
+		var id uint
+		_, err := fmt.Sscan(idParam, &id)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusBadRequest,
+						Title:   "Invalid detect ID",
+						Message: err.Error(),
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+
+		variant := c.Query("variant", imageVariantOriginal)
+		expires := int64(c.QueryInt("expires", 0))
+		if err := verifyImageSignature(id, variant, expires, c.Query("sig"), time.Now()); err != nil {
+			return c.Status(fiber.StatusForbidden).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusForbidden,
+						Title:   "Invalid image URL",
+						Message: err.Error(),
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+
+		detect, err := h.service.GetDetectFile(id)
+		if err != nil || detect.Path == "" {
+			return c.Status(fiber.StatusNotFound).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusNotFound,
+						Title:   "Image not found",
+						Message: "No image associated with this detect",
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+
+		// The URL is only valid until it expires, so caches must not keep it longer
+		c.Set(fiber.HeaderCacheControl, fmt.Sprintf("private, max-age=%d", expires-time.Now().Unix()))
+
+		if variant == imageVariantThumbnail {
+			thumbnail, err := encodeThumbnail(detect.Path)
+			if err != nil {
+				return c.Status(fiber.StatusNotFound).JSON(helpers.ResponseForm{
+					Success: false,
+					Errors: []helpers.ResponseError{
+						{
+							Code:    fiber.StatusNotFound,
+							Title:   "Image not found",
+							Message: err.Error(),
+							Source:  helpers.WhereAmI(),
+						},
+					},
+				})
+			}
+			c.Set(fiber.HeaderContentType, "image/jpeg")
+			return c.Send(thumbnail)
+		}
+
+		if _, err := os.Stat(detect.Path); os.IsNotExist(err) {
+			return c.Status(fiber.StatusNotFound).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusNotFound,
+						Title:   "Image not found",
+						Message: "File does not exist on server",
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+		c.Set(fiber.HeaderContentType, getMimeType(detect.Path))
+		return c.SendFile(detect.Path)
+	}
+}
+
+// @Summary GetDetectClip
+// @Tags Detect
+// @Description Download the motion clip (zip of JPEG frames with metadata.json) recorded around a detect
+// @Produce application/zip
+// @Param id path string true "Detect ID"
+// @Router /api/v1/detect/{id}/clip [get]
+// @Security ApiKeyAuth
+func (h *detectHandler) GetDetectClip() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		idParam := c.Params("id")
+		var id uint
+		_, err := fmt.Sscan(idParam, &id)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusBadRequest,
+						Title:   "Invalid detect ID",
+						Message: err.Error(),
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+
+		detect, err := h.service.GetDetect(id)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusNotFound,
+						Title:   "Detect not found",
+						Message: err.Error(),
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+
+		// Clip is written after the post-roll window, so it may not exist yet
+		if detect.ClipPath == "" || !insideClipDir(detect.ClipPath) {
+			return c.Status(fiber.StatusNotFound).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusNotFound,
+						Title:   "Clip not found",
+						Message: "No clip associated with this detect",
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+
+		if _, err := os.Stat(detect.ClipPath); os.IsNotExist(err) {
+			return c.Status(fiber.StatusNotFound).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusNotFound,
+						Title:   "Clip not found",
+						Message: "Clip does not exist on server",
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+
+		filename := filepath.Base(detect.ClipPath)
+		c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+		c.Set("Content-Type", "application/zip")
+
+		return c.SendFile(detect.ClipPath)
+	}
+}
+
 // @Summary UpdateDetect
 // @Tags Detect
 // @Description Update an existing detect
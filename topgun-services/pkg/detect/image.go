@@ -0,0 +1,42 @@
+package detect
+
+import (
+	"image"
+
+	"github.com/nfnt/resize"
+)
+
+// scaleToFit resizes the image to fit within maxWidth x maxHeight while maintaining aspect ratio.
+// A zero bound is treated as unlimited. Images that already fit are returned unchanged.
+func scaleToFit(img image.Image, maxWidth, maxHeight uint, interp resize.InterpolationFunction) image.Image {
+	bounds := img.Bounds()
+	width := uint(bounds.Dx())
+	height := uint(bounds.Dy())
+
+	targetWidth, targetHeight := fitDimensions(width, height, maxWidth, maxHeight)
+	if targetWidth == width && targetHeight == height {
+		return img
+	}
+	return resize.Resize(targetWidth, targetHeight, img, interp)
+}
+
+// fitDimensions returns the size scaleToFit produces for a width x height image,
+// rounded the same way resize calculates a missing side
+func fitDimensions(width, height, maxWidth, maxHeight uint) (uint, uint) {
+	if width == 0 || height == 0 {
+		return width, height
+	}
+	if (maxWidth == 0 || width <= maxWidth) && (maxHeight == 0 || height <= maxHeight) {
+		return width, height
+	}
+
+	switch {
+	case maxHeight == 0,
+		maxWidth != 0 && float64(width)/float64(height) > float64(maxWidth)/float64(maxHeight):
+		// Width is the limiting factor
+		return maxWidth, uint(0.7 + float64(height)*float64(maxWidth)/float64(width))
+	default:
+		// Height is the limiting factor
+		return uint(0.7 + float64(width)*float64(maxHeight)/float64(height)), maxHeight
+	}
+}
@@ -0,0 +1,136 @@
+package detect
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	// Register decoders for image.Decode
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/nfnt/resize"
+)
+
+// Image variants served by the signed image route
+const (
+	imageVariantOriginal  = "original"
+	imageVariantThumbnail = "thumbnail"
+)
+
+// Image delivery modes a detection subscriber can choose
+const (
+	imageModeURL    = "url"    // signed short-lived URLs, the default
+	imageModeInline = "inline" // base64 image embedded in every message
+)
+
+var (
+	imageURLSecret     []byte
+	imageURLSecretOnce sync.Once
+)
+
+// parseImageMode validates a subscriber's image mode, empty selects URLs
+func parseImageMode(mode string) (bool, error) {
+	switch strings.ToLower(mode) {
+	case "", imageModeURL:
+		return false, nil
+	case imageModeInline:
+		return true, nil
+	default:
+		return false, fmt.Errorf("image_mode must be %s or %s, got %q", imageModeURL, imageModeInline, mode)
+	}
+}
+
+// imageSigningKey returns realtime.image_url.secret, or a random per-process key when unset.
+// A random key only verifies on the instance that signed the URL, so the server refuses to
+// start the backplane without a secret.
+func imageSigningKey() []byte {
+	imageURLSecretOnce.Do(func() {
+		if secret := settings().ImageURLSecret; secret != "" {
+			imageURLSecret = []byte(secret)
+			return
+		}
+		imageURLSecret = make([]byte, 32)
+		if _, err := rand.Read(imageURLSecret); err != nil {
+			log.Panicf("failed to generate image URL secret: %v", err)
+		}
+		log.Println("realtime.image_url.secret is not set, image URLs are only valid on this instance")
+	})
+	return imageURLSecret
+}
+
+func imageSignature(detectID uint, variant string, expires int64) string {
+	mac := hmac.New(sha256.New, imageSigningKey())
+	fmt.Fprintf(mac, "%d:%s:%d", detectID, variant, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signImageURL returns a URL for the detection image that expires at the given time
+func signImageURL(detectID uint, variant string, expiresAt time.Time) string {
+	query := url.Values{}
+	query.Set("variant", variant)
+	query.Set("expires", fmt.Sprint(expiresAt.Unix()))
+	query.Set("sig", imageSignature(detectID, variant, expiresAt.Unix()))
+
+	baseURL := settings().ImageURLBaseURL
+	return fmt.Sprintf("%s/api/v1/detect/%d/image?%s", baseURL, detectID, query.Encode())
+}
+
+// verifyImageSignature checks a signed image URL, it fails once the URL has expired
+func verifyImageSignature(detectID uint, variant string, expires int64, signature string, now time.Time) error {
+	if now.Unix() > expires {
+		return fmt.Errorf("image URL expired")
+	}
+	expected := imageSignature(detectID, variant, expires)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return fmt.Errorf("invalid image URL signature")
+	}
+	return nil
+}
+
+// imageDimensions reads the size of an image file from its header without decoding the pixels
+func imageDimensions(path string) (int, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	return config.Width, config.Height, nil
+}
+
+// encodeThumbnail scales an image file to the thumbnail size and encodes it as JPEG
+func encodeThumbnail(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	size := settings().ThumbnailSize
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleToFit(img, size, size, resize.Bilinear), &jpeg.Options{Quality: defaultRenditionQuality}); err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+	return buf.Bytes(), nil
+}
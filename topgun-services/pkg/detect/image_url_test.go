@@ -0,0 +1,185 @@
+package detect_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"topgun-services/pkg/detect"
+	"topgun-services/pkg/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestDetectionImageURLs(t *testing.T) {
+	config := detect.DefaultConfig()
+	config.SSEHeartbeat = 50 * time.Millisecond
+	config.ClipEnabled = false
+	detect.SetConfig(config)
+	defer detect.SetConfig(detect.DefaultConfig())
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.Camera{}, &models.Detect{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	imagePath := filepath.Join(t.TempDir(), "detect.jpg")
+	file, err := os.Create(imagePath)
+	if err != nil {
+		t.Fatalf("failed to create image: %v", err)
+	}
+	if err := jpeg.Encode(file, image.NewRGBA(image.Rect(0, 0, 800, 600)), nil); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+	file.Close()
+
+	repository := detect.NewDetectRepository(db)
+	service := detect.NewDetectService(repository)
+	cameraID := uuid.New()
+	savedDetect, err := repository.CreateDetect(models.Detect{CameraID: cameraID, Path: imagePath})
+	if err != nil {
+		t.Fatalf("failed to create detect: %v", err)
+	}
+
+	app := fiber.New()
+	detect.NewDetectHandler(app.Group("/api/v1/detect"), service)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go app.Listener(listener)
+	defer app.Shutdown()
+	baseURL := fmt.Sprintf("http://%s", listener.Addr())
+
+	// Subscribe in both modes, then broadcast once
+	urlStream, urlReader, err := openEventStream(fmt.Sprintf("%s/api/v1/detect/events?camera_id=%s", baseURL, cameraID), "")
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	defer urlStream.Body.Close()
+	inlineStream, inlineReader, err := openEventStream(fmt.Sprintf("%s/api/v1/detect/events?camera_id=%s&image_mode=inline", baseURL, cameraID), "")
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	defer inlineStream.Body.Close()
+
+	detect.BroadcastDetection(savedDetect)
+
+	var urlMessage detect.DetectionMessage
+	fetchImage := func(url string) (image.Image, int, error) {
+		resp, err := http.Get(baseURL + url)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != fiber.StatusOK {
+			return nil, resp.StatusCode, nil
+		}
+		img, err := jpeg.Decode(resp.Body)
+		return img, resp.StatusCode, err
+	}
+
+	tests := []Test{
+		{
+			TestName: "URLModeCarriesSignedURLs",
+			Func: func() error {
+				events, err := readSSEEvents(urlReader, 1, 2*time.Second)
+				if err != nil {
+					return err
+				}
+				if err := json.Unmarshal([]byte(events[0].Data), &urlMessage); err != nil {
+					return err
+				}
+				if urlMessage.ImageData != "" {
+					return errors.New("URL mode message embeds the image")
+				}
+				if urlMessage.ImageURL == "" || urlMessage.ThumbnailURL == "" {
+					return errors.New("URL mode message has no image URLs")
+				}
+				if urlMessage.ImageWidth != 800 || urlMessage.ImageHeight != 600 {
+					return fmt.Errorf("expected image 800x600, got %dx%d", urlMessage.ImageWidth, urlMessage.ImageHeight)
+				}
+				if urlMessage.ThumbnailWidth != 320 || urlMessage.ThumbnailHeight != 240 {
+					return fmt.Errorf("expected thumbnail 320x240, got %dx%d", urlMessage.ThumbnailWidth, urlMessage.ThumbnailHeight)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "InlineModeEmbedsImage",
+			Func: func() error {
+				events, err := readSSEEvents(inlineReader, 1, 2*time.Second)
+				if err != nil {
+					return err
+				}
+				var message detect.DetectionMessage
+				if err := json.Unmarshal([]byte(events[0].Data), &message); err != nil {
+					return err
+				}
+				if message.ImageData == "" || message.MimeType != "image/jpeg" {
+					return errors.New("inline mode message has no image data")
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "FetchImageAndThumbnail",
+			Func: func() error {
+				img, status, err := fetchImage(urlMessage.ImageURL)
+				if err != nil || status != fiber.StatusOK {
+					return fmt.Errorf("image request failed with status %d: %v", status, err)
+				}
+				if img.Bounds().Dx() != 800 {
+					return fmt.Errorf("expected original width 800, got %d", img.Bounds().Dx())
+				}
+
+				thumbnail, status, err := fetchImage(urlMessage.ThumbnailURL)
+				if err != nil || status != fiber.StatusOK {
+					return fmt.Errorf("thumbnail request failed with status %d: %v", status, err)
+				}
+				if thumbnail.Bounds().Dx() != urlMessage.ThumbnailWidth || thumbnail.Bounds().Dy() != urlMessage.ThumbnailHeight {
+					return fmt.Errorf("thumbnail is %dx%d, message announced %dx%d",
+						thumbnail.Bounds().Dx(), thumbnail.Bounds().Dy(), urlMessage.ThumbnailWidth, urlMessage.ThumbnailHeight)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "RejectTamperedURL",
+			Func: func() error {
+				tampered := strings.Replace(urlMessage.ImageURL, "variant=original", "variant=thumbnail", 1)
+				_, status, err := fetchImage(tampered)
+				if err != nil {
+					return err
+				}
+				if status != fiber.StatusForbidden {
+					return fmt.Errorf("expected status 403, got %d", status)
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			if err := test.Func(); err != nil {
+				t.Errorf("Test %s failed with error: %v", test.TestName, err)
+			}
+		})
+	}
+}
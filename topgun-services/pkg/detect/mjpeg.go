@@ -0,0 +1,212 @@
+package detect
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	helpers "github.com/zercle/gofiber-helpers"
+)
+
+const mjpegBoundary = "frame"
+
+// How often the last frame is re-sent while the camera is idle, so dead viewers are noticed
+const mjpegKeepAliveInterval = 5 * time.Second
+
+type cameraStreamHandler struct{}
+
+// NewCameraStreamHandler registers HTTP video routes under the camera group
+func NewCameraStreamHandler(router fiber.Router) {
+	handler := &cameraStreamHandler{}
+	router.Get("/:id/stream.mjpg", handler.GetMJPEGStream())
+	router.Get("/:id/snapshot", handler.GetSnapshot())
+	router.Get("/:id/playback", WebSocketUpgrade(), handler.HandlePlayback())
+	router.Get("/:id/playback.mjpeg", handler.ExportPlayback())
+}
+
+// @Summary GetMJPEGStream
+// @Tags Camera
+// @Description Stream live frames of a camera as multipart/x-mixed-replace (MJPEG)
+// @Produce multipart/x-mixed-replace
+// @Param id path string true "Camera ID"
+// @Param fps query number false "Maximum frames per second (0 = unlimited)"
+// @Param resolution query string false "Maximum resolution as WIDTHxHEIGHT or WIDTH, e.g. 640x360"
+// @Param quality query string false "Rendition preset: low, medium, high or original"
+// @Router /api/v1/camera/{id}/stream.mjpg [get]
+// @Security ApiKeyAuth
+func (h *cameraStreamHandler) GetMJPEGStream() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		cameraID, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusBadRequest,
+						Title:   "Invalid camera ID",
+						Message: err.Error(),
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+
+		fps := c.QueryFloat("fps", 0)
+		if fps < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusBadRequest,
+						Title:   "Invalid fps",
+						Message: "fps must be zero or a positive number",
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+
+		maxWidth, maxHeight, err := parseResolution(c.Query("resolution"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusBadRequest,
+						Title:   "Invalid resolution",
+						Message: err.Error(),
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+
+		rendition, err := parseRendition(c.Query("quality"), "", "")
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusBadRequest,
+						Title:   "Invalid quality",
+						Message: err.Error(),
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+		// An explicit resolution overrides the preset size
+		if maxWidth > 0 || maxHeight > 0 {
+			rendition.MaxWidth = maxWidth
+			rendition.MaxHeight = maxHeight
+			if rendition.Quality == 0 {
+				rendition.Quality = defaultRenditionQuality
+			}
+		}
+
+		client := newVideoClient(nil, c.IP(), cameraID, true, rendition, fps)
+		if userID, ok := c.Locals("user_id").(string); ok {
+			client.userID = userID
+		}
+		videoHub.register <- client
+
+		c.Set(fiber.HeaderContentType, "multipart/x-mixed-replace; boundary="+mjpegBoundary)
+		c.Set(fiber.HeaderCacheControl, "no-cache, no-store, must-revalidate")
+		c.Set("Pragma", "no-cache")
+		c.Set(fiber.HeaderConnection, "close")
+
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer func() {
+				videoHub.unregister <- client
+			}()
+
+			keepAlive := time.NewTicker(mjpegKeepAliveInterval)
+			defer keepAlive.Stop()
+
+			// Fires when the fps limit allows the pending frame to be sent
+			var throttle <-chan time.Time
+			var lastFrame []byte
+			var lastSent time.Time
+			for {
+				select {
+				case <-client.notify:
+				case <-throttle:
+				case <-client.done:
+					return
+				case <-keepAlive.C:
+					if lastFrame == nil || time.Since(lastSent) < mjpegKeepAliveInterval {
+						continue
+					}
+					if err := writeMJPEGPart(w, lastFrame); err != nil {
+						log.Printf("MJPEG client disconnected. Camera ID: %s", cameraID)
+						return
+					}
+					lastSent = time.Now()
+					continue
+				}
+
+				throttle = nil
+				frameData, wait := client.take(time.Now())
+				if wait > 0 {
+					throttle = time.After(wait)
+					continue
+				}
+				if frameData == nil {
+					continue
+				}
+				lastFrame = frameData
+
+				if err := writeMJPEGPart(w, lastFrame); err != nil {
+					log.Printf("MJPEG client disconnected. Camera ID: %s", cameraID)
+					return
+				}
+				lastSent = time.Now()
+			}
+		})
+
+		return nil
+	}
+}
+
+// writeMJPEGPart writes one JPEG frame as a multipart part and flushes it to the client
+func writeMJPEGPart(w *bufio.Writer, frameData []byte) error {
+	if _, err := fmt.Fprintf(w, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", mjpegBoundary, len(frameData)); err != nil {
+		return err
+	}
+	if _, err := w.Write(frameData); err != nil {
+		return err
+	}
+	if _, err := w.WriteString("\r\n"); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// parseResolution parses "WIDTHxHEIGHT" or "WIDTH" into maximum dimensions.
+// An empty value means keep the original resolution.
+func parseResolution(resolution string) (uint, uint, error) {
+	if resolution == "" {
+		return 0, 0, nil
+	}
+
+	widthStr, heightStr, hasHeight := strings.Cut(strings.ToLower(resolution), "x")
+	width, err := strconv.ParseUint(widthStr, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("resolution must be WIDTHxHEIGHT or WIDTH, got %q", resolution)
+	}
+
+	var height uint64
+	if hasHeight {
+		height, err = strconv.ParseUint(heightStr, 10, 32)
+		if err != nil {
+			return 0, 0, fmt.Errorf("resolution must be WIDTHxHEIGHT or WIDTH, got %q", resolution)
+		}
+	}
+
+	return uint(width), uint(height), nil
+}
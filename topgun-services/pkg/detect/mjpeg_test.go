@@ -0,0 +1,306 @@
+package detect_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"topgun-services/pkg/detect"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// mjpegStream is an open stream.mjpg response
+type mjpegStream struct {
+	resp     *http.Response
+	reader   *bufio.Reader
+	boundary string
+}
+
+// openMJPEGStream requests a stream.mjpg URL. The response starts with the first frame,
+// prime is called until it arrives.
+func openMJPEGStream(url string, prime func()) (*mjpegStream, error) {
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(url)
+		results <- result{resp, err}
+	}()
+	var resp *http.Response
+	for resp == nil {
+		select {
+		case r := <-results:
+			if r.err != nil {
+				return nil, r.err
+			}
+			resp = r.resp
+		case <-time.After(50 * time.Millisecond):
+			prime()
+		}
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("expected status 200, got %d", resp.StatusCode)
+	}
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get(fiber.HeaderContentType))
+	if err != nil || mediaType != "multipart/x-mixed-replace" || params["boundary"] == "" {
+		resp.Body.Close()
+		return nil, fmt.Errorf("expected multipart/x-mixed-replace, got %q", resp.Header.Get(fiber.HeaderContentType))
+	}
+	return &mjpegStream{resp: resp, reader: bufio.NewReader(resp.Body), boundary: params["boundary"]}, nil
+}
+
+func (s *mjpegStream) Close() {
+	s.resp.Body.Close()
+}
+
+// waitViewerGone waits until the viewer of a camera is unregistered. A writer only notices a
+// closed connection when it writes, so prime is called meanwhile.
+func waitViewerGone(cameraID uuid.UUID, prime func()) error {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if _, ok := viewerOf(cameraID); !ok {
+			return nil
+		}
+		prime()
+	}
+	return errors.New("the viewer is still registered after disconnecting")
+}
+
+// next reads the next part by its Content-Length, the way MJPEG players do, giving up after timeout
+func (s *mjpegStream) next(timeout time.Duration) ([]byte, string, error) {
+	type result struct {
+		frame       []byte
+		contentType string
+		err         error
+	}
+	results := make(chan result, 1)
+	go func() {
+		var r result
+		r.frame, r.contentType, r.err = s.readPart()
+		results <- r
+	}()
+	select {
+	case r := <-results:
+		return r.frame, r.contentType, r.err
+	case <-time.After(timeout):
+		return nil, "", errors.New("no frame received")
+	}
+}
+
+func (s *mjpegStream) readPart() ([]byte, string, error) {
+	line, err := s.reader.ReadString('\n')
+	for err == nil && strings.TrimSpace(line) == "" {
+		line, err = s.reader.ReadString('\n')
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if strings.TrimSpace(line) != "--"+s.boundary {
+		return nil, "", fmt.Errorf("expected boundary, got %q", line)
+	}
+	header, err := textproto.NewReader(s.reader).ReadMIMEHeader()
+	if err != nil {
+		return nil, "", err
+	}
+	length, err := strconv.Atoi(header.Get(fiber.HeaderContentLength))
+	if err != nil {
+		return nil, "", fmt.Errorf("part without Content-Length: %w", err)
+	}
+	frame := make([]byte, length)
+	_, err = io.ReadFull(s.reader, frame)
+	return frame, header.Get(fiber.HeaderContentType), err
+}
+
+// testJPEG encodes a gradient image of the given size
+func testJPEG(width, height int) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, img, nil)
+	return buf.Bytes(), err
+}
+
+// viewerOf returns the stats of the first viewer of a camera
+func viewerOf(cameraID uuid.UUID) (detect.VideoViewerStats, bool) {
+	for _, viewer := range detect.GetVideoViewerStats() {
+		if viewer.CameraID != nil && *viewer.CameraID == cameraID {
+			return viewer, true
+		}
+	}
+	return detect.VideoViewerStats{}, false
+}
+
+func TestMJPEGStream(t *testing.T) {
+	app := fiber.New()
+	detect.NewCameraStreamHandler(app.Group("/camera"))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go app.Listener(listener)
+	defer app.Shutdown()
+	baseURL := fmt.Sprintf("http://%s/camera", listener.Addr())
+
+	source, err := testJPEG(64, 48)
+	if err != nil {
+		t.Fatalf("failed to encode test frame: %v", err)
+	}
+	broadcast := func(cameraID uuid.UUID, frame []byte) {
+		detect.BroadcastVideoFrame(&detect.VideoFrameMessage{
+			CameraID: cameraID,
+			Frame:    base64.StdEncoding.EncodeToString(frame),
+		})
+	}
+
+	tests := []Test{
+		{
+			TestName: "StreamsFramesAsMultipartJPEG",
+			Func: func() error {
+				cameraID := uuid.New()
+				stream, err := openMJPEGStream(fmt.Sprintf("%s/%s/stream.mjpg", baseURL, cameraID), func() { broadcast(cameraID, source) })
+				if err != nil {
+					return err
+				}
+				defer func() {
+					stream.Close()
+					waitViewerGone(cameraID, func() { broadcast(cameraID, source) })
+				}()
+
+				frame, contentType, err := stream.next(5 * time.Second)
+				if err != nil {
+					return err
+				}
+				if contentType != "image/jpeg" {
+					return fmt.Errorf("expected image/jpeg parts, got %q", contentType)
+				}
+				if !bytes.Equal(frame, source) {
+					return errors.New("the original rendition must pass the frame through unchanged")
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "OtherCamerasAreNotStreamed",
+			Func: func() error {
+				cameraID := uuid.New()
+				stream, err := openMJPEGStream(fmt.Sprintf("%s/%s/stream.mjpg", baseURL, cameraID), func() { broadcast(cameraID, source) })
+				if err != nil {
+					return err
+				}
+				defer func() {
+					stream.Close()
+					waitViewerGone(cameraID, func() { broadcast(cameraID, source) })
+				}()
+
+				other := []byte("frame of another camera")
+				broadcast(uuid.New(), other)
+				for {
+					frame, _, err := stream.next(300 * time.Millisecond)
+					if err != nil {
+						return nil
+					}
+					if bytes.Equal(frame, other) {
+						return errors.New("received a frame of another camera")
+					}
+				}
+			},
+		},
+		{
+			TestName: "ResolutionScalesFrames",
+			Func: func() error {
+				cameraID := uuid.New()
+				stream, err := openMJPEGStream(fmt.Sprintf("%s/%s/stream.mjpg?resolution=32x32", baseURL, cameraID), func() { broadcast(cameraID, source) })
+				if err != nil {
+					return err
+				}
+				defer func() {
+					stream.Close()
+					waitViewerGone(cameraID, func() { broadcast(cameraID, source) })
+				}()
+
+				frame, _, err := stream.next(5 * time.Second)
+				if err != nil {
+					return err
+				}
+				config, err := jpeg.DecodeConfig(bytes.NewReader(frame))
+				if err != nil {
+					return err
+				}
+				// 64x48 fits 32x32 at 32x24, the aspect ratio is kept
+				if config.Width != 32 || config.Height != 24 {
+					return fmt.Errorf("expected a 32x24 frame, got %dx%d", config.Width, config.Height)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "ViewerIsUnregisteredOnDisconnect",
+			Func: func() error {
+				cameraID := uuid.New()
+				stream, err := openMJPEGStream(fmt.Sprintf("%s/%s/stream.mjpg", baseURL, cameraID), func() { broadcast(cameraID, source) })
+				if err != nil {
+					return err
+				}
+				if viewer, ok := viewerOf(cameraID); !ok || viewer.Transport != "mjpeg" {
+					stream.Close()
+					return errors.New("the MJPEG viewer is not listed")
+				}
+				stream.Close()
+				return waitViewerGone(cameraID, func() { broadcast(cameraID, source) })
+			},
+		},
+		{
+			TestName: "InvalidParametersAreRejected",
+			Func: func() error {
+				cameraID := uuid.New()
+				for _, target := range []string{
+					"/camera/not-a-uuid/stream.mjpg",
+					fmt.Sprintf("/camera/%s/stream.mjpg?fps=-1", cameraID),
+					fmt.Sprintf("/camera/%s/stream.mjpg?resolution=wide", cameraID),
+					fmt.Sprintf("/camera/%s/stream.mjpg?quality=ultra", cameraID),
+				} {
+					resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, target, nil))
+					if err != nil {
+						return err
+					}
+					resp.Body.Close()
+					if resp.StatusCode != fiber.StatusBadRequest {
+						return fmt.Errorf("%s: expected status 400, got %d", target, resp.StatusCode)
+					}
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			if err := test.Func(); err != nil {
+				t.Errorf("Test %s failed with error: %v", test.TestName, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package detect_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"topgun-services/pkg/camera"
+	"topgun-services/pkg/detect"
+	"topgun-services/pkg/models"
+	"topgun-services/pkg/mqtt"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/uuid"
+)
+
+// notifyingDetectService reports every created detection, detections are created on the MQTT client goroutine
+type notifyingDetectService struct {
+	fakeDetectService
+	created chan models.Detect
+}
+
+func (s *notifyingDetectService) CreateDetect(detect models.Detect) (*models.Detect, error) {
+	created, err := s.fakeDetectService.CreateDetect(detect)
+	s.created <- *created
+	return created, err
+}
+
+// TestMQTTIngestThroughEmbeddedBroker runs the ingest path from a camera publish to a stored detection
+func TestMQTTIngestThroughEmbeddedBroker(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	cameraID := uuid.New()
+	cameras := &fakeCameraService{cameras: map[uuid.UUID]models.Camera{
+		cameraID: {ID: cameraID, Token: "camera-token"},
+	}}
+	broker, err := mqtt.NewBroker(mqtt.BrokerConfig{
+		Listeners:       []mqtt.ListenerConfig{{Type: mqtt.ListenerTCP, Address: address}},
+		ServiceUsername: "topgun-services",
+		ServicePassword: "service-secret",
+		Cameras:         camera.NewMQTTAuthenticator(cameras),
+		ACL:             mqtt.BrokerACL{Publish: []string{"topgun/ai/" + mqtt.CameraPlaceholder}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+
+	manager, err := mqtt.NewManager(mqtt.ConnectionConfig{
+		Broker:   "tcp://" + address,
+		ClientID: "topgun-services",
+		Username: "topgun-services",
+		Password: "service-secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Connect(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Disconnect()
+
+	detects := &notifyingDetectService{created: make(chan models.Detect, 1)}
+	deadLetters := &fakeDeadLetterRepository{deadLetters: make(map[uint]*models.DeadLetter)}
+	handler := detect.NewMQTTDetectHandler(detects, detect.NewMQTTCameraResolver("topgun/ai/+", cameras), deadLetters)
+	if err := detect.StartMQTTSubscription(manager, handler); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []Test{
+		{
+			TestName: "RejectsCameraWithoutToken",
+			Func: func() error {
+				opts := paho.NewClientOptions().AddBroker("tcp://" + address).SetClientID("pi-bad").
+					SetUsername(cameraID.String()).SetPassword("wrong-token").SetAutoReconnect(false)
+				client := paho.NewClient(opts)
+				token := client.Connect()
+				if token.WaitTimeout(5*time.Second) && token.Error() == nil {
+					client.Disconnect(0)
+					return fmt.Errorf("expected a wrong camera token to be rejected")
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "StoresDetectionPublishedByCamera",
+			Func: func() error {
+				opts := paho.NewClientOptions().AddBroker("tcp://" + address).SetClientID("pi-01").
+					SetUsername(cameraID.String()).SetPassword("camera-token").SetAutoReconnect(false)
+				client := paho.NewClient(opts)
+				if token := client.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+					return fmt.Errorf("camera failed to connect: %v", token.Error())
+				}
+				defer client.Disconnect(0)
+
+				payload := fmt.Sprintf(`{"schema_version":1,"device_id":"pi-01","message_id":"broker-1","payload":`+
+					`{"x":0.28,"y":0.76,"w":0.21,"h":0.46,"lat":14.3,"lon":101.1,"alt":43,"confidence":0.9,"track_id":5,"timestamp":%d}}`, time.Now().Unix())
+				if token := client.Publish("topgun/ai/"+cameraID.String(), 1, false, payload); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+					return fmt.Errorf("camera failed to publish: %v", token.Error())
+				}
+
+				select {
+				case stored := <-detects.created:
+					if stored.CameraID != cameraID || len(stored.Objects) != 1 {
+						return fmt.Errorf("unexpected detection %+v", stored)
+					}
+				case <-time.After(5 * time.Second):
+					return fmt.Errorf("detection was not stored, dead letters: %d", len(deadLetters.deadLetters))
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			if err := test.Func(); err != nil {
+				t.Errorf("Test %s failed with error: %v", test.TestName, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package detect
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"topgun-services/pkg/domain"
+	"topgun-services/pkg/models"
+	"topgun-services/pkg/mqtt"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Policies for detections from cameras that are not registered
+const (
+	UnknownCamerasReject   = "reject"   // drop the detection
+	UnknownCamerasRegister = "register" // create the camera and keep the detection
+)
+
+// How long a camera found in the database is trusted before it is looked up again
+const knownCameraTTL = time.Minute
+
+// MQTTCameraResolver maps an MQTT detection to its source camera.
+// The camera is taken from the topic level matching the first wildcard of the subscription pattern,
+// then from the camera_id of the payload, then from mqtt.camera_id for the bare topic.
+type MQTTCameraResolver struct {
+	pattern []string
+	level   int // topic level holding the camera, -1 when the pattern has no wildcard
+	cameras domain.CameraService
+
+	mutex sync.Mutex
+	known map[uuid.UUID]time.Time
+}
+
+// NewMQTTCameraResolver creates a resolver for the given subscription pattern.
+// Without a camera service every resolved camera is accepted.
+func NewMQTTCameraResolver(pattern string, cameras domain.CameraService) *MQTTCameraResolver {
+	return &MQTTCameraResolver{
+		pattern: strings.Split(pattern, "/"),
+		level:   mqtt.WildcardLevel(pattern),
+		cameras: cameras,
+		known:   make(map[uuid.UUID]time.Time),
+	}
+}
+
+// Topics returns the topics to subscribe to. A pattern ending in a single level wildcard
+// also subscribes to the bare topic, so sources that do not name a camera keep working.
+func (r *MQTTCameraResolver) Topics() []string {
+	topics := []string{strings.Join(r.pattern, "/")}
+	if r.level > 0 && r.level == len(r.pattern)-1 && r.pattern[r.level] == "+" {
+		topics = append(topics, strings.Join(r.pattern[:r.level], "/"))
+	}
+	return topics
+}
+
+// topicCamera returns the camera segment of a topic, empty when the topic has none
+func (r *MQTTCameraResolver) topicCamera(topic string) string {
+	if r.level < 0 {
+		return ""
+	}
+	levels := strings.Split(topic, "/")
+	if r.level >= len(levels) {
+		return ""
+	}
+	return levels[r.level]
+}
+
+// Resolve returns the camera a detection belongs to and makes sure it is registered
+func (r *MQTTCameraResolver) Resolve(topic, payloadCameraID string) (uuid.UUID, error) {
+	cameraID, err := r.cameraID(topic, payloadCameraID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if err := r.ensureRegistered(cameraID); err != nil {
+		return uuid.Nil, err
+	}
+	return cameraID, nil
+}
+
+// cameraID picks the camera from the topic, the payload or the configured default, in that order
+func (r *MQTTCameraResolver) cameraID(topic, payloadCameraID string) (uuid.UUID, error) {
+	if segment := r.topicCamera(topic); segment != "" {
+		cameraID, err := uuid.Parse(segment)
+		if err != nil {
+			return uuid.Nil, fmt.Errorf("topic %s does not name a camera UUID: %q", topic, segment)
+		}
+		return cameraID, nil
+	}
+	if payloadCameraID != "" {
+		cameraID, err := uuid.Parse(payloadCameraID)
+		if err != nil {
+			return uuid.Nil, fmt.Errorf("payload camera_id is not a UUID: %q", payloadCameraID)
+		}
+		return cameraID, nil
+	}
+	return DefaultCameraID(), nil
+}
+
+// ensureRegistered checks the camera exists, registering it when mqtt.unknown_cameras allows
+func (r *MQTTCameraResolver) ensureRegistered(cameraID uuid.UUID) error {
+	if r.cameras == nil {
+		return nil
+	}
+
+	now := time.Now()
+	r.mutex.Lock()
+	checkedAt, ok := r.known[cameraID]
+	r.mutex.Unlock()
+	if ok && now.Sub(checkedAt) < knownCameraTTL {
+		return nil
+	}
+
+	_, err := r.cameras.GetCamera(cameraID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if settings().UnknownCameras != UnknownCamerasRegister {
+			return fmt.Errorf("camera %s is not registered", cameraID)
+		}
+		_, err = r.cameras.CreateCamera(models.Camera{
+			ID:   cameraID,
+			Name: fmt.Sprintf("MQTT camera %s", cameraID.String()[:8]),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to register camera %s: %w", cameraID, err)
+		}
+		log.Printf("Registered camera %s from MQTT detections", cameraID)
+	} else if err != nil {
+		return fmt.Errorf("failed to look up camera %s: %w", cameraID, err)
+	}
+
+	r.mutex.Lock()
+	r.known[cameraID] = now
+	r.mutex.Unlock()
+	return nil
+}
@@ -0,0 +1,181 @@
+package detect_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"topgun-services/pkg/detect"
+	"topgun-services/pkg/models"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// fakeCameraService keeps cameras in a map
+type fakeCameraService struct {
+	cameras map[uuid.UUID]models.Camera
+}
+
+func (s *fakeCameraService) GetCameras(pagination models.Pagination, filter models.Search) ([]models.Camera, *models.Pagination, *models.Search, error) {
+	return nil, nil, nil, nil
+}
+
+func (s *fakeCameraService) CreateCamera(camera models.Camera) (*models.Camera, error) {
+	s.cameras[camera.ID] = camera
+	return &camera, nil
+}
+
+func (s *fakeCameraService) UpdateCamera(id uuid.UUID, camera models.Camera) (*models.Camera, error) {
+	return nil, nil
+}
+
+func (s *fakeCameraService) DeleteCamera(id uuid.UUID) error {
+	return nil
+}
+
+func (s *fakeCameraService) RotateCameraToken(id uuid.UUID) (*models.Camera, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *fakeCameraService) GetCamera(id uuid.UUID) (*models.Camera, error) {
+	camera, ok := s.cameras[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &camera, nil
+}
+
+// fakeSubscriber records the topics handlers are registered for
+type fakeSubscriber struct {
+	topics []string
+}
+
+func (s *fakeSubscriber) Handle(topic string, qos byte, handler mqtt.MessageHandler) error {
+	s.topics = append(s.topics, topic)
+	return nil
+}
+
+func TestMQTTCameraResolver(t *testing.T) {
+	defaultCamera := uuid.New()
+	config := detect.DefaultConfig()
+	config.DefaultCameraID = defaultCamera
+	detect.SetConfig(config)
+	defer detect.SetConfig(detect.DefaultConfig())
+
+	known := uuid.New()
+	cameras := &fakeCameraService{cameras: map[uuid.UUID]models.Camera{
+		known:         {ID: known},
+		defaultCamera: {ID: defaultCamera},
+	}}
+	resolver := detect.NewMQTTCameraResolver("topgun/ai/+", cameras)
+
+	tests := []Test{
+		{
+			TestName: "SubscribesToPatternAndBareTopic",
+			Func: func() error {
+				topics := resolver.Topics()
+				if len(topics) != 2 || topics[0] != "topgun/ai/+" || topics[1] != "topgun/ai" {
+					return fmt.Errorf("unexpected topics %v", topics)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "RegistersDetectionTopics",
+			Func: func() error {
+				subscriber := &fakeSubscriber{}
+				handler := detect.NewMQTTDetectHandler(nil, resolver, nil)
+				if err := detect.StartMQTTSubscription(subscriber, handler); err != nil {
+					return err
+				}
+				if len(subscriber.topics) != 2 {
+					return fmt.Errorf("expected handlers for the pattern and the bare topic, got %v", subscriber.topics)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "CameraFromTopic",
+			Func: func() error {
+				cameraID, err := resolver.Resolve("topgun/ai/"+known.String(), uuid.New().String())
+				if err != nil {
+					return err
+				}
+				if cameraID != known {
+					return fmt.Errorf("expected camera %s from the topic, got %s", known, cameraID)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "CameraFromPayloadOrDefault",
+			Func: func() error {
+				cameraID, err := resolver.Resolve("topgun/ai", known.String())
+				if err != nil {
+					return err
+				}
+				if cameraID != known {
+					return fmt.Errorf("expected camera %s from the payload, got %s", known, cameraID)
+				}
+				cameraID, err = resolver.Resolve("topgun/ai", "")
+				if err != nil {
+					return err
+				}
+				if cameraID != defaultCamera {
+					return fmt.Errorf("expected the default camera %s, got %s", defaultCamera, cameraID)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "RejectsInvalidTopicCamera",
+			Func: func() error {
+				if _, err := resolver.Resolve("topgun/ai/not-a-uuid", ""); err == nil {
+					return fmt.Errorf("expected an invalid camera segment to be rejected")
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "RejectsUnknownCamera",
+			Func: func() error {
+				config.UnknownCameras = detect.UnknownCamerasReject
+				detect.SetConfig(config)
+				unknown := uuid.New()
+				if _, err := resolver.Resolve("topgun/ai/"+unknown.String(), ""); err == nil {
+					return fmt.Errorf("expected unknown camera %s to be rejected", unknown)
+				}
+				if _, ok := cameras.cameras[unknown]; ok {
+					return fmt.Errorf("rejected camera %s was registered", unknown)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "RegistersUnknownCamera",
+			Func: func() error {
+				config.UnknownCameras = detect.UnknownCamerasRegister
+				detect.SetConfig(config)
+				unknown := uuid.New()
+				cameraID, err := resolver.Resolve("topgun/ai/"+unknown.String(), "")
+				if err != nil {
+					return err
+				}
+				if _, ok := cameras.cameras[unknown]; !ok || cameraID != unknown {
+					return fmt.Errorf("expected camera %s to be registered", unknown)
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			if err := test.Func(); err != nil {
+				t.Errorf("Test %s failed with error: %v", test.TestName, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package detect
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// DetectionSchemaVersion is the newest MQTT detection envelope version this service understands
+const DetectionSchemaVersion = 1
+
+// DetectionEnvelope wraps an MQTT detection payload:
+//
+//	{"schema_version": 1, "device_id": "pi-01", "message_id": "...", "payload": {...}}
+//
+// Messages without schema_version are the legacy bare payload and decode as version 0.
+type DetectionEnvelope struct {
+	SchemaVersion int             `json:"schema_version"`
+	DeviceID      string          `json:"device_id"`
+	MessageID     string          `json:"message_id"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// Stages at which an MQTT detection can fail, recorded on its dead letter
+const (
+	IngestStageDecode   = "decode"
+	IngestStageValidate = "validate"
+	IngestStageCamera   = "camera"
+	IngestStageStore    = "store"
+)
+
+// IngestError is why an MQTT detection was not stored
+type IngestError struct {
+	Stage    string
+	Envelope DetectionEnvelope
+	Err      error
+}
+
+func (e *IngestError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Stage, e.Err)
+}
+
+func (e *IngestError) Unwrap() error {
+	return e.Err
+}
+
+// decodeDetection reads the envelope and the frame of detections it carries.
+// Legacy bare payloads are rejected when mqtt.require_envelope is set.
+func decodeDetection(raw []byte) (DetectionEnvelope, RaspberryPIFrameDetection, error) {
+	var envelope DetectionEnvelope
+	var frame RaspberryPIFrameDetection
+
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return envelope, frame, fmt.Errorf("message is not a JSON object: %w", err)
+	}
+
+	if _, ok := probe["schema_version"]; !ok {
+		if settings().RequireEnvelope {
+			return envelope, frame, errors.New("message has no schema_version envelope")
+		}
+		envelope.Payload = raw
+	} else {
+		if err := json.Unmarshal(raw, &envelope); err != nil {
+			return envelope, frame, fmt.Errorf("invalid envelope: %w", err)
+		}
+		if envelope.SchemaVersion < 1 || envelope.SchemaVersion > DetectionSchemaVersion {
+			return envelope, frame, fmt.Errorf("unsupported schema_version %d, expected 1 to %d", envelope.SchemaVersion, DetectionSchemaVersion)
+		}
+		var missing []string
+		if envelope.DeviceID == "" {
+			missing = append(missing, "device_id")
+		}
+		if envelope.MessageID == "" {
+			missing = append(missing, "message_id")
+		}
+		if len(bytes.TrimSpace(envelope.Payload)) == 0 || string(envelope.Payload) == "null" {
+			missing = append(missing, "payload")
+		}
+		if len(missing) > 0 {
+			return envelope, frame, fmt.Errorf("envelope is missing %s", strings.Join(missing, ", "))
+		}
+	}
+
+	frame, err := decodeFrame(envelope.Payload)
+	if err != nil {
+		return envelope, frame, fmt.Errorf("invalid detection payload: %w", err)
+	}
+	return envelope, frame, nil
+}
+
+// decodeFrame reads a frame level payload with an objects list, or a single object payload
+func decodeFrame(payload []byte) (RaspberryPIFrameDetection, error) {
+	var frame RaspberryPIFrameDetection
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &probe); err != nil {
+		return frame, err
+	}
+
+	if _, ok := probe["objects"]; ok {
+		if err := json.Unmarshal(payload, &frame); err != nil {
+			return frame, err
+		}
+		// Objects without their own timestamp were taken at the frame time
+		for i := range frame.Objects {
+			if frame.Objects[i].Timestamp == 0 {
+				frame.Objects[i].Timestamp = frame.Timestamp
+			}
+		}
+		return frame, nil
+	}
+
+	var detection RaspberryPIDetection
+	if err := json.Unmarshal(payload, &detection); err != nil {
+		return frame, err
+	}
+	return RaspberryPIFrameDetection{
+		Timestamp: detection.Timestamp,
+		CameraID:  detection.CameraID,
+		Objects:   []RaspberryPIDetection{detection},
+	}, nil
+}
+
+// validateDetection checks a frame and each of its objects are plausible, every violated rule is reported
+func validateDetection(frame RaspberryPIFrameDetection, now time.Time) error {
+	var problems []string
+	switch {
+	case len(frame.Objects) == 0:
+		problems = append(problems, "objects is empty")
+	case len(frame.Objects) > settings().DetectionMaxObjects:
+		problems = append(problems, fmt.Sprintf("%d objects exceed the limit of %d", len(frame.Objects), settings().DetectionMaxObjects))
+	}
+	problems = append(problems, validateTimestamp("timestamp", frame.Timestamp, now)...)
+
+	for i, object := range frame.Objects {
+		prefix := ""
+		if len(frame.Objects) > 1 {
+			prefix = fmt.Sprintf("objects[%d].", i)
+		}
+		problems = append(problems, validateObject(prefix, object)...)
+		if object.Timestamp != frame.Timestamp {
+			problems = append(problems, validateTimestamp(prefix+"timestamp", object.Timestamp, now)...)
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// validateObject checks the position, box and confidence of one detected object
+func validateObject(prefix string, detection RaspberryPIDetection) []string {
+	var problems []string
+	inRange := func(name string, value, low, high float64) {
+		if math.IsNaN(value) || value < low || value > high {
+			problems = append(problems, fmt.Sprintf("%s%s %v is outside %v to %v", prefix, name, value, low, high))
+		}
+	}
+
+	inRange("confidence", detection.Confidence, 0, 1)
+	inRange("lat", detection.Lat, -90, 90)
+	inRange("lon", detection.Lon, -180, 180)
+	if math.IsNaN(detection.Alt) || math.IsInf(detection.Alt, 0) {
+		problems = append(problems, prefix+"alt is not a number")
+	}
+
+	// Bounding boxes are normalized to the frame size
+	inRange("x", detection.X, 0, 1)
+	inRange("y", detection.Y, 0, 1)
+	inRange("w", detection.W, 0, 1)
+	inRange("h", detection.H, 0, 1)
+	if detection.W == 0 || detection.H == 0 {
+		problems = append(problems, prefix+"bounding box has no area")
+	}
+
+	if detection.TrackID < 0 {
+		problems = append(problems, fmt.Sprintf("%strack_id %d is negative", prefix, detection.TrackID))
+	}
+	return problems
+}
+
+// validateTimestamp checks a unix timestamp is set and not ahead of the server clock
+func validateTimestamp(name string, timestamp float64, now time.Time) []string {
+	if timestamp <= 0 {
+		return []string{name + " is missing"}
+	}
+	if at := time.Unix(0, int64(timestamp*float64(time.Second))); at.After(now.Add(settings().DetectionClockSkew)) {
+		return []string{fmt.Sprintf("%s %s is in the future", name, at.Format(time.RFC3339))}
+	}
+	return nil
+}
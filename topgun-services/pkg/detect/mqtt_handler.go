@@ -3,122 +3,260 @@ package detect
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"image"
 	"image/jpeg"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 	"topgun-services/pkg/domain"
 	"topgun-services/pkg/models"
+	"topgun-services/pkg/mqtt"
 
-	mqtt "github.com/eclipse/paho.mqtt.golang"
+	paho "github.com/eclipse/paho.mqtt.golang"
 	"github.com/google/uuid"
 	"github.com/nfnt/resize"
 )
 
 // MQTTDetectHandler handles MQTT messages for detection data
 type MQTTDetectHandler struct {
-	service  domain.DetectService
-	cameraID uuid.UUID
+	service     domain.DetectService
+	cameras     *MQTTCameraResolver
+	deadLetters domain.DeadLetterRepository
 }
 
-// NewMQTTDetectHandler creates a new MQTT detect handler
-func NewMQTTDetectHandler(service domain.DetectService, cameraID uuid.UUID) *MQTTDetectHandler {
+// NewMQTTDetectHandler creates a new MQTT detect handler.
+// Messages that cannot be stored are kept as dead letters, unless deadLetters is nil.
+func NewMQTTDetectHandler(service domain.DetectService, cameras *MQTTCameraResolver, deadLetters domain.DeadLetterRepository) *MQTTDetectHandler {
 	return &MQTTDetectHandler{
-		service:  service,
-		cameraID: cameraID,
+		service:     service,
+		cameras:     cameras,
+		deadLetters: deadLetters,
 	}
 }
 
 // HandleMessage processes incoming MQTT messages
-func (h *MQTTDetectHandler) HandleMessage(client mqtt.Client, msg mqtt.Message) {
+func (h *MQTTDetectHandler) HandleMessage(client paho.Client, msg paho.Message) {
 	log.Printf("Received MQTT message on topic %s", msg.Topic())
 
-	// Parse RaspberryPI detection data
-	var piDetection RaspberryPIDetection
-	if err := json.Unmarshal(msg.Payload(), &piDetection); err != nil {
-		log.Printf("Failed to parse MQTT message: %v", err)
-		return
+	if _, err := h.process(msg.Topic(), msg.Payload(), true); err != nil {
+		log.Printf("Rejected MQTT detection on topic %s: %v", msg.Topic(), err)
+		h.deadLetter(msg.Topic(), msg.Payload(), err)
+	}
+}
+
+// process stores one MQTT detection message and broadcasts it.
+// A frame is captured only for live messages, a retried message is older than the frame buffer
+// and is stored with frame_missing set.
+func (h *MQTTDetectHandler) process(topic string, payload []byte, live bool) (*models.Detect, *IngestError) {
+	envelope, frame, err := decodeDetection(payload)
+	if err != nil {
+		return nil, &IngestError{Stage: IngestStageDecode, Envelope: envelope, Err: err}
+	}
+	if err := validateDetection(frame, time.Now()); err != nil {
+		return nil, &IngestError{Stage: IngestStageValidate, Envelope: envelope, Err: err}
 	}
 
-	log.Printf("RaspberryPI Detection: TrackID=%d, Lat=%.6f, Lon=%.6f, Alt=%.2f, Confidence=%.2f",
-		piDetection.TrackID, piDetection.Lat, piDetection.Lon, piDetection.Alt, piDetection.Confidence)
+	trackIDs := make([]string, len(frame.Objects))
+	for i, piDetection := range frame.Objects {
+		trackIDs[i] = strconv.Itoa(piDetection.TrackID)
+		log.Printf("RaspberryPI Detection: Device=%s, Message=%s, TrackID=%d, Lat=%.6f, Lon=%.6f, Alt=%.2f, Confidence=%.2f",
+			envelope.DeviceID, envelope.MessageID, piDetection.TrackID, piDetection.Lat, piDetection.Lon, piDetection.Alt, piDetection.Confidence)
+	}
 
-	// Capture current video frame
-	frameData, _, err := GetLatestVideoFrame()
+	cameraID, err := h.cameras.Resolve(topic, frame.CameraID)
 	if err != nil {
-		log.Printf("Failed to get video frame: %v", err)
-		// Continue anyway, we'll save detection without image
+		return nil, &IngestError{Stage: IngestStageCamera, Envelope: envelope, Err: err}
 	}
 
-	// Save captured frame to file
+	// Save the frame closest to the detection time once for every object in it
+	at := time.Unix(0, int64(frame.Timestamp*float64(time.Second)))
 	var imagePath string
-	if frameData != nil {
-		imagePath, err = h.saveFrameToFile(frameData, piDetection.TrackID)
+	var frameOffsetMs *int64
+	if live {
+		frameData, offset, err := h.captureFrame(cameraID, at)
 		if err != nil {
-			log.Printf("Failed to save frame to file: %v", err)
-		} else {
-			log.Printf("Saved captured frame to: %s", imagePath)
+			log.Printf("Failed to get video frame: %v", err)
+			// Continue anyway, we'll save detection without image
+		}
+		if frameData != nil {
+			imagePath, err = h.saveFrameToFile(frameData, captureTrackNames(trackIDs))
+			if err != nil {
+				log.Printf("Failed to save frame to file: %v", err)
+			} else {
+				log.Printf("Saved captured frame to: %s (%s from the detection)", imagePath, offset.Round(time.Millisecond))
+				offsetMs := offset.Milliseconds()
+				frameOffsetMs = &offsetMs
+			}
 		}
 	}
 
 	// Create objects array with detection data
-	objectsData := map[string]interface{}{
-		"x":          piDetection.X,
-		"y":          piDetection.Y,
-		"w":          piDetection.W,
-		"h":          piDetection.H,
-		"lat":        piDetection.Lat,
-		"lon":        piDetection.Lon,
-		"alt":        piDetection.Alt,
-		"confidence": piDetection.Confidence,
-		"track_id":   piDetection.TrackID,
-		"timestamp":  piDetection.Timestamp,
+	objects := make([]interface{}, len(frame.Objects))
+	for i, piDetection := range frame.Objects {
+		objects[i] = map[string]interface{}{
+			"x":          piDetection.X,
+			"y":          piDetection.Y,
+			"w":          piDetection.W,
+			"h":          piDetection.H,
+			"lat":        piDetection.Lat,
+			"lon":        piDetection.Lon,
+			"alt":        piDetection.Alt,
+			"confidence": piDetection.Confidence,
+			"track_id":   piDetection.TrackID,
+			"timestamp":  piDetection.Timestamp,
+		}
 	}
 
 	// Marshal to JSON
-	objectsJSON, err := json.Marshal([]interface{}{objectsData})
+	objectsJSON, err := json.Marshal(objects)
 	if err != nil {
-		log.Printf("Failed to marshal objects data: %v", err)
-		return
+		return nil, &IngestError{Stage: IngestStageStore, Envelope: envelope, Err: fmt.Errorf("failed to marshal objects data: %w", err)}
 	}
 
 	// Create detection record
 	detect := models.Detect{
-		CameraID:  h.cameraID,
-		Timestamp: time.Unix(int64(piDetection.Timestamp), 0),
-		Path:      imagePath,
+		CameraID:      cameraID,
+		Timestamp:     at,
+		Path:          imagePath,
+		FrameOffsetMs: frameOffsetMs,
+		FrameMissing:  imagePath == "",
 	}
 
 	// Parse objects into JSONRawMessageArray
 	if err := json.Unmarshal(objectsJSON, &detect.Objects); err != nil {
-		log.Printf("Failed to unmarshal objects: %v", err)
-		return
+		return nil, &IngestError{Stage: IngestStageStore, Envelope: envelope, Err: fmt.Errorf("failed to unmarshal objects: %w", err)}
 	}
 
 	// Save to database
 	savedDetect, err := h.service.CreateDetect(detect)
 	if err != nil {
-		log.Printf("Failed to save detection to database: %v", err)
-		return
+		return nil, &IngestError{Stage: IngestStageStore, Envelope: envelope, Err: fmt.Errorf("failed to save detection to database: %w", err)}
 	}
 
 	log.Printf("Successfully saved detection ID=%d with %d objects to database", savedDetect.ID, len(savedDetect.Objects))
 
 	// Broadcast to WebSocket clients
-	if hub != nil {
-		hub.broadcast <- &BroadcastMessage{
-			CameraID: h.cameraID,
-			Detect:   savedDetect,
+	BroadcastDetection(savedDetect)
+	log.Printf("Broadcasted detection to WebSocket clients")
+	return savedDetect, nil
+}
+
+// deadLetter keeps a rejected message with the reason so it can be inspected and retried
+func (h *MQTTDetectHandler) deadLetter(topic string, payload []byte, ingestErr *IngestError) {
+	if h.deadLetters == nil {
+		return
+	}
+	deadLetter, err := h.deadLetters.CreateDeadLetter(models.DeadLetter{
+		Topic:         topic,
+		SchemaVersion: ingestErr.Envelope.SchemaVersion,
+		DeviceID:      ingestErr.Envelope.DeviceID,
+		MessageID:     ingestErr.Envelope.MessageID,
+		Payload:       string(payload),
+		Stage:         ingestErr.Stage,
+		Reason:        ingestErr.Err.Error(),
+		Status:        models.DeadLetterPending,
+	})
+	if err != nil {
+		log.Printf("Failed to store dead letter of topic %s: %v", topic, err)
+		return
+	}
+	log.Printf("Stored MQTT message as dead letter ID=%d (%s)", deadLetter.ID, ingestErr.Stage)
+}
+
+var (
+	// ErrDeadLetterReprocessed is returned when retrying a dead letter that already became a detection
+	ErrDeadLetterReprocessed = errors.New("already reprocessed")
+	// ErrDeadLetterReprocessing is returned when another retry of the dead letter is still running
+	ErrDeadLetterReprocessing = errors.New("already being reprocessed")
+)
+
+// Reprocess retries a dead letter, with a corrected payload when one is given.
+// The dead letter is claimed first so concurrent retries cannot create two detections.
+// On success it is marked reprocessed and linked to the new detection, on failure it is pending again.
+func (h *MQTTDetectHandler) Reprocess(id uint, payload []byte) (*models.DeadLetter, *models.Detect, error) {
+	if h.deadLetters == nil {
+		return nil, nil, errors.New("dead letters are not stored")
+	}
+	claimed, err := h.deadLetters.ClaimDeadLetter(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	deadLetter, err := h.deadLetters.GetDeadLetter(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !claimed {
+		if deadLetter.Status == models.DeadLetterReprocessed {
+			return deadLetter, nil, fmt.Errorf("%w: dead letter %d", ErrDeadLetterReprocessed, id)
 		}
-		log.Printf("Broadcasted detection to WebSocket clients")
+		return deadLetter, nil, fmt.Errorf("%w: dead letter %d", ErrDeadLetterReprocessing, id)
+	}
+	if len(payload) == 0 {
+		payload = []byte(deadLetter.Payload)
 	}
+
+	detect, ingestErr := h.process(deadLetter.Topic, payload, false)
+	update := models.DeadLetter{Attempts: deadLetter.Attempts + 1, Payload: string(payload)}
+	if ingestErr != nil {
+		update.Status = models.DeadLetterPending
+		update.Stage = ingestErr.Stage
+		update.Reason = ingestErr.Err.Error()
+	} else {
+		now := time.Now()
+		update.Status = models.DeadLetterReprocessed
+		update.DetectID = &detect.ID
+		update.ReprocessedAt = &now
+	}
+	updated, err := h.deadLetters.UpdateDeadLetter(id, update)
+	if err != nil {
+		return nil, detect, fmt.Errorf("failed to update dead letter %d: %w", id, err)
+	}
+	if ingestErr != nil {
+		return updated, nil, ingestErr
+	}
+	return updated, detect, nil
+}
+
+// captureFrame returns the buffered frame of the camera closest to the detection time and its offset,
+// frames further away than video.capture.match_tolerance_ms would not show the detected objects.
+// The buffer keeps the last frames of a source that stopped, so frames received longer than
+// video.capture.max_frame_age_seconds ago are not attached either.
+func (h *MQTTDetectHandler) captureFrame(cameraID uuid.UUID, at time.Time) ([]byte, time.Duration, error) {
+	buffer, ok := lookupFrameBuffer(cameraID)
+	if !ok {
+		return nil, 0, fmt.Errorf("no video frames of camera %s", cameraID)
+	}
+	frame, offset, ok := buffer.Closest(at)
+	if !ok {
+		return nil, 0, fmt.Errorf("no video frames of camera %s", cameraID)
+	}
+	if tolerance := settings().CaptureMatchTolerance; offset.Abs() > tolerance {
+		return nil, 0, fmt.Errorf("closest frame of camera %s is %s from the detection, more than %s", cameraID, offset.Round(time.Millisecond), tolerance)
+	}
+	if age, maxAge := time.Since(frame.ReceivedAt), settings().CaptureMaxFrameAge; age > maxAge {
+		return nil, 0, fmt.Errorf("closest frame of camera %s was received %s ago, more than %s", cameraID, age.Round(time.Millisecond), maxAge)
+	}
+	return frame.Data, offset, nil
+}
+
+// Track IDs named in a capture file name, the rest are summarized to keep the name short
+const maxCaptureTracks = 8
+
+// captureTrackNames joins the track IDs of a frame for its capture file name
+func captureTrackNames(trackIDs []string) string {
+	if len(trackIDs) > maxCaptureTracks {
+		return fmt.Sprintf("%s-and-%d-more", strings.Join(trackIDs[:maxCaptureTracks], "-"), len(trackIDs)-maxCaptureTracks)
+	}
+	return strings.Join(trackIDs, "-")
 }
 
 // saveFrameToFile saves the captured frame to upload directory
-func (h *MQTTDetectHandler) saveFrameToFile(frameData []byte, trackID int) (string, error) {
+// trackIDs names the tracks in the frame, e.g. 256 or 256-257-260
+func (h *MQTTDetectHandler) saveFrameToFile(frameData []byte, trackIDs string) (string, error) {
 	// Create upload directory if not exists
 	uploadDir := "./upload"
 	if err := os.MkdirAll(uploadDir, 0755); err != nil {
@@ -137,33 +275,17 @@ func (h *MQTTDetectHandler) saveFrameToFile(frameData []byte, trackID int) (stri
 	width := bounds.Dx()
 	height := bounds.Dy()
 
-	var resizedImg image.Image
-	if width > 1280 || height > 720 {
-		// Calculate target dimensions maintaining aspect ratio
-		aspectRatio := float64(width) / float64(height)
-		var targetWidth, targetHeight uint
-
-		if aspectRatio > 16.0/9.0 {
-			// Width is the limiting factor
-			targetWidth = 1280
-			targetHeight = 0 // Let resize calculate height
-		} else {
-			// Height is the limiting factor
-			targetWidth = 0 // Let resize calculate width
-			targetHeight = 720
-		}
-
-		// Resize using Lanczos3 (high quality)
-		resizedImg = resize.Resize(targetWidth, targetHeight, img, resize.Lanczos3)
+	// Resize using Lanczos3 (high quality)
+	resizedImg := scaleToFit(img, 1280, 720, resize.Lanczos3)
+	if resizedImg != img {
 		log.Printf("Resized image from %dx%d to %dx%d", width, height, resizedImg.Bounds().Dx(), resizedImg.Bounds().Dy())
 	} else {
-		resizedImg = img
 		log.Printf("Image size %dx%d is already smaller than 720p, keeping original", width, height)
 	}
 
 	// Generate filename with timestamp and track_id
 	timestamp := time.Now().Format("20060102_150405")
-	filename := fmt.Sprintf("mqtt_capture_%s_track_%d.jpg", timestamp, trackID)
+	filename := fmt.Sprintf("mqtt_capture_%s_track_%s.jpg", timestamp, trackIDs)
 	filePath := filepath.Join(uploadDir, filename)
 
 	// Create output file
@@ -181,43 +303,20 @@ func (h *MQTTDetectHandler) saveFrameToFile(frameData []byte, trackID int) (stri
 	return filePath, nil
 }
 
-// StartMQTTSubscription starts subscribing to MQTT topic for detection data
-func StartMQTTSubscription(mqttBroker, mqttTopic string, cameraID uuid.UUID, service domain.DetectService) error {
-	// Create MQTT client options
-	opts := mqtt.NewClientOptions()
-	opts.AddBroker(mqttBroker)
-	opts.SetClientID(fmt.Sprintf("topgun-detect-%s", uuid.New().String()))
-	opts.SetAutoReconnect(true)
-	opts.SetConnectRetry(true)
-	opts.SetConnectRetryInterval(5 * time.Second)
-	opts.SetMaxReconnectInterval(30 * time.Second)
-
-	// Connection handlers
-	opts.SetOnConnectHandler(func(client mqtt.Client) {
-		log.Printf("MQTT connected to broker: %s", mqttBroker)
-	})
-
-	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
-		log.Printf("MQTT connection lost: %v", err)
-	})
-
-	// Create MQTT client
-	client := mqtt.NewClient(opts)
-
-	// Connect to broker
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		return fmt.Errorf("failed to connect to MQTT broker: %w", token.Error())
-	}
-
-	// Create message handler
-	handler := NewMQTTDetectHandler(service, cameraID)
+// DefaultCameraID returns the camera used for sources that do not identify themselves
+func DefaultCameraID() uuid.UUID {
+	return settings().DefaultCameraID
+}
 
-	// Subscribe to topic
-	if token := client.Subscribe(mqttTopic, 1, handler.HandleMessage); token.Wait() && token.Error() != nil {
-		return fmt.Errorf("failed to subscribe to MQTT topic %s: %w", mqttTopic, token.Error())
+// StartMQTTSubscription registers the handler on the topics of its camera pattern, e.g. topgun/ai/+.
+// Detections are stored under the camera named by the topic or payload, see MQTTCameraResolver.
+// Registered topics stay subscribed across reconnects, an error only means the first subscribe failed.
+func StartMQTTSubscription(subscriber mqtt.Subscriber, handler *MQTTDetectHandler) error {
+	var errs []error
+	for _, topic := range handler.cameras.Topics() {
+		if err := subscriber.Handle(topic, 1, handler.HandleMessage); err != nil {
+			errs = append(errs, err)
+		}
 	}
-
-	log.Printf("Successfully subscribed to MQTT topic: %s", mqttTopic)
-
-	return nil
+	return errors.Join(errs...)
 }
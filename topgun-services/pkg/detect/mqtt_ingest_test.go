@@ -0,0 +1,418 @@
+package detect_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"topgun-services/pkg/detect"
+	"topgun-services/pkg/models"
+
+	"github.com/google/uuid"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// fakeDetectService keeps created detections in memory
+type fakeDetectService struct {
+	detects []models.Detect
+}
+
+func (s *fakeDetectService) CreateDetect(detect models.Detect) (*models.Detect, error) {
+	detect.ID = uint(len(s.detects) + 1)
+	s.detects = append(s.detects, detect)
+	return &detect, nil
+}
+
+func (s *fakeDetectService) GetDetects(pagination models.Pagination, filter models.Search, startDate, endDate string) ([]models.Detect, *models.Pagination, *models.Search, error) {
+	return s.detects, &pagination, &filter, nil
+}
+
+func (s *fakeDetectService) GetDetectsByCameras(cameraIDs []string, pagination models.Pagination) ([]models.Detect, *models.Pagination, error) {
+	return nil, &pagination, nil
+}
+
+func (s *fakeDetectService) GetDetect(id uint) (*models.Detect, error) {
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s *fakeDetectService) GetDetectFile(id uint) (*models.Detect, error) {
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s *fakeDetectService) UpdateDetect(id uint, detect models.Detect) (*models.Detect, error) {
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s *fakeDetectService) DeleteDetect(id uint) error {
+	return gorm.ErrRecordNotFound
+}
+
+// fakeDeadLetterRepository keeps dead letters in memory
+type fakeDeadLetterRepository struct {
+	deadLetters map[uint]*models.DeadLetter
+}
+
+func (r *fakeDeadLetterRepository) CreateDeadLetter(deadLetter models.DeadLetter) (*models.DeadLetter, error) {
+	deadLetter.ID = uint(len(r.deadLetters) + 1)
+	r.deadLetters[deadLetter.ID] = &deadLetter
+	return &deadLetter, nil
+}
+
+func (r *fakeDeadLetterRepository) GetDeadLetters(pagination models.Pagination, status string) ([]models.DeadLetter, *models.Pagination, error) {
+	return nil, &pagination, nil
+}
+
+func (r *fakeDeadLetterRepository) GetDeadLetter(id uint) (*models.DeadLetter, error) {
+	deadLetter, ok := r.deadLetters[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	copied := *deadLetter
+	return &copied, nil
+}
+
+func (r *fakeDeadLetterRepository) UpdateDeadLetter(id uint, update models.DeadLetter) (*models.DeadLetter, error) {
+	deadLetter, ok := r.deadLetters[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	// Only non-zero fields are written, like a GORM struct update
+	if update.Payload != "" {
+		deadLetter.Payload = update.Payload
+	}
+	if update.Stage != "" {
+		deadLetter.Stage = update.Stage
+	}
+	if update.Reason != "" {
+		deadLetter.Reason = update.Reason
+	}
+	if update.Status != "" {
+		deadLetter.Status = update.Status
+	}
+	if update.Attempts != 0 {
+		deadLetter.Attempts = update.Attempts
+	}
+	if update.DetectID != nil {
+		deadLetter.DetectID = update.DetectID
+	}
+	if update.ReprocessedAt != nil {
+		deadLetter.ReprocessedAt = update.ReprocessedAt
+	}
+	copied := *deadLetter
+	return &copied, nil
+}
+
+func (r *fakeDeadLetterRepository) ClaimDeadLetter(id uint) (bool, error) {
+	deadLetter, ok := r.deadLetters[id]
+	if !ok || deadLetter.Status != models.DeadLetterPending {
+		return false, nil
+	}
+	deadLetter.Status = models.DeadLetterReprocessing
+	return true, nil
+}
+
+func (r *fakeDeadLetterRepository) DeleteDeadLetter(id uint) error {
+	delete(r.deadLetters, id)
+	return nil
+}
+
+// fakeMessage is an MQTT message delivered to a handler
+type fakeMessage struct {
+	topic   string
+	payload []byte
+}
+
+func (m *fakeMessage) Duplicate() bool   { return false }
+func (m *fakeMessage) Qos() byte         { return 1 }
+func (m *fakeMessage) Retained() bool    { return false }
+func (m *fakeMessage) Topic() string     { return m.topic }
+func (m *fakeMessage) MessageID() uint16 { return 0 }
+func (m *fakeMessage) Payload() []byte   { return m.payload }
+func (m *fakeMessage) Ack()              {}
+
+func TestMQTTDetectionIngest(t *testing.T) {
+	cameraID := uuid.New()
+	topic := "topgun/ai/" + cameraID.String()
+	detects := &fakeDetectService{}
+	deadLetters := &fakeDeadLetterRepository{deadLetters: make(map[uint]*models.DeadLetter)}
+	handler := detect.NewMQTTDetectHandler(detects, detect.NewMQTTCameraResolver("topgun/ai/+", nil), deadLetters)
+
+	envelope := func(version int, confidence float64) []byte {
+		return fmt.Appendf(nil, `{"schema_version":%d,"device_id":"pi-01","message_id":"msg-%d","payload":`+
+			`{"x":0.28,"y":0.76,"w":0.21,"h":0.46,"lat":14.3,"lon":101.1,"alt":43,"confidence":%v,"track_id":256,"timestamp":%d}}`,
+			version, time.Now().UnixNano(), confidence, time.Now().Unix())
+	}
+	lastDeadLetter := func() *models.DeadLetter {
+		return deadLetters.deadLetters[uint(len(deadLetters.deadLetters))]
+	}
+
+	tests := []Test{
+		{
+			TestName: "StoresValidEnvelope",
+			Func: func() error {
+				handler.HandleMessage(nil, &fakeMessage{topic: topic, payload: envelope(1, 0.9)})
+				if len(detects.detects) != 1 || detects.detects[0].CameraID != cameraID || len(detects.detects[0].Objects) != 1 {
+					return fmt.Errorf("expected one detection of camera %s, got %+v", cameraID, detects.detects)
+				}
+				if len(deadLetters.deadLetters) != 0 {
+					return fmt.Errorf("expected no dead letters, got %d", len(deadLetters.deadLetters))
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "StoresAllObjectsOfAFrame",
+			Func: func() error {
+				payload := fmt.Appendf(nil, `{"schema_version":1,"device_id":"pi-01","message_id":"frame-1","payload":{"timestamp":%d,"objects":[`+
+					`{"x":0.1,"y":0.1,"w":0.1,"h":0.1,"lat":14.3,"lon":101.1,"confidence":0.9,"track_id":1},`+
+					`{"x":0.4,"y":0.4,"w":0.1,"h":0.1,"lat":14.3,"lon":101.1,"confidence":0.8,"track_id":2},`+
+					`{"x":0.7,"y":0.7,"w":0.1,"h":0.1,"lat":14.3,"lon":101.1,"confidence":0.7,"track_id":3}]}}`, time.Now().Unix())
+				before := len(detects.detects)
+				handler.HandleMessage(nil, &fakeMessage{topic: topic, payload: payload})
+				if len(detects.detects) != before+1 {
+					return fmt.Errorf("expected one detection for the frame, got %d", len(detects.detects)-before)
+				}
+				if objects := detects.detects[before].Objects; len(objects) != 3 {
+					return fmt.Errorf("expected 3 objects on the detection, got %d", len(objects))
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "AttachesFrameClosestToDetection",
+			Func: func() error {
+				var source bytes.Buffer
+				if err := jpeg.Encode(&source, image.NewRGBA(image.Rect(0, 0, 32, 24)), nil); err != nil {
+					return err
+				}
+				frame := base64.StdEncoding.EncodeToString(source.Bytes())
+				now := float64(time.Now().UnixNano()) / float64(time.Second)
+				for _, offset := range []float64{-2, -1, 0} {
+					detect.UpdateVideoFrameCache(&detect.VideoFrameMessage{CameraID: cameraID, Frame: frame, Timestamp: now + offset})
+				}
+
+				detectionAt := func(timestamp float64) []byte {
+					return fmt.Appendf(nil, `{"x":0.5,"y":0.5,"w":0.1,"h":0.1,"lat":14.3,"lon":101.1,"confidence":0.9,"track_id":7,"timestamp":%f}`, timestamp)
+				}
+				handler.HandleMessage(nil, &fakeMessage{topic: topic, payload: detectionAt(now - 0.95)})
+				matched := detects.detects[len(detects.detects)-1]
+				if matched.Path != "" {
+					defer os.Remove(matched.Path)
+				}
+				if matched.FrameMissing || matched.FrameOffsetMs == nil {
+					return errors.New("expected a frame to be matched")
+				}
+				if offset := *matched.FrameOffsetMs; offset > -40 || offset < -60 {
+					return fmt.Errorf("expected the frame 50ms before the detection, got an offset of %dms", offset)
+				}
+
+				handler.HandleMessage(nil, &fakeMessage{topic: topic, payload: detectionAt(now - 5)})
+				missing := detects.detects[len(detects.detects)-1]
+				if !missing.FrameMissing || missing.Path != "" || missing.FrameOffsetMs != nil {
+					return fmt.Errorf("expected no frame outside the tolerance, got path %q", missing.Path)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "SkipsFramesOfAStoppedSource",
+			Func: func() error {
+				config := detect.DefaultConfig()
+				config.CaptureMaxFrameAge = 200 * time.Millisecond
+				detect.SetConfig(config)
+				defer detect.SetConfig(detect.DefaultConfig())
+
+				var source bytes.Buffer
+				if err := jpeg.Encode(&source, image.NewRGBA(image.Rect(0, 0, 32, 24)), nil); err != nil {
+					return err
+				}
+				stoppedID := uuid.New()
+				stopped := detect.NewMQTTDetectHandler(detects, detect.NewMQTTCameraResolver("topgun/ai/+", nil), deadLetters)
+				now := float64(time.Now().UnixNano()) / float64(time.Second)
+				detect.UpdateVideoFrameCache(&detect.VideoFrameMessage{CameraID: stoppedID, Frame: base64.StdEncoding.EncodeToString(source.Bytes()), Timestamp: now})
+
+				// The source stops, its last frame stays buffered
+				time.Sleep(300 * time.Millisecond)
+				payload := fmt.Appendf(nil, `{"x":0.5,"y":0.5,"w":0.1,"h":0.1,"lat":14.3,"lon":101.1,"confidence":0.9,"track_id":8,"timestamp":%f}`, now)
+				stopped.HandleMessage(nil, &fakeMessage{topic: "topgun/ai/" + stoppedID.String(), payload: payload})
+				stale := detects.detects[len(detects.detects)-1]
+				if stale.Path != "" {
+					os.Remove(stale.Path)
+				}
+				if stale.CameraID != stoppedID || !stale.FrameMissing || stale.Path != "" {
+					return fmt.Errorf("expected no frame attached from a stopped source, got path %q", stale.Path)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "DeadLettersInvalidConfidence",
+			Func: func() error {
+				handler.HandleMessage(nil, &fakeMessage{topic: topic, payload: envelope(1, 1.5)})
+				deadLetter := lastDeadLetter()
+				if deadLetter == nil || deadLetter.Stage != detect.IngestStageValidate || deadLetter.DeviceID != "pi-01" {
+					return fmt.Errorf("expected a validation dead letter of pi-01, got %+v", deadLetter)
+				}
+				if deadLetter.Status != models.DeadLetterPending {
+					return fmt.Errorf("expected a pending dead letter, got %q", deadLetter.Status)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "DeadLettersUnsupportedVersion",
+			Func: func() error {
+				handler.HandleMessage(nil, &fakeMessage{topic: topic, payload: envelope(9, 0.9)})
+				if deadLetter := lastDeadLetter(); deadLetter.Stage != detect.IngestStageDecode {
+					return fmt.Errorf("expected a decode dead letter, got stage %q", deadLetter.Stage)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "ReprocessesCorrectedPayload",
+			Func: func() error {
+				if _, _, err := handler.Reprocess(1, nil); err == nil {
+					return errors.New("expected the unchanged payload to be rejected again")
+				}
+				deadLetter, created, err := handler.Reprocess(1, envelope(1, 0.8))
+				if err != nil {
+					return err
+				}
+				if deadLetter.Status != models.DeadLetterReprocessed || deadLetter.Attempts != 2 {
+					return fmt.Errorf("expected a reprocessed dead letter after 2 attempts, got %q after %d", deadLetter.Status, deadLetter.Attempts)
+				}
+				if created == nil || deadLetter.DetectID == nil || *deadLetter.DetectID != created.ID {
+					return fmt.Errorf("expected the dead letter to link the new detection")
+				}
+				if _, _, err := handler.Reprocess(1, nil); !errors.Is(err, detect.ErrDeadLetterReprocessed) {
+					return fmt.Errorf("expected a second retry to be refused, got %v", err)
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			if err := test.Func(); err != nil {
+				t.Errorf("Test %s failed with error: %v", test.TestName, err)
+			}
+		})
+	}
+}
+
+func TestDeadLetterReprocessOnce(t *testing.T) {
+	config := detect.DefaultConfig()
+	config.ClipEnabled = false
+	detect.SetConfig(config)
+	defer detect.SetConfig(detect.DefaultConfig())
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	// Every connection would open its own in-memory database
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get database: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&models.Detect{}, &models.DeadLetter{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	cameraID := uuid.New()
+	topic := "topgun/ai/" + cameraID.String()
+	deadLetters := detect.NewDeadLetterRepository(db)
+	handler := detect.NewMQTTDetectHandler(detect.NewDetectService(detect.NewDetectRepository(db)), detect.NewMQTTCameraResolver("topgun/ai/+", nil), deadLetters)
+	payload := fmt.Appendf(nil, `{"schema_version":1,"device_id":"pi-01","message_id":"retry-1","payload":`+
+		`{"x":0.28,"y":0.76,"w":0.21,"h":0.46,"lat":14.3,"lon":101.1,"alt":43,"confidence":0.9,"track_id":7,"timestamp":%d}}`, time.Now().Unix())
+
+	tests := []Test{
+		{
+			TestName: "ConcurrentRetriesCreateOneDetection",
+			Func: func() error {
+				deadLetter, err := deadLetters.CreateDeadLetter(models.DeadLetter{Topic: topic, Payload: string(payload), Status: models.DeadLetterPending})
+				if err != nil {
+					return err
+				}
+
+				const retries = 8
+				var wg sync.WaitGroup
+				errs := make(chan error, retries)
+				for range retries {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						_, _, err := handler.Reprocess(deadLetter.ID, nil)
+						errs <- err
+					}()
+				}
+				wg.Wait()
+				close(errs)
+
+				succeeded := 0
+				for err := range errs {
+					switch {
+					case err == nil:
+						succeeded++
+					case errors.Is(err, detect.ErrDeadLetterReprocessed), errors.Is(err, detect.ErrDeadLetterReprocessing):
+					default:
+						return err
+					}
+				}
+				if succeeded != 1 {
+					return fmt.Errorf("expected exactly one retry to succeed, got %d", succeeded)
+				}
+				var count int64
+				if err := db.Model(&models.Detect{}).Count(&count).Error; err != nil {
+					return err
+				}
+				if count != 1 {
+					return fmt.Errorf("expected one detection, got %d", count)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "FailedRetryIsPendingAgain",
+			Func: func() error {
+				deadLetter, err := deadLetters.CreateDeadLetter(models.DeadLetter{Topic: topic, Payload: `{"schema_version":1}`, Status: models.DeadLetterPending})
+				if err != nil {
+					return err
+				}
+				if _, _, err := handler.Reprocess(deadLetter.ID, nil); err == nil {
+					return errors.New("expected the invalid payload to be rejected")
+				}
+				stored, err := deadLetters.GetDeadLetter(deadLetter.ID)
+				if err != nil {
+					return err
+				}
+				if stored.Status != models.DeadLetterPending {
+					return fmt.Errorf("expected a rejected retry to leave the dead letter pending, got %q", stored.Status)
+				}
+				if claimed, err := deadLetters.ClaimDeadLetter(deadLetter.ID); err != nil || !claimed {
+					return fmt.Errorf("expected the dead letter to be claimable again, got %v, %v", claimed, err)
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			if err := test.Func(); err != nil {
+				t.Errorf("Test %s failed with error: %v", test.TestName, err)
+			}
+		})
+	}
+}
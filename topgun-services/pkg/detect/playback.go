@@ -0,0 +1,257 @@
+package detect
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	helpers "github.com/zercle/gofiber-helpers"
+)
+
+// Longest pause between two archived frames during playback, gaps in the archive are skipped over
+const maxPlaybackGap = 5 * time.Second
+
+// parsePlaybackTime parses an RFC 3339 time or a Unix timestamp in seconds
+func parsePlaybackTime(name, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, fmt.Errorf("%s is required", name)
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Unix(0, int64(seconds*float64(time.Second))), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be an RFC 3339 time or a Unix timestamp, got %q", name, value)
+	}
+	return t, nil
+}
+
+// parsePlaybackRange validates the from and to query parameters of a playback request
+func parsePlaybackRange(fromParam, toParam string) (time.Time, time.Time, error) {
+	from, err := parsePlaybackTime("from", fromParam)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	to, err := parsePlaybackTime("to", toParam)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if !to.After(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("to must be after from")
+	}
+	if maxRange := settings().PlaybackMaxRange; to.Sub(from) > maxRange {
+		return time.Time{}, time.Time{}, fmt.Errorf("playback range must not exceed %s", maxRange)
+	}
+	return from, to, nil
+}
+
+// parsePlaybackSpeed accepts 1, 2 or 4, an empty value plays in real time
+func parsePlaybackSpeed(speed string) (float64, error) {
+	switch speed {
+	case "", "1":
+		return 1, nil
+	case "2":
+		return 2, nil
+	case "4":
+		return 4, nil
+	}
+	return 0, fmt.Errorf("speed must be 1, 2 or 4, got %q", speed)
+}
+
+// HandlePlayback - WebSocket handler replaying archived frames of a camera
+// Frames are sent as VideoFrameMessage JSON with their original spacing divided by speed,
+// followed by a playback_end message.
+func (h *cameraStreamHandler) HandlePlayback() fiber.Handler {
+	return websocket.New(func(c *websocket.Conn) {
+		defer c.Close()
+
+		writeError := func(err error) {
+			c.WriteJSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		cameraID, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			writeError(fmt.Errorf("invalid camera_id format"))
+			return
+		}
+		from, to, err := parsePlaybackRange(c.Query("from"), c.Query("to"))
+		if err != nil {
+			writeError(err)
+			return
+		}
+		speed, err := parsePlaybackSpeed(c.Query("speed"))
+		if err != nil {
+			writeError(err)
+			return
+		}
+
+		frames, err := videoArchive.Range(cameraID, from, to)
+		if err != nil {
+			log.Printf("Failed to read archive of camera %s: %v", cameraID, err)
+			writeError(fmt.Errorf("failed to read archive"))
+			return
+		}
+
+		if err := c.WriteJSON(fiber.Map{
+			"status":    "playing",
+			"camera_id": cameraID.String(),
+			"frames":    len(frames),
+			"speed":     speed,
+		}); err != nil {
+			return
+		}
+
+		// Stop replaying as soon as the viewer goes away
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for {
+				if _, _, err := c.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+		// The connection is recycled once the handler returns, the reader must be gone by then
+		defer func() {
+			c.SetReadDeadline(time.Now())
+			<-done
+		}()
+
+		sent := 0
+		for i, frame := range frames {
+			if i > 0 {
+				gap := min(frame.ReceivedAt.Sub(frames[i-1].ReceivedAt), maxPlaybackGap)
+				select {
+				case <-time.After(time.Duration(float64(gap) / speed)):
+				case <-done:
+					return
+				}
+			}
+
+			frameData, err := os.ReadFile(frame.Path)
+			if err != nil {
+				// Pruned while playing
+				continue
+			}
+			width, height, _ := imageDimensions(frame.Path)
+			message := VideoFrameMessage{
+				CameraID:    cameraID,
+				Frame:       base64.StdEncoding.EncodeToString(frameData),
+				Timestamp:   float64(frame.ReceivedAt.UnixNano()) / float64(time.Second),
+				FrameNumber: i + 1,
+				Width:       width,
+				Height:      height,
+			}
+			if err := c.WriteJSON(message); err != nil {
+				return
+			}
+			sent++
+		}
+
+		c.WriteJSON(fiber.Map{
+			"type":   "playback_end",
+			"frames": sent,
+		})
+	})
+}
+
+// @Summary ExportPlayback
+// @Tags Camera
+// @Description Download the archived frames of a camera between two times as an MJPEG file (concatenated JPEGs)
+// @Produce video/x-motion-jpeg
+// @Param id path string true "Camera ID"
+// @Param from query string true "Start as RFC 3339 time or Unix timestamp"
+// @Param to query string true "End as RFC 3339 time or Unix timestamp"
+// @Router /api/v1/camera/{id}/playback.mjpeg [get]
+// @Security ApiKeyAuth
+func (h *cameraStreamHandler) ExportPlayback() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		cameraID, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusBadRequest,
+						Title:   "Invalid camera ID",
+						Message: err.Error(),
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+
+		from, to, err := parsePlaybackRange(c.Query("from"), c.Query("to"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusBadRequest,
+						Title:   "Invalid playback range",
+						Message: err.Error(),
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+
+		frames, err := videoArchive.Range(cameraID, from, to)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusInternalServerError,
+						Title:   "Failed to read archive",
+						Message: err.Error(),
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+		if len(frames) == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusNotFound,
+						Title:   "No archived frames",
+						Message: fmt.Sprintf("No frames of camera %s were archived in this range", cameraID),
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+
+		filename := fmt.Sprintf("camera_%s_%s.mjpeg", cameraID, from.UTC().Format("20060102_150405"))
+		c.Set(fiber.HeaderContentType, "video/x-motion-jpeg")
+		c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, filename))
+		c.Set("X-Frame-Count", strconv.Itoa(len(frames)))
+
+		// Read one frame at a time so long ranges are not held in memory
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			for _, frame := range frames {
+				frameData, err := os.ReadFile(frame.Path)
+				if err != nil {
+					continue
+				}
+				if _, err := w.Write(frameData); err != nil {
+					return
+				}
+			}
+			w.Flush()
+		})
+
+		return nil
+	}
+}
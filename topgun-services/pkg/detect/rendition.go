@@ -0,0 +1,205 @@
+package detect
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image/jpeg"
+	"log"
+	"strconv"
+	"sync"
+
+	"github.com/nfnt/resize"
+)
+
+// videoRendition is the size and JPEG quality a viewer receives.
+// The zero value means the original frame as sent by the source.
+type videoRendition struct {
+	MaxWidth  uint
+	MaxHeight uint
+	Quality   int
+}
+
+// Quality presets selectable with quality=low|medium|high
+var renditionPresets = map[string]videoRendition{
+	"low":    {MaxWidth: 480, Quality: 50},
+	"medium": {MaxWidth: 854, Quality: 70},
+	"high":   {MaxWidth: 1280, Quality: 85},
+}
+
+const defaultRenditionQuality = 75
+
+// parseRendition builds a rendition from the quality preset and explicit width/height query values.
+// An explicit width or height overrides the preset size, the preset still selects the JPEG quality.
+func parseRendition(quality, width, height string) (videoRendition, error) {
+	var rendition videoRendition
+	if quality != "" && quality != "original" {
+		preset, ok := renditionPresets[quality]
+		if !ok {
+			return videoRendition{}, fmt.Errorf("quality must be one of low, medium, high or original, got %q", quality)
+		}
+		rendition = preset
+	}
+
+	if width != "" || height != "" {
+		maxWidth, err := parseDimension(width)
+		if err != nil {
+			return videoRendition{}, fmt.Errorf("width must be a positive integer, got %q", width)
+		}
+		maxHeight, err := parseDimension(height)
+		if err != nil {
+			return videoRendition{}, fmt.Errorf("height must be a positive integer, got %q", height)
+		}
+		rendition.MaxWidth = maxWidth
+		rendition.MaxHeight = maxHeight
+		if rendition.Quality == 0 {
+			rendition.Quality = defaultRenditionQuality
+		}
+	}
+	return rendition, nil
+}
+
+func parseDimension(value string) (uint, error) {
+	if value == "" {
+		return 0, nil
+	}
+	dimension, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || dimension == 0 {
+		return 0, fmt.Errorf("invalid dimension %q", value)
+	}
+	return uint(dimension), nil
+}
+
+// isOriginal reports whether the rendition passes frames through untouched
+func (r videoRendition) isOriginal() bool {
+	return r == videoRendition{}
+}
+
+// String returns the preset name or a WIDTHxHEIGHT@QUALITY label
+func (r videoRendition) String() string {
+	if r.isOriginal() {
+		return "original"
+	}
+	for name, preset := range renditionPresets {
+		if preset == r {
+			return name
+		}
+	}
+	return fmt.Sprintf("%dx%d@%d", r.MaxWidth, r.MaxHeight, r.Quality)
+}
+
+// renderedFrame is one frame encoded for a single rendition
+type renderedFrame struct {
+	jpeg    []byte // JPEG bytes for MJPEG viewers
+	message []byte // JSON VideoFrameMessage for WebSocket viewers
+}
+
+// renderFrame re-encodes a decoded source frame for the rendition
+func renderFrame(frame *VideoFrameMessage, frameData []byte, rendition videoRendition) (*renderedFrame, error) {
+	if rendition.isOriginal() {
+		return &renderedFrame{jpeg: frameData, message: mustMarshal(frame)}, nil
+	}
+
+	jpegData, width, height, err := encodeRendition(frameData, rendition)
+	if err != nil {
+		return nil, err
+	}
+
+	rendered := *frame
+	rendered.Frame = base64.StdEncoding.EncodeToString(jpegData)
+	rendered.Width = width
+	rendered.Height = height
+
+	return &renderedFrame{jpeg: jpegData, message: mustMarshal(&rendered)}, nil
+}
+
+// encodeRendition scales a JPEG to the rendition bounds and re-encodes it,
+// returning the new JPEG bytes and its dimensions
+func encodeRendition(frameData []byte, rendition videoRendition) ([]byte, int, int, error) {
+	img, err := jpeg.Decode(bytes.NewReader(frameData))
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to decode JPEG image: %w", err)
+	}
+
+	// Bilinear keeps live re-encoding cheap, saved captures still use Lanczos3
+	resizedImg := scaleToFit(img, rendition.MaxWidth, rendition.MaxHeight, resize.Bilinear)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, resizedImg, &jpeg.Options{Quality: rendition.Quality}); err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to encode JPEG: %w", err)
+	}
+
+	return buf.Bytes(), resizedImg.Bounds().Dx(), resizedImg.Bounds().Dy(), nil
+}
+
+// liveFrame is one source frame shared by every viewer it is offered to. The hub only hands it
+// out, each rendition is encoded on first use by a viewer's writer goroutine and then cached, so
+// a slow encode never holds up the hub and viewers of the same rendition share one encode.
+type liveFrame struct {
+	source *VideoFrameMessage
+
+	decodeOnce sync.Once
+	frameData  []byte // decoded JPEG, nil if the base64 payload is invalid
+
+	mutex      sync.Mutex
+	renditions map[videoRendition]*renditionCache
+}
+
+// renditionCache is a rendition of a live frame, rendered once
+type renditionCache struct {
+	once     sync.Once
+	rendered *renderedFrame
+}
+
+func newLiveFrame(frame *VideoFrameMessage) *liveFrame {
+	return &liveFrame{source: frame, renditions: make(map[videoRendition]*renditionCache)}
+}
+
+// render returns the frame encoded for the rendition, nil if it cannot be rendered
+func (f *liveFrame) render(rendition videoRendition) *renderedFrame {
+	f.mutex.Lock()
+	cache, ok := f.renditions[rendition]
+	if !ok {
+		cache = &renditionCache{}
+		f.renditions[rendition] = cache
+	}
+	f.mutex.Unlock()
+
+	cache.once.Do(func() {
+		f.decodeOnce.Do(func() {
+			frameData, err := base64.StdEncoding.DecodeString(f.source.Frame)
+			if err != nil {
+				log.Printf("Failed to decode video frame: %v", err)
+				return
+			}
+			f.frameData = frameData
+		})
+		if f.frameData == nil {
+			// Without the JPEG only the original JSON message can be delivered
+			if rendition.isOriginal() {
+				cache.rendered = &renderedFrame{message: mustMarshal(f.source)}
+			}
+			return
+		}
+
+		rendered, err := renderFrame(f.source, f.frameData, rendition)
+		if err != nil {
+			log.Printf("Failed to render %s video frame: %v", rendition, err)
+			return
+		}
+		cache.rendered = rendered
+	})
+	return cache.rendered
+}
+
+// payload returns the bytes a viewer receives: JPEG for raw viewers, the JSON message otherwise
+func (f *liveFrame) payload(rendition videoRendition, raw bool) []byte {
+	rendered := f.render(rendition)
+	if rendered == nil {
+		return nil
+	}
+	if raw {
+		return rendered.jpeg
+	}
+	return rendered.message
+}
@@ -0,0 +1,244 @@
+package detect_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"net"
+	"testing"
+	"time"
+
+	"topgun-services/pkg/detect"
+
+	fastws "github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+func TestVideoRenditions(t *testing.T) {
+	app := fiber.New()
+	app.Get("/ws/video-stream", detect.WebSocketUpgrade(), detect.NewDetectHandlerForWebSocket().HandleVideoStream())
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go app.Listener(listener)
+	defer app.Shutdown()
+
+	source, err := testJPEG(640, 360)
+	if err != nil {
+		t.Fatalf("failed to encode test frame: %v", err)
+	}
+	broadcast := func(cameraID uuid.UUID) {
+		detect.BroadcastVideoFrame(&detect.VideoFrameMessage{
+			CameraID: cameraID,
+			Frame:    base64.StdEncoding.EncodeToString(source),
+			Width:    640,
+			Height:   360,
+		})
+	}
+
+	// connect subscribes to a camera and reads the confirmation message
+	connect := func(cameraID uuid.UUID, query string) (*fastws.Conn, error) {
+		conn, _, err := fastws.DefaultDialer.Dial(fmt.Sprintf("ws://%s/ws/video-stream?camera_id=%s%s", listener.Addr(), cameraID, query), nil)
+		if err != nil {
+			return nil, err
+		}
+		var confirmation map[string]interface{}
+		if err := conn.ReadJSON(&confirmation); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if confirmation["status"] != "connected" {
+			conn.Close()
+			return nil, fmt.Errorf("expected a connected status, got %v", confirmation)
+		}
+		return conn, nil
+	}
+
+	// nextFrame broadcasts the source frame until a frame message arrives, skipping status events
+	nextFrame := func(conn *fastws.Conn, cameraID uuid.UUID) (*detect.VideoFrameMessage, []byte, error) {
+		messages := make(chan []byte)
+		errs := make(chan error, 1)
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			for {
+				_, data, err := conn.ReadMessage()
+				if err != nil {
+					errs <- err
+					return
+				}
+				select {
+				case messages <- data:
+				case <-done:
+					return
+				}
+			}
+		}()
+
+		deadline := time.After(5 * time.Second)
+		for {
+			select {
+			case data := <-messages:
+				var frame detect.VideoFrameMessage
+				if err := json.Unmarshal(data, &frame); err != nil || frame.Frame == "" {
+					continue
+				}
+				frameData, err := base64.StdEncoding.DecodeString(frame.Frame)
+				return &frame, frameData, err
+			case err := <-errs:
+				return nil, nil, err
+			case <-time.After(50 * time.Millisecond):
+				broadcast(cameraID)
+			case <-deadline:
+				return nil, nil, errors.New("no frame received")
+			}
+		}
+	}
+
+	// expectSize checks both the message dimensions and the encoded JPEG
+	expectSize := func(frame *detect.VideoFrameMessage, frameData []byte, width, height int) error {
+		if frame.Width != width || frame.Height != height {
+			return fmt.Errorf("expected a %dx%d message, got %dx%d", width, height, frame.Width, frame.Height)
+		}
+		config, err := jpeg.DecodeConfig(bytes.NewReader(frameData))
+		if err != nil {
+			return err
+		}
+		if config.Width != width || config.Height != height {
+			return fmt.Errorf("expected a %dx%d JPEG, got %dx%d", width, height, config.Width, config.Height)
+		}
+		return nil
+	}
+
+	tests := []Test{
+		{
+			TestName: "OriginalPassesFrameThrough",
+			Func: func() error {
+				cameraID := uuid.New()
+				conn, err := connect(cameraID, "")
+				if err != nil {
+					return err
+				}
+				defer conn.Close()
+
+				frame, frameData, err := nextFrame(conn, cameraID)
+				if err != nil {
+					return err
+				}
+				if !bytes.Equal(frameData, source) {
+					return errors.New("the original rendition must pass the frame through unchanged")
+				}
+				return expectSize(frame, frameData, 640, 360)
+			},
+		},
+		{
+			TestName: "QualityPresetScalesFrames",
+			Func: func() error {
+				cameraID := uuid.New()
+				conn, err := connect(cameraID, "&quality=low")
+				if err != nil {
+					return err
+				}
+				defer conn.Close()
+
+				frame, frameData, err := nextFrame(conn, cameraID)
+				if err != nil {
+					return err
+				}
+				// low is at most 480 wide, the aspect ratio is kept
+				return expectSize(frame, frameData, 480, 270)
+			},
+		},
+		{
+			TestName: "WidthOverridesPreset",
+			Func: func() error {
+				cameraID := uuid.New()
+				conn, err := connect(cameraID, "&quality=high&width=200")
+				if err != nil {
+					return err
+				}
+				defer conn.Close()
+
+				frame, frameData, err := nextFrame(conn, cameraID)
+				if err != nil {
+					return err
+				}
+				return expectSize(frame, frameData, 200, 113)
+			},
+		},
+		{
+			TestName: "ViewersOfOneRenditionGetTheSameFrame",
+			Func: func() error {
+				cameraID := uuid.New()
+				first, err := connect(cameraID, "&quality=medium&width=320")
+				if err != nil {
+					return err
+				}
+				defer first.Close()
+				second, err := connect(cameraID, "&quality=medium&width=320")
+				if err != nil {
+					return err
+				}
+				defer second.Close()
+
+				// Both viewers are registered, a single broadcast reaches both
+				broadcast(cameraID)
+				var frames [2]*detect.VideoFrameMessage
+				for i, conn := range []*fastws.Conn{first, second} {
+					conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+					for frames[i] == nil {
+						var frame detect.VideoFrameMessage
+						if err := conn.ReadJSON(&frame); err != nil {
+							return err
+						}
+						if frame.Frame != "" {
+							frames[i] = &frame
+						}
+					}
+				}
+				if frames[0].Frame != frames[1].Frame {
+					return errors.New("viewers of the same rendition received different encodes")
+				}
+				frameData, err := base64.StdEncoding.DecodeString(frames[0].Frame)
+				if err != nil {
+					return err
+				}
+				return expectSize(frames[0], frameData, 320, 180)
+			},
+		},
+		{
+			TestName: "InvalidRenditionIsRejected",
+			Func: func() error {
+				for _, query := range []string{"&quality=ultra", "&width=0", "&height=tall"} {
+					conn, _, err := fastws.DefaultDialer.Dial(fmt.Sprintf("ws://%s/ws/video-stream?camera_id=%s%s", listener.Addr(), uuid.New(), query), nil)
+					if err != nil {
+						return err
+					}
+					var response map[string]interface{}
+					err = conn.ReadJSON(&response)
+					conn.Close()
+					if err != nil {
+						return err
+					}
+					if _, ok := response["error"]; !ok {
+						return fmt.Errorf("%s: expected an error message, got %v", query, response)
+					}
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			if err := test.Func(); err != nil {
+				t.Errorf("Test %s failed with error: %v", test.TestName, err)
+			}
+		})
+	}
+}
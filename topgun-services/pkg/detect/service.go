@@ -1,19 +1,32 @@
 package detect
 
 import (
+	"log"
+	"os"
 	"topgun-services/pkg/domain"
 	"topgun-services/pkg/models"
 )
 
 type detectService struct {
 	repository domain.DetectRepository
+	clips      *clipRecorder
 }
 
 func NewDetectService(repo domain.DetectRepository) domain.DetectService {
-	return &detectService{repository: repo}
+	service := &detectService{repository: repo}
+	service.clips = newClipRecorder(service.attachClip)
+	return service
 }
 func (s *detectService) CreateDetect(detect models.Detect) (*models.Detect, error) {
-	return s.repository.CreateDetect(detect)
+	createdDetect, err := s.repository.CreateDetect(detect)
+	if err != nil {
+		return nil, err
+	}
+
+	// Record a motion clip around the detection from the rolling frame buffer
+	s.clips.Capture(createdDetect)
+
+	return createdDetect, nil
 }
 func (s *detectService) GetDetects(pagination models.Pagination, filter models.Search, startDate, endDate string) ([]models.Detect, *models.Pagination, *models.Search, error) {
 	return s.repository.GetDetects(pagination, filter, startDate, endDate)
@@ -28,8 +41,30 @@ func (s *detectService) GetDetectFile(id uint) (*models.Detect, error) {
 	return s.repository.GetDetectFile(id)
 }
 func (s *detectService) UpdateDetect(id uint, detect models.Detect) (*models.Detect, error) {
+	// Clips are linked by the recorder only, a client supplied path would be served and removed
+	detect.ClipPath = ""
 	return s.repository.UpdateDetect(id, detect)
 }
 func (s *detectService) DeleteDetect(id uint) error {
-	return s.repository.DeleteDetect(id)
+	detect, err := s.repository.GetDetect(id)
+	if err != nil {
+		return err
+	}
+	if err := s.repository.DeleteDetect(id); err != nil {
+		return err
+	}
+
+	// Clips are retained only as long as their detection
+	if detect.ClipPath != "" && insideClipDir(detect.ClipPath) {
+		if err := os.Remove(detect.ClipPath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove clip %s: %v", detect.ClipPath, err)
+		}
+	}
+	return nil
+}
+
+// attachClip links a saved clip file to its detection
+func (s *detectService) attachClip(detectID uint, clipPath string) error {
+	_, err := s.repository.UpdateDetect(detectID, models.Detect{ClipPath: clipPath})
+	return err
 }
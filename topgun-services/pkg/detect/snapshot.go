@@ -0,0 +1,138 @@
+package detect
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	helpers "github.com/zercle/gofiber-helpers"
+)
+
+// @Summary GetSnapshot
+// @Tags Camera
+// @Description Get the most recent frame of a camera as a JPEG
+// @Produce image/jpeg
+// @Param id path string true "Camera ID"
+// @Param max_age query number false "Maximum frame age in seconds, older frames return 404"
+// @Param width query int false "Maximum width in pixels"
+// @Param height query int false "Maximum height in pixels"
+// @Param quality query string false "Rendition preset: low, medium, high or original"
+// @Router /api/v1/camera/{id}/snapshot [get]
+// @Security ApiKeyAuth
+func (h *cameraStreamHandler) GetSnapshot() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		cameraID, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusBadRequest,
+						Title:   "Invalid camera ID",
+						Message: err.Error(),
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+
+		maxAge := c.QueryFloat("max_age", 0)
+		if maxAge < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusBadRequest,
+						Title:   "Invalid max_age",
+						Message: "max_age must be zero or a positive number of seconds",
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+
+		rendition, err := parseRendition(c.Query("quality"), c.Query("width"), c.Query("height"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusBadRequest,
+						Title:   "Invalid rendition",
+						Message: err.Error(),
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+
+		frame, err := GetCameraLatestFrame(cameraID)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusNotFound,
+						Title:   "Snapshot not found",
+						Message: err.Error(),
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+
+		// Staleness uses the server receive time so a skewed camera clock can't hide a dead feed
+		if age := time.Since(frame.ReceivedAt); maxAge > 0 && age.Seconds() > maxAge {
+			return c.Status(fiber.StatusNotFound).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusNotFound,
+						Title:   "Snapshot is stale",
+						Message: fmt.Sprintf("latest frame is %.1f seconds old, max_age is %.1f", age.Seconds(), maxAge),
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+
+		c.Set(fiber.HeaderLastModified, snapshotTime(frame).UTC().Format(http.TimeFormat))
+		c.Set(fiber.HeaderCacheControl, "no-cache")
+		if c.Fresh() {
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+
+		frameData := frame.Data
+		if !rendition.isOriginal() {
+			frameData, _, _, err = encodeRendition(frame.Data, rendition)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(helpers.ResponseForm{
+					Success: false,
+					Errors: []helpers.ResponseError{
+						{
+							Code:    fiber.StatusInternalServerError,
+							Title:   "Failed to resize snapshot",
+							Message: err.Error(),
+							Source:  helpers.WhereAmI(),
+						},
+					},
+				})
+			}
+		}
+
+		c.Set(fiber.HeaderContentType, "image/jpeg")
+		return c.Send(frameData)
+	}
+}
+
+// snapshotTime returns the source timestamp of a frame, or the receive time when the source sent none
+func snapshotTime(frame BufferedFrame) time.Time {
+	if frame.Timestamp <= 0 {
+		return frame.ReceivedAt
+	}
+	seconds := int64(frame.Timestamp)
+	nanos := int64((frame.Timestamp - float64(seconds)) * float64(time.Second))
+	return time.Unix(seconds, nanos)
+}
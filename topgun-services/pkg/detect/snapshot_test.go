@@ -0,0 +1,133 @@
+package detect_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"topgun-services/pkg/detect"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+func TestSnapshot(t *testing.T) {
+	var source bytes.Buffer
+	if err := jpeg.Encode(&source, image.NewRGBA(image.Rect(0, 0, 640, 480)), nil); err != nil {
+		t.Fatalf("failed to encode test frame: %v", err)
+	}
+
+	cameraID := uuid.New()
+	frameTime := time.Unix(1700000000, 0)
+	detect.UpdateVideoFrameCache(&detect.VideoFrameMessage{
+		CameraID:  cameraID,
+		Frame:     base64.StdEncoding.EncodeToString(source.Bytes()),
+		Timestamp: float64(frameTime.Unix()),
+	})
+
+	app := fiber.New()
+	detect.NewCameraStreamHandler(app.Group("/camera"))
+
+	get := func(path string, header http.Header) (*http.Response, error) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		return app.Test(req)
+	}
+
+	tests := []Test{
+		{
+			TestName: "ReturnsLatestFrame",
+			Func: func() error {
+				resp, err := get(fmt.Sprintf("/camera/%s/snapshot", cameraID), nil)
+				if err != nil {
+					return err
+				}
+				if resp.StatusCode != fiber.StatusOK {
+					return fmt.Errorf("expected status 200, got %d", resp.StatusCode)
+				}
+				if got := resp.Header.Get(fiber.HeaderContentType); got != "image/jpeg" {
+					return fmt.Errorf("expected image/jpeg, got %q", got)
+				}
+				if got := resp.Header.Get(fiber.HeaderLastModified); got != frameTime.UTC().Format(http.TimeFormat) {
+					return fmt.Errorf("unexpected Last-Modified %q", got)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "NotModifiedSinceFrame",
+			Func: func() error {
+				header := http.Header{}
+				header.Set(fiber.HeaderIfModifiedSince, frameTime.UTC().Format(http.TimeFormat))
+				resp, err := get(fmt.Sprintf("/camera/%s/snapshot", cameraID), header)
+				if err != nil {
+					return err
+				}
+				if resp.StatusCode != fiber.StatusNotModified {
+					return fmt.Errorf("expected status 304, got %d", resp.StatusCode)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "Resize",
+			Func: func() error {
+				resp, err := get(fmt.Sprintf("/camera/%s/snapshot?width=160", cameraID), nil)
+				if err != nil {
+					return err
+				}
+				img, err := jpeg.Decode(resp.Body)
+				if err != nil {
+					return err
+				}
+				if img.Bounds().Dx() != 160 || img.Bounds().Dy() != 120 {
+					return fmt.Errorf("expected 160x120, got %dx%d", img.Bounds().Dx(), img.Bounds().Dy())
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "StaleFrame",
+			Func: func() error {
+				time.Sleep(20 * time.Millisecond)
+				resp, err := get(fmt.Sprintf("/camera/%s/snapshot?max_age=0.01", cameraID), nil)
+				if err != nil {
+					return err
+				}
+				if resp.StatusCode != fiber.StatusNotFound {
+					return fmt.Errorf("expected status 404, got %d", resp.StatusCode)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "UnknownCamera",
+			Func: func() error {
+				resp, err := get(fmt.Sprintf("/camera/%s/snapshot", uuid.New()), nil)
+				if err != nil {
+					return err
+				}
+				if resp.StatusCode != fiber.StatusNotFound {
+					return fmt.Errorf("expected status 404, got %d", resp.StatusCode)
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			if err := test.Func(); err != nil {
+				t.Errorf("Test %s failed with error: %v", test.TestName, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package detect
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Liveness states of a video source
+const (
+	SourceStatusOnline   = "online"
+	SourceStatusDegraded = "degraded" // frames are late or below the minimum fps
+	SourceStatusOffline  = "offline"
+)
+
+// How often source liveness is re-evaluated
+const sourceCheckInterval = 500 * time.Millisecond
+
+// Window over which the frame rate of a source is measured
+const sourceFPSWindow = 5 * time.Second
+
+// VideoSourceStats is the liveness of one camera's video source
+type VideoSourceStats struct {
+	CameraID       uuid.UUID `json:"camera_id"`
+	Status         string    `json:"status"`
+	LastFrameAt    time.Time `json:"last_frame_at"`
+	FPS            float64   `json:"fps"` // measured over the last few seconds
+	Width          int       `json:"width"`
+	Height         int       `json:"height"`
+	Model          string    `json:"model"`
+	FramesReceived uint64    `json:"frames_received"`
+	StatusSince    time.Time `json:"status_since"`
+}
+
+// SourceStatusEvent is sent to viewers and dashboards when a source changes status
+type SourceStatusEvent struct {
+	Type           string `json:"type"` // always source_status
+	PreviousStatus string `json:"previous_status"`
+	VideoSourceStats
+}
+
+// videoSource tracks frames received from one camera
+type videoSource struct {
+	stats    VideoSourceStats
+	arrivals []time.Time // receive times within the fps window
+}
+
+// videoSourceTracker keeps the liveness of every camera that has sent a frame
+type videoSourceTracker struct {
+	sources     map[uuid.UUID]*videoSource
+	mutex       sync.Mutex
+	monitorOnce sync.Once
+}
+
+// Global video source tracker, fed by frames from local sources and the backplane
+var videoSources = &videoSourceTracker{sources: make(map[uuid.UUID]*videoSource)}
+
+// Stream of source status changes for SSE dashboards
+var sourceEvents = newEventStream()
+
+// record updates a source with a received frame and returns a status event if it came back online
+func (t *videoSourceTracker) record(frame *VideoFrameMessage, now time.Time) *SourceStatusEvent {
+	// Started with the first frame rather than in init, which runs before the config is loaded
+	t.monitorOnce.Do(func() { go t.monitor() })
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	source, ok := t.sources[frame.CameraID]
+	if !ok {
+		source = &videoSource{stats: VideoSourceStats{CameraID: frame.CameraID}}
+		t.sources[frame.CameraID] = source
+	}
+
+	source.arrivals = append(source.arrivals, now)
+	source.measure(now)
+	source.stats.LastFrameAt = now
+	source.stats.Width = frame.Width
+	source.stats.Height = frame.Height
+	source.stats.Model = frame.Model
+	source.stats.FramesReceived++
+
+	// A fresh frame ends an outage right away
+	switch source.stats.Status {
+	case SourceStatusOnline:
+		return nil
+	case SourceStatusDegraded:
+		// A low frame rate stays degraded until it recovers
+		if source.belowMinFPS() {
+			return nil
+		}
+	}
+	return source.transition(SourceStatusOnline, now)
+}
+
+// disconnect marks a source offline as soon as its input connection closes
+func (t *videoSourceTracker) disconnect(cameraID uuid.UUID, now time.Time) *SourceStatusEvent {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	source, ok := t.sources[cameraID]
+	if !ok || source.stats.Status == SourceStatusOffline {
+		return nil
+	}
+	source.arrivals = nil
+	source.stats.FPS = 0
+	return source.transition(SourceStatusOffline, now)
+}
+
+// evaluate re-checks every source against the liveness thresholds and returns the status changes
+func (t *videoSourceTracker) evaluate(now time.Time) []*SourceStatusEvent {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	degradedAfter := settings().SourceDegradedAfter
+	offlineAfter := settings().SourceOfflineAfter
+
+	var events []*SourceStatusEvent
+	for _, source := range t.sources {
+		age := now.Sub(source.stats.LastFrameAt)
+
+		// Recompute the rate so a source that stopped does not keep its last fps
+		source.measure(now)
+
+		status := SourceStatusOnline
+		switch {
+		case age > offlineAfter:
+			status = SourceStatusOffline
+		case age > degradedAfter || source.belowMinFPS():
+			status = SourceStatusDegraded
+		}
+		if status != source.stats.Status {
+			events = append(events, source.transition(status, now))
+		}
+	}
+	return events
+}
+
+// measure drops receive times outside the fps window and updates the measured frame rate
+func (s *videoSource) measure(now time.Time) {
+	cutoff := now.Add(-sourceFPSWindow)
+	drop := 0
+	for drop < len(s.arrivals) && s.arrivals[drop].Before(cutoff) {
+		drop++
+	}
+	s.arrivals = s.arrivals[drop:]
+	s.stats.FPS = measuredFPS(s.arrivals)
+}
+
+// belowMinFPS reports whether a source with a full measurement window is slower than video.source.min_fps
+func (s *videoSource) belowMinFPS() bool {
+	minFPS := settings().SourceMinFPS
+	if minFPS <= 0 || len(s.arrivals) < 2 {
+		return false
+	}
+	// Too early to judge a source that just connected
+	if s.arrivals[len(s.arrivals)-1].Sub(s.arrivals[0]) < sourceFPSWindow/2 {
+		return false
+	}
+	return s.stats.FPS < minFPS
+}
+
+// transition changes the status and returns the event describing the change
+func (s *videoSource) transition(status string, now time.Time) *SourceStatusEvent {
+	event := &SourceStatusEvent{
+		Type:           "source_status",
+		PreviousStatus: s.stats.Status,
+	}
+	s.stats.Status = status
+	s.stats.StatusSince = now
+	event.VideoSourceStats = s.stats
+	return event
+}
+
+// measuredFPS returns the frame rate over the receive times, oldest first
+func measuredFPS(arrivals []time.Time) float64 {
+	if len(arrivals) < 2 {
+		return 0
+	}
+	span := arrivals[len(arrivals)-1].Sub(arrivals[0]).Seconds()
+	if span <= 0 {
+		return 0
+	}
+	return float64(len(arrivals)-1) / span
+}
+
+// list returns the liveness of every known source ordered by camera
+func (t *videoSourceTracker) list() []VideoSourceStats {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	sources := make([]VideoSourceStats, 0, len(t.sources))
+	for _, source := range t.sources {
+		sources = append(sources, source.stats)
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		return sources[i].CameraID.String() < sources[j].CameraID.String()
+	})
+	return sources
+}
+
+// monitor periodically downgrades sources that stopped sending frames
+func (t *videoSourceTracker) monitor() {
+	ticker := time.NewTicker(sourceCheckInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		for _, event := range t.evaluate(now) {
+			publishSourceStatus(event)
+		}
+	}
+}
+
+// publishSourceStatus sends a status change to video viewers and SSE dashboards
+func publishSourceStatus(event *SourceStatusEvent) {
+	if event == nil {
+		return
+	}
+	log.Printf("Video source %s is %s (was %q)", event.CameraID, event.Status, event.PreviousStatus)
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to marshal source status: %v", err)
+		return
+	}
+	sourceEvents.publish(data, nil, event.CameraID, "")
+	if videoHub != nil {
+		videoHub.sendStatus(event.CameraID, data)
+	}
+}
+
+// GetVideoSources returns the liveness of every camera that has sent a frame
+func GetVideoSources() []VideoSourceStats {
+	return videoSources.list()
+}
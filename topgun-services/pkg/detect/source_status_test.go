@@ -0,0 +1,127 @@
+package detect_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"net"
+	"testing"
+	"time"
+
+	"topgun-services/pkg/detect"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+func TestVideoSourceStatus(t *testing.T) {
+	config := detect.DefaultConfig()
+	config.SSEHeartbeat = 50 * time.Millisecond
+	config.SourceDegradedAfter = 300 * time.Millisecond
+	config.SourceOfflineAfter = 800 * time.Millisecond
+	detect.SetConfig(config)
+	defer detect.SetConfig(detect.DefaultConfig())
+
+	var source bytes.Buffer
+	if err := jpeg.Encode(&source, image.NewRGBA(image.Rect(0, 0, 64, 48)), nil); err != nil {
+		t.Fatalf("failed to encode test frame: %v", err)
+	}
+	frame := base64.StdEncoding.EncodeToString(source.Bytes())
+
+	app := fiber.New()
+	detect.NewVideoHandler(app.Group("/video"))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go app.Listener(listener)
+	defer app.Shutdown()
+
+	cameraID := uuid.New()
+	stream, reader, err := openEventStream(fmt.Sprintf("http://%s/video/source-events?camera_id=%s", listener.Addr(), cameraID), "")
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	defer stream.Body.Close()
+
+	readStatus := func() (detect.SourceStatusEvent, error) {
+		var event detect.SourceStatusEvent
+		events, err := readSSEEvents(reader, 1, 3*time.Second)
+		if err != nil {
+			return event, err
+		}
+		err = json.Unmarshal([]byte(events[0].Data), &event)
+		return event, err
+	}
+
+	tests := []Test{
+		{
+			TestName: "OnlineOnFirstFrame",
+			Func: func() error {
+				detect.BroadcastVideoFrame(&detect.VideoFrameMessage{
+					CameraID: cameraID,
+					Frame:    frame,
+					Width:    64,
+					Height:   48,
+					Model:    "yolov8n",
+				})
+				event, err := readStatus()
+				if err != nil {
+					return err
+				}
+				if event.Status != detect.SourceStatusOnline || event.PreviousStatus != "" {
+					return fmt.Errorf("expected online from unknown, got %q from %q", event.Status, event.PreviousStatus)
+				}
+				if event.Model != "yolov8n" || event.Width != 64 || event.Height != 48 {
+					return fmt.Errorf("unexpected source details %+v", event.VideoSourceStats)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "DegradedThenOffline",
+			Func: func() error {
+				event, err := readStatus()
+				if err != nil {
+					return err
+				}
+				if event.Status != detect.SourceStatusDegraded {
+					return fmt.Errorf("expected degraded, got %q", event.Status)
+				}
+				event, err = readStatus()
+				if err != nil {
+					return err
+				}
+				if event.Status != detect.SourceStatusOffline || event.PreviousStatus != detect.SourceStatusDegraded {
+					return fmt.Errorf("expected offline from degraded, got %q from %q", event.Status, event.PreviousStatus)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "OnlineAgainAfterOutage",
+			Func: func() error {
+				detect.BroadcastVideoFrame(&detect.VideoFrameMessage{CameraID: cameraID, Frame: frame})
+				event, err := readStatus()
+				if err != nil {
+					return err
+				}
+				if event.Status != detect.SourceStatusOnline || event.PreviousStatus != detect.SourceStatusOffline {
+					return fmt.Errorf("expected online from offline, got %q from %q", event.Status, event.PreviousStatus)
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			if err := test.Func(); err != nil {
+				t.Errorf("Test %s failed with error: %v", test.TestName, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package detect
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	helpers "github.com/zercle/gofiber-helpers"
+)
+
+// How long an EventSource waits before reconnecting after the stream ends
+const sseRetryMillis = 3000
+
+// @Summary HandleDetectionEvents
+// @Tags Detect
+// @Description Stream detections as Server-Sent Events, with the same payload as the detection WebSocket.
+// @Description Reconnecting with the Last-Event-ID header (or last_event_id query) replays missed events.
+// @Produce text/event-stream
+// @Param camera_id query string false "Only send detections from this camera"
+// @Param image_mode query string false "url (default) for signed image URLs or inline for base64 images"
+// @Param last_event_id query string false "Resume after this event ID"
+// @Router /api/v1/detect/events [get]
+// @Security ApiKeyAuth
+func (h *detectHandler) HandleDetectionEvents() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var filter streamFilter
+		if cameraIDParam := c.Query("camera_id"); cameraIDParam != "" {
+			cameraID, err := uuid.Parse(cameraIDParam)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(helpers.ResponseForm{
+					Success: false,
+					Errors: []helpers.ResponseError{
+						{
+							Code:    fiber.StatusBadRequest,
+							Title:   "Invalid camera ID",
+							Message: err.Error(),
+							Source:  helpers.WhereAmI(),
+						},
+					},
+				})
+			}
+			filter.CameraID = cameraID
+		}
+
+		inlineImages, err := parseImageMode(c.Query("image_mode"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusBadRequest,
+						Title:   "Invalid image mode",
+						Message: err.Error(),
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+
+		return streamEvents(c, detectionEvents, "detection", filter, inlineImages)
+	}
+}
+
+// @Summary HandleAttackEvents
+// @Tags Detect
+// @Description Stream attack data as Server-Sent Events, with the same payload as the attack WebSocket.
+// @Description Reconnecting with the Last-Event-ID header (or last_event_id query) replays missed events.
+// @Produce text/event-stream
+// @Param drone_id query string false "Only send attacks from this drone"
+// @Param last_event_id query string false "Resume after this event ID"
+// @Router /api/v1/detect/attack-events [get]
+// @Security ApiKeyAuth
+func (h *detectHandler) HandleAttackEvents() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		filter := streamFilter{DroneID: c.Query("drone_id")}
+		return streamEvents(c, attackEvents, "attack", filter, false)
+	}
+}
+
+// streamEvents replays missed events and then writes live events until the client goes away
+func streamEvents(c *fiber.Ctx, stream *eventStream, eventName string, filter streamFilter, inlineImages bool) error {
+	lastEventID := c.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+
+	subscriber, replay := stream.subscribe(filter, inlineImages, lastEventID)
+	heartbeatInterval := settings().SSEHeartbeat
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	// Stop nginx from buffering the stream
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer stream.unsubscribe(subscriber)
+
+		if _, err := fmt.Fprintf(w, "retry: %d\n\n", sseRetryMillis); err != nil {
+			return
+		}
+		for _, event := range replay {
+			if err := writeSSEEvent(w, eventName, event.ID, event.payload(inlineImages)); err != nil {
+				return
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		heartbeat := time.NewTicker(heartbeatInterval)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case event, ok := <-subscriber.events:
+				if !ok {
+					// Dropped for falling behind, the client resumes with Last-Event-ID
+					log.Printf("SSE %s client fell behind, closing stream", eventName)
+					return
+				}
+				if err := writeSSEEvent(w, eventName, event.ID, event.payload(inlineImages)); err != nil {
+					return
+				}
+			case <-heartbeat.C:
+				if _, err := fmt.Fprintf(w, ": heartbeat %d\n\n", time.Now().Unix()); err != nil {
+					return
+				}
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+
+	return nil
+}
+
+// writeSSEEvent writes one event in text/event-stream format, payloads are single-line JSON
+func writeSSEEvent(w *bufio.Writer, eventName, id string, data []byte) error {
+	_, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", id, eventName, data)
+	return err
+}
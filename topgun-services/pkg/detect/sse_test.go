@@ -0,0 +1,199 @@
+package detect_test
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"topgun-services/pkg/detect"
+	"topgun-services/pkg/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// sseEvent is one event parsed from a text/event-stream response
+type sseEvent struct {
+	ID    string
+	Event string
+	Data  string
+}
+
+// readSSEEvents reads events until count are received, skipping comments and retry lines
+func readSSEEvents(reader *bufio.Reader, count int, timeout time.Duration) ([]sseEvent, error) {
+	type result struct {
+		events []sseEvent
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var events []sseEvent
+		var event sseEvent
+		for len(events) < count {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				done <- result{events, err}
+				return
+			}
+			line = strings.TrimRight(line, "\n")
+			switch {
+			case line == "":
+				if event.Data != "" {
+					events = append(events, event)
+				}
+				event = sseEvent{}
+			case strings.HasPrefix(line, "id: "):
+				event.ID = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				event.Event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				event.Data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+		done <- result{events, nil}
+	}()
+
+	select {
+	case r := <-done:
+		return r.events, r.err
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("timed out waiting for %d events", count)
+	}
+}
+
+// openEventStream opens an SSE stream and waits for the first heartbeat so the subscription is registered
+func openEventStream(url, lastEventID string) (*http.Response, *bufio.Reader, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			resp.Body.Close()
+			return nil, nil, err
+		}
+		if strings.HasPrefix(line, ": heartbeat") {
+			return resp, reader, nil
+		}
+	}
+}
+
+func TestAttackEvents(t *testing.T) {
+	config := detect.DefaultConfig()
+	config.SSEHeartbeat = 50 * time.Millisecond
+	detect.SetConfig(config)
+	defer detect.SetConfig(detect.DefaultConfig())
+
+	app := fiber.New()
+	detect.NewDetectHandler(app.Group("/detect"), nil)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go app.Listener(listener)
+	defer app.Shutdown()
+
+	baseURL := fmt.Sprintf("http://%s/detect/attack-events", listener.Addr())
+
+	subscribe := func(query, lastEventID string) (*http.Response, *bufio.Reader, error) {
+		return openEventStream(baseURL+query, lastEventID)
+	}
+
+	tests := []Test{
+		{
+			TestName: "FiltersByDrone",
+			Func: func() error {
+				resp, reader, err := subscribe("?drone_id=drone-b", "")
+				if err != nil {
+					return err
+				}
+				defer resp.Body.Close()
+
+				if got := resp.Header.Get(fiber.HeaderContentType); got != "text/event-stream" {
+					return fmt.Errorf("expected text/event-stream, got %q", got)
+				}
+
+				detect.BroadcastAttack(&models.Attack{DroneID: "drone-a", Status: "skipped"})
+				detect.BroadcastAttack(&models.Attack{DroneID: "drone-b", Status: "wanted"})
+
+				events, err := readSSEEvents(reader, 1, 2*time.Second)
+				if err != nil {
+					return err
+				}
+				if events[0].Event != "attack" {
+					return fmt.Errorf("expected attack event, got %q", events[0].Event)
+				}
+				if !strings.Contains(events[0].Data, `"drone_id":"drone-b"`) {
+					return fmt.Errorf("expected drone-b payload, got %s", events[0].Data)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "ResumesFromLastEventID",
+			Func: func() error {
+				resp, reader, err := subscribe("?drone_id=drone-c", "")
+				if err != nil {
+					return err
+				}
+				detect.BroadcastAttack(&models.Attack{DroneID: "drone-c", Status: "first"})
+				events, err := readSSEEvents(reader, 1, 2*time.Second)
+				resp.Body.Close()
+				if err != nil {
+					return err
+				}
+				lastEventID := events[0].ID
+
+				// Published while the client is disconnected
+				detect.BroadcastAttack(&models.Attack{DroneID: "drone-c", Status: "second"})
+				detect.BroadcastAttack(&models.Attack{DroneID: "drone-c", Status: "third"})
+				time.Sleep(50 * time.Millisecond)
+
+				req, err := http.NewRequest(http.MethodGet, baseURL+"?drone_id=drone-c", nil)
+				if err != nil {
+					return err
+				}
+				req.Header.Set("Last-Event-ID", lastEventID)
+				resp, err = http.DefaultClient.Do(req)
+				if err != nil {
+					return err
+				}
+				defer resp.Body.Close()
+
+				events, err = readSSEEvents(bufio.NewReader(resp.Body), 2, 2*time.Second)
+				if err != nil {
+					return err
+				}
+				if !strings.Contains(events[0].Data, `"status":"second"`) || !strings.Contains(events[1].Data, `"status":"third"`) {
+					return fmt.Errorf("unexpected replay %q, %q", events[0].Data, events[1].Data)
+				}
+				if events[0].ID == lastEventID {
+					return errors.New("replay repeated the last seen event")
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			if err := test.Func(); err != nil {
+				t.Errorf("Test %s failed with error: %v", test.TestName, err)
+			}
+		})
+	}
+}
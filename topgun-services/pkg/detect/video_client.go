@@ -0,0 +1,180 @@
+package detect
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/google/uuid"
+)
+
+// Video client structure
+// Each client holds only the newest undelivered frame, older pending frames are dropped.
+type VideoClient struct {
+	id          uuid.UUID
+	conn        *websocket.Conn
+	remoteAddr  string
+	userID      string
+	cameraID    uuid.UUID // uuid.Nil receives frames from every camera
+	raw         bool      // receive decoded JPEG bytes instead of JSON messages
+	transport   string    // websocket, mjpeg or webrtc
+	rendition   videoRendition
+	maxFPS      float64 // 0 = unlimited
+	connectedAt time.Time
+
+	notify chan struct{} // signalled when a new frame is pending
+	done   chan struct{} // closed when the hub unregisters the client
+	status chan []byte   // source status events for WebSocket viewers
+
+	mutex         sync.Mutex
+	pending       *liveFrame
+	pendingSince  time.Time
+	lastDelivered time.Time
+	framesSent    uint64
+	framesDropped uint64
+	bytesSent     uint64
+}
+
+// VideoViewerStats is the delivery state of one video viewer
+type VideoViewerStats struct {
+	ID              uuid.UUID  `json:"id"`
+	RemoteAddr      string     `json:"remote_addr"`
+	CameraID        *uuid.UUID `json:"camera_id"`
+	Transport       string     `json:"transport"`
+	Rendition       string     `json:"rendition"`
+	MaxFPS          float64    `json:"max_fps"`
+	ConnectedAt     time.Time  `json:"connected_at"`
+	LastDeliveredAt *time.Time `json:"last_delivered_at"`
+	FramesSent      uint64     `json:"frames_sent"`
+	FramesDropped   uint64     `json:"frames_dropped"`
+}
+
+// newVideoClient creates a viewer; maxFPS <= 0 falls back to video.viewer.max_fps
+func newVideoClient(conn *websocket.Conn, remoteAddr string, cameraID uuid.UUID, raw bool, rendition videoRendition, maxFPS float64) *VideoClient {
+	if maxFPS <= 0 {
+		maxFPS = settings().ViewerMaxFPS
+	}
+	transport := "websocket"
+	if raw {
+		transport = "mjpeg"
+	}
+	return &VideoClient{
+		id:          uuid.New(),
+		conn:        conn,
+		remoteAddr:  remoteAddr,
+		cameraID:    cameraID,
+		raw:         raw,
+		transport:   transport,
+		rendition:   rendition,
+		maxFPS:      maxFPS,
+		connectedAt: time.Now(),
+		notify:      make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		status:      make(chan []byte, 8),
+	}
+}
+
+// offer replaces the pending frame with a newer one without blocking the hub
+func (vc *VideoClient) offer(frame *liveFrame, now time.Time) {
+	vc.mutex.Lock()
+	if vc.pending != nil {
+		vc.framesDropped++
+	} else {
+		vc.pendingSince = now
+	}
+	vc.pending = frame
+	vc.mutex.Unlock()
+
+	select {
+	case vc.notify <- struct{}{}:
+	default:
+	}
+}
+
+// take returns the pending frame rendered for the client if the fps limit allows sending now,
+// otherwise it returns how long to wait before trying again
+func (vc *VideoClient) take(now time.Time) ([]byte, time.Duration) {
+	vc.mutex.Lock()
+	if vc.pending == nil {
+		vc.mutex.Unlock()
+		return nil, 0
+	}
+	if vc.maxFPS > 0 && !vc.lastDelivered.IsZero() {
+		interval := time.Duration(float64(time.Second) / vc.maxFPS)
+		if wait := vc.lastDelivered.Add(interval).Sub(now); wait > 0 {
+			vc.mutex.Unlock()
+			return nil, wait
+		}
+	}
+	frame := vc.pending
+	vc.pending = nil
+	vc.lastDelivered = now
+	vc.mutex.Unlock()
+
+	// Rendered outside the lock, the hub keeps offering newer frames meanwhile
+	payload := frame.payload(vc.rendition, vc.raw)
+	if payload == nil {
+		return nil, 0
+	}
+
+	vc.mutex.Lock()
+	vc.framesSent++
+	vc.bytesSent += uint64(len(payload))
+	vc.mutex.Unlock()
+	return payload, 0
+}
+
+// stalled reports whether a frame has been waiting longer than the stall timeout
+func (vc *VideoClient) stalled(now time.Time, timeout time.Duration) bool {
+	vc.mutex.Lock()
+	defer vc.mutex.Unlock()
+
+	if vc.pending == nil {
+		return false
+	}
+	// A low fps limit legitimately keeps frames pending for a full interval
+	if vc.maxFPS > 0 {
+		if interval := time.Duration(2 * float64(time.Second) / vc.maxFPS); interval > timeout {
+			timeout = interval
+		}
+	}
+	return now.Sub(vc.pendingSince) > timeout
+}
+
+// stats returns a snapshot of the client's delivery counters
+func (vc *VideoClient) stats() VideoViewerStats {
+	vc.mutex.Lock()
+	defer vc.mutex.Unlock()
+
+	stats := VideoViewerStats{
+		ID:            vc.id,
+		RemoteAddr:    vc.remoteAddr,
+		Transport:     vc.transport,
+		Rendition:     vc.rendition.String(),
+		MaxFPS:        vc.maxFPS,
+		ConnectedAt:   vc.connectedAt,
+		FramesSent:    vc.framesSent,
+		FramesDropped: vc.framesDropped,
+	}
+	if vc.cameraID != uuid.Nil {
+		cameraID := vc.cameraID
+		stats.CameraID = &cameraID
+	}
+	if !vc.lastDelivered.IsZero() {
+		lastDelivered := vc.lastDelivered
+		stats.LastDeliveredAt = &lastDelivered
+	}
+	return stats
+}
+
+// GetVideoViewerStats returns delivery counters for every connected video viewer
+func GetVideoViewerStats() []VideoViewerStats {
+	videoHub.mutex.RLock()
+	defer videoHub.mutex.RUnlock()
+
+	viewers := make([]VideoViewerStats, 0, len(videoHub.clients))
+	for client := range videoHub.clients {
+		viewers = append(viewers, client.stats())
+	}
+	return viewers
+}
@@ -0,0 +1,195 @@
+package detect_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"topgun-services/pkg/detect"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+func TestVideoViewerDelivery(t *testing.T) {
+	app := fiber.New()
+	detect.NewCameraStreamHandler(app.Group("/camera"))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go app.Listener(listener)
+	defer app.Shutdown()
+	streamURL := func(cameraID uuid.UUID, query string) string {
+		return fmt.Sprintf("http://%s/camera/%s/stream.mjpg%s", listener.Addr(), cameraID, query)
+	}
+
+	// The original rendition passes frames through, so any bytes identify a frame
+	broadcast := func(cameraID uuid.UUID, frame []byte) {
+		detect.BroadcastVideoFrame(&detect.VideoFrameMessage{
+			CameraID: cameraID,
+			Frame:    base64.StdEncoding.EncodeToString(frame),
+		})
+	}
+
+	tests := []Test{
+		{
+			TestName: "LatestFrameWins",
+			Func: func() error {
+				cameraID := uuid.New()
+				prime := func() { broadcast(cameraID, []byte("frame-0")) }
+				stream, err := openMJPEGStream(streamURL(cameraID, "?fps=1"), prime)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					stream.Close()
+					waitViewerGone(cameraID, prime)
+				}()
+
+				if _, _, err := stream.next(5 * time.Second); err != nil {
+					return err
+				}
+				// All of these arrive within the fps interval, only the newest is delivered
+				for i := 1; i <= 9; i++ {
+					broadcast(cameraID, []byte(fmt.Sprintf("frame-%d", i)))
+				}
+				frame, _, err := stream.next(3 * time.Second)
+				if err != nil {
+					return err
+				}
+				if string(frame) != "frame-9" {
+					return fmt.Errorf("expected frame-9 after the fps interval, got %q", frame)
+				}
+
+				viewer, ok := viewerOf(cameraID)
+				if !ok {
+					return errors.New("the viewer is not listed")
+				}
+				if viewer.FramesDropped < 8 || viewer.FramesSent < 2 || viewer.LastDeliveredAt == nil {
+					return fmt.Errorf("expected at least 8 dropped and 2 sent frames, got %d dropped and %d sent", viewer.FramesDropped, viewer.FramesSent)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "FPSLimitSpacesFrames",
+			Func: func() error {
+				cameraID := uuid.New()
+				prime := func() { broadcast(cameraID, []byte("frame")) }
+				stream, err := openMJPEGStream(streamURL(cameraID, "?fps=5"), prime)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					stream.Close()
+					waitViewerGone(cameraID, prime)
+				}()
+
+				stop := make(chan struct{})
+				defer close(stop)
+				go func() {
+					for {
+						select {
+						case <-stop:
+							return
+						case <-time.After(20 * time.Millisecond):
+							prime()
+						}
+					}
+				}()
+
+				if _, _, err := stream.next(5 * time.Second); err != nil {
+					return err
+				}
+				start := time.Now()
+				for i := 0; i < 3; i++ {
+					if _, _, err := stream.next(5 * time.Second); err != nil {
+						return err
+					}
+				}
+				// Three intervals of 200ms, frames arrive every 20ms
+				if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+					return fmt.Errorf("3 frames at 5 fps took only %s", elapsed)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "StalledViewerIsDisconnected",
+			Func: func() error {
+				config := detect.DefaultConfig()
+				config.ViewerStallTimeout = 300 * time.Millisecond
+				detect.SetConfig(config)
+				defer detect.SetConfig(detect.DefaultConfig())
+
+				cameraID := uuid.New()
+				stream, err := openMJPEGStream(streamURL(cameraID, ""), func() { broadcast(cameraID, []byte("frame")) })
+				if err != nil {
+					return err
+				}
+				defer stream.Close()
+
+				// Never reading the body fills the socket buffers until the writer blocks
+				large := make([]byte, 1<<20)
+				rand.Read(large)
+				for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+					if _, ok := viewerOf(cameraID); !ok {
+						return nil
+					}
+					broadcast(cameraID, large)
+				}
+				return errors.New("the stalled viewer is still registered")
+			},
+		},
+		{
+			TestName: "SlowViewerDoesNotHoldUpOthers",
+			Func: func() error {
+				cameraID := uuid.New()
+				prime := func() { broadcast(cameraID, []byte("frame-0")) }
+				slow, err := openMJPEGStream(streamURL(cameraID, "?fps=1"), prime)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					slow.Close()
+					waitViewerGone(cameraID, prime)
+				}()
+				fast, err := openMJPEGStream(streamURL(cameraID, ""), prime)
+				if err != nil {
+					return err
+				}
+				defer fast.Close()
+
+				// The fast viewer gets every frame while the slow one waits out its interval
+				for i := 1; i <= 3; i++ {
+					want := []byte(fmt.Sprintf("frame-%d", i))
+					broadcast(cameraID, want)
+					for {
+						frame, _, err := fast.next(time.Second)
+						if err != nil {
+							return fmt.Errorf("fast viewer: %w", err)
+						}
+						if bytes.Equal(frame, want) {
+							break
+						}
+					}
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			if err := test.Func(); err != nil {
+				t.Errorf("Test %s failed with error: %v", test.TestName, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package detect
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	helpers "github.com/zercle/gofiber-helpers"
+)
+
+type videoHandler struct{}
+
+// NewVideoHandler registers live video monitoring routes
+func NewVideoHandler(router fiber.Router) {
+	handler := &videoHandler{}
+	router.Get("/viewers", handler.GetViewers())
+	router.Get("/sources", handler.GetSources())
+	router.Get("/source-events", handler.HandleSourceEvents())
+	router.Post("/webrtc", handler.CreateWebRTCSession())
+}
+
+// @Summary GetVideoViewers
+// @Tags Video
+// @Description List connected video viewers with delivered and dropped frame counts
+// @Produce json
+// @Router /api/v1/video/viewers [get]
+// @Security ApiKeyAuth
+func (h *videoHandler) GetViewers() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.Status(fiber.StatusOK).JSON(helpers.ResponseForm{
+			Success: true,
+			Data: fiber.Map{
+				"viewers": GetVideoViewerStats(),
+			},
+		})
+	}
+}
+
+// @Summary GetVideoSources
+// @Tags Video
+// @Description List video sources with their liveness status (online, degraded or offline), last frame time, measured fps, resolution and model
+// @Produce json
+// @Router /api/v1/video/sources [get]
+// @Security ApiKeyAuth
+func (h *videoHandler) GetSources() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.Status(fiber.StatusOK).JSON(helpers.ResponseForm{
+			Success: true,
+			Data: fiber.Map{
+				"sources": GetVideoSources(),
+			},
+		})
+	}
+}
+
+// @Summary HandleSourceEvents
+// @Tags Video
+// @Description Stream video source status changes as Server-Sent Events.
+// @Description Reconnecting with the Last-Event-ID header (or last_event_id query) replays missed events.
+// @Produce text/event-stream
+// @Param camera_id query string false "Only send status changes of this camera"
+// @Param last_event_id query string false "Resume after this event ID"
+// @Router /api/v1/video/source-events [get]
+// @Security ApiKeyAuth
+func (h *videoHandler) HandleSourceEvents() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var filter streamFilter
+		if cameraIDParam := c.Query("camera_id"); cameraIDParam != "" {
+			cameraID, err := uuid.Parse(cameraIDParam)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(helpers.ResponseForm{
+					Success: false,
+					Errors: []helpers.ResponseError{
+						{
+							Code:    fiber.StatusBadRequest,
+							Title:   "Invalid camera ID",
+							Message: err.Error(),
+							Source:  helpers.WhereAmI(),
+						},
+					},
+				})
+			}
+			filter.CameraID = cameraID
+		}
+
+		return streamEvents(c, sourceEvents, "source_status", filter, false)
+	}
+}
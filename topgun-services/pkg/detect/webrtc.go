@@ -0,0 +1,344 @@
+package detect
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+	helpers "github.com/zercle/gofiber-helpers"
+)
+
+// The H.264 format of the published track, Constrained Baseline as written by openh264 for real-time video
+var webrtcH264Codec = webrtc.RTPCodecCapability{
+	MimeType:    webrtc.MimeTypeH264,
+	ClockRate:   90000,
+	SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f",
+}
+
+var errH264Unavailable = errors.New("this build has no H.264 encoder, build with cgo and -tags openh264")
+
+// WebRTCOffer is the signalling request of a browser starting a WebRTC viewer session
+type WebRTCOffer struct {
+	SDP      string    `json:"sdp"`
+	Type     string    `json:"type"` // always offer
+	CameraID uuid.UUID `json:"camera_id"`
+	Quality  string    `json:"quality"` // low, medium, high or original
+	Width    string    `json:"width"`
+	Height   string    `json:"height"`
+	FPS      float64   `json:"fps"`
+}
+
+// webrtcICEServers returns the configured STUN/TURN URLs, none are needed on a local network
+func webrtcICEServers() []webrtc.ICEServer {
+	urls := settings().WebRTCICEServers
+	if len(urls) == 0 {
+		return nil
+	}
+	return []webrtc.ICEServer{{URLs: urls}}
+}
+
+// videoStreamFallbackURL returns the WebSocket stream a browser should use when WebRTC does not connect
+func videoStreamFallbackURL(offer WebRTCOffer) string {
+	query := url.Values{}
+	if offer.CameraID != uuid.Nil {
+		query.Set("camera_id", offer.CameraID.String())
+	}
+	if offer.Quality != "" {
+		query.Set("quality", offer.Quality)
+	}
+	if offer.Width != "" {
+		query.Set("width", offer.Width)
+	}
+	if offer.Height != "" {
+		query.Set("height", offer.Height)
+	}
+	if offer.FPS > 0 {
+		query.Set("fps", strconv.FormatFloat(offer.FPS, 'f', -1, 64))
+	}
+	if len(query) == 0 {
+		return "/ws/video-stream"
+	}
+	return "/ws/video-stream?" + query.Encode()
+}
+
+// webrtcBitrate returns the target bitrate in bits per second, video.webrtc.bitrate_kbps for the
+// original rendition, scaled by the JPEG quality of other renditions
+func webrtcBitrate(rendition videoRendition) int {
+	kbps := settings().WebRTCBitrateKbps
+	if rendition.Quality > 0 {
+		kbps = kbps * rendition.Quality / 100
+	}
+	return kbps * 1000
+}
+
+// webrtcSession is one browser receiving a camera as a WebRTC video track
+type webrtcSession struct {
+	peer      *webrtc.PeerConnection
+	track     *webrtc.TrackLocalStaticSample
+	encoder   *h264Encoder
+	client    *VideoClient
+	closeOnce sync.Once
+
+	// Guards registration against a close that happens before the peer connects
+	mutex      sync.Mutex
+	closed     bool
+	registered bool
+}
+
+// start registers the viewer with the hub and starts streaming, unless the session was closed first
+func (s *webrtcSession) start() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed || s.registered {
+		return
+	}
+	videoHub.register <- s.client
+	s.registered = true
+	log.Printf("WebRTC viewer %s connected. Camera ID: %s", s.client.remoteAddr, s.client.cameraID)
+	go s.stream()
+}
+
+// close unregisters the viewer and tears down the peer connection
+func (s *webrtcSession) close() {
+	s.closeOnce.Do(func() {
+		s.mutex.Lock()
+		s.closed = true
+		registered := s.registered
+		s.mutex.Unlock()
+
+		if registered {
+			videoHub.removeClient(s.client)
+		}
+		if err := s.peer.Close(); err != nil {
+			log.Printf("Error closing WebRTC session: %v", err)
+		}
+		log.Printf("WebRTC viewer %s disconnected", s.client.remoteAddr)
+	})
+}
+
+// stream encodes the newest pending frame as an H.264 access unit and writes it to the track.
+// Frames dropped for this viewer are never encoded, so the P-frames only reference frames it received.
+func (s *webrtcSession) stream() {
+	defer s.close()
+	defer s.encoder.Close()
+
+	client := s.client
+	lastSample := time.Now()
+	var throttle <-chan time.Time
+	for {
+		select {
+		case <-client.notify:
+		case <-throttle:
+		case <-client.done:
+			return
+		}
+
+		throttle = nil
+		frameData, wait := client.take(time.Now())
+		if wait > 0 {
+			throttle = time.After(wait)
+			continue
+		}
+		if frameData == nil {
+			continue
+		}
+
+		img, err := jpeg.Decode(bytes.NewReader(frameData))
+		if err != nil {
+			log.Printf("Error decoding WebRTC frame: %v", err)
+			continue
+		}
+		accessUnit, err := s.encoder.Encode(img)
+		if err != nil {
+			log.Printf("Error encoding WebRTC frame: %v", err)
+			continue
+		}
+		if len(accessUnit) == 0 {
+			// Skipped by the rate control
+			continue
+		}
+		now := time.Now()
+		if err := s.track.WriteSample(media.Sample{Data: accessUnit, Duration: now.Sub(lastSample)}); err != nil {
+			log.Printf("Error writing WebRTC frame: %v", err)
+			return
+		}
+		lastSample = now
+	}
+}
+
+// @Summary CreateWebRTCSession
+// @Tags Video
+// @Description Answer a WebRTC offer for a camera's live video. The offer must receive video with H.264
+// @Description (Constrained Baseline, packetization-mode 1), the camera is published as one video track playable
+// @Description in a <video> element. On failure, or if the connection does not come up, use fallback_url, the
+// @Description equivalent /ws/video-stream subscription. Builds without the openh264 encoder answer 501.
+// @Accept json
+// @Produce json
+// @Param offer body WebRTCOffer true "SDP offer and viewer options"
+// @Router /api/v1/video/webrtc [post]
+// @Security ApiKeyAuth
+func (h *videoHandler) CreateWebRTCSession() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var offer WebRTCOffer
+		if err := c.BodyParser(&offer); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(helpers.ResponseForm{
+				Success: false,
+				Errors: []helpers.ResponseError{
+					{
+						Code:    fiber.StatusBadRequest,
+						Title:   "Invalid request body",
+						Message: err.Error(),
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+		fallbackURL := videoStreamFallbackURL(offer)
+
+		negotiationFailed := func(status int, title string, err error) error {
+			return c.Status(status).JSON(helpers.ResponseForm{
+				Success: false,
+				Data: fiber.Map{
+					"fallback_url": fallbackURL,
+				},
+				Errors: []helpers.ResponseError{
+					{
+						Code:    status,
+						Title:   title,
+						Message: err.Error(),
+						Source:  helpers.WhereAmI(),
+					},
+				},
+			})
+		}
+
+		if offer.CameraID == uuid.Nil {
+			return negotiationFailed(fiber.StatusBadRequest, "Missing camera_id", fmt.Errorf("camera_id is required"))
+		}
+		if offer.Type != webrtc.SDPTypeOffer.String() || offer.SDP == "" {
+			return negotiationFailed(fiber.StatusBadRequest, "Invalid offer", fmt.Errorf("an SDP of type offer is required"))
+		}
+		if offer.FPS < 0 {
+			return negotiationFailed(fiber.StatusBadRequest, "Invalid fps", fmt.Errorf("fps must be zero or a positive number"))
+		}
+		rendition, err := parseRendition(offer.Quality, offer.Width, offer.Height)
+		if err != nil {
+			return negotiationFailed(fiber.StatusBadRequest, "Invalid video rendition", err)
+		}
+		if !h264Available {
+			return negotiationFailed(fiber.StatusNotImplemented, "WebRTC video unavailable", errH264Unavailable)
+		}
+
+		peer, err := webrtc.NewPeerConnection(webrtc.Configuration{ICEServers: webrtcICEServers()})
+		if err != nil {
+			return negotiationFailed(fiber.StatusInternalServerError, "Failed to create peer connection", err)
+		}
+
+		client := newVideoClient(nil, c.IP(), offer.CameraID, true, rendition, offer.FPS)
+		client.transport = "webrtc"
+		if userID, ok := c.Locals("user_id").(string); ok {
+			client.userID = userID
+		}
+		track, err := webrtc.NewTrackLocalStaticSample(webrtcH264Codec, "video", offer.CameraID.String())
+		if err != nil {
+			peer.Close()
+			return negotiationFailed(fiber.StatusInternalServerError, "Failed to create video track", err)
+		}
+		session := &webrtcSession{
+			peer:    peer,
+			track:   track,
+			encoder: newH264Encoder(webrtcBitrate(rendition), offer.FPS),
+			client:  client,
+		}
+
+		sender, err := peer.AddTrack(track)
+		if err != nil {
+			session.close()
+			return negotiationFailed(fiber.StatusInternalServerError, "Failed to add video track", err)
+		}
+		// RTCP must be read for the interceptors to run, a viewer that lost packets asks for a key frame
+		go func() {
+			for {
+				packets, _, err := sender.ReadRTCP()
+				if err != nil {
+					return
+				}
+				for _, packet := range packets {
+					switch packet.(type) {
+					case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+						session.encoder.ForceKeyFrame()
+					}
+				}
+			}
+		}()
+
+		peer.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+			switch state {
+			case webrtc.PeerConnectionStateConnected:
+				session.start()
+			case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateDisconnected, webrtc.PeerConnectionStateClosed:
+				session.close()
+			}
+		})
+
+		// Sessions that never connect would otherwise hold their ICE agent forever
+		time.AfterFunc(settings().WebRTCConnectTimeout, func() {
+			if peer.ConnectionState() != webrtc.PeerConnectionStateConnected {
+				log.Printf("WebRTC viewer %s did not connect, closing session", client.remoteAddr)
+				session.close()
+			}
+		})
+
+		if err := peer.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer.SDP}); err != nil {
+			session.close()
+			return negotiationFailed(fiber.StatusBadRequest, "Invalid offer", err)
+		}
+		answer, err := peer.CreateAnswer(nil)
+		if err != nil {
+			session.close()
+			return negotiationFailed(fiber.StatusBadRequest, "Failed to create answer", err)
+		}
+		// The answer only lists codecs both sides support, without H.264 the track has nothing to send
+		if !strings.Contains(answer.SDP, "H264/90000") {
+			session.close()
+			return negotiationFailed(fiber.StatusBadRequest, "Unsupported video codec", fmt.Errorf("the offer must receive H.264 video"))
+		}
+
+		// Answer with every candidate included, so no trickle ICE endpoint is needed
+		gatheringComplete := webrtc.GatheringCompletePromise(peer)
+		if err := peer.SetLocalDescription(answer); err != nil {
+			session.close()
+			return negotiationFailed(fiber.StatusInternalServerError, "Failed to set local description", err)
+		}
+		select {
+		case <-gatheringComplete:
+		case <-time.After(settings().WebRTCConnectTimeout):
+			session.close()
+			return negotiationFailed(fiber.StatusServiceUnavailable, "ICE gathering timed out", fmt.Errorf("no ICE candidates gathered within %s", settings().WebRTCConnectTimeout))
+		}
+
+		localDescription := peer.LocalDescription()
+		return c.Status(fiber.StatusOK).JSON(helpers.ResponseForm{
+			Success: true,
+			Data: fiber.Map{
+				"session_id":   client.id,
+				"sdp":          localDescription.SDP,
+				"type":         localDescription.Type.String(),
+				"fallback_url": fallbackURL,
+			},
+		})
+	}
+}
@@ -0,0 +1,310 @@
+//go:build openh264 && cgo
+
+package detect_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"topgun-services/pkg/detect"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+)
+
+// Types of the H.264 NAL units
+const (
+	nalSlice = 1
+	nalIDR   = 5
+	nalSPS   = 7
+)
+
+// nalTypes returns the types of the NAL units starting in an RTP payload, packetization-mode 1
+func nalTypes(payload []byte) []byte {
+	if len(payload) < 2 {
+		return nil
+	}
+	switch payload[0] & 0x1f {
+	case 24: // STAP-A, 16 bit sizes before each NAL unit
+		var types []byte
+		for rest := payload[1:]; len(rest) > 2; {
+			size := int(rest[0])<<8 | int(rest[1])
+			if size == 0 || len(rest) < 2+size {
+				break
+			}
+			types = append(types, rest[2]&0x1f)
+			rest = rest[2+size:]
+		}
+		return types
+	case 28: // FU-A, the type is in the header of the first fragment
+		if payload[1]&0x80 != 0 {
+			return []byte{payload[1] & 0x1f}
+		}
+		return nil
+	}
+	return []byte{payload[0] & 0x1f}
+}
+
+// readPicture reads RTP packets up to the end of the next access unit and returns its NAL unit types
+func readPicture(track *webrtc.TrackRemote) ([]byte, error) {
+	var types []byte
+	for {
+		packet, _, err := track.ReadRTP()
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, nalTypes(packet.Payload)...)
+		if packet.Marker {
+			return types, nil
+		}
+	}
+}
+
+// pictureType returns nalIDR or nalSlice for an access unit
+func pictureType(types []byte) byte {
+	for _, t := range types {
+		if t == nalIDR || t == nalSlice {
+			return t
+		}
+	}
+	return 0
+}
+
+func TestWebRTCSession(t *testing.T) {
+	app := fiber.New()
+	detect.NewVideoHandler(app.Group("/video"))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go app.Listener(listener)
+	defer app.Shutdown()
+	signalURL := fmt.Sprintf("http://%s/video/webrtc", listener.Addr())
+
+	var source bytes.Buffer
+	if err := jpeg.Encode(&source, image.NewRGBA(image.Rect(0, 0, 32, 24)), nil); err != nil {
+		t.Fatalf("failed to encode test frame: %v", err)
+	}
+	cameraID := uuid.New()
+
+	type signalResponse struct {
+		Success bool `json:"success"`
+		Data    struct {
+			SessionID   uuid.UUID `json:"session_id"`
+			SDP         string    `json:"sdp"`
+			Type        string    `json:"type"`
+			FallbackURL string    `json:"fallback_url"`
+		} `json:"data"`
+	}
+	signal := func(body fiber.Map) (signalResponse, int, error) {
+		var response signalResponse
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return response, 0, err
+		}
+		resp, err := http.Post(signalURL, fiber.MIMEApplicationJSON, bytes.NewReader(payload))
+		if err != nil {
+			return response, 0, err
+		}
+		defer resp.Body.Close()
+		err = json.NewDecoder(resp.Body).Decode(&response)
+		return response, resp.StatusCode, err
+	}
+
+	tests := []Test{
+		{
+			TestName: "ReceivesH264VideoTrack",
+			Func: func() error {
+				peer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+				if err != nil {
+					return err
+				}
+				defer peer.Close()
+
+				if _, err := peer.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{
+					Direction: webrtc.RTPTransceiverDirectionRecvonly,
+				}); err != nil {
+					return err
+				}
+				tracks := make(chan *webrtc.TrackRemote, 1)
+				peer.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+					tracks <- track
+				})
+
+				offer, err := peer.CreateOffer(nil)
+				if err != nil {
+					return err
+				}
+				gatheringComplete := webrtc.GatheringCompletePromise(peer)
+				if err := peer.SetLocalDescription(offer); err != nil {
+					return err
+				}
+				<-gatheringComplete
+
+				response, status, err := signal(fiber.Map{
+					"type":      "offer",
+					"sdp":       peer.LocalDescription().SDP,
+					"camera_id": cameraID,
+				})
+				if err != nil {
+					return err
+				}
+				if status != fiber.StatusOK || response.Data.Type != "answer" {
+					return fmt.Errorf("expected an answer with status 200, got %q with status %d", response.Data.Type, status)
+				}
+				if err := peer.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: response.Data.SDP}); err != nil {
+					return err
+				}
+
+				// The hub registers the viewer once the connection is up, the track fires on the first packet
+				stop := make(chan struct{})
+				defer close(stop)
+				go func() {
+					for {
+						detect.BroadcastVideoFrame(&detect.VideoFrameMessage{
+							CameraID: cameraID,
+							Frame:    base64.StdEncoding.EncodeToString(source.Bytes()),
+						})
+						select {
+						case <-stop:
+							return
+						case <-time.After(100 * time.Millisecond):
+						}
+					}
+				}()
+
+				var track *webrtc.TrackRemote
+				select {
+				case track = <-tracks:
+				case <-time.After(10 * time.Second):
+					return errors.New("no video track received")
+				}
+				if track.Codec().MimeType != webrtc.MimeTypeH264 {
+					return fmt.Errorf("expected an H.264 track, got %s", track.Codec().MimeType)
+				}
+
+				// The stream opens with an IDR picture after the SPS, later frames are P-frames
+				track.SetReadDeadline(time.Now().Add(10 * time.Second))
+				var opening []byte
+				for pictureType(opening) != nalIDR {
+					if opening, err = readPicture(track); err != nil {
+						return fmt.Errorf("no IDR picture received: %w", err)
+					}
+				}
+				if !bytes.Contains(opening, []byte{nalSPS}) {
+					return fmt.Errorf("the IDR picture has no SPS, NAL units %v", opening)
+				}
+				for i := 0; i < 3; i++ {
+					picture, err := readPicture(track)
+					if err != nil {
+						return err
+					}
+					if pictureType(picture) != nalSlice {
+						return fmt.Errorf("expected P-frames after the IDR picture, got NAL units %v", picture)
+					}
+				}
+
+				// A picture loss indication is answered with a key frame well before the intra period of 30
+				if err := peer.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(track.SSRC())}}); err != nil {
+					return err
+				}
+				keyFrame := false
+				for i := 0; i < 10 && !keyFrame; i++ {
+					picture, err := readPicture(track)
+					if err != nil {
+						return err
+					}
+					keyFrame = pictureType(picture) == nalIDR
+				}
+				if !keyFrame {
+					return errors.New("no key frame after a picture loss indication")
+				}
+
+				for _, connection := range detect.GetConnectionStats() {
+					if connection.ID == response.Data.SessionID && connection.Transport == "webrtc" {
+						return nil
+					}
+				}
+				return errors.New("WebRTC viewer is not listed as a connection")
+			},
+		},
+		{
+			TestName: "OfferWithoutVideoReturnsFallback",
+			Func: func() error {
+				peer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+				if err != nil {
+					return err
+				}
+				defer peer.Close()
+
+				if _, err := peer.CreateDataChannel("video", nil); err != nil {
+					return err
+				}
+				offer, err := peer.CreateOffer(nil)
+				if err != nil {
+					return err
+				}
+				if err := peer.SetLocalDescription(offer); err != nil {
+					return err
+				}
+
+				response, status, err := signal(fiber.Map{
+					"type":      "offer",
+					"sdp":       offer.SDP,
+					"camera_id": cameraID,
+				})
+				if err != nil {
+					return err
+				}
+				if status != fiber.StatusBadRequest {
+					return fmt.Errorf("expected status 400, got %d", status)
+				}
+				if response.Data.FallbackURL == "" {
+					return errors.New("expected a fallback_url")
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "InvalidOfferReturnsFallback",
+			Func: func() error {
+				response, status, err := signal(fiber.Map{
+					"type":      "offer",
+					"sdp":       "not an sdp",
+					"camera_id": cameraID,
+					"quality":   "low",
+				})
+				if err != nil {
+					return err
+				}
+				if status != fiber.StatusBadRequest {
+					return fmt.Errorf("expected status 400, got %d", status)
+				}
+				expected := fmt.Sprintf("/ws/video-stream?camera_id=%s&quality=low", cameraID)
+				if response.Data.FallbackURL != expected {
+					return fmt.Errorf("expected fallback %q, got %q", expected, response.Data.FallbackURL)
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			if err := test.Func(); err != nil {
+				t.Errorf("Test %s failed with error: %v", test.TestName, err)
+			}
+		})
+	}
+}
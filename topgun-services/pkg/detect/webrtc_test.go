@@ -0,0 +1,71 @@
+//go:build !openh264 || !cgo
+
+package detect_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"topgun-services/pkg/detect"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+func TestWebRTCSession(t *testing.T) {
+	app := fiber.New()
+	detect.NewVideoHandler(app.Group("/video"))
+
+	tests := []Test{
+		{
+			TestName: "MissingEncoderReturnsFallback",
+			Func: func() error {
+				cameraID := uuid.New()
+				payload, err := json.Marshal(fiber.Map{
+					"type":      "offer",
+					"sdp":       "v=0",
+					"camera_id": cameraID,
+					"quality":   "low",
+				})
+				if err != nil {
+					return err
+				}
+				req := httptest.NewRequest(fiber.MethodPost, "/video/webrtc", bytes.NewReader(payload))
+				req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+				resp, err := app.Test(req)
+				if err != nil {
+					return err
+				}
+				defer resp.Body.Close()
+
+				var response struct {
+					Data struct {
+						FallbackURL string `json:"fallback_url"`
+					} `json:"data"`
+				}
+				if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+					return err
+				}
+				if resp.StatusCode != fiber.StatusNotImplemented {
+					return fmt.Errorf("expected status 501 without an H.264 encoder, got %d", resp.StatusCode)
+				}
+				expected := fmt.Sprintf("/ws/video-stream?camera_id=%s&quality=low", cameraID)
+				if response.Data.FallbackURL != expected {
+					return fmt.Errorf("expected fallback %q, got %q", expected, response.Data.FallbackURL)
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			if err := test.Func(); err != nil {
+				t.Errorf("Test %s failed with error: %v", test.TestName, err)
+			}
+		})
+	}
+}
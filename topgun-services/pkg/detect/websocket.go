@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"sync"
+	"time"
 	"topgun-services/pkg/models"
 
 	"github.com/gofiber/contrib/websocket"
@@ -16,9 +18,15 @@ import (
 
 // WebSocket client structure
 type Client struct {
-	conn     *websocket.Conn
-	cameraID uuid.UUID
-	send     chan []byte
+	id           uuid.UUID
+	conn         *websocket.Conn
+	remoteAddr   string
+	userID       string
+	cameraID     uuid.UUID
+	inlineImages bool // receive base64 images instead of signed URLs
+	connectedAt  time.Time
+	send         chan []byte
+	counters     connectionCounters
 }
 
 // WebSocket hub to manage clients
@@ -31,8 +39,17 @@ type Hub struct {
 }
 
 type BroadcastMessage struct {
-	CameraID uuid.UUID
-	Detect   *models.Detect
+	CameraID   uuid.UUID       `json:"camera_id"`
+	Data       json.RawMessage `json:"data"`             // DetectionMessage with signed image URLs
+	InlineData json.RawMessage `json:"inline,omitempty"` // DetectionMessage with the image embedded, nil when no subscriber wants it
+}
+
+// payload returns the message variant for a subscriber's image mode
+func (m *BroadcastMessage) payload(inlineImages bool) []byte {
+	if inlineImages && m.InlineData != nil {
+		return m.InlineData
+	}
+	return m.Data
 }
 
 // Detection message with image
@@ -42,19 +59,29 @@ type DetectionMessage struct {
 	Timestamp string                     `json:"timestamp"`
 	Path      string                     `json:"path"`
 	Objects   models.JSONRawMessageArray `json:"objects"`
-	ImageData string                     `json:"image_data"` // Base64 encoded image
+	ImageData string                     `json:"image_data"` // Base64 encoded image, inline mode only
 	MimeType  string                     `json:"mime_type"`  // image/jpeg, image/png, etc.
+
+	// Signed image URLs, URL mode only
+	ImageURL          string `json:"image_url,omitempty"`
+	ImageWidth        int    `json:"image_width,omitempty"`
+	ImageHeight       int    `json:"image_height,omitempty"`
+	ThumbnailURL      string `json:"thumbnail_url,omitempty"`
+	ThumbnailWidth    int    `json:"thumbnail_width,omitempty"`
+	ThumbnailHeight   int    `json:"thumbnail_height,omitempty"`
+	ImageURLExpiresAt string `json:"image_url_expires_at,omitempty"`
 }
 
 // Video frame message from Python
 type VideoFrameMessage struct {
-	Frame       string  `json:"frame"`        // Base64 encoded JPEG
-	Timestamp   float64 `json:"timestamp"`    // Unix timestamp
-	FrameNumber int     `json:"frame_number"` // Frame sequence number
-	Detections  int     `json:"detections"`   // Number of objects detected
-	Width       int     `json:"width"`        // Frame width
-	Height      int     `json:"height"`       // Frame height
-	Model       string  `json:"model"`        // Model name used
+	CameraID    uuid.UUID `json:"camera_id"`    // Source camera
+	Frame       string    `json:"frame"`        // Base64 encoded JPEG
+	Timestamp   float64   `json:"timestamp"`    // Unix timestamp
+	FrameNumber int       `json:"frame_number"` // Frame sequence number
+	Detections  int       `json:"detections"`   // Number of objects detected
+	Width       int       `json:"width"`        // Frame width
+	Height      int       `json:"height"`       // Frame height
+	Model       string    `json:"model"`        // Model name used
 }
 
 // RaspberryPI MQTT Detection Data
@@ -69,6 +96,15 @@ type RaspberryPIDetection struct {
 	Confidence float64 `json:"confidence"`
 	TrackID    int     `json:"track_id"`
 	Timestamp  float64 `json:"timestamp"`
+	CameraID   string  `json:"camera_id,omitempty"` // Used when the topic does not name the camera
+}
+
+// RaspberryPIFrameDetection is every object detected in one frame.
+// The single object RaspberryPIDetection format decodes as a frame with one object.
+type RaspberryPIFrameDetection struct {
+	Timestamp float64                `json:"timestamp"`
+	CameraID  string                 `json:"camera_id,omitempty"` // Used when the topic does not name the camera
+	Objects   []RaspberryPIDetection `json:"objects"`
 }
 
 // Video frame cache for capturing
@@ -87,27 +123,14 @@ type VideoHub struct {
 	mutex      sync.RWMutex
 }
 
-// Video client structure
-type VideoClient struct {
-	conn *websocket.Conn
-	send chan []byte
-}
-
 // Attack hub for broadcasting attack data to all clients
 type AttackHub struct {
 	clients    map[*AttackClient]bool
-	broadcast  chan *models.Attack
 	register   chan *AttackClient
 	unregister chan *AttackClient
 	mutex      sync.RWMutex
 }
 
-// Attack client structure
-type AttackClient struct {
-	conn *websocket.Conn
-	send chan []byte
-}
-
 // Global video hub instance
 var videoHub *VideoHub
 
@@ -141,7 +164,6 @@ func init() {
 	// Initialize attack hub
 	attackHub = &AttackHub{
 		clients:    make(map[*AttackClient]bool),
-		broadcast:  make(chan *models.Attack, 100),
 		register:   make(chan *AttackClient),
 		unregister: make(chan *AttackClient),
 	}
@@ -162,37 +184,71 @@ func (vh *VideoHub) run() {
 			log.Printf("Video client connected. Total clients: %d", len(vh.clients))
 
 		case client := <-vh.unregister:
-			vh.mutex.Lock()
-			if _, ok := vh.clients[client]; ok {
-				delete(vh.clients, client)
-				close(client.send)
-				log.Printf("Video client disconnected. Total clients: %d", len(vh.clients))
-			}
-			vh.mutex.Unlock()
+			vh.removeClient(client)
 
 		case frame := <-vh.broadcast:
 			vh.mutex.RLock()
-			frameData := mustMarshal(frame)
+			now := time.Now()
+			stallTimeout := settings().ViewerStallTimeout
 			// Collect clients to unregister
 			var toUnregister []*VideoClient
+
+			// Viewers render the frame themselves, the hub only hands it out
+			live := newLiveFrame(frame)
+
 			for client := range vh.clients {
-				select {
-				case client.send <- frameData:
-				default:
-					// Client buffer full, mark for disconnect
+				// Only send to clients subscribed to this camera
+				if client.cameraID != uuid.Nil && client.cameraID != frame.CameraID {
+					continue
+				}
+
+				// Latest frame wins, a stale pending frame is dropped
+				client.offer(live, now)
+				if client.stalled(now, stallTimeout) {
+					// Client has not taken a frame for too long, mark for disconnect
 					toUnregister = append(toUnregister, client)
 				}
 			}
 			vh.mutex.RUnlock()
 
-			// Unregister slow clients outside the lock
+			// Unregister stalled clients outside the lock
 			for _, client := range toUnregister {
-				vh.unregister <- client
+				log.Printf("Video client %s stalled, disconnecting", client.remoteAddr)
+				vh.removeClient(client)
 			}
 		}
 	}
 }
 
+// removeClient unregisters a video client and signals its writer to stop
+func (vh *VideoHub) removeClient(client *VideoClient) {
+	vh.mutex.Lock()
+	defer vh.mutex.Unlock()
+
+	if _, ok := vh.clients[client]; ok {
+		delete(vh.clients, client)
+		close(client.done)
+		log.Printf("Video client disconnected. Total clients: %d", len(vh.clients))
+	}
+}
+
+// sendStatus queues a source status event for WebSocket viewers of the camera, MJPEG viewers only receive frames
+func (vh *VideoHub) sendStatus(cameraID uuid.UUID, event []byte) {
+	vh.mutex.RLock()
+	defer vh.mutex.RUnlock()
+
+	for client := range vh.clients {
+		if client.raw || client.cameraID != uuid.Nil && client.cameraID != cameraID {
+			continue
+		}
+		select {
+		case client.status <- event:
+		default:
+			log.Printf("Video client %s status buffer full, dropping source status", client.remoteAddr)
+		}
+	}
+}
+
 // Run attack hub to handle attack client connections and broadcasts
 func (ah *AttackHub) run() {
 	for {
@@ -204,40 +260,48 @@ func (ah *AttackHub) run() {
 			log.Printf("Attack client connected. Total clients: %d", len(ah.clients))
 
 		case client := <-ah.unregister:
-			ah.mutex.Lock()
-			if _, ok := ah.clients[client]; ok {
-				delete(ah.clients, client)
-				close(client.send)
-				log.Printf("Attack client disconnected. Total clients: %d", len(ah.clients))
-			}
-			ah.mutex.Unlock()
+			ah.removeClient(client)
+		}
+	}
+}
 
-		case attack := <-ah.broadcast:
-			ah.mutex.RLock()
-			attackData := mustMarshal(attack)
-			// Collect clients to unregister
-			var toUnregister []*AttackClient
-			for client := range ah.clients {
-				select {
-				case client.send <- attackData:
-				default:
-					// Client buffer full, mark for disconnect
-					toUnregister = append(toUnregister, client)
-				}
-			}
-			ah.mutex.RUnlock()
+// deliver coalesces an attack into the pending updates of every subscribed client.
+// It never blocks, a slow client receives the latest state of each drone instead of every update.
+func (ah *AttackHub) deliver(attack *models.Attack) {
+	attackData := mustMarshal(attack)
+	attackEvents.publish(attackData, nil, uuid.Nil, attack.DroneID)
 
-			// Unregister slow clients outside the lock
-			for _, client := range toUnregister {
-				ah.unregister <- client
-			}
-			log.Printf("Broadcasted attack data to %d clients. DroneID: %s", len(ah.clients), attack.DroneID)
-		}
+	ah.mutex.RLock()
+	defer ah.mutex.RUnlock()
+
+	now := time.Now()
+	for client := range ah.clients {
+		client.offer(attack, attackData, now)
+	}
+}
+
+// removeClient unregisters an attack client and signals its writer to stop
+func (ah *AttackHub) removeClient(client *AttackClient) {
+	ah.mutex.Lock()
+	defer ah.mutex.Unlock()
+
+	if _, ok := ah.clients[client]; ok {
+		delete(ah.clients, client)
+		close(client.done)
+		log.Printf("Attack client disconnected. Total clients: %d", len(ah.clients))
 	}
 }
 
-// Broadcast video frame to all connected clients
+// Broadcast video frame to all connected clients, including those on other instances
 func BroadcastVideoFrame(frame *VideoFrameMessage) {
+	broadcastVideoFrameLocal(frame)
+	publishToBackplane(backplaneKindFrame, frame.CameraID, frame)
+}
+
+// broadcastVideoFrameLocal sends a video frame to viewers connected to this instance
+func broadcastVideoFrameLocal(frame *VideoFrameMessage) {
+	publishSourceStatus(videoSources.record(frame, time.Now()))
+
 	if videoHub != nil {
 		select {
 		case videoHub.broadcast <- frame:
@@ -265,8 +329,19 @@ func UpdateVideoFrameCache(frame *VideoFrameMessage) {
 		return
 	}
 
+	receivedAt := time.Now()
 	videoFrameCache.frame = frameData
 	videoFrameCache.timestamp = frame.Timestamp
+
+	// Keep the last few seconds per camera for clip capture
+	getFrameBuffer(frame.CameraID).Add(BufferedFrame{
+		Data:       frameData,
+		Timestamp:  frame.Timestamp,
+		ReceivedAt: receivedAt,
+	})
+
+	// Persist sampled frames for later playback
+	videoArchive.add(frame.CameraID, frameData, receivedAt)
 }
 
 // GetLatestVideoFrame returns the latest cached video frame
@@ -289,14 +364,31 @@ func GetLatestVideoFrame() ([]byte, float64, error) {
 	return frameCopy, videoFrameCache.timestamp, nil
 }
 
-// BroadcastAttack broadcasts attack data to all connected WebSocket clients
+// GetCameraLatestFrame returns the newest frame received from a camera
+func GetCameraLatestFrame(cameraID uuid.UUID) (BufferedFrame, error) {
+	buffer, ok := lookupFrameBuffer(cameraID)
+	if !ok {
+		return BufferedFrame{}, fmt.Errorf("no video frame available for camera %s", cameraID)
+	}
+
+	frame, ok := buffer.Latest()
+	if !ok {
+		return BufferedFrame{}, fmt.Errorf("no video frame available for camera %s", cameraID)
+	}
+	return frame, nil
+}
+
+// BroadcastAttack broadcasts attack data to all connected clients, including those on other instances
 func BroadcastAttack(attack *models.Attack) {
+	recordAttackState(attack, false)
+	broadcastAttackLocal(attack)
+	publishToBackplane(backplaneKindAttack, uuid.Nil, attack)
+}
+
+// broadcastAttackLocal sends attack data to clients connected to this instance
+func broadcastAttackLocal(attack *models.Attack) {
 	if attackHub != nil {
-		select {
-		case attackHub.broadcast <- attack:
-		default:
-			log.Println("Attack broadcast channel full, dropping attack data")
-		}
+		attackHub.deliver(attack)
 	}
 }
 
@@ -311,42 +403,69 @@ func (h *Hub) run() {
 			log.Printf("Client connected. Camera ID: %s. Total clients: %d", client.cameraID, len(h.clients))
 
 		case client := <-h.unregister:
-			h.mutex.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-				log.Printf("Client disconnected. Camera ID: %s. Total clients: %d", client.cameraID, len(h.clients))
-			}
-			h.mutex.Unlock()
+			h.removeClient(client)
 
 		case message := <-h.broadcast:
+			detectionEvents.publish(message.Data, message.InlineData, message.CameraID, "")
+
 			h.mutex.RLock()
 			// Collect clients to unregister
 			var toUnregister []*Client
 			for client := range h.clients {
 				// Only send to clients subscribed to this camera
 				if client.cameraID == message.CameraID {
-					// Create message with image data
-					detectionMsg := createDetectionMessage(message.Detect)
 					select {
-					case client.send <- mustMarshal(detectionMsg):
+					case client.send <- message.payload(client.inlineImages):
 					default:
 						// Client buffer full, mark for disconnect
+						client.counters.dropped.Add(1)
 						toUnregister = append(toUnregister, client)
 					}
 				}
 			}
 			h.mutex.RUnlock()
 
-			// Unregister slow clients outside the lock
+			// Unregister slow clients outside the lock, sending to h.unregister here would block this goroutine
 			for _, client := range toUnregister {
-				h.unregister <- client
+				h.removeClient(client)
 			}
 		}
 	}
 }
 
-// Create detection message with base64 encoded image
+// removeClient unregisters a detection client and closes its send channel
+func (h *Hub) removeClient(client *Client) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if _, ok := h.clients[client]; ok {
+		delete(h.clients, client)
+		close(client.send)
+		log.Printf("Client disconnected. Camera ID: %s. Total clients: %d", client.cameraID, len(h.clients))
+	}
+}
+
+// wantsInlineImages reports whether any detection subscriber asked for base64 images.
+// With a backplane running the inline variant is always built for subscribers on other instances.
+func (h *Hub) wantsInlineImages() bool {
+	backplaneMutex.RLock()
+	relayed := backplane != nil
+	backplaneMutex.RUnlock()
+	if relayed || detectionEvents.hasInlineSubscribers() {
+		return true
+	}
+
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	for client := range h.clients {
+		if client.inlineImages {
+			return true
+		}
+	}
+	return false
+}
+
+// Create detection message with signed image URLs
 func createDetectionMessage(detect *models.Detect) *DetectionMessage {
 	msg := &DetectionMessage{
 		ID:        detect.ID,
@@ -355,6 +474,40 @@ func createDetectionMessage(detect *models.Detect) *DetectionMessage {
 		Path:      detect.Path,
 		Objects:   detect.Objects,
 	}
+	if detect.Path == "" {
+		return msg
+	}
+
+	expiresAt := time.Now().Add(settings().ImageURLTTL)
+	msg.MimeType = getMimeType(detect.Path)
+	msg.ImageURL = signImageURL(detect.ID, imageVariantOriginal, expiresAt)
+	msg.ThumbnailURL = signImageURL(detect.ID, imageVariantThumbnail, expiresAt)
+	msg.ImageURLExpiresAt = expiresAt.Format("2006-01-02T15:04:05Z07:00")
+
+	// Only the header is read, the bytes are fetched by the client
+	width, height, err := imageDimensions(detect.Path)
+	if err != nil {
+		log.Printf("Failed to read image size %s: %v", detect.Path, err)
+		return msg
+	}
+	thumbnailWidth, thumbnailHeight := fitDimensions(uint(width), uint(height), settings().ThumbnailSize, settings().ThumbnailSize)
+	msg.ImageWidth = width
+	msg.ImageHeight = height
+	msg.ThumbnailWidth = int(thumbnailWidth)
+	msg.ThumbnailHeight = int(thumbnailHeight)
+
+	return msg
+}
+
+// Create detection message with base64 encoded image
+func createInlineDetectionMessage(detect *models.Detect) *DetectionMessage {
+	msg := &DetectionMessage{
+		ID:        detect.ID,
+		CameraID:  detect.CameraID,
+		Timestamp: detect.Timestamp.Format("2006-01-02T15:04:05Z07:00"),
+		Path:      detect.Path,
+		Objects:   detect.Objects,
+	}
 
 	// Read and encode image file
 	if detect.Path != "" {
@@ -400,13 +553,30 @@ func getMimeType(path string) string {
 	}
 }
 
-// Broadcast detection to subscribed clients
+// Broadcast detection to subscribed clients, including those on other instances.
+// Messages are built here rather than in the hub goroutine, and the image is only
+// read and encoded when an inline subscriber needs it.
 func BroadcastDetection(detect *models.Detect) {
+	if hub == nil {
+		return
+	}
+
+	message := &BroadcastMessage{
+		CameraID: detect.CameraID,
+		Data:     mustMarshal(createDetectionMessage(detect)),
+	}
+	if hub.wantsInlineImages() {
+		message.InlineData = mustMarshal(createInlineDetectionMessage(detect))
+	}
+
+	broadcastDetectionLocal(message)
+	publishToBackplane(backplaneKindDetection, detect.CameraID, message)
+}
+
+// broadcastDetectionLocal sends a detection to clients connected to this instance
+func broadcastDetectionLocal(message *BroadcastMessage) {
 	if hub != nil {
-		hub.broadcast <- &BroadcastMessage{
-			CameraID: detect.CameraID,
-			Detect:   detect,
-		}
+		hub.broadcast <- message
 	}
 }
 
@@ -423,7 +593,8 @@ func (h *detectHandler) HandleWebSocket() fiber.Handler {
 
 		// Read initial message to get camera_id
 		var subscribeMsg struct {
-			CameraID string `json:"camera_id"`
+			CameraID  string `json:"camera_id"`
+			ImageMode string `json:"image_mode"` // url (default) or inline
 		}
 
 		if err := c.ReadJSON(&subscribeMsg); err != nil {
@@ -441,11 +612,28 @@ func (h *detectHandler) HandleWebSocket() fiber.Handler {
 			return
 		}
 
+		inlineImages, err := parseImageMode(subscribeMsg.ImageMode)
+		if err != nil {
+			c.WriteJSON(fiber.Map{
+				"error": err.Error(),
+			})
+			return
+		}
+		imageMode := imageModeURL
+		if inlineImages {
+			imageMode = imageModeInline
+		}
+
 		// Create client
 		client = &Client{
-			conn:     c,
-			cameraID: cameraID,
-			send:     make(chan []byte, 256),
+			id:           uuid.New(),
+			conn:         c,
+			remoteAddr:   c.RemoteAddr().String(),
+			userID:       socketUserID(c),
+			cameraID:     cameraID,
+			inlineImages: inlineImages,
+			connectedAt:  time.Now(),
+			send:         make(chan []byte, 256),
 		}
 
 		// Register client
@@ -453,8 +641,9 @@ func (h *detectHandler) HandleWebSocket() fiber.Handler {
 
 		// Send confirmation
 		c.WriteJSON(fiber.Map{
-			"status":    "subscribed",
-			"camera_id": cameraID.String(),
+			"status":     "subscribed",
+			"camera_id":  cameraID.String(),
+			"image_mode": imageMode,
 		})
 
 		// Start goroutine to write messages
@@ -464,6 +653,7 @@ func (h *detectHandler) HandleWebSocket() fiber.Handler {
 					log.Printf("Error writing message: %v", err)
 					return
 				}
+				client.counters.recordSent(len(message))
 			}
 		}()
 
@@ -486,12 +676,20 @@ func (h *detectHandler) HandleWebSocket() fiber.Handler {
 }
 
 // HandleAttackWebSocket - WebSocket handler for broadcasting attack data to frontend
+// Optional drone_id and status query parameters (comma separated) filter the updates, and max_rate limits
+// the updates per second per drone. Updates waiting to be sent are coalesced to the latest state of each drone.
+// A {"type":"subscribe","drone_ids":[...],"statuses":[...],"max_rate":n} message changes the filters at any time.
 func (h *detectHandler) HandleAttackWebSocket() fiber.Handler {
 	return websocket.New(func(c *websocket.Conn) {
-		client := &AttackClient{
-			conn: c,
-			send: make(chan []byte, 256),
+		subscription, err := parseAttackSubscription(c.Query("drone_id"), c.Query("status"), c.Query("max_rate"))
+		if err != nil {
+			c.WriteJSON(fiber.Map{
+				"error": err.Error(),
+			})
+			c.Close()
+			return
 		}
+		client := newAttackClient(c, subscription)
 
 		// Register client
 		attackHub.register <- client
@@ -500,36 +698,56 @@ func (h *detectHandler) HandleAttackWebSocket() fiber.Handler {
 		writeChan := make(chan interface{}, 100)
 		done := make(chan struct{})
 		var closeOnce sync.Once
+		// Closed when the writer has exited, gofiber reuses the Conn once the handler returns
+		writerDone := make(chan struct{})
+		stopped := func() bool {
+			select {
+			case <-done:
+				return true
+			default:
+				return false
+			}
+		}
 
 		// Start single write goroutine
 		go func() {
+			defer close(writerDone)
 			defer func() {
 				attackHub.unregister <- client
 			}()
 
 			// Send initial confirmation
 			if err := c.WriteJSON(fiber.Map{
-				"status":  "connected",
-				"message": "Subscribed to attack data stream",
+				"status":       "connected",
+				"message":      "Subscribed to attack data stream",
+				"subscription": subscription,
 			}); err != nil {
 				log.Printf("Error sending initial message: %v", err)
 				closeOnce.Do(func() { close(done) })
 				return
 			}
 
+			// Send the latest state of every subscribed drone, newer updates follow
+			if err := c.WriteJSON(attackSnapshot(client.currentSubscription())); err != nil {
+				log.Printf("Error sending attack snapshot: %v", err)
+				closeOnce.Do(func() { close(done) })
+				return
+			}
+
+			// Fires when the rate limit allows a held back update to be sent
+			var throttle <-chan time.Time
 			for {
 				select {
-				case message, ok := <-client.send:
-					if !ok {
-						closeOnce.Do(func() { close(done) })
-						return
-					}
-					if err := c.WriteMessage(websocket.TextMessage, message); err != nil {
-						log.Printf("Error writing attack data: %v", err)
-						closeOnce.Do(func() { close(done) })
+				case <-client.notify:
+				case <-throttle:
+				case <-client.done:
+					// Disconnected by the hub
+					closeOnce.Do(func() { close(done) })
+					return
+				case msg := <-writeChan:
+					if stopped() {
 						return
 					}
-				case msg := <-writeChan:
 					switch m := msg.(type) {
 					case []byte:
 						if err := c.WriteMessage(websocket.PongMessage, m); err != nil {
@@ -544,9 +762,27 @@ func (h *detectHandler) HandleAttackWebSocket() fiber.Handler {
 							return
 						}
 					}
+					continue
 				case <-done:
 					return
 				}
+
+				messages, wait := client.take(time.Now())
+				throttle = nil
+				if wait > 0 {
+					throttle = time.After(wait)
+				}
+				for _, message := range messages {
+					if stopped() {
+						return
+					}
+					if err := c.WriteMessage(websocket.TextMessage, message); err != nil {
+						log.Printf("Error writing attack data: %v", err)
+						closeOnce.Do(func() { close(done) })
+						return
+					}
+					client.counters.recordSent(len(message))
+				}
 			}
 		}()
 
@@ -570,28 +806,55 @@ func (h *detectHandler) HandleAttackWebSocket() fiber.Handler {
 				}
 			}
 
-			// Handle text messages (e.g., ping from client)
+			// Handle text messages (ping or subscription changes from client)
 			if messageType == websocket.TextMessage {
-				var msg map[string]interface{}
-				if err := json.Unmarshal(payload, &msg); err == nil {
-					if msgType, ok := msg["type"].(string); ok && msgType == "ping" {
-						// Send pong response via write channel
-						select {
-						case writeChan <- map[string]interface{}{
-							"type":      "pong",
-							"timestamp": msg["timestamp"],
-						}:
-						default:
-							log.Printf("Write channel full, skipping pong")
+				var msg struct {
+					Type      string      `json:"type"`
+					Timestamp interface{} `json:"timestamp"`
+					AttackSubscription
+				}
+				if err := json.Unmarshal(payload, &msg); err != nil {
+					continue
+				}
+
+				var reply map[string]interface{}
+				switch msg.Type {
+				case "ping":
+					reply = map[string]interface{}{
+						"type":      "pong",
+						"timestamp": msg.Timestamp,
+					}
+				case "subscribe":
+					if err := msg.AttackSubscription.validate(); err != nil {
+						reply = map[string]interface{}{
+							"error": err.Error(),
 						}
+						break
+					}
+					client.subscribe(msg.AttackSubscription)
+					reply = map[string]interface{}{
+						"status":       "subscribed",
+						"subscription": client.currentSubscription(),
 					}
+				default:
+					continue
+				}
+
+				// Send the response via write channel
+				select {
+				case writeChan <- reply:
+				default:
+					log.Printf("Write channel full, skipping %s response", msg.Type)
 				}
 			}
 		}
+
+		// Unblock a write in progress and wait for the writer before the Conn is reused
+		c.SetWriteDeadline(time.Now())
+		<-writerDone
 	})
 }
 
-// Helper function to marshal JSON
 func mustMarshal(v interface{}) []byte {
 	data, err := json.Marshal(v)
 	if err != nil {
@@ -612,11 +875,32 @@ func WebSocketUpgrade() fiber.Handler {
 }
 
 // HandleVideoInput - WebSocket handler for receiving video frames from Python
+// The source camera is taken from the camera_id query parameter, then from each frame,
+// then falls back to the default camera.
 func (h *detectHandler) HandleVideoInput() fiber.Handler {
 	return websocket.New(func(c *websocket.Conn) {
-		log.Println("Python video source connected")
+		sourceCameraID := DefaultCameraID()
+		if cameraIDParam := c.Query("camera_id"); cameraIDParam != "" {
+			parsed, err := uuid.Parse(cameraIDParam)
+			if err != nil {
+				log.Printf("Invalid camera ID: %v", err)
+				c.WriteJSON(fiber.Map{
+					"error": "Invalid camera_id format",
+				})
+				c.Close()
+				return
+			}
+			sourceCameraID = parsed
+		}
+
+		log.Printf("Python video source connected. Camera ID: %s", sourceCameraID)
+		// Cameras this connection sent frames for, marked offline when it closes
+		sentCameras := make(map[uuid.UUID]bool)
 		defer func() {
 			log.Println("Python video source disconnected")
+			for cameraID := range sentCameras {
+				publishSourceStatus(videoSources.disconnect(cameraID, time.Now()))
+			}
 			c.Close()
 		}()
 
@@ -635,6 +919,10 @@ func (h *detectHandler) HandleVideoInput() fiber.Handler {
 				}
 				break
 			}
+			if frame.CameraID == uuid.Nil {
+				frame.CameraID = sourceCameraID
+			}
+			sentCameras[frame.CameraID] = true
 
 			// Broadcast frame to all viewer clients
 			BroadcastVideoFrame(&frame)
@@ -649,13 +937,39 @@ func (h *detectHandler) HandleVideoInput() fiber.Handler {
 }
 
 // HandleVideoStream - WebSocket handler for clients to view the video stream
+// An optional camera_id query parameter limits the stream to a single camera,
+// quality=low|medium|high or width/height select a server-side rendition,
+// and an optional fps query parameter limits the delivery rate for this viewer.
 func (h *detectHandler) HandleVideoStream() fiber.Handler {
 	return websocket.New(func(c *websocket.Conn) {
-		client := &VideoClient{
-			conn: c,
-			send: make(chan []byte, 256),
+		var cameraID uuid.UUID
+		if cameraIDParam := c.Query("camera_id"); cameraIDParam != "" {
+			parsed, err := uuid.Parse(cameraIDParam)
+			if err != nil {
+				log.Printf("Invalid camera ID: %v", err)
+				c.WriteJSON(fiber.Map{
+					"error": "Invalid camera_id format",
+				})
+				c.Close()
+				return
+			}
+			cameraID = parsed
+		}
+
+		rendition, err := parseRendition(c.Query("quality"), c.Query("width"), c.Query("height"))
+		if err != nil {
+			log.Printf("Invalid video rendition: %v", err)
+			c.WriteJSON(fiber.Map{
+				"error": err.Error(),
+			})
+			c.Close()
+			return
 		}
 
+		maxFPS, _ := strconv.ParseFloat(c.Query("fps"), 64)
+		client := newVideoClient(c, c.RemoteAddr().String(), cameraID, false, rendition, maxFPS)
+		client.userID = socketUserID(c)
+
 		// Register client
 		videoHub.register <- client
 
@@ -663,36 +977,66 @@ func (h *detectHandler) HandleVideoStream() fiber.Handler {
 		writeChan := make(chan interface{}, 100)
 		done := make(chan struct{})
 		var closeOnce sync.Once
+		// Closed when the writer has exited, gofiber reuses the Conn once the handler returns
+		writerDone := make(chan struct{})
+		stopped := func() bool {
+			select {
+			case <-done:
+				return true
+			default:
+				return false
+			}
+		}
 
 		// Start single write goroutine
 		go func() {
+			defer close(writerDone)
 			defer func() {
 				videoHub.unregister <- client
 			}()
 
-			// Send initial confirmation
+			// Send initial confirmation with the current status of the subscribed sources
+			sources := make([]VideoSourceStats, 0)
+			for _, source := range GetVideoSources() {
+				if cameraID == uuid.Nil || source.CameraID == cameraID {
+					sources = append(sources, source)
+				}
+			}
 			if err := c.WriteJSON(fiber.Map{
 				"status":  "connected",
 				"message": "Subscribed to video stream",
+				"sources": sources,
 			}); err != nil {
 				log.Printf("Error sending initial message: %v", err)
 				closeOnce.Do(func() { close(done) })
 				return
 			}
 
+			// Fires when the fps limit allows the pending frame to be sent
+			var throttle <-chan time.Time
 			for {
 				select {
-				case message, ok := <-client.send:
-					if !ok {
-						closeOnce.Do(func() { close(done) })
+				case <-client.notify:
+				case <-throttle:
+				case <-client.done:
+					// Disconnected by the hub, e.g. after a sustained stall
+					closeOnce.Do(func() { close(done) })
+					c.Close()
+					return
+				case event := <-client.status:
+					if stopped() {
 						return
 					}
-					if err := c.WriteMessage(websocket.TextMessage, message); err != nil {
-						log.Printf("Error writing video frame: %v", err)
+					if err := c.WriteMessage(websocket.TextMessage, event); err != nil {
+						log.Printf("Error writing source status: %v", err)
 						closeOnce.Do(func() { close(done) })
 						return
 					}
+					continue
 				case msg := <-writeChan:
+					if stopped() {
+						return
+					}
 					switch m := msg.(type) {
 					case []byte:
 						if err := c.WriteMessage(websocket.PongMessage, m); err != nil {
@@ -707,9 +1051,28 @@ func (h *detectHandler) HandleVideoStream() fiber.Handler {
 							return
 						}
 					}
+					continue
 				case <-done:
 					return
 				}
+
+				throttle = nil
+				message, wait := client.take(time.Now())
+				if wait > 0 {
+					throttle = time.After(wait)
+					continue
+				}
+				if message == nil {
+					continue
+				}
+				if stopped() {
+					return
+				}
+				if err := c.WriteMessage(websocket.TextMessage, message); err != nil {
+					log.Printf("Error writing video frame: %v", err)
+					closeOnce.Do(func() { close(done) })
+					return
+				}
 			}
 		}()
 
@@ -751,5 +1114,9 @@ func (h *detectHandler) HandleVideoStream() fiber.Handler {
 				}
 			}
 		}
+
+		// Unblock a write in progress and wait for the writer before the Conn is reused
+		c.SetWriteDeadline(time.Now())
+		<-writerDone
 	})
 }
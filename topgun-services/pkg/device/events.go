@@ -0,0 +1,77 @@
+package device
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+
+	"topgun-services/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+// Device event types sent to WebSocket clients
+const (
+	EventSnapshot  = "snapshot"  // every device when a client connects
+	EventStatus    = "status"    // a device came online or went offline
+	EventTelemetry = "telemetry" // a heartbeat was received
+)
+
+// Event is a device update sent to WebSocket clients
+type Event struct {
+	Type    string          `json:"type"`
+	Device  *models.Device  `json:"device,omitempty"`
+	Devices []models.Device `json:"devices,omitempty"`
+}
+
+// eventClient is a WebSocket client of the device events
+type eventClient struct {
+	deviceID uuid.UUID // only events of this device, every device when Nil
+	send     chan []byte
+}
+
+// eventHub fans device events out to WebSocket clients
+type eventHub struct {
+	mutex   sync.RWMutex
+	clients map[*eventClient]struct{}
+}
+
+// Global device event hub
+var events = &eventHub{clients: make(map[*eventClient]struct{})}
+
+func (h *eventHub) register(client *eventClient) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.clients[client] = struct{}{}
+}
+
+func (h *eventHub) unregister(client *eventClient) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, ok := h.clients[client]; ok {
+		delete(h.clients, client)
+		close(client.send)
+	}
+}
+
+// publish sends an event to every interested client, clients too slow to keep up miss it
+func (h *eventHub) publish(event Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error marshaling device event: %v", err)
+		return
+	}
+
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	for client := range h.clients {
+		if client.deviceID != uuid.Nil && (event.Device == nil || event.Device.ID != client.deviceID) {
+			continue
+		}
+		select {
+		case client.send <- data:
+		default:
+			log.Printf("Device event client too slow, dropping %s event", event.Type)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"topgun-services/internal/handlers"
+	"topgun-services/pkg/detect"
+	"topgun-services/pkg/domain"
+	"topgun-services/pkg/models"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	helpers "github.com/zercle/gofiber-helpers"
+	"gorm.io/gorm"
+)
+
+type deviceHandler struct {
+	service domain.DeviceService
+}
+
+// NewDeviceHandler registers the routes to monitor edge devices
+func NewDeviceHandler(router fiber.Router, routerResource *handlers.RouterResources, deviceService domain.DeviceService) {
+	handler := &deviceHandler{service: deviceService}
+	router.Get("/", routerResource.ReqAuthHandler(), handler.GetDevices())
+	router.Get("/ws", routerResource.ReqAuthHandler(), detect.WebSocketUpgrade(), handler.HandleWebSocket())
+	router.Get("/:id", routerResource.ReqAuthHandler(), handler.GetDevice())
+	router.Get("/:id/telemetry", routerResource.ReqAuthHandler(), handler.GetTelemetry())
+	router.Put("/:id", routerResource.ReqAuthHandler(), handler.UpdateDevice())
+	router.Delete("/:id", routerResource.ReqAuthHandler(), handler.DeleteDevice())
+}
+
+// deviceError answers with the status matching a service error
+func deviceError(c *fiber.Ctx, title string, err error) error {
+	status := fiber.StatusInternalServerError
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		status = fiber.StatusNotFound
+	}
+	return errorResponse(c, status, title, err)
+}
+
+// errorResponse answers with a single error
+func errorResponse(c *fiber.Ctx, status int, title string, err error) error {
+	return c.Status(status).JSON(helpers.ResponseForm{
+		Success: false,
+		Errors: []helpers.ResponseError{
+			{
+				Code:    status,
+				Title:   title,
+				Message: err.Error(),
+				Source:  helpers.WhereAmI(),
+			},
+		},
+	})
+}
+
+// @Summary GetDevices
+// @Tags Device
+// @Description List edge devices with their online status and latest telemetry
+// @Produce json
+// @Param page query int false "Page number"
+// @Param per_page query int false "Items per page"
+// @Router /api/v1/devices/ [get]
+// @Security ApiKeyAuth
+func (h *deviceHandler) GetDevices() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var pagination models.Pagination
+		if err := c.QueryParser(&pagination); err != nil {
+			return errorResponse(c, fiber.StatusBadRequest, "Invalid pagination query parameters", err)
+		}
+
+		devices, p, err := h.service.GetDevices(pagination)
+		if err != nil {
+			return deviceError(c, "Failed to retrieve devices", err)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(helpers.ResponseForm{
+			Success: true,
+			Data: fiber.Map{
+				"devices":    devices,
+				"pagination": p,
+			},
+		})
+	}
+}
+
+// @Summary GetDevice
+// @Tags Device
+// @Description Get an edge device with its attached cameras, online status and latest telemetry
+// @Produce json
+// @Param id path string true "Device ID"
+// @Router /api/v1/devices/{id} [get]
+// @Security ApiKeyAuth
+func (h *deviceHandler) GetDevice() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return errorResponse(c, fiber.StatusBadRequest, "Invalid device ID", err)
+		}
+
+		device, err := h.service.GetDevice(id)
+		if err != nil {
+			return deviceError(c, "Failed to retrieve device", err)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(helpers.ResponseForm{
+			Success: true,
+			Data:    device,
+		})
+	}
+}
+
+// @Summary GetDeviceTelemetry
+// @Tags Device
+// @Description Get the telemetry history of an edge device, newest first
+// @Produce json
+// @Param id path string true "Device ID"
+// @Param from query string false "Start time (RFC3339)"
+// @Param to query string false "End time (RFC3339)"
+// @Param page query int false "Page number"
+// @Param per_page query int false "Items per page"
+// @Router /api/v1/devices/{id}/telemetry [get]
+// @Security ApiKeyAuth
+func (h *deviceHandler) GetTelemetry() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return errorResponse(c, fiber.StatusBadRequest, "Invalid device ID", err)
+		}
+		var pagination models.Pagination
+		if err := c.QueryParser(&pagination); err != nil {
+			return errorResponse(c, fiber.StatusBadRequest, "Invalid pagination query parameters", err)
+		}
+		var from, to time.Time
+		if value := c.Query("from"); value != "" {
+			if from, err = time.Parse(time.RFC3339, value); err != nil {
+				return errorResponse(c, fiber.StatusBadRequest, "Invalid from time", err)
+			}
+		}
+		if value := c.Query("to"); value != "" {
+			if to, err = time.Parse(time.RFC3339, value); err != nil {
+				return errorResponse(c, fiber.StatusBadRequest, "Invalid to time", err)
+			}
+		}
+
+		telemetry, p, err := h.service.GetTelemetry(id, from, to, pagination)
+		if err != nil {
+			return deviceError(c, "Failed to retrieve device telemetry", err)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(helpers.ResponseForm{
+			Success: true,
+			Data: fiber.Map{
+				"telemetry":  telemetry,
+				"pagination": p,
+			},
+		})
+	}
+}
+
+// @Summary UpdateDevice
+// @Tags Device
+// @Description Rename an edge device, everything else is reported by its heartbeats
+// @Accept json
+// @Produce json
+// @Param id path string true "Device ID"
+// @Param request body object{name=string} true "Device name"
+// @Router /api/v1/devices/{id} [put]
+// @Security ApiKeyAuth
+func (h *deviceHandler) UpdateDevice() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return errorResponse(c, fiber.StatusBadRequest, "Invalid device ID", err)
+		}
+		var request struct {
+			Name string `json:"name"`
+		}
+		if err := c.BodyParser(&request); err != nil {
+			return errorResponse(c, fiber.StatusBadRequest, "Invalid request body", err)
+		}
+		if request.Name == "" {
+			return errorResponse(c, fiber.StatusBadRequest, "Invalid request body", errors.New("name is required"))
+		}
+
+		device, err := h.service.UpdateDevice(id, models.Device{Name: request.Name})
+		if err != nil {
+			return deviceError(c, "Failed to update device", err)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(helpers.ResponseForm{
+			Success: true,
+			Data:    device,
+		})
+	}
+}
+
+// @Summary DeleteDevice
+// @Tags Device
+// @Description Remove an edge device and its telemetry history, it registers again with its next heartbeat
+// @Produce json
+// @Param id path string true "Device ID"
+// @Router /api/v1/devices/{id} [delete]
+// @Security ApiKeyAuth
+func (h *deviceHandler) DeleteDevice() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return errorResponse(c, fiber.StatusBadRequest, "Invalid device ID", err)
+		}
+
+		if err := h.service.DeleteDevice(id); err != nil {
+			return deviceError(c, "Failed to delete device", err)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(helpers.ResponseForm{
+			Success: true,
+		})
+	}
+}
+
+// snapshot returns the watched device, or every device page by page
+func (h *deviceHandler) snapshot(deviceID uuid.UUID) ([]models.Device, error) {
+	if deviceID != uuid.Nil {
+		device, err := h.service.GetDevice(deviceID)
+		if err != nil {
+			return nil, err
+		}
+		return []models.Device{*device}, nil
+	}
+
+	var devices []models.Device
+	for page := 1; ; page++ {
+		batch, p, err := h.service.GetDevices(models.Pagination{Page: page, PerPage: 50})
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, batch...)
+		if len(batch) == 0 || int64(len(devices)) >= p.Total {
+			return devices, nil
+		}
+	}
+}
+
+// @Summary DeviceEvents
+// @Tags Device
+// @Description WebSocket stream of device status and telemetry. A snapshot of the devices is sent first,
+// @Description then status events when a device comes online or goes offline and telemetry events for each heartbeat.
+// @Param device_id query string false "Only events of this device"
+// @Param access_token query string false "JWT access token, when the Authorization header cannot be set"
+// @Router /api/v1/devices/ws [get]
+// @Security ApiKeyAuth
+func (h *deviceHandler) HandleWebSocket() fiber.Handler {
+	return websocket.New(func(c *websocket.Conn) {
+		defer c.Close()
+
+		var deviceID uuid.UUID
+		if value := c.Query("device_id"); value != "" {
+			id, err := uuid.Parse(value)
+			if err != nil {
+				c.WriteJSON(fiber.Map{
+					"error": "Invalid device_id format",
+				})
+				return
+			}
+			deviceID = id
+		}
+
+		// Register before the snapshot so no event between the two is missed
+		client := &eventClient{deviceID: deviceID, send: make(chan []byte, 64)}
+		events.register(client)
+		defer events.unregister(client)
+
+		devices, err := h.snapshot(deviceID)
+		if err != nil {
+			c.WriteJSON(fiber.Map{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		data, err := json.Marshal(Event{Type: EventSnapshot, Devices: devices})
+		if err != nil {
+			log.Printf("Error marshaling device snapshot: %v", err)
+			return
+		}
+		if err := c.WriteMessage(websocket.TextMessage, data); err != nil {
+			return
+		}
+
+		// Write events until the client is unregistered
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for message := range client.send {
+				if err := c.WriteMessage(websocket.TextMessage, message); err != nil {
+					log.Printf("Error writing device event: %v", err)
+					return
+				}
+			}
+		}()
+
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure, websocket.CloseAbnormalClosure) {
+					log.Printf("Unexpected close error: %v", err)
+				}
+				break
+			}
+		}
+		events.unregister(client)
+		<-done
+	})
+}
@@ -0,0 +1,120 @@
+package device_test
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"topgun-services/internal/handlers"
+	"topgun-services/pkg/device"
+	"topgun-services/pkg/models"
+
+	fastws "github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestDeviceWebSocket(t *testing.T) {
+	secret := []byte("device-test-secret")
+	routerResource := handlers.NewRouterResources(func(token *jwt.Token) (interface{}, error) {
+		return secret, nil
+	}, nil, nil)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Subject:   "user-1",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}).SignedString(secret)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.Camera{}, &models.Device{}, &models.DeviceTelemetry{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	camera := models.Camera{Name: "gate", Token: "camera-secret-token"}
+	if err := db.Create(&camera).Error; err != nil {
+		t.Fatalf("failed to create camera: %v", err)
+	}
+	repository := device.NewDeviceRepository(db)
+	service := device.NewDeviceService(repository)
+	if _, _, err := service.RecordHeartbeat("pi-gate", models.DeviceHeartbeat{Cameras: []string{camera.ID.String()}}, time.Now()); err != nil {
+		t.Fatalf("failed to record heartbeat: %v", err)
+	}
+
+	app := fiber.New()
+	device.NewDeviceHandler(app.Group("/devices"), routerResource, service)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go app.Listener(listener)
+	defer app.Shutdown()
+
+	tests := []Test{
+		{
+			TestName: "RequiresAuth",
+			Func: func() error {
+				conn, _, err := fastws.DefaultDialer.Dial(fmt.Sprintf("ws://%s/devices/ws", listener.Addr()), nil)
+				if err == nil {
+					conn.Close()
+					return errors.New("connected without a token")
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "SnapshotLeavesOutCameraTokens",
+			Func: func() error {
+				conn, _, err := fastws.DefaultDialer.Dial(fmt.Sprintf("ws://%s/devices/ws?access_token=%s", listener.Addr(), token), nil)
+				if err != nil {
+					return err
+				}
+				defer conn.Close()
+				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				_, data, err := conn.ReadMessage()
+				if err != nil {
+					return err
+				}
+				if !strings.Contains(string(data), camera.ID.String()) {
+					return fmt.Errorf("expected camera %s in the snapshot, got %s", camera.ID, data)
+				}
+				if strings.Contains(string(data), camera.Token) {
+					return fmt.Errorf("the snapshot must not include camera tokens: %s", data)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "PreloadedCamerasHaveNoToken",
+			Func: func() error {
+				registered, err := repository.GetDeviceByHardwareID("pi-gate")
+				if err != nil {
+					return err
+				}
+				if len(registered.Cameras) != 1 {
+					return fmt.Errorf("expected one attached camera, got %d", len(registered.Cameras))
+				}
+				if registered.Cameras[0].Token != "" {
+					return errors.New("cameras of a device must be loaded without their token")
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			if err := test.Func(); err != nil {
+				t.Errorf("Test %s failed with error: %v", test.TestName, err)
+			}
+		})
+	}
+}
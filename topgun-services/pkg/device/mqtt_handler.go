@@ -0,0 +1,98 @@
+package device
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"topgun-services/pkg/domain"
+	"topgun-services/pkg/models"
+	"topgun-services/pkg/mqtt"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/gofiber/fiber/v2"
+	"github.com/spf13/viper"
+)
+
+// HeartbeatTopic returns the topic filter devices publish heartbeats to, the + level is the hardware ID
+func HeartbeatTopic() string {
+	topic := viper.GetString("mqtt.heartbeat_topic")
+	if topic == "" {
+		topic = "topgun/devices/+/heartbeat"
+	}
+	return topic
+}
+
+// MQTTHeartbeatHandler records device heartbeats received over MQTT
+type MQTTHeartbeatHandler struct {
+	service domain.DeviceService
+	pattern []string
+	level   int // topic level holding the hardware ID, -1 when the topic has none
+}
+
+func NewMQTTHeartbeatHandler(service domain.DeviceService, topic string) *MQTTHeartbeatHandler {
+	return &MQTTHeartbeatHandler{service: service, pattern: strings.Split(topic, "/"), level: mqtt.WildcardLevel(topic)}
+}
+
+// hardwareID takes the hardware ID from the topic, then from the payload
+func (h *MQTTHeartbeatHandler) hardwareID(topic string, heartbeat models.DeviceHeartbeat) string {
+	if levels := strings.Split(topic, "/"); h.level >= 0 && h.level < len(levels) && len(levels) == len(h.pattern) {
+		return levels[h.level]
+	}
+	return heartbeat.HardwareID
+}
+
+func (h *MQTTHeartbeatHandler) HandleMessage(client paho.Client, msg paho.Message) {
+	var heartbeat models.DeviceHeartbeat
+	if err := json.Unmarshal(msg.Payload(), &heartbeat); err != nil {
+		log.Printf("Invalid device heartbeat on topic %s: %v", msg.Topic(), err)
+		return
+	}
+
+	device, cameOnline, err := h.service.RecordHeartbeat(h.hardwareID(msg.Topic(), heartbeat), heartbeat, time.Now())
+	if err != nil {
+		log.Printf("Rejected device heartbeat on topic %s: %v", msg.Topic(), err)
+		return
+	}
+	if cameOnline {
+		log.Printf("Device %s (%s) is online", device.HardwareID, device.Name)
+		events.publish(Event{Type: EventStatus, Device: device})
+	}
+	events.publish(Event{Type: EventTelemetry, Device: device})
+}
+
+// StartMQTTSubscription registers the heartbeat topic on the shared MQTT connection
+func StartMQTTSubscription(subscriber mqtt.Subscriber, handler *MQTTHeartbeatHandler) error {
+	return subscriber.Handle(strings.Join(handler.pattern, "/"), 0, handler.HandleMessage)
+}
+
+// StartMonitor reports devices going offline and prunes old telemetry until stop is closed
+func StartMonitor(service domain.DeviceService, stop <-chan struct{}) {
+	check := time.NewTicker(5 * time.Second)
+	defer check.Stop()
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
+
+	for {
+		select {
+		case now := <-check.C:
+			for _, device := range service.MarkOffline(now) {
+				log.Printf("Device %s (%s) is offline, last heartbeat %s", device.HardwareID, device.Name, device.LastHeartbeatAt.Format(time.RFC3339))
+				events.publish(Event{Type: EventStatus, Device: &device})
+			}
+		case now := <-prune.C:
+			// Prune once, from the main process
+			if fiber.IsChild() {
+				continue
+			}
+			if removed, err := service.PruneTelemetry(now); err != nil {
+				log.Printf("Failed to prune device telemetry: %v", err)
+			} else if removed > 0 {
+				log.Printf("Pruned %d device telemetry records", removed)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package device
+
+import (
+	"errors"
+	"time"
+
+	"topgun-services/pkg/domain"
+	"topgun-services/pkg/models"
+	"topgun-services/pkg/utils"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// omitCameraToken preloads the cameras of a device without their MQTT broker token
+func omitCameraToken(db *gorm.DB) *gorm.DB {
+	return db.Omit("token")
+}
+
+type deviceRepository struct {
+	DB *gorm.DB
+}
+
+func NewDeviceRepository(db *gorm.DB) domain.DeviceRepository {
+	return &deviceRepository{DB: db}
+}
+func (r *deviceRepository) GetDevices(pagination models.Pagination) ([]models.Device, *models.Pagination, error) {
+	if r.DB == nil {
+		return nil, nil, gorm.ErrInvalidDB
+	}
+	var devices []models.Device
+	dbTx := utils.ApplyPagination(r.DB.Order("hardware_id"), &pagination, &devices)
+	err := dbTx.Preload("Cameras", omitCameraToken).Limit(pagination.PerPage).Find(&devices).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	return devices, &pagination, nil
+}
+func (r *deviceRepository) GetDevice(id uuid.UUID) (*models.Device, error) {
+	if r.DB == nil {
+		return nil, gorm.ErrInvalidDB
+	}
+	var device models.Device
+	err := r.DB.Preload("Cameras", omitCameraToken).First(&device, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+func (r *deviceRepository) GetDeviceByHardwareID(hardwareID string) (*models.Device, error) {
+	if r.DB == nil {
+		return nil, gorm.ErrInvalidDB
+	}
+	var device models.Device
+	err := r.DB.Preload("Cameras", omitCameraToken).First(&device, "hardware_id = ?", hardwareID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+func (r *deviceRepository) CreateDevice(device models.Device) (*models.Device, error) {
+	if r.DB == nil {
+		return nil, gorm.ErrInvalidDB
+	}
+	err := r.DB.Omit("Cameras").Create(&device).Error
+	if err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+func (r *deviceRepository) UpdateDevice(id uuid.UUID, device models.Device) (*models.Device, error) {
+	if r.DB == nil {
+		return nil, gorm.ErrInvalidDB
+	}
+	var existingDevice models.Device
+	err := r.DB.First(&existingDevice, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	err = r.DB.Model(&existingDevice).Omit("Cameras").Updates(device).Error
+	if err != nil {
+		return nil, err
+	}
+	return r.GetDevice(id)
+}
+
+// SetDeviceCameras replaces the cameras attached to a device, IDs of unknown cameras are ignored
+func (r *deviceRepository) SetDeviceCameras(id uuid.UUID, cameraIDs []uuid.UUID) error {
+	if r.DB == nil {
+		return gorm.ErrInvalidDB
+	}
+	cameras := []models.Camera{}
+	if len(cameraIDs) > 0 {
+		if err := r.DB.Find(&cameras, "id IN ?", cameraIDs).Error; err != nil {
+			return err
+		}
+	}
+	return r.DB.Model(&models.Device{ID: id}).Association("Cameras").Replace(cameras)
+}
+func (r *deviceRepository) DeleteDevice(id uuid.UUID) error {
+	if r.DB == nil {
+		return gorm.ErrInvalidDB
+	}
+	var device models.Device
+	err := r.DB.Where("id = ?", id).First(&device).Error
+	if err != nil {
+		return err
+	}
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&device).Association("Cameras").Clear(); err != nil {
+			return err
+		}
+		if err := tx.Delete(&models.DeviceTelemetry{}, "device_id = ?", id).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Device{}, "id = ?", id).Error
+	})
+}
+func (r *deviceRepository) CreateTelemetry(telemetry models.DeviceTelemetry) (*models.DeviceTelemetry, error) {
+	if r.DB == nil {
+		return nil, gorm.ErrInvalidDB
+	}
+	err := r.DB.Create(&telemetry).Error
+	if err != nil {
+		return nil, err
+	}
+	return &telemetry, nil
+}
+
+// GetTelemetry returns the telemetry history of a device, newest first. Zero times leave the range open.
+func (r *deviceRepository) GetTelemetry(deviceID uuid.UUID, from, to time.Time, pagination models.Pagination) ([]models.DeviceTelemetry, *models.Pagination, error) {
+	if r.DB == nil {
+		return nil, nil, gorm.ErrInvalidDB
+	}
+	var telemetry []models.DeviceTelemetry
+	dbTx := r.DB.Where("device_id = ?", deviceID)
+	if !from.IsZero() {
+		dbTx = dbTx.Where("at >= ?", from)
+	}
+	if !to.IsZero() {
+		dbTx = dbTx.Where("at <= ?", to)
+	}
+	dbTx = utils.ApplyPagination(dbTx.Order("at DESC"), &pagination, &telemetry)
+	err := dbTx.Limit(pagination.PerPage).Find(&telemetry).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	return telemetry, &pagination, nil
+}
+
+// GetLatestTelemetry returns the last reported telemetry of a device, nil when it never reported any
+func (r *deviceRepository) GetLatestTelemetry(deviceID uuid.UUID) (*models.DeviceTelemetry, error) {
+	if r.DB == nil {
+		return nil, gorm.ErrInvalidDB
+	}
+	var telemetry models.DeviceTelemetry
+	err := r.DB.Where("device_id = ?", deviceID).Order("at DESC").First(&telemetry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &telemetry, nil
+}
+
+// DeleteTelemetryBefore removes telemetry older than the retention and returns how many rows were removed
+func (r *deviceRepository) DeleteTelemetryBefore(before time.Time) (int64, error) {
+	if r.DB == nil {
+		return 0, gorm.ErrInvalidDB
+	}
+	result := r.DB.Delete(&models.DeviceTelemetry{}, "at < ?", before)
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,246 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"topgun-services/pkg/domain"
+	"topgun-services/pkg/models"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+type deviceService struct {
+	repository domain.DeviceRepository
+
+	// Devices seen online by this instance and their last heartbeat, to report when they go offline
+	mutex    sync.Mutex
+	lastSeen map[uuid.UUID]time.Time
+}
+
+func NewDeviceService(repo domain.DeviceRepository) domain.DeviceService {
+	return &deviceService{
+		repository: repo,
+		lastSeen:   make(map[uuid.UUID]time.Time),
+	}
+}
+
+// offlineAfter returns how long a device may miss heartbeats before it is offline
+func offlineAfter() time.Duration {
+	timeout := time.Duration(viper.GetFloat64("devices.offline_after_seconds") * float64(time.Second))
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	return timeout
+}
+
+// telemetryRetention returns how long telemetry history is kept
+func telemetryRetention() time.Duration {
+	retention := time.Duration(viper.GetFloat64("devices.telemetry_retention_hours") * float64(time.Hour))
+	if retention <= 0 {
+		retention = 7 * 24 * time.Hour
+	}
+	return retention
+}
+
+// withStatus sets the status of a device from its last heartbeat
+func withStatus(device *models.Device, now time.Time) {
+	device.Status = models.DeviceOffline
+	if device.LastHeartbeatAt != nil && now.Sub(*device.LastHeartbeatAt) < offlineAfter() {
+		device.Status = models.DeviceOnline
+	}
+}
+
+func (s *deviceService) GetDevices(pagination models.Pagination) ([]models.Device, *models.Pagination, error) {
+	devices, p, err := s.repository.GetDevices(pagination)
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	for i := range devices {
+		withStatus(&devices[i], now)
+		if devices[i].Telemetry, err = s.repository.GetLatestTelemetry(devices[i].ID); err != nil {
+			return nil, nil, err
+		}
+	}
+	return devices, p, nil
+}
+func (s *deviceService) GetDevice(id uuid.UUID) (*models.Device, error) {
+	device, err := s.repository.GetDevice(id)
+	if err != nil {
+		return nil, err
+	}
+	withStatus(device, time.Now())
+	if device.Telemetry, err = s.repository.GetLatestTelemetry(id); err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+// UpdateDevice changes the name of a device, everything else is reported by its heartbeats
+func (s *deviceService) UpdateDevice(id uuid.UUID, device models.Device) (*models.Device, error) {
+	if _, err := s.repository.UpdateDevice(id, models.Device{Name: device.Name}); err != nil {
+		return nil, err
+	}
+	return s.GetDevice(id)
+}
+func (s *deviceService) DeleteDevice(id uuid.UUID) error {
+	if err := s.repository.DeleteDevice(id); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	delete(s.lastSeen, id)
+	s.mutex.Unlock()
+	return nil
+}
+func (s *deviceService) GetTelemetry(deviceID uuid.UUID, from, to time.Time, pagination models.Pagination) ([]models.DeviceTelemetry, *models.Pagination, error) {
+	if _, err := s.repository.GetDevice(deviceID); err != nil {
+		return nil, nil, err
+	}
+	return s.repository.GetTelemetry(deviceID, from, to, pagination)
+}
+
+// ErrInvalidHeartbeat is returned for heartbeats that cannot belong to a device
+var ErrInvalidHeartbeat = errors.New("invalid heartbeat")
+
+// validateHeartbeat checks the identity and metrics of a heartbeat and parses its camera IDs
+func validateHeartbeat(hardwareID string, heartbeat models.DeviceHeartbeat) ([]uuid.UUID, error) {
+	var problems []string
+	if hardwareID == "" || len(hardwareID) > 64 || strings.ContainsAny(hardwareID, "/+#") {
+		problems = append(problems, fmt.Sprintf("hardware_id %q must be 1 to 64 characters without / + #", hardwareID))
+	}
+	for name, value := range map[string]float64{
+		"cpu_temp": heartbeat.CPUTemperature,
+		"load":     heartbeat.Load,
+		"fps":      heartbeat.FPS,
+	} {
+		if math.IsNaN(value) || math.IsInf(value, 0) || value < -100 || value > 1000 {
+			problems = append(problems, fmt.Sprintf("%s %v is not plausible", name, value))
+		}
+	}
+	if math.IsNaN(heartbeat.DiskUsed) || heartbeat.DiskUsed < 0 || heartbeat.DiskUsed > 100 {
+		problems = append(problems, fmt.Sprintf("disk_used_percent %v is outside 0 to 100", heartbeat.DiskUsed))
+	}
+	if heartbeat.Uptime < 0 {
+		problems = append(problems, "uptime is negative")
+	}
+
+	cameraIDs := make([]uuid.UUID, 0, len(heartbeat.Cameras))
+	for _, camera := range heartbeat.Cameras {
+		id, err := uuid.Parse(camera)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("camera %q is not a UUID", camera))
+			continue
+		}
+		cameraIDs = append(cameraIDs, id)
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidHeartbeat, strings.Join(problems, "; "))
+	}
+	return cameraIDs, nil
+}
+
+// RecordHeartbeat registers the device on its first heartbeat, updates what it reports and stores its telemetry.
+// The receive time is used rather than a device clock, edge devices often have no real time clock.
+// It also reports whether the device came online with this heartbeat.
+func (s *deviceService) RecordHeartbeat(hardwareID string, heartbeat models.DeviceHeartbeat, receivedAt time.Time) (*models.Device, bool, error) {
+	cameraIDs, err := validateHeartbeat(hardwareID, heartbeat)
+	if err != nil {
+		return nil, false, err
+	}
+
+	reported := models.Device{
+		Name:            heartbeat.Name,
+		Firmware:        heartbeat.Firmware,
+		ModelFile:       heartbeat.ModelFile,
+		ModelVersion:    heartbeat.ModelVersion,
+		IP:              heartbeat.IP,
+		LastHeartbeatAt: &receivedAt,
+	}
+	device, err := s.repository.GetDeviceByHardwareID(hardwareID)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		reported.HardwareID = hardwareID
+		if reported.Name == "" {
+			reported.Name = hardwareID
+		}
+		device, err = s.repository.CreateDevice(reported)
+	case err == nil:
+		device, err = s.repository.UpdateDevice(device.ID, reported)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Only heartbeats listing cameras change the attached cameras
+	if heartbeat.Cameras != nil {
+		if err := s.repository.SetDeviceCameras(device.ID, cameraIDs); err != nil {
+			return nil, false, err
+		}
+		if device, err = s.repository.GetDevice(device.ID); err != nil {
+			return nil, false, err
+		}
+	}
+
+	modelVersion := heartbeat.ModelVersion
+	if modelVersion == "" {
+		modelVersion = device.ModelVersion
+	}
+	device.Telemetry, err = s.repository.CreateTelemetry(models.DeviceTelemetry{
+		DeviceID:       device.ID,
+		At:             receivedAt,
+		CPUTemperature: heartbeat.CPUTemperature,
+		Load:           heartbeat.Load,
+		FPS:            heartbeat.FPS,
+		DiskUsed:       heartbeat.DiskUsed,
+		Uptime:         heartbeat.Uptime,
+		ModelVersion:   modelVersion,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	s.mutex.Lock()
+	_, wasOnline := s.lastSeen[device.ID]
+	s.lastSeen[device.ID] = receivedAt
+	s.mutex.Unlock()
+
+	device.Status = models.DeviceOnline
+	return device, !wasOnline, nil
+}
+
+// MarkOffline returns the devices whose heartbeats stopped since the last call
+func (s *deviceService) MarkOffline(now time.Time) []models.Device {
+	var silent []uuid.UUID
+	s.mutex.Lock()
+	for id, lastSeen := range s.lastSeen {
+		if now.Sub(lastSeen) >= offlineAfter() {
+			silent = append(silent, id)
+			delete(s.lastSeen, id)
+		}
+	}
+	s.mutex.Unlock()
+
+	devices := make([]models.Device, 0, len(silent))
+	for _, id := range silent {
+		device, err := s.repository.GetDevice(id)
+		if err != nil {
+			continue
+		}
+		withStatus(device, now)
+		devices = append(devices, *device)
+	}
+	return devices
+}
+
+// PruneTelemetry removes telemetry older than devices.telemetry_retention_hours
+func (s *deviceService) PruneTelemetry(now time.Time) (int64, error) {
+	return s.repository.DeleteTelemetryBefore(now.Add(-telemetryRetention()))
+}
@@ -0,0 +1,313 @@
+package device_test
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"topgun-services/pkg/device"
+	"topgun-services/pkg/models"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+type Test struct {
+	TestName string
+	Func     func() error
+}
+
+// fakeDeviceRepository keeps devices and telemetry in memory
+type fakeDeviceRepository struct {
+	mutex     sync.Mutex
+	devices   map[uuid.UUID]models.Device
+	telemetry []models.DeviceTelemetry
+}
+
+func newFakeDeviceRepository() *fakeDeviceRepository {
+	return &fakeDeviceRepository{devices: make(map[uuid.UUID]models.Device)}
+}
+
+func (r *fakeDeviceRepository) GetDevices(pagination models.Pagination) ([]models.Device, *models.Pagination, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	devices := make([]models.Device, 0, len(r.devices))
+	for _, device := range r.devices {
+		devices = append(devices, device)
+	}
+	pagination.Total = int64(len(devices))
+	return devices, &pagination, nil
+}
+func (r *fakeDeviceRepository) GetDevice(id uuid.UUID) (*models.Device, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	device, ok := r.devices[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &device, nil
+}
+func (r *fakeDeviceRepository) GetDeviceByHardwareID(hardwareID string) (*models.Device, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, device := range r.devices {
+		if device.HardwareID == hardwareID {
+			return &device, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+func (r *fakeDeviceRepository) CreateDevice(device models.Device) (*models.Device, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	device.ID = uuid.New()
+	r.devices[device.ID] = device
+	return &device, nil
+}
+func (r *fakeDeviceRepository) UpdateDevice(id uuid.UUID, device models.Device) (*models.Device, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	stored, ok := r.devices[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	// Like gorm Updates, only non-zero fields change
+	if device.Name != "" {
+		stored.Name = device.Name
+	}
+	if device.Firmware != "" {
+		stored.Firmware = device.Firmware
+	}
+	if device.ModelFile != "" {
+		stored.ModelFile = device.ModelFile
+	}
+	if device.ModelVersion != "" {
+		stored.ModelVersion = device.ModelVersion
+	}
+	if device.IP != "" {
+		stored.IP = device.IP
+	}
+	if device.LastHeartbeatAt != nil {
+		stored.LastHeartbeatAt = device.LastHeartbeatAt
+	}
+	r.devices[id] = stored
+	return &stored, nil
+}
+func (r *fakeDeviceRepository) SetDeviceCameras(id uuid.UUID, cameraIDs []uuid.UUID) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	stored, ok := r.devices[id]
+	if !ok {
+		return gorm.ErrRecordNotFound
+	}
+	stored.Cameras = nil
+	for _, cameraID := range cameraIDs {
+		stored.Cameras = append(stored.Cameras, models.Camera{ID: cameraID})
+	}
+	r.devices[id] = stored
+	return nil
+}
+func (r *fakeDeviceRepository) DeleteDevice(id uuid.UUID) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.devices, id)
+	return nil
+}
+func (r *fakeDeviceRepository) CreateTelemetry(telemetry models.DeviceTelemetry) (*models.DeviceTelemetry, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	telemetry.ID = uint(len(r.telemetry) + 1)
+	r.telemetry = append(r.telemetry, telemetry)
+	return &telemetry, nil
+}
+func (r *fakeDeviceRepository) GetTelemetry(deviceID uuid.UUID, from, to time.Time, pagination models.Pagination) ([]models.DeviceTelemetry, *models.Pagination, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var telemetry []models.DeviceTelemetry
+	for _, record := range r.telemetry {
+		if record.DeviceID == deviceID && (from.IsZero() || !record.At.Before(from)) && (to.IsZero() || !record.At.After(to)) {
+			telemetry = append(telemetry, record)
+		}
+	}
+	pagination.Total = int64(len(telemetry))
+	return telemetry, &pagination, nil
+}
+func (r *fakeDeviceRepository) GetLatestTelemetry(deviceID uuid.UUID) (*models.DeviceTelemetry, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for i := len(r.telemetry) - 1; i >= 0; i-- {
+		if r.telemetry[i].DeviceID == deviceID {
+			telemetry := r.telemetry[i]
+			return &telemetry, nil
+		}
+	}
+	return nil, nil
+}
+func (r *fakeDeviceRepository) DeleteTelemetryBefore(before time.Time) (int64, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	kept := r.telemetry[:0]
+	for _, record := range r.telemetry {
+		if !record.At.Before(before) {
+			kept = append(kept, record)
+		}
+	}
+	removed := int64(len(r.telemetry) - len(kept))
+	r.telemetry = kept
+	return removed, nil
+}
+
+func TestDeviceService(t *testing.T) {
+	viper.Set("devices.offline_after_seconds", 30)
+	defer viper.Set("devices.offline_after_seconds", nil)
+
+	repository := newFakeDeviceRepository()
+	service := device.NewDeviceService(repository)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	cameraID := uuid.New()
+	heartbeat := models.DeviceHeartbeat{
+		Firmware:       "1.4.2",
+		ModelFile:      "drone-v3.onnx",
+		ModelVersion:   "v3",
+		IP:             "10.0.0.21",
+		Cameras:        []string{cameraID.String()},
+		CPUTemperature: 61.5,
+		Load:           1.2,
+		FPS:            14.8,
+		DiskUsed:       42,
+		Uptime:         3600,
+	}
+
+	tests := []Test{
+		{
+			TestName: "FirstHeartbeatRegistersDevice",
+			Func: func() error {
+				registered, cameOnline, err := service.RecordHeartbeat("pi-north", heartbeat, start)
+				if err != nil {
+					return err
+				}
+				if !cameOnline {
+					return fmt.Errorf("expected the first heartbeat to bring the device online")
+				}
+				if registered.HardwareID != "pi-north" || registered.Name != "pi-north" || registered.Firmware != "1.4.2" {
+					return fmt.Errorf("unexpected registered device %+v", registered)
+				}
+				if len(registered.Cameras) != 1 || registered.Cameras[0].ID != cameraID {
+					return fmt.Errorf("expected camera %s attached, got %+v", cameraID, registered.Cameras)
+				}
+				if registered.Status != models.DeviceOnline {
+					return fmt.Errorf("expected status online, got %s", registered.Status)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "NextHeartbeatKeepsDeviceOnline",
+			Func: func() error {
+				next := heartbeat
+				next.Cameras = nil
+				next.CPUTemperature = 78
+				registered, cameOnline, err := service.RecordHeartbeat("pi-north", next, start.Add(10*time.Second))
+				if err != nil {
+					return err
+				}
+				if cameOnline {
+					return fmt.Errorf("expected no online transition for a device already online")
+				}
+				if len(registered.Cameras) != 1 {
+					return fmt.Errorf("expected a heartbeat without cameras to keep the attached cameras, got %d", len(registered.Cameras))
+				}
+				if registered.Telemetry == nil || registered.Telemetry.CPUTemperature != 78 {
+					return fmt.Errorf("expected latest telemetry with cpu_temp 78, got %+v", registered.Telemetry)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "TelemetryHistoryIsKept",
+			Func: func() error {
+				registered, err := repository.GetDeviceByHardwareID("pi-north")
+				if err != nil {
+					return err
+				}
+				telemetry, _, err := service.GetTelemetry(registered.ID, time.Time{}, time.Time{}, models.Pagination{})
+				if err != nil {
+					return err
+				}
+				if len(telemetry) != 2 {
+					return fmt.Errorf("expected 2 telemetry records, got %d", len(telemetry))
+				}
+				if !telemetry[0].At.Equal(start) || telemetry[0].ModelVersion != "v3" {
+					return fmt.Errorf("expected telemetry at receive time with model version v3, got %+v", telemetry[0])
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "InvalidHeartbeatIsRejected",
+			Func: func() error {
+				invalid := []struct {
+					hardwareID string
+					heartbeat  models.DeviceHeartbeat
+				}{
+					{"", heartbeat},
+					{"pi/north", heartbeat},
+					{"pi-north", models.DeviceHeartbeat{CPUTemperature: math.NaN()}},
+					{"pi-north", models.DeviceHeartbeat{DiskUsed: 140}},
+					{"pi-north", models.DeviceHeartbeat{Uptime: -1}},
+					{"pi-north", models.DeviceHeartbeat{Cameras: []string{"camera-1"}}},
+				}
+				for _, c := range invalid {
+					if _, _, err := service.RecordHeartbeat(c.hardwareID, c.heartbeat, start); !errors.Is(err, device.ErrInvalidHeartbeat) {
+						return fmt.Errorf("expected %q %+v to be rejected, got %v", c.hardwareID, c.heartbeat, err)
+					}
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "SilentDeviceGoesOffline",
+			Func: func() error {
+				if offline := service.MarkOffline(start.Add(20 * time.Second)); len(offline) != 0 {
+					return fmt.Errorf("expected no device offline 10s after its heartbeat, got %d", len(offline))
+				}
+				offline := service.MarkOffline(start.Add(45 * time.Second))
+				if len(offline) != 1 || offline[0].HardwareID != "pi-north" || offline[0].Status != models.DeviceOffline {
+					return fmt.Errorf("expected pi-north offline, got %+v", offline)
+				}
+				if again := service.MarkOffline(start.Add(50 * time.Second)); len(again) != 0 {
+					return fmt.Errorf("expected the offline transition to be reported once, got %d", len(again))
+				}
+				if _, cameOnline, err := service.RecordHeartbeat("pi-north", heartbeat, start.Add(60*time.Second)); err != nil || !cameOnline {
+					return fmt.Errorf("expected the device back online with its next heartbeat, got %v %v", cameOnline, err)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "OldTelemetryIsPruned",
+			Func: func() error {
+				removed, err := service.PruneTelemetry(start.Add(7*24*time.Hour + 30*time.Second))
+				if err != nil {
+					return err
+				}
+				if removed != 2 {
+					return fmt.Errorf("expected the 2 telemetry records older than 7 days pruned, got %d", removed)
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			if err := test.Func(); err != nil {
+				t.Errorf("Test %s failed with error: %v", test.TestName, err)
+			}
+		})
+	}
+}
@@ -19,4 +19,5 @@ type CameraService interface {
 	UpdateCamera(id uuid.UUID, camera models.Camera) (*models.Camera, error)
 	DeleteCamera(id uuid.UUID) error
 	GetCamera(id uuid.UUID) (*models.Camera, error)
+	RotateCameraToken(id uuid.UUID) (*models.Camera, error)
 }
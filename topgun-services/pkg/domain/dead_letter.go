@@ -0,0 +1,12 @@
+package domain
+
+import "topgun-services/pkg/models"
+
+type DeadLetterRepository interface {
+	CreateDeadLetter(deadLetter models.DeadLetter) (*models.DeadLetter, error)
+	GetDeadLetters(pagination models.Pagination, status string) ([]models.DeadLetter, *models.Pagination, error)
+	GetDeadLetter(id uint) (*models.DeadLetter, error)
+	UpdateDeadLetter(id uint, deadLetter models.DeadLetter) (*models.DeadLetter, error)
+	ClaimDeadLetter(id uint) (bool, error)
+	DeleteDeadLetter(id uint) error
+}
@@ -0,0 +1,35 @@
+package domain
+
+import (
+	"time"
+
+	"topgun-services/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+type DeviceRepository interface {
+	GetDevices(pagination models.Pagination) ([]models.Device, *models.Pagination, error)
+	GetDevice(id uuid.UUID) (*models.Device, error)
+	GetDeviceByHardwareID(hardwareID string) (*models.Device, error)
+	CreateDevice(device models.Device) (*models.Device, error)
+	UpdateDevice(id uuid.UUID, device models.Device) (*models.Device, error)
+	SetDeviceCameras(id uuid.UUID, cameraIDs []uuid.UUID) error
+	DeleteDevice(id uuid.UUID) error
+	CreateTelemetry(telemetry models.DeviceTelemetry) (*models.DeviceTelemetry, error)
+	GetTelemetry(deviceID uuid.UUID, from, to time.Time, pagination models.Pagination) ([]models.DeviceTelemetry, *models.Pagination, error)
+	GetLatestTelemetry(deviceID uuid.UUID) (*models.DeviceTelemetry, error)
+	DeleteTelemetryBefore(before time.Time) (int64, error)
+}
+type DeviceService interface {
+	GetDevices(pagination models.Pagination) ([]models.Device, *models.Pagination, error)
+	GetDevice(id uuid.UUID) (*models.Device, error)
+	UpdateDevice(id uuid.UUID, device models.Device) (*models.Device, error)
+	DeleteDevice(id uuid.UUID) error
+	GetTelemetry(deviceID uuid.UUID, from, to time.Time, pagination models.Pagination) ([]models.DeviceTelemetry, *models.Pagination, error)
+	// RecordHeartbeat registers or updates the device, stores its telemetry and reports whether it came online
+	RecordHeartbeat(hardwareID string, heartbeat models.DeviceHeartbeat, receivedAt time.Time) (*models.Device, bool, error)
+	// MarkOffline returns the devices that went offline since the last call
+	MarkOffline(now time.Time) []models.Device
+	PruneTelemetry(now time.Time) (int64, error)
+}
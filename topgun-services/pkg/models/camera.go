@@ -11,13 +11,22 @@ type Camera struct {
 	ID        uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
 	Name      string    `json:"name"`
 	Location  string    `json:"location"`
-	Token     string    `json:"token"`
+	Token     string    `json:"-"` // MQTT broker password, only shown when issued
 	Institute string    `json:"institute"`
 	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime;default:CURRENT_TIMESTAMP" swaggerignore:"true"`
 	UpdatedAt time.Time `json:"updated_at" gorm:"autoUpdateTime;default:CURRENT_TIMESTAMP" swaggerignore:"true"`
 }
 
+// CameraWithToken is a camera with its MQTT broker token, returned only when the token is issued
+type CameraWithToken struct {
+	Camera
+	Token string `json:"token"`
+}
+
 func (u *Camera) BeforeCreate(tx *gorm.DB) error {
-	u.ID = uuid.New()
+	// Cameras registered from their own ID (e.g. an MQTT topic) keep it
+	if u.ID == uuid.Nil {
+		u.ID = uuid.New()
+	}
 	return nil
 }
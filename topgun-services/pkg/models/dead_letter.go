@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Dead letter states
+const (
+	DeadLetterPending      = "pending"      // waiting for inspection or reprocessing
+	DeadLetterReprocessing = "reprocessing" // claimed by a retry in progress
+	DeadLetterReprocessed  = "reprocessed"  // stored as a detection after a retry
+)
+
+// DeadLetter is an ingested message that could not be turned into a detection
+type DeadLetter struct {
+	ID            uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	Topic         string     `json:"topic" gorm:"index"`
+	SchemaVersion int        `json:"schema_version"` // 0 for messages without an envelope
+	DeviceID      string     `json:"device_id" gorm:"index"`
+	MessageID     string     `json:"message_id" gorm:"index"`
+	Payload       string     `json:"payload" gorm:"type:text"` // raw message as received
+	Stage         string     `json:"stage"`                    // decode, validate, camera or store
+	Reason        string     `json:"reason"`
+	Status        string     `json:"status" gorm:"index;default:pending"`
+	Attempts      int        `json:"attempts"`
+	DetectID      *uint      `json:"detect_id"` // detection created by a successful retry
+	ReprocessedAt *time.Time `json:"reprocessed_at"`
+	CreatedAt     time.Time  `json:"created_at" gorm:"autoCreateTime;default:CURRENT_TIMESTAMP" swaggerignore:"true"`
+	UpdatedAt     time.Time  `json:"updated_at" gorm:"autoUpdateTime;default:CURRENT_TIMESTAMP" swaggerignore:"true"`
+}
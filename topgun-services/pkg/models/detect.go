@@ -54,5 +54,10 @@ type Detect struct {
 	Timestamp time.Time           `json:"timestamp" gorm:"autoCreateTime;default:CURRENT_TIMESTAMP" swaggerignore:"true"`
 	Camera    Camera              `gorm:"foreignKey:CameraID;references:ID" json:"camera"`
 	Path      string              `json:"path"`
+	ClipPath  string              `json:"clip_path"`
 	Objects   JSONRawMessageArray `json:"objects" gorm:"type:jsonb" swaggerignore:"true"`
+	// Capture time of the saved frame minus the detection time, nil when no frame was matched
+	FrameOffsetMs *int64 `json:"frame_offset_ms"`
+	// No frame was captured within the match tolerance of the detection time
+	FrameMissing bool `json:"frame_missing"`
 }
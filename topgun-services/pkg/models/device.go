@@ -0,0 +1,69 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Device states
+const (
+	DeviceOnline  = "online"
+	DeviceOffline = "offline"
+)
+
+// Device is an edge node, e.g. a Raspberry PI, running detection for one or more cameras.
+// It registers itself with its first heartbeat.
+type Device struct {
+	ID              uuid.UUID        `gorm:"type:uuid;primaryKey" json:"id"`
+	HardwareID      string           `gorm:"uniqueIndex" json:"hardware_id"` // the device_id of its MQTT messages
+	Name            string           `json:"name"`
+	Firmware        string           `json:"firmware"`
+	ModelFile       string           `json:"model_file"`
+	ModelVersion    string           `json:"model_version"`
+	IP              string           `json:"ip"`
+	Cameras         []Camera         `gorm:"many2many:device_cameras" json:"cameras"`
+	LastHeartbeatAt *time.Time       `json:"last_heartbeat_at"`
+	Status          string           `gorm:"-" json:"status"`              // online or offline, from the last heartbeat
+	Telemetry       *DeviceTelemetry `gorm:"-" json:"telemetry,omitempty"` // latest telemetry
+	CreatedAt       time.Time        `json:"created_at" gorm:"autoCreateTime;default:CURRENT_TIMESTAMP" swaggerignore:"true"`
+	UpdatedAt       time.Time        `json:"updated_at" gorm:"autoUpdateTime;default:CURRENT_TIMESTAMP" swaggerignore:"true"`
+}
+
+func (d *Device) BeforeCreate(tx *gorm.DB) error {
+	if d.ID == uuid.Nil {
+		d.ID = uuid.New()
+	}
+	return nil
+}
+
+// DeviceTelemetry is the health of a device reported by one heartbeat
+type DeviceTelemetry struct {
+	ID             uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	DeviceID       uuid.UUID `gorm:"type:uuid;index:idx_device_telemetry_at" json:"device_id"`
+	At             time.Time `gorm:"index:idx_device_telemetry_at" json:"at"`
+	CPUTemperature float64   `json:"cpu_temp"`          // °C
+	Load           float64   `json:"load"`              // 1 minute load average
+	FPS            float64   `json:"fps"`               // detection frames per second
+	DiskUsed       float64   `json:"disk_used_percent"` // 0 to 100
+	Uptime         int64     `json:"uptime"`            // seconds
+	ModelVersion   string    `json:"model_version"`
+}
+
+// DeviceHeartbeat is the payload a device publishes on its heartbeat topic.
+// It is timestamped on receipt, edge devices often have no real time clock.
+type DeviceHeartbeat struct {
+	HardwareID     string   `json:"hardware_id"`
+	Name           string   `json:"name"`
+	Firmware       string   `json:"firmware"`
+	ModelFile      string   `json:"model_file"`
+	ModelVersion   string   `json:"model_version"`
+	IP             string   `json:"ip"`
+	Cameras        []string `json:"cameras"` // camera IDs attached to the device
+	CPUTemperature float64  `json:"cpu_temp"`
+	Load           float64  `json:"load"`
+	FPS            float64  `json:"fps"`
+	DiskUsed       float64  `json:"disk_used_percent"`
+	Uptime         int64    `json:"uptime"`
+}
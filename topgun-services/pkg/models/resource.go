@@ -2,8 +2,8 @@ package models
 
 import (
 	"crypto"
+	"topgun-services/pkg/mqtt"
 
-	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/session"
 	"github.com/gofiber/storage/redis"
@@ -20,7 +20,7 @@ type Resources struct {
 	RedisStorage   *redis.Storage
 	JwtResources   *JwtResources
 	SessConfig     session.Config
-	MQTTClient     mqtt.Client
+	MQTT           *mqtt.Manager // shared MQTT connection, nil only before the server is set up
 }
 
 type JwtResources struct {
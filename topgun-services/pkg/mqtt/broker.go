@@ -0,0 +1,196 @@
+package mqtt
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+
+	broker "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// Listener types of the embedded broker
+const (
+	ListenerTCP       = "tcp"
+	ListenerWebsocket = "ws"
+)
+
+// CameraPlaceholder in an ACL topic is replaced by the ID of the connected camera
+const CameraPlaceholder = "%c"
+
+// ListenerConfig is one address the embedded broker accepts clients on
+type ListenerConfig struct {
+	ID      string
+	Type    string // tcp or ws, tcp when empty
+	Address string
+	TLS     *tls.Config // nil for a plain listener
+}
+
+// CameraAuthenticator reports whether a camera ID and token belong to a registered camera
+type CameraAuthenticator func(cameraID, token string) bool
+
+// BrokerACL lists the topics a camera may use, CameraPlaceholder stands for its own ID
+type BrokerACL struct {
+	Publish   []string
+	Subscribe []string
+}
+
+// BrokerConfig configures the embedded broker
+type BrokerConfig struct {
+	Listeners []ListenerConfig
+	// The service connects with these credentials and may use every topic
+	ServiceUsername string
+	ServicePassword string
+	// Cameras connect with their ID as username and their token as password
+	Cameras CameraAuthenticator
+	ACL     BrokerACL
+	// Clients without credentials are accepted, limited to the ACL topics without CameraPlaceholder
+	AllowAnonymous bool
+}
+
+// Broker is an in-process MQTT broker
+type Broker struct {
+	server *broker.Server
+}
+
+// NewBroker starts an embedded broker listening on every configured listener
+func NewBroker(config BrokerConfig) (*Broker, error) {
+	if len(config.Listeners) == 0 {
+		return nil, fmt.Errorf("embedded MQTT broker has no listeners")
+	}
+	if config.ServiceUsername != "" && config.ServicePassword == "" {
+		return nil, fmt.Errorf("embedded MQTT broker service account %s has no password", config.ServiceUsername)
+	}
+
+	server := broker.New(&broker.Options{
+		Logger: slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})),
+	})
+	if err := server.AddHook(&brokerAuthHook{config: config}, nil); err != nil {
+		return nil, err
+	}
+	for i, listenerConfig := range config.Listeners {
+		id := listenerConfig.ID
+		if id == "" {
+			id = fmt.Sprintf("%s-%d", listenerConfig.Type, i)
+		}
+		lc := listeners.Config{ID: id, Address: listenerConfig.Address, TLSConfig: listenerConfig.TLS}
+
+		var listener listeners.Listener
+		switch listenerConfig.Type {
+		case ListenerTCP, "":
+			listener = listeners.NewTCP(lc)
+		case ListenerWebsocket:
+			listener = listeners.NewWebsocket(lc)
+		default:
+			return nil, fmt.Errorf("unknown MQTT listener type %q of listener %s", listenerConfig.Type, id)
+		}
+		if err := server.AddListener(listener); err != nil {
+			server.Close()
+			return nil, fmt.Errorf("failed to listen on %s: %w", listenerConfig.Address, err)
+		}
+		log.Printf("Embedded MQTT broker listening on %s (%s)", listener.Address(), listener.Protocol())
+	}
+
+	if err := server.Serve(); err != nil {
+		server.Close()
+		return nil, err
+	}
+	return &Broker{server: server}, nil
+}
+
+// ServerTLSConfig loads the certificate of a TLS listener. Clients must present a certificate
+// signed by the client CA when clientCAFile is set.
+func ServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load MQTT listener certificate: %w", err)
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{certificate},
+	}
+	if clientCAFile != "" {
+		caPEM, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read MQTT client CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in MQTT client CA file %s", clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// Close disconnects every client and stops the listeners
+func (b *Broker) Close() error {
+	return b.server.Close()
+}
+
+// brokerAuthHook authenticates the service and cameras and limits cameras to their ACL topics
+type brokerAuthHook struct {
+	broker.HookBase
+	config BrokerConfig
+}
+
+func (h *brokerAuthHook) ID() string {
+	return "topgun-auth"
+}
+
+func (h *brokerAuthHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{broker.OnConnectAuthenticate, broker.OnACLCheck}, []byte{b})
+}
+
+func (h *brokerAuthHook) OnConnectAuthenticate(cl *broker.Client, pk packets.Packet) bool {
+	username := string(pk.Connect.Username)
+	password := pk.Connect.Password
+	switch {
+	case h.isService(username):
+		return subtle.ConstantTimeCompare(password, []byte(h.config.ServicePassword)) == 1
+	case username == "":
+		return h.config.AllowAnonymous
+	case h.config.Cameras != nil:
+		if h.config.Cameras(username, string(password)) {
+			return true
+		}
+	}
+	log.Printf("Embedded MQTT broker rejected client %s with username %q", cl.ID, username)
+	return false
+}
+
+func (h *brokerAuthHook) OnACLCheck(cl *broker.Client, topic string, write bool) bool {
+	username := string(cl.Properties.Username)
+	if h.isService(username) {
+		return true
+	}
+
+	filters := h.config.ACL.Subscribe
+	if write {
+		filters = h.config.ACL.Publish
+	}
+	for _, filter := range filters {
+		if username == "" && strings.Contains(filter, CameraPlaceholder) {
+			continue
+		}
+		filter = strings.ReplaceAll(filter, CameraPlaceholder, username)
+		// A subscription must stay within an allowed filter, it is compared as a topic
+		if TopicMatches(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// isService reports whether the username is the service account
+func (h *brokerAuthHook) isService(username string) bool {
+	return h.config.ServiceUsername != "" && username == h.config.ServiceUsername
+}
@@ -0,0 +1,190 @@
+package mqtt_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"topgun-services/pkg/mqtt"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// connectClient connects a plain client to the broker as a camera or anonymous client would
+func connectClient(address, clientID, username, password string) (paho.Client, error) {
+	opts := paho.NewClientOptions()
+	opts.AddBroker("tcp://" + address)
+	opts.SetClientID(clientID)
+	opts.SetUsername(username)
+	opts.SetPassword(password)
+	opts.SetAutoReconnect(false)
+	client := paho.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(5 * time.Second) {
+		return nil, fmt.Errorf("timed out connecting %s", clientID)
+	}
+	return client, token.Error()
+}
+
+// subscribeResult subscribes and returns the granted QoS, 0x80 when the broker refused
+func subscribeResult(client paho.Client, topic string) (byte, error) {
+	token := client.Subscribe(topic, 1, func(paho.Client, paho.Message) {})
+	if !token.WaitTimeout(5 * time.Second) {
+		return 0, fmt.Errorf("timed out subscribing to %s", topic)
+	}
+	if err := token.Error(); err != nil {
+		return 0, err
+	}
+	return token.(*paho.SubscribeToken).Result()[topic], nil
+}
+
+func TestEmbeddedBroker(t *testing.T) {
+	address, err := freeAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cameras := map[string]string{"cam-1": "token-1", "cam-2": "token-2"}
+	broker, err := mqtt.NewBroker(mqtt.BrokerConfig{
+		Listeners:       []mqtt.ListenerConfig{{ID: "tcp", Type: mqtt.ListenerTCP, Address: address}},
+		ServiceUsername: "topgun-services",
+		ServicePassword: "service-secret",
+		Cameras: func(cameraID, token string) bool {
+			return token != "" && cameras[cameraID] == token
+		},
+		ACL: mqtt.BrokerACL{
+			Publish:   []string{"topgun/ai/" + mqtt.CameraPlaceholder, "topgun/public"},
+			Subscribe: []string{"topgun/command", "topgun/command/" + mqtt.CameraPlaceholder},
+		},
+		AllowAnonymous: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+
+	service, err := mqtt.NewManager(mqtt.ConnectionConfig{
+		Broker:   "tcp://" + address,
+		ClientID: "service",
+		Username: "topgun-services",
+		Password: "service-secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Connect(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	defer service.Disconnect()
+
+	received := make(chan string, 8)
+	if err := service.Handle("topgun/#", 1, func(client paho.Client, msg paho.Message) {
+		received <- msg.Topic()
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// expectOnly waits for a topic and checks nothing else arrived before it
+	expectOnly := func(topic string) error {
+		select {
+		case got := <-received:
+			if got != topic {
+				return fmt.Errorf("expected a message on %s, got %s", topic, got)
+			}
+			return nil
+		case <-time.After(5 * time.Second):
+			return fmt.Errorf("no message received on %s", topic)
+		}
+	}
+
+	tests := []Test{
+		{
+			TestName: "RejectsWrongCameraToken",
+			Func: func() error {
+				if client, err := connectClient(address, "cam-1-bad", "cam-1", "token-2"); err == nil {
+					client.Disconnect(0)
+					return fmt.Errorf("expected a wrong camera token to be rejected")
+				}
+				if client, err := connectClient(address, "service-bad", "topgun-services", "guess"); err == nil {
+					client.Disconnect(0)
+					return fmt.Errorf("expected a wrong service password to be rejected")
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "CameraPublishesOnlyToItsTopic",
+			Func: func() error {
+				camera, err := connectClient(address, "cam-1", "cam-1", "token-1")
+				if err != nil {
+					return err
+				}
+				defer camera.Disconnect(0)
+
+				// Denied QoS 0 publishes are dropped, the allowed one after them must arrive first
+				camera.Publish("topgun/ai/cam-2", 0, false, "{}").WaitTimeout(5 * time.Second)
+				camera.Publish("topgun/command", 0, false, "{}").WaitTimeout(5 * time.Second)
+				camera.Publish("topgun/ai/cam-1", 1, false, "{}").WaitTimeout(5 * time.Second)
+				if err := expectOnly("topgun/ai/cam-1"); err != nil {
+					return err
+				}
+
+				// An MQTT 3.1.1 client has no way to learn a QoS 1 publish was denied, it is disconnected
+				camera.Publish("topgun/ai/cam-2", 1, false, "{}")
+				for deadline := time.Now().Add(5 * time.Second); camera.IsConnectionOpen(); {
+					if time.Now().After(deadline) {
+						return fmt.Errorf("expected the camera to be disconnected after a denied QoS 1 publish")
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "CameraSubscribesOnlyToCommands",
+			Func: func() error {
+				camera, err := connectClient(address, "cam-2", "cam-2", "token-2")
+				if err != nil {
+					return err
+				}
+				defer camera.Disconnect(0)
+
+				for topic, allowed := range map[string]bool{
+					"topgun/command":       true,
+					"topgun/command/cam-2": true,
+					"topgun/command/cam-1": false,
+					"topgun/#":             false,
+				} {
+					qos, err := subscribeResult(camera, topic)
+					if err != nil {
+						return err
+					}
+					if (qos != 0x80) != allowed {
+						return fmt.Errorf("subscription to %s allowed: %v, expected %v", topic, qos != 0x80, allowed)
+					}
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "AnonymousClientUsesSharedTopicsOnly",
+			Func: func() error {
+				anonymous, err := connectClient(address, "anonymous", "", "")
+				if err != nil {
+					return err
+				}
+				defer anonymous.Disconnect(0)
+
+				anonymous.Publish("topgun/ai/", 0, false, "{}").WaitTimeout(5 * time.Second)
+				anonymous.Publish("topgun/public", 1, false, "{}").WaitTimeout(5 * time.Second)
+				return expectOnly("topgun/public")
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			if err := test.Func(); err != nil {
+				t.Errorf("Test %s failed with error: %v", test.TestName, err)
+			}
+		})
+	}
+}
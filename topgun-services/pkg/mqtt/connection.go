@@ -0,0 +1,103 @@
+package mqtt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// ConnectionConfig describes how the service connects to the broker
+type ConnectionConfig struct {
+	Broker   string
+	ClientID string
+	Username string
+	Password string // a token for token based brokers
+	TLS      TLSConfig
+	Will     WillConfig
+}
+
+// TLSConfig selects the certificates of a TLS connection
+type TLSConfig struct {
+	Enabled            bool
+	CAFile             string // CA that signed the broker certificate, the system pool when empty
+	CertFile           string // client certificate for mutual TLS
+	KeyFile            string
+	ServerName         string // overrides the host name the broker certificate is checked against
+	InsecureSkipVerify bool
+}
+
+// WillConfig is where the service announces whether it is connected.
+// The broker publishes the offline status as the last will when the service drops without disconnecting.
+type WillConfig struct {
+	Topic    string // empty disables status messages
+	QoS      byte
+	Retained bool
+}
+
+// Service states published to the will topic
+const (
+	StatusOnline  = "online"
+	StatusOffline = "offline"
+)
+
+// ServiceStatus is the payload published to the will topic
+type ServiceStatus struct {
+	Status   string    `json:"status"`
+	ClientID string    `json:"client_id"`
+	At       time.Time `json:"at"`
+}
+
+// Load builds the TLS client configuration, nil when TLS is disabled
+func (c TLSConfig) Load() (*tls.Config, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" {
+		caPEM, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read MQTT CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in MQTT CA file %s", c.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, fmt.Errorf("MQTT client certificate and key must be set together")
+		}
+		certificate, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load MQTT client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+// tlsBroker switches a plain broker URL to its TLS scheme, the client only uses TLS for TLS schemes
+func tlsBroker(broker string) string {
+	for plain, secure := range map[string]string{"tcp://": "ssl://", "mqtt://": "mqtts://", "ws://": "wss://"} {
+		if strings.HasPrefix(broker, plain) {
+			return secure + strings.TrimPrefix(broker, plain)
+		}
+	}
+	return broker
+}
+
+// statusPayload encodes a service status message
+func statusPayload(status, clientID string) []byte {
+	payload, _ := json.Marshal(ServiceStatus{Status: status, ClientID: clientID, At: time.Now()})
+	return payload
+}
@@ -7,20 +7,70 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// File sending modes
+const (
+	FileModeAuto    = "auto"     // one message up to the one-shot limit, chunked above it
+	FileModeOneShot = "one_shot" // the whole file in one message
+	FileModeChunked = "chunked"  // a checksummed chunked transfer
 )
 
 type Handler struct {
-	service *Service
+	service   *Service
+	manager   *Manager
+	transfers *Transfers // chunked transfers, nil disables them
 }
 
-func NewHandler(service *Service) *Handler {
+func NewHandler(service *Service, manager *Manager, transfers *Transfers) *Handler {
 	return &Handler{
-		service: service,
+		service:   service,
+		manager:   manager,
+		transfers: transfers,
+	}
+}
+
+// sendMode decides whether a file of size bytes is sent as a chunked transfer,
+// with the status to answer when the requested mode cannot be used
+func (h *Handler) sendMode(mode string, size int64) (bool, int, error) {
+	limit := int64(defaultOneShotMax)
+	if h.transfers != nil {
+		limit = h.transfers.OneShotMax()
+	}
+	switch mode {
+	case "", FileModeAuto:
+		return h.transfers != nil && size > limit, 0, nil
+	case FileModeOneShot:
+		if size > limit {
+			return false, fiber.StatusRequestEntityTooLarge, fmt.Errorf("file of %d bytes exceeds the one-shot limit of %d bytes, use mode chunked", size, limit)
+		}
+		return false, 0, nil
+	case FileModeChunked:
+		if h.transfers == nil {
+			return false, fiber.StatusServiceUnavailable, fmt.Errorf("chunked transfers are not available")
+		}
+		return true, 0, nil
+	default:
+		return false, fiber.StatusBadRequest, fmt.Errorf("unknown mode %q, use auto, one_shot or chunked", mode)
 	}
 }
 
+// splitReceivers parses a comma separated list of receiver IDs
+func splitReceivers(value string) []string {
+	var receivers []string
+	for _, receiver := range strings.Split(value, ",") {
+		if receiver = strings.TrimSpace(receiver); receiver != "" {
+			receivers = append(receivers, receiver)
+		}
+	}
+	return receivers
+}
+
 // PublishMessage handles POST requests to publish messages to MQTT
 // @Summary Publish message to MQTT
 // @Description Publish a message to the configured MQTT topic (topgun/ai)
@@ -97,15 +147,18 @@ func (h *Handler) PublishJSON(c *fiber.Ctx) error {
 
 // GetStatus handles GET requests to check MQTT connection status
 // @Summary Get MQTT connection status
-// @Description Check if the MQTT client is connected to the broker
+// @Description Check if the MQTT client is connected to the broker and list every topic subscription with its state
 // @Tags MQTT
 // @Produce json
 // @Success 200 {object} map[string]interface{}
 // @Router /api/v1/mqtt/status [get]
 func (h *Handler) GetStatus(c *fiber.Ctx) error {
+	status := h.manager.Status()
 	return c.JSON(fiber.Map{
-		"connected": h.service.IsConnected(),
-		"topic":     h.service.GetTopic(),
+		"connected":     status.Connected,
+		"broker":        status.Broker,
+		"topic":         h.service.GetTopic(),
+		"subscriptions": status.Subscriptions,
 	})
 }
 
@@ -115,13 +168,18 @@ type PublishRequest struct {
 
 // UploadFile handles file upload and publishes it via MQTT
 // @Summary Upload and send file via MQTT
-// @Description Upload a file (e.g., .pt model file) and send it through MQTT topic
+// @Description Upload a file (e.g., .pt model file) and send it through MQTT topic.
+// @Description Files above the one-shot limit are sent as a chunked transfer, answered with 202 and the transfer progress.
 // @Tags MQTT
 // @Accept multipart/form-data
 // @Produce json
 // @Param file formData file true "File to upload and send (.pt, .pth, etc.)"
-// @Param encode_base64 formData boolean false "Encode file as base64 (default: true)"
+// @Param encode_base64 formData boolean false "Encode file as base64 (default: true), one-shot mode only"
+// @Param mode formData string false "auto (default), one_shot or chunked"
+// @Param receivers formData string false "Comma separated receiver IDs expected to confirm a chunked transfer"
+// @Param chunk_size formData int false "Bytes per chunk of a chunked transfer"
 // @Success 200 {object} map[string]interface{}
+// @Success 202 {object} map[string]interface{}
 // @Failure 400 {object} map[string]interface{}
 // @Failure 500 {object} map[string]interface{}
 // @Router /api/v1/mqtt/upload-file [post]
@@ -134,6 +192,44 @@ func (h *Handler) UploadFile(c *fiber.Ctx) error {
 		})
 	}
 
+	chunked, status, err := h.sendMode(c.FormValue("mode"), file.Size)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if chunked {
+		// Keep the upload on disk, the transfer reads it chunk by chunk
+		if err := os.MkdirAll(h.transfers.Dir(), 0755); err != nil {
+			log.Printf("Error creating transfer directory: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to store file",
+			})
+		}
+		path := filepath.Join(h.transfers.Dir(), uuid.New().String()+filepath.Ext(file.Filename))
+		if err := c.SaveFile(file, path); err != nil {
+			log.Printf("Error saving file: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to store file",
+			})
+		}
+		chunkSize, _ := strconv.Atoi(c.FormValue("chunk_size"))
+		progress, err := h.transfers.Send(path, file.Filename, splitReceivers(c.FormValue("receivers")), chunkSize, true)
+		if err != nil {
+			os.Remove(path)
+			log.Printf("Error starting transfer: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error":   "Failed to start transfer",
+				"details": err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+			"success":  true,
+			"message":  "Chunked transfer started",
+			"transfer": progress,
+		})
+	}
+
 	// Check if we should encode as base64 (default: true)
 	encodeBase64 := c.FormValue("encode_base64", "true") == "true"
 
@@ -218,12 +314,14 @@ func (h *Handler) UploadFile(c *fiber.Ctx) error {
 
 // SendFileByPath handles sending a file from server filesystem via MQTT
 // @Summary Send file from server path via MQTT
-// @Description Send a file from the server filesystem through MQTT topic
+// @Description Send a file from the server filesystem through MQTT topic.
+// @Description Files above the one-shot limit are sent as a chunked transfer, answered with 202 and the transfer progress.
 // @Tags MQTT
 // @Accept json
 // @Produce json
 // @Param request body FilePathRequest true "File path on server"
 // @Success 200 {object} map[string]interface{}
+// @Success 202 {object} map[string]interface{}
 // @Failure 400 {object} map[string]interface{}
 // @Failure 500 {object} map[string]interface{}
 // @Router /api/v1/mqtt/send-file [post]
@@ -241,6 +339,36 @@ func (h *Handler) SendFileByPath(c *fiber.Ctx) error {
 		})
 	}
 
+	info, err := os.Stat(req.FilePath)
+	if err != nil {
+		log.Printf("Error reading file from path: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   fmt.Sprintf("Failed to read file: %s", req.FilePath),
+			"details": err.Error(),
+		})
+	}
+	chunked, status, err := h.sendMode(req.Mode, info.Size())
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if chunked {
+		progress, err := h.transfers.Send(req.FilePath, filepath.Base(req.FilePath), req.Receivers, req.ChunkSize, false)
+		if err != nil {
+			log.Printf("Error starting transfer: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error":   "Failed to start transfer",
+				"details": err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+			"success":  true,
+			"message":  "Chunked transfer started",
+			"transfer": progress,
+		})
+	}
+
 	// Read file from server
 	fileBytes, err := os.ReadFile(req.FilePath)
 	if err != nil {
@@ -310,8 +438,48 @@ func (h *Handler) SendFileByPath(c *fiber.Ctx) error {
 }
 
 type FilePathRequest struct {
-	FilePath     string `json:"file_path" example:"./models/best.pt"`
-	EncodeBase64 bool   `json:"encode_base64" example:"true"`
+	FilePath     string   `json:"file_path" example:"./models/best.pt"`
+	EncodeBase64 bool     `json:"encode_base64" example:"true"`
+	Mode         string   `json:"mode" example:"auto"`          // auto, one_shot or chunked
+	Receivers    []string `json:"receivers" example:"pi-north"` // expected to confirm a chunked transfer
+	ChunkSize    int      `json:"chunk_size" example:"65536"`   // bytes per chunk of a chunked transfer
+}
+
+// ListTransfers handles GET requests for chunked transfers
+// @Summary List chunked file transfers
+// @Description List the progress of recent chunked file transfers, newest first
+// @Tags MQTT
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/mqtt/transfers [get]
+func (h *Handler) ListTransfers(c *fiber.Ctx) error {
+	transfers := []TransferProgress{}
+	if h.transfers != nil {
+		transfers = h.transfers.List()
+	}
+	return c.JSON(fiber.Map{
+		"transfers": transfers,
+	})
+}
+
+// GetTransfer handles GET requests for the progress of one chunked transfer
+// @Summary Get chunked file transfer progress
+// @Description Get the chunks sent and resent and the progress acknowledged by each receiver of a transfer
+// @Tags MQTT
+// @Produce json
+// @Param id path string true "Transfer ID"
+// @Success 200 {object} TransferProgress
+// @Failure 404 {object} map[string]interface{}
+// @Router /api/v1/mqtt/transfers/{id} [get]
+func (h *Handler) GetTransfer(c *fiber.Ctx) error {
+	if h.transfers != nil {
+		if progress, ok := h.transfers.Get(c.Params("id")); ok {
+			return c.JSON(progress)
+		}
+	}
+	return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+		"error": "Transfer not found",
+	})
 }
 
 // SetupRoutes sets up the MQTT routes
@@ -321,4 +489,6 @@ func SetupRoutes(group fiber.Router, handler *Handler) {
 	group.Post("/publish-json", handler.PublishJSON)
 	group.Post("/upload-file", handler.UploadFile)
 	group.Post("/send-file", handler.SendFileByPath)
+	group.Get("/transfers", handler.ListTransfers)
+	group.Get("/transfers/:id", handler.GetTransfer)
 }
@@ -0,0 +1,290 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// JournalEntry is one raw MQTT message as received.
+// JSON payloads are kept readable in Payload, anything else is base64 encoded in PayloadBase64.
+type JournalEntry struct {
+	At            time.Time       `json:"at"`
+	Topic         string          `json:"topic"`
+	QoS           byte            `json:"qos"`
+	Retained      bool            `json:"retained,omitempty"`
+	Payload       json.RawMessage `json:"payload,omitempty"`
+	PayloadBase64 []byte          `json:"payload_base64,omitempty"`
+}
+
+// NewJournalEntry records a received message
+func NewJournalEntry(at time.Time, topic string, qos byte, retained bool, payload []byte) JournalEntry {
+	entry := JournalEntry{At: at, Topic: topic, QoS: qos, Retained: retained}
+	if json.Valid(payload) {
+		entry.Payload = append(json.RawMessage(nil), payload...)
+	} else {
+		entry.PayloadBase64 = append([]byte(nil), payload...)
+	}
+	return entry
+}
+
+// Bytes returns the raw payload of the message
+func (e JournalEntry) Bytes() []byte {
+	if e.Payload != nil {
+		return e.Payload
+	}
+	return e.PayloadBase64
+}
+
+// JournalRecord is a journal entry stored in the log database
+type JournalRecord struct {
+	ID       uint      `json:"id" gorm:"primaryKey"`
+	At       time.Time `json:"at" gorm:"index"`
+	Topic    string    `json:"topic" gorm:"index"`
+	QoS      byte      `json:"qos"`
+	Retained bool      `json:"retained"`
+	Payload  []byte    `json:"payload"`
+}
+
+func (JournalRecord) TableName() string {
+	return "mqtt_journal"
+}
+
+// JournalStore persists batches of journal entries
+type JournalStore interface {
+	Write(entries []JournalEntry) error
+}
+
+// FileJournalStore appends entries as JSON lines to one file per day, mqtt-2006-01-02.jsonl
+type FileJournalStore struct {
+	Dir string
+}
+
+func (s FileJournalStore) Write(entries []JournalEntry) error {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+
+	var file *os.File
+	var fileDay string
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+	for _, entry := range entries {
+		// Entries are filed by the day they were received
+		day := entry.At.Format("2006-01-02")
+		if day != fileDay {
+			if file != nil {
+				file.Close()
+			}
+			var err error
+			file, err = os.OpenFile(filepath.Join(s.Dir, fmt.Sprintf("mqtt-%s.jsonl", day)), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0664)
+			if err != nil {
+				file = nil
+				return err
+			}
+			fileDay = day
+		}
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if _, err := file.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DBJournalStore inserts entries into the mqtt_journal table
+type DBJournalStore struct {
+	DB *gorm.DB
+}
+
+func (s DBJournalStore) Write(entries []JournalEntry) error {
+	if s.DB == nil {
+		return gorm.ErrInvalidDB
+	}
+	records := make([]JournalRecord, len(entries))
+	for i, entry := range entries {
+		records[i] = JournalRecord{At: entry.At, Topic: entry.Topic, QoS: entry.QoS, Retained: entry.Retained, Payload: entry.Bytes()}
+	}
+	return s.DB.Create(&records).Error
+}
+
+// Journal records received messages in the background, messages are dropped when the store falls behind
+type Journal struct {
+	store  JournalStore
+	topics []string
+	queue  chan JournalEntry
+	stop   chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+// Entries written to the store at most at once, and how often a partial batch is flushed
+const (
+	journalBatchSize  = 200
+	journalFlushEvery = time.Second
+)
+
+// NewJournal starts a journal writing to store. Only messages on topics matching one of the
+// topic filters are recorded, every message when topics is empty.
+func NewJournal(store JournalStore, topics []string) *Journal {
+	j := &Journal{
+		store:  store,
+		topics: topics,
+		queue:  make(chan JournalEntry, 5000),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go j.run()
+	return j
+}
+
+// Record queues a received message, it never blocks the caller
+func (j *Journal) Record(topic string, qos byte, retained bool, payload []byte) {
+	if len(j.topics) > 0 {
+		matched := false
+		for _, filter := range j.topics {
+			if TopicMatches(filter, topic) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return
+		}
+	}
+
+	select {
+	case j.queue <- NewJournalEntry(time.Now(), topic, qos, retained, payload):
+	default:
+		log.Printf("MQTT journal queue full, dropping message on topic %s", topic)
+	}
+}
+
+// Close writes the queued messages and stops the journal
+func (j *Journal) Close() {
+	j.once.Do(func() { close(j.stop) })
+	<-j.done
+}
+
+func (j *Journal) run() {
+	defer close(j.done)
+	batch := make([]JournalEntry, 0, journalBatchSize)
+	ticker := time.NewTicker(journalFlushEvery)
+	defer ticker.Stop()
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := j.store.Write(batch); err != nil {
+			log.Printf("Failed to write %d MQTT journal entries: %v", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case entry := <-j.queue:
+			batch = append(batch, entry)
+			if len(batch) >= journalBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-j.stop:
+			for {
+				select {
+				case entry := <-j.queue:
+					batch = append(batch, entry)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// ReadJournalFile reads the entries of a journal file in the order they were recorded
+func ReadJournalFile(path string) ([]JournalEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(file)
+	// Payloads can carry whole frames
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// ReadJournalDB reads the entries received between from and to, on topics matching filter when set
+func ReadJournalDB(db *gorm.DB, from, to time.Time, filter string) ([]JournalEntry, error) {
+	if db == nil {
+		return nil, gorm.ErrInvalidDB
+	}
+	query := db.Model(&JournalRecord{}).Order("at ASC, id ASC")
+	if !from.IsZero() {
+		query = query.Where("at >= ?", from)
+	}
+	if !to.IsZero() {
+		query = query.Where("at <= ?", to)
+	}
+
+	var records []JournalRecord
+	if err := query.Find(&records).Error; err != nil {
+		return nil, err
+	}
+	entries := make([]JournalEntry, 0, len(records))
+	for _, record := range records {
+		if filter != "" && !TopicMatches(filter, record.Topic) {
+			continue
+		}
+		entries = append(entries, NewJournalEntry(record.At, record.Topic, record.QoS, record.Retained, record.Payload))
+	}
+	return entries, nil
+}
+
+// TopicMatches reports whether a topic matches a subscription filter with + and # wildcards
+func TopicMatches(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
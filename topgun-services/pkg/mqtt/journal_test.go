@@ -0,0 +1,187 @@
+package mqtt_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"topgun-services/pkg/mqtt"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+func TestJournal(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Date(2025, 1, 31, 10, 0, 0, 0, time.Local)
+
+	tests := []Test{
+		{
+			TestName: "MatchesTopicFilters",
+			Func: func() error {
+				cases := []struct {
+					filter, topic string
+					match         bool
+				}{
+					{"topgun/ai/+", "topgun/ai/cam-1", true},
+					{"topgun/ai/+", "topgun/ai", false},
+					{"topgun/ai/+", "topgun/ai/cam-1/extra", false},
+					{"topgun/#", "topgun/ai/cam-1", true},
+					{"topgun/#", "topgun", true},
+					{"topgun/ai", "topgun/command", false},
+				}
+				for _, c := range cases {
+					if mqtt.TopicMatches(c.filter, c.topic) != c.match {
+						return fmt.Errorf("filter %s on topic %s should match: %v", c.filter, c.topic, c.match)
+					}
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "FindsWildcardLevel",
+			Func: func() error {
+				cases := map[string]int{
+					"topgun/ai/+":                2,
+					"topgun/devices/+/heartbeat": 2,
+					"topgun/#":                   1,
+					"topgun/ai":                  -1,
+				}
+				for filter, level := range cases {
+					if got := mqtt.WildcardLevel(filter); got != level {
+						return fmt.Errorf("expected wildcard level %d of filter %s, got %d", level, filter, got)
+					}
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "WritesAndReadsFileJournal",
+			Func: func() error {
+				binary := []byte{0xff, 0xd8, 0x00, 0x01}
+				entries := []mqtt.JournalEntry{
+					mqtt.NewJournalEntry(at, "topgun/ai/cam-1", 1, false, []byte(`{"track_id":1}`)),
+					mqtt.NewJournalEntry(at.Add(time.Second), "topgun/frames", 0, true, binary),
+				}
+				if err := (mqtt.FileJournalStore{Dir: dir}).Write(entries); err != nil {
+					return err
+				}
+
+				path := filepath.Join(dir, "mqtt-2025-01-31.jsonl")
+				raw, err := os.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				if !bytes.Contains(raw, []byte(`"payload":{"track_id":1}`)) {
+					return fmt.Errorf("JSON payload is not readable in the journal: %s", raw)
+				}
+
+				read, err := mqtt.ReadJournalFile(path)
+				if err != nil {
+					return err
+				}
+				if len(read) != 2 {
+					return fmt.Errorf("expected 2 entries, got %d", len(read))
+				}
+				if read[0].Topic != "topgun/ai/cam-1" || string(read[0].Bytes()) != `{"track_id":1}` {
+					return fmt.Errorf("unexpected first entry %+v", read[0])
+				}
+				if !bytes.Equal(read[1].Bytes(), binary) || !read[1].Retained {
+					return fmt.Errorf("binary payload was not kept, got %v", read[1].Bytes())
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "RecordsOnlyJournaledTopics",
+			Func: func() error {
+				journalDir := filepath.Join(dir, "filtered")
+				journal := mqtt.NewJournal(mqtt.FileJournalStore{Dir: journalDir}, []string{"topgun/ai/+"})
+				journal.Record("topgun/ai/cam-1", 1, false, []byte(`{}`))
+				journal.Record("topgun/command", 1, false, []byte(`{}`))
+				journal.Close()
+
+				files, err := filepath.Glob(filepath.Join(journalDir, "*.jsonl"))
+				if err != nil || len(files) != 1 {
+					return fmt.Errorf("expected one journal file, got %v %v", files, err)
+				}
+				read, err := mqtt.ReadJournalFile(files[0])
+				if err != nil {
+					return err
+				}
+				if len(read) != 1 || read[0].Topic != "topgun/ai/cam-1" {
+					return fmt.Errorf("expected only the detection topic to be journaled, got %+v", read)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "ReplaysIntoHandlers",
+			Func: func() error {
+				manager, err := mqtt.NewManager(mqtt.ConnectionConfig{Broker: "tcp://127.0.0.1:1", ClientID: "replay"})
+				if err != nil {
+					return err
+				}
+				var received []string
+				err = manager.Handle("topgun/ai/+", 1, func(client paho.Client, msg paho.Message) {
+					received = append(received, msg.Topic()+" "+string(msg.Payload()))
+				})
+				if err != nil {
+					return err
+				}
+
+				entries := []mqtt.JournalEntry{
+					mqtt.NewJournalEntry(at, "topgun/ai/cam-1", 1, false, []byte(`{"n":1}`)),
+					mqtt.NewJournalEntry(at.Add(time.Second), "topgun/command", 1, false, []byte(`{"n":2}`)),
+					mqtt.NewJournalEntry(at.Add(2*time.Second), "topgun/ai/cam-2", 1, false, []byte(`{"n":3}`)),
+				}
+				replayed, err := mqtt.Replay(context.Background(), entries, 0, func(entry mqtt.JournalEntry) error {
+					manager.Deliver(entry)
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+				if replayed != 3 || len(received) != 2 || received[1] != `topgun/ai/cam-2 {"n":3}` {
+					return fmt.Errorf("unexpected replay of %d messages, handled %v", replayed, received)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "ReplaysAtAcceleratedPace",
+			Func: func() error {
+				entries := []mqtt.JournalEntry{
+					mqtt.NewJournalEntry(at, "a", 0, false, nil),
+					mqtt.NewJournalEntry(at.Add(time.Second), "a", 0, false, nil),
+				}
+				start := time.Now()
+				if _, err := mqtt.Replay(context.Background(), entries, 10, func(mqtt.JournalEntry) error { return nil }); err != nil {
+					return err
+				}
+				// One second of recording at ten times the speed
+				if elapsed := time.Since(start); elapsed < 90*time.Millisecond || elapsed > 500*time.Millisecond {
+					return fmt.Errorf("expected the replay to take about 100ms, took %s", elapsed)
+				}
+
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				if replayed, err := mqtt.Replay(ctx, entries, 1, func(mqtt.JournalEntry) error { return nil }); err == nil || replayed != 0 {
+					return fmt.Errorf("expected a cancelled replay to deliver nothing, replayed %d: %v", replayed, err)
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			if err := test.Func(); err != nil {
+				t.Errorf("Test %s failed with error: %v", test.TestName, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package mqtt
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Manager owns the single MQTT connection of the service.
+// Packages register topic handlers with Handle, the manager subscribes them
+// and subscribes again every time the connection is re-established.
+type Manager struct {
+	client  mqtt.Client
+	broker  string
+	config  ConnectionConfig
+	journal *Journal // records received messages when set
+
+	mutex         sync.RWMutex
+	subscriptions map[string]*subscription
+}
+
+// SubscriptionStatus describes one registered topic handler
+type SubscriptionStatus struct {
+	Topic         string     `json:"topic"`
+	QoS           byte       `json:"qos"`
+	Subscribed    bool       `json:"subscribed"`
+	SubscribedAt  *time.Time `json:"subscribed_at,omitempty"`
+	Messages      uint64     `json:"messages"`
+	LastMessageAt *time.Time `json:"last_message_at,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+}
+
+// ManagerStatus describes the connection and every registered subscription
+type ManagerStatus struct {
+	Broker        string               `json:"broker"`
+	Connected     bool                 `json:"connected"`
+	Subscriptions []SubscriptionStatus `json:"subscriptions"`
+}
+
+// subscription is a registered topic handler and its state, guarded by the manager mutex
+type subscription struct {
+	handler mqtt.MessageHandler
+	status  SubscriptionStatus
+}
+
+// Time to wait for a single subscribe or unsubscribe acknowledgement
+const subscribeTimeout = 10 * time.Second
+
+// NewManager creates the MQTT connection manager, Connect starts the connection
+func NewManager(config ConnectionConfig) (*Manager, error) {
+	tlsConfig, err := config.TLS.Load()
+	if err != nil {
+		return nil, err
+	}
+	broker := config.Broker
+	if tlsConfig != nil {
+		broker = tlsBroker(broker)
+	}
+
+	m := &Manager{
+		broker:        broker,
+		config:        config,
+		subscriptions: make(map[string]*subscription),
+	}
+
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(broker)
+	opts.SetClientID(config.ClientID)
+	opts.SetUsername(config.Username)
+	opts.SetPassword(config.Password)
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+	if config.Will.Topic != "" {
+		opts.SetBinaryWill(config.Will.Topic, statusPayload(StatusOffline, config.ClientID), config.Will.QoS, config.Will.Retained)
+	}
+	opts.SetAutoReconnect(true)
+	opts.SetConnectRetry(true)
+	opts.SetConnectRetryInterval(5 * time.Second)
+	opts.SetMaxReconnectInterval(30 * time.Second)
+	opts.SetOnConnectHandler(m.onConnect)
+	opts.SetConnectionLostHandler(m.onConnectionLost)
+	m.client = mqtt.NewClient(opts)
+	return m, nil
+}
+
+// Connect connects to the broker, waiting at most timeout for the first attempt.
+// When the broker cannot be reached in time the client keeps retrying in the background
+// and registered handlers are subscribed once it connects.
+func (m *Manager) Connect(timeout time.Duration) error {
+	token := m.client.Connect()
+	if !token.WaitTimeout(timeout) {
+		return fmt.Errorf("MQTT broker %s not reachable within %s, retrying in the background", m.broker, timeout)
+	}
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("failed to connect to MQTT broker: %w", err)
+	}
+	return nil
+}
+
+// Client returns the underlying client for publishing
+func (m *Manager) Client() mqtt.Client {
+	return m.client
+}
+
+// IsConnected checks if the client is connected to the broker
+func (m *Manager) IsConnected() bool {
+	return m.client.IsConnected()
+}
+
+// Handle registers a handler for a topic filter, replacing a previous handler of the same topic.
+// The topic is subscribed at once when connected, otherwise on the next connect.
+func (m *Manager) Handle(topic string, qos byte, handler mqtt.MessageHandler) error {
+	m.mutex.Lock()
+	sub := &subscription{
+		handler: handler,
+		status:  SubscriptionStatus{Topic: topic, QoS: qos},
+	}
+	m.subscriptions[topic] = sub
+	m.mutex.Unlock()
+
+	if !m.client.IsConnected() {
+		log.Printf("MQTT topic %s registered, subscribing once connected", topic)
+		return nil
+	}
+	return m.subscribe(topic, sub)
+}
+
+// Unhandle removes the handler of a topic and unsubscribes from it
+func (m *Manager) Unhandle(topic string) error {
+	m.mutex.Lock()
+	_, ok := m.subscriptions[topic]
+	delete(m.subscriptions, topic)
+	m.mutex.Unlock()
+
+	if !ok || !m.client.IsConnected() {
+		return nil
+	}
+	token := m.client.Unsubscribe(topic)
+	if !token.WaitTimeout(subscribeTimeout) {
+		return fmt.Errorf("timed out unsubscribing from MQTT topic %s", topic)
+	}
+	return token.Error()
+}
+
+// subscribe subscribes one registered topic and records the outcome
+func (m *Manager) subscribe(topic string, sub *subscription) error {
+	token := m.client.Subscribe(topic, sub.status.QoS, m.dispatch(sub))
+	var err error
+	if !token.WaitTimeout(subscribeTimeout) {
+		err = fmt.Errorf("timed out waiting for the subscription acknowledgement")
+	} else {
+		err = token.Error()
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.subscriptions[topic] != sub {
+		// Replaced or removed while subscribing
+		return nil
+	}
+	if err != nil {
+		sub.status.Subscribed = false
+		sub.status.LastError = err.Error()
+		return fmt.Errorf("failed to subscribe to MQTT topic %s: %w", topic, err)
+	}
+	now := time.Now()
+	sub.status.Subscribed = true
+	sub.status.SubscribedAt = &now
+	sub.status.LastError = ""
+	log.Printf("Subscribed to MQTT topic: %s", topic)
+	return nil
+}
+
+// SetJournal records every received message in the journal, the manager closes it on Disconnect
+func (m *Manager) SetJournal(journal *Journal) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.journal = journal
+}
+
+// dispatch counts and journals messages of a subscription before passing them to its handler
+func (m *Manager) dispatch(sub *subscription) mqtt.MessageHandler {
+	return func(client mqtt.Client, msg mqtt.Message) {
+		now := time.Now()
+		m.mutex.Lock()
+		sub.status.Messages++
+		sub.status.LastMessageAt = &now
+		journal := m.journal
+		m.mutex.Unlock()
+
+		if journal != nil {
+			journal.Record(msg.Topic(), msg.Qos(), msg.Retained(), msg.Payload())
+		}
+
+		sub.handler(client, msg)
+	}
+}
+
+// onConnect announces the service and subscribes every registered topic, the broker drops them with a clean session
+func (m *Manager) onConnect(client mqtt.Client) {
+	log.Printf("Connected to MQTT broker at %s", m.broker)
+	m.publishStatus(StatusOnline)
+
+	m.mutex.RLock()
+	topics := make(map[string]*subscription, len(m.subscriptions))
+	for topic, sub := range m.subscriptions {
+		topics[topic] = sub
+	}
+	m.mutex.RUnlock()
+
+	for topic, sub := range topics {
+		if err := m.subscribe(topic, sub); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+}
+
+// onConnectionLost marks every subscription inactive until the client reconnects
+func (m *Manager) onConnectionLost(client mqtt.Client, err error) {
+	log.Printf("MQTT connection lost: %v", err)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, sub := range m.subscriptions {
+		sub.status.Subscribed = false
+		sub.status.LastError = err.Error()
+	}
+}
+
+// Status returns the connection state and every registered subscription ordered by topic
+func (m *Manager) Status() ManagerStatus {
+	m.mutex.RLock()
+	subscriptions := make([]SubscriptionStatus, 0, len(m.subscriptions))
+	for _, sub := range m.subscriptions {
+		subscriptions = append(subscriptions, sub.status)
+	}
+	m.mutex.RUnlock()
+
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].Topic < subscriptions[j].Topic
+	})
+	return ManagerStatus{
+		Broker:        m.broker,
+		Connected:     m.client.IsConnected(),
+		Subscriptions: subscriptions,
+	}
+}
+
+// publishStatus announces the service state on the will topic
+func (m *Manager) publishStatus(status string) {
+	will := m.config.Will
+	if will.Topic == "" {
+		return
+	}
+	token := m.client.Publish(will.Topic, will.QoS, will.Retained, statusPayload(status, m.config.ClientID))
+	if !token.WaitTimeout(time.Second) || token.Error() != nil {
+		log.Printf("Warning: failed to publish MQTT service status %s: %v", status, token.Error())
+	}
+}
+
+// Disconnect unsubscribes every topic and closes the connection, stopping any reconnect attempts.
+// The broker does not send the last will on a clean disconnect, the offline status is published first.
+func (m *Manager) Disconnect() {
+	if m.client.IsConnected() {
+		m.publishStatus(StatusOffline)
+
+		m.mutex.RLock()
+		topics := make([]string, 0, len(m.subscriptions))
+		for topic := range m.subscriptions {
+			topics = append(topics, topic)
+		}
+		m.mutex.RUnlock()
+
+		if len(topics) > 0 {
+			m.client.Unsubscribe(topics...).WaitTimeout(time.Second)
+		}
+	}
+	m.client.Disconnect(250)
+	log.Println("Disconnected from MQTT broker")
+
+	m.mutex.Lock()
+	journal := m.journal
+	m.journal = nil
+	m.mutex.Unlock()
+	if journal != nil {
+		journal.Close()
+	}
+}
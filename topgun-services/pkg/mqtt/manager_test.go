@@ -0,0 +1,338 @@
+package mqtt_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"topgun-services/pkg/mqtt"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	broker "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+)
+
+type Test struct {
+	TestName string
+	Func     func() error
+}
+
+// certificate is a generated certificate and its key
+type certificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newCertificate creates a certificate signed by parent, self signed when parent is nil
+func newCertificate(template *x509.Certificate, parent *certificate) (*certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &certificate{cert: cert, key: key, der: der}, nil
+}
+
+func newCA(name string) (*certificate, error) {
+	return newCertificate(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}, nil)
+}
+
+// write stores the certificate and key as PEM files in dir
+func (c *certificate) write(dir, name string) (string, string, error) {
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600); err != nil {
+		return "", "", err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return "", "", err
+	}
+	return certFile, keyFile, nil
+}
+
+func (c *certificate) tls() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+// freeAddress returns a local address nothing listens on
+func freeAddress() (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer listener.Close()
+	return listener.Addr().String(), nil
+}
+
+func TestManagerTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := newCA("topgun test CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := newCertificate(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := newCertificate(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "topgun-services"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCA, err := newCA("other CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caFile, _, err := ca.write(dir, "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCAFile, _, err := otherCA.write(dir, "other-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile, err := client.write(dir, "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A local broker requiring client certificates and a password
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	address, err := freeAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mochi := broker.New(&broker.Options{InlineClient: true})
+	err = mochi.AddHook(new(auth.Hook), &auth.Options{
+		Ledger: &auth.Ledger{
+			Auth: auth.AuthRules{
+				{Username: "topgun", Password: "secret", Allow: true},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mochi.AddListener(listeners.NewTCP(listeners.Config{
+		ID:      "tls",
+		Address: address,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{server.tls()},
+			ClientCAs:    clientCAs,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			MinVersion:   tls.VersionTLS12,
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mochi.Serve(); err != nil {
+		t.Fatal(err)
+	}
+	defer mochi.Close()
+
+	config := func(clientID string) mqtt.ConnectionConfig {
+		return mqtt.ConnectionConfig{
+			Broker:   "tcp://" + address,
+			ClientID: clientID,
+			Username: "topgun",
+			Password: "secret",
+			TLS: mqtt.TLSConfig{
+				Enabled:  true,
+				CAFile:   caFile,
+				CertFile: certFile,
+				KeyFile:  keyFile,
+			},
+			Will: mqtt.WillConfig{Topic: "topgun/services/status", QoS: 1},
+		}
+	}
+	// The client keeps retrying a rejected connection, so rejections surface as a timeout
+	connectWithin := func(config mqtt.ConnectionConfig, timeout time.Duration) (*mqtt.Manager, error) {
+		manager, err := mqtt.NewManager(config)
+		if err != nil {
+			return nil, err
+		}
+		if err := manager.Connect(timeout); err != nil {
+			manager.Disconnect()
+			return nil, err
+		}
+		return manager, nil
+	}
+	connect := func(config mqtt.ConnectionConfig) (*mqtt.Manager, error) {
+		return connectWithin(config, 5*time.Second)
+	}
+
+	tests := []Test{
+		{
+			TestName: "ConnectsWithTLSAndCredentials",
+			Func: func() error {
+				manager, err := connect(config("tls-roundtrip"))
+				if err != nil {
+					return err
+				}
+				defer manager.Disconnect()
+
+				received := make(chan string, 1)
+				err = manager.Handle("topgun/test", 1, func(client paho.Client, msg paho.Message) {
+					received <- string(msg.Payload())
+				})
+				if err != nil {
+					return err
+				}
+				manager.Client().Publish("topgun/test", 1, false, "hello").WaitTimeout(5 * time.Second)
+				select {
+				case payload := <-received:
+					if payload != "hello" {
+						return fmt.Errorf("unexpected payload %q", payload)
+					}
+				case <-time.After(5 * time.Second):
+					return fmt.Errorf("published message was not received")
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "RejectsWrongPassword",
+			Func: func() error {
+				wrong := config("tls-wrong-password")
+				wrong.Password = "guess"
+				if manager, err := connectWithin(wrong, time.Second); err == nil {
+					manager.Disconnect()
+					return fmt.Errorf("expected a wrong password to be rejected")
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "RejectsUntrustedBroker",
+			Func: func() error {
+				untrusted := config("tls-untrusted")
+				untrusted.TLS.CAFile = otherCAFile
+				if manager, err := connectWithin(untrusted, time.Second); err == nil {
+					manager.Disconnect()
+					return fmt.Errorf("expected a broker signed by an unknown CA to be rejected")
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "RequiresCertificateAndKeyTogether",
+			Func: func() error {
+				partial := config("tls-partial")
+				partial.TLS.KeyFile = ""
+				if _, err := mqtt.NewManager(partial); err == nil {
+					return fmt.Errorf("expected a certificate without a key to be rejected")
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "PublishesWillWhenDropped",
+			Func: func() error {
+				watcher, err := connect(config("tls-watcher"))
+				if err != nil {
+					return err
+				}
+				defer watcher.Disconnect()
+
+				statuses := make(chan mqtt.ServiceStatus, 4)
+				err = watcher.Handle("topgun/services/status", 1, func(client paho.Client, msg paho.Message) {
+					var status mqtt.ServiceStatus
+					if json.Unmarshal(msg.Payload(), &status) == nil && status.ClientID == "tls-service" {
+						statuses <- status
+					}
+				})
+				if err != nil {
+					return err
+				}
+
+				service, err := connect(config("tls-service"))
+				if err != nil {
+					return err
+				}
+				defer service.Disconnect()
+				if err := expectStatus(statuses, mqtt.StatusOnline); err != nil {
+					return err
+				}
+
+				// Dropping the connection without a DISCONNECT makes the broker send the will
+				serviceClient, ok := mochi.Clients.Get("tls-service")
+				if !ok {
+					return fmt.Errorf("service client not found on the broker")
+				}
+				serviceClient.Stop(fmt.Errorf("dropped by test"))
+				return expectStatus(statuses, mqtt.StatusOffline)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			if err := test.Func(); err != nil {
+				t.Errorf("Test %s failed with error: %v", test.TestName, err)
+			}
+		})
+	}
+}
+
+// expectStatus waits for the next service status message
+func expectStatus(statuses <-chan mqtt.ServiceStatus, expected string) error {
+	select {
+	case status := <-statuses:
+		if status.Status != expected {
+			return fmt.Errorf("expected status %s, got %s", expected, status.Status)
+		}
+		return nil
+	case <-time.After(5 * time.Second):
+		return fmt.Errorf("no %s status received", expected)
+	}
+}
@@ -0,0 +1,76 @@
+package mqtt
+
+import (
+	"context"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Replay passes journal entries to deliver in order. With a speed of 1 entries keep their original
+// spacing, 10 replays ten times faster and 0 delivers them without waiting.
+// It returns how many entries were delivered.
+func Replay(ctx context.Context, entries []JournalEntry, speed float64, deliver func(JournalEntry) error) (int, error) {
+	start := time.Now()
+	for i, entry := range entries {
+		if speed > 0 && i > 0 {
+			offset := time.Duration(float64(entry.At.Sub(entries[0].At)) / speed)
+			if wait := time.Until(start.Add(offset)); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return i, ctx.Err()
+				case <-timer.C:
+				}
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+		if err := deliver(entry); err != nil {
+			return i, err
+		}
+	}
+	return len(entries), nil
+}
+
+// Deliver passes a journal entry to every registered handler whose topic filter matches,
+// as if it had been received from the broker. It returns how many handlers received it.
+func (m *Manager) Deliver(entry JournalEntry) int {
+	m.mutex.RLock()
+	var handlers []mqtt.MessageHandler
+	for filter, sub := range m.subscriptions {
+		if TopicMatches(filter, entry.Topic) {
+			handlers = append(handlers, sub.handler)
+		}
+	}
+	m.mutex.RUnlock()
+
+	for _, handler := range handlers {
+		handler(m.client, &replayedMessage{entry: entry})
+	}
+	return len(handlers)
+}
+
+// Publish sends a journal entry to the broker, waiting for the acknowledgement
+func (m *Manager) Publish(entry JournalEntry) error {
+	token := m.client.Publish(entry.Topic, entry.QoS, entry.Retained, entry.Bytes())
+	if !token.WaitTimeout(subscribeTimeout) {
+		return context.DeadlineExceeded
+	}
+	return token.Error()
+}
+
+// replayedMessage is a journal entry seen as a received message
+type replayedMessage struct {
+	entry JournalEntry
+}
+
+func (m *replayedMessage) Duplicate() bool   { return false }
+func (m *replayedMessage) Qos() byte         { return m.entry.QoS }
+func (m *replayedMessage) Retained() bool    { return m.entry.Retained }
+func (m *replayedMessage) Topic() string     { return m.entry.Topic }
+func (m *replayedMessage) MessageID() uint16 { return 0 }
+func (m *replayedMessage) Payload() []byte   { return m.entry.Bytes() }
+func (m *replayedMessage) Ack()              {}
@@ -0,0 +1,24 @@
+package mqtt
+
+import (
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Subscriber registers topic handlers, implemented by Manager.
+// Packages subscribing to their topics depend on it so they can be tested without a broker.
+type Subscriber interface {
+	Handle(topic string, qos byte, handler mqtt.MessageHandler) error
+}
+
+// WildcardLevel returns the index of the first + or # level of a topic filter, -1 when it has none.
+// Topics matching e.g. topgun/devices/+/heartbeat carry the ID of their sender at that level.
+func WildcardLevel(filter string) int {
+	for i, level := range strings.Split(filter, "/") {
+		if level == "+" || level == "#" {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,551 @@
+package mqtt
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/uuid"
+)
+
+// Transfer states
+const (
+	TransferSending        = "sending"        // manifest and chunks are being published
+	TransferAwaitingAck    = "awaiting_ack"   // every chunk was sent, waiting for receivers to confirm
+	TransferCompleted      = "completed"      // every receiver verified the file
+	TransferFailed         = "failed"         // publishing failed or a receiver gave up
+	TransferUnacknowledged = "unacknowledged" // sent, but no receiver reported back
+)
+
+// Receiver states, the status field of acknowledgements
+const (
+	ReceiverPending   = "pending"   // expected, nothing heard yet
+	ReceiverReceiving = "receiving" // chunks are missing
+	ReceiverComplete  = "complete"  // the file was assembled and its SHA-256 matched
+	ReceiverFailed    = "failed"    // the receiver gave up, or did not answer after every retry
+)
+
+// TransferConfig configures chunked file transfers
+type TransferConfig struct {
+	Topic      string        // base topic, e.g. topgun/model
+	ChunkSize  int           // bytes per chunk
+	AckTimeout time.Duration // silence after which the manifest is sent again
+	MaxRetries int           // manifest resends and retransmission rounds per receiver
+	Dir        string        // where uploaded files wait until their transfer finished
+	OneShotMax int64         // largest file sent as a single message in auto mode
+}
+
+// TransferManifest announces a file before its chunks, on <topic>/<transfer_id>/manifest.
+// Chunks follow as raw bytes on <topic>/<transfer_id>/chunk/<index>, counted from 0, and receivers
+// answer with a TransferAck on <topic>/<transfer_id>/ack/<receiver_id>.
+type TransferManifest struct {
+	TransferID string `json:"transfer_id"`
+	Filename   string `json:"filename"`
+	Ext        string `json:"ext"`
+	Size       int64  `json:"size"`
+	SHA256     string `json:"sha256"`
+	ChunkSize  int    `json:"chunk_size"`
+	ChunkCount int    `json:"chunk_count"`
+	ChunkTopic string `json:"chunk_topic"` // with {index} in place of the chunk index
+	AckTopic   string `json:"ack_topic"`   // with {receiver_id} in place of the receiver ID
+}
+
+// TransferAck is the progress a receiver reports. Receivers answer every manifest, so a manifest
+// sent again asks for the chunks still missing.
+type TransferAck struct {
+	Status   string `json:"status"`   // receiving, complete or failed
+	Received int    `json:"received"` // chunks received so far
+	Missing  []int  `json:"missing"`  // chunks to send again
+	Error    string `json:"error,omitempty"`
+}
+
+// ReceiverProgress is the last reported progress of one receiver
+type ReceiverProgress struct {
+	ID              string     `json:"id"`
+	Status          string     `json:"status"`
+	Received        int        `json:"received"`
+	Missing         []int      `json:"missing,omitempty"`
+	Retransmissions int        `json:"retransmissions"`
+	Error           string     `json:"error,omitempty"`
+	UpdatedAt       *time.Time `json:"updated_at,omitempty"`
+}
+
+// TransferProgress is the state of a transfer exposed over the API
+type TransferProgress struct {
+	Manifest      TransferManifest   `json:"manifest"`
+	Status        string             `json:"status"`
+	ChunksSent    int                `json:"chunks_sent"`
+	ChunksResent  int                `json:"chunks_resent"`
+	ManifestsSent int                `json:"manifests_sent"`
+	Receivers     []ReceiverProgress `json:"receivers"`
+	Error         string             `json:"error,omitempty"`
+	StartedAt     time.Time          `json:"started_at"`
+	FinishedAt    *time.Time         `json:"finished_at,omitempty"`
+}
+
+// Largest file sent as a single message when no limit is configured, the base64 message
+// stays below the 256 KiB limit of many brokers
+const defaultOneShotMax = 128 * 1024
+
+// Largest chunk, a chunk is one MQTT message
+const maxChunkSize = 1024 * 1024
+
+// Time to wait for a single publish acknowledgement
+const publishTimeout = 10 * time.Second
+
+// Finished transfers kept for the progress API
+const keptTransfers = 100
+
+// receivedAck is an acknowledgement routed to its transfer
+type receivedAck struct {
+	receiver string
+	ack      TransferAck
+}
+
+// transfer is a running or finished transfer, progress is guarded by the Transfers mutex
+type transfer struct {
+	progress  TransferProgress
+	receivers map[string]int // index in progress.Receivers by receiver ID
+	listed    bool           // receivers were given, otherwise any receiver may still report
+	path      string
+	remove    bool // the file is an upload owned by the transfer
+	acks      chan receivedAck
+}
+
+// Transfers sends files over MQTT in checksummed chunks and tracks their acknowledgements
+type Transfers struct {
+	manager *Manager
+	config  TransferConfig
+
+	mutex     sync.RWMutex
+	transfers map[string]*transfer
+	order     []string // transfer IDs, oldest first
+}
+
+// NewTransfers registers the acknowledgement topic on the manager
+func NewTransfers(manager *Manager, config TransferConfig) (*Transfers, error) {
+	if config.Topic == "" {
+		config.Topic = "topgun/model"
+	}
+	if config.ChunkSize <= 0 {
+		config.ChunkSize = 64 * 1024
+	}
+	if config.AckTimeout <= 0 {
+		config.AckTimeout = 10 * time.Second
+	}
+	if config.MaxRetries <= 0 {
+		config.MaxRetries = 5
+	}
+	if config.Dir == "" {
+		config.Dir = "./upload/transfers"
+	}
+	if config.OneShotMax <= 0 {
+		config.OneShotMax = defaultOneShotMax
+	}
+
+	t := &Transfers{
+		manager:   manager,
+		config:    config,
+		transfers: make(map[string]*transfer),
+	}
+	if err := manager.Handle(config.Topic+"/+/ack/+", 1, t.handleAck); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Dir returns where uploads are stored until their transfer finishes
+func (t *Transfers) Dir() string {
+	return t.config.Dir
+}
+
+// OneShotMax returns the largest file sent as a single message in auto mode
+func (t *Transfers) OneShotMax() int64 {
+	return t.config.OneShotMax
+}
+
+// Send starts a transfer of the file at path, announced under filename.
+// Receivers lists the IDs expected to confirm, when empty every receiver that reports is tracked
+// and the transfer only finishes once a resent manifest brought no further acknowledgements.
+// With remove the file is deleted once the transfer finished.
+func (t *Transfers) Send(path, filename string, receivers []string, chunkSize int, remove bool) (*TransferProgress, error) {
+	if chunkSize <= 0 {
+		chunkSize = t.config.ChunkSize
+	}
+	// A chunk is read into memory, keep requested sizes reasonable
+	chunkSize = min(chunkSize, maxChunkSize)
+	filename = filepath.Base(filename)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to checksum %s: %w", path, err)
+	}
+
+	id := uuid.New().String()
+	base := t.config.Topic + "/" + id
+	chunkCount := int((size + int64(chunkSize) - 1) / int64(chunkSize))
+	current := &transfer{
+		progress: TransferProgress{
+			Manifest: TransferManifest{
+				TransferID: id,
+				Filename:   filename,
+				Ext:        filepath.Ext(filename),
+				Size:       size,
+				SHA256:     hex.EncodeToString(hash.Sum(nil)),
+				ChunkSize:  chunkSize,
+				ChunkCount: chunkCount,
+				ChunkTopic: base + "/chunk/{index}",
+				AckTopic:   base + "/ack/{receiver_id}",
+			},
+			Status:    TransferSending,
+			Receivers: []ReceiverProgress{},
+			StartedAt: time.Now(),
+		},
+		receivers: make(map[string]int),
+		listed:    len(receivers) > 0,
+		path:      path,
+		remove:    remove,
+		acks:      make(chan receivedAck, 64),
+	}
+	for _, receiver := range receivers {
+		current.receiver(receiver)
+	}
+
+	t.mutex.Lock()
+	t.transfers[id] = current
+	t.order = append(t.order, id)
+	t.pruneLocked()
+	progress := current.snapshot()
+	t.mutex.Unlock()
+
+	go t.run(current)
+	return &progress, nil
+}
+
+// Get returns the progress of a transfer
+func (t *Transfers) Get(id string) (TransferProgress, bool) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	current, ok := t.transfers[id]
+	if !ok {
+		return TransferProgress{}, false
+	}
+	return current.snapshot(), true
+}
+
+// List returns the progress of the kept transfers, newest first
+func (t *Transfers) List() []TransferProgress {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	list := make([]TransferProgress, 0, len(t.order))
+	for i := len(t.order) - 1; i >= 0; i-- {
+		list = append(list, t.transfers[t.order[i]].snapshot())
+	}
+	return list
+}
+
+// pruneLocked forgets the oldest finished transfers beyond keptTransfers
+func (t *Transfers) pruneLocked() {
+	for i := 0; len(t.order) > keptTransfers && i < len(t.order); {
+		if t.transfers[t.order[i]].progress.FinishedAt == nil {
+			i++
+			continue
+		}
+		delete(t.transfers, t.order[i])
+		t.order = append(t.order[:i], t.order[i+1:]...)
+	}
+}
+
+// snapshot copies the progress so it can be read without the lock
+func (tr *transfer) snapshot() TransferProgress {
+	progress := tr.progress
+	progress.Receivers = make([]ReceiverProgress, len(tr.progress.Receivers))
+	for i, receiver := range tr.progress.Receivers {
+		receiver.Missing = append([]int(nil), receiver.Missing...)
+		progress.Receivers[i] = receiver
+	}
+	return progress
+}
+
+// receiver returns the progress of a receiver, adding it when unknown
+func (tr *transfer) receiver(id string) *ReceiverProgress {
+	index, ok := tr.receivers[id]
+	if !ok {
+		index = len(tr.progress.Receivers)
+		tr.progress.Receivers = append(tr.progress.Receivers, ReceiverProgress{ID: id, Status: ReceiverPending})
+		tr.receivers[id] = index
+	}
+	return &tr.progress.Receivers[index]
+}
+
+// handleAck routes an acknowledgement on <topic>/<transfer_id>/ack/<receiver_id> to its transfer
+func (t *Transfers) handleAck(client mqtt.Client, msg mqtt.Message) {
+	levels := strings.Split(strings.TrimPrefix(msg.Topic(), t.config.Topic+"/"), "/")
+	if len(levels) != 3 || levels[1] != "ack" {
+		return
+	}
+	var ack TransferAck
+	if err := json.Unmarshal(msg.Payload(), &ack); err != nil {
+		log.Printf("Invalid transfer acknowledgement on %s: %v", msg.Topic(), err)
+		return
+	}
+
+	t.mutex.RLock()
+	current, ok := t.transfers[levels[0]]
+	t.mutex.RUnlock()
+	if !ok {
+		return
+	}
+	select {
+	case current.acks <- receivedAck{receiver: levels[2], ack: ack}:
+	default:
+		log.Printf("Transfer %s acknowledgement queue full, dropping ack of %s", levels[0], levels[2])
+	}
+}
+
+// run publishes the manifest and the chunks, then answers acknowledgements until every receiver finished
+func (t *Transfers) run(current *transfer) {
+	defer func() {
+		if current.remove {
+			os.Remove(current.path)
+		}
+	}()
+
+	file, err := os.Open(current.path)
+	if err != nil {
+		t.finish(current, TransferFailed, err.Error())
+		return
+	}
+	defer file.Close()
+
+	if err := t.sendManifest(current); err != nil {
+		t.finish(current, TransferFailed, err.Error())
+		return
+	}
+	for index := 0; index < current.progress.Manifest.ChunkCount; index++ {
+		if err := t.sendChunk(current, file, index, false); err != nil {
+			t.finish(current, TransferFailed, err.Error())
+			return
+		}
+	}
+	t.update(current, func() { current.progress.Status = TransferAwaitingAck })
+
+	retries := 0
+	polled := false // a manifest went out since the last receiver activity
+	timer := time.NewTimer(t.config.AckTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case received := <-current.acks:
+			missing, gaveUp, active := t.recordAck(current, received)
+			for _, index := range missing {
+				if err := t.sendChunk(current, file, index, true); err != nil {
+					t.finish(current, TransferFailed, err.Error())
+					return
+				}
+			}
+			if gaveUp {
+				log.Printf("Transfer %s: receiver %s still misses chunks after %d retransmissions", current.progress.Manifest.TransferID, received.receiver, t.config.MaxRetries)
+			}
+			// Without a receiver list another receiver may still report, such transfers end on a timeout
+			if current.listed {
+				if status, reason, done := t.outcome(current, false); done {
+					t.finish(current, status, reason)
+					return
+				}
+			}
+			// Only receivers still working keep the transfer waiting, finished ones answer every manifest
+			if active {
+				retries = 0
+				polled = false
+				timer.Reset(t.config.AckTimeout)
+			}
+
+		case <-timer.C:
+			retries++
+			// Unlisted receivers that are late or lost the last chunks only report on a resent manifest
+			if current.listed || polled || retries > t.config.MaxRetries {
+				if status, reason, done := t.outcome(current, retries > t.config.MaxRetries); done {
+					t.finish(current, status, reason)
+					return
+				}
+			}
+			// Ask the receivers that went quiet for their missing chunks
+			if err := t.sendManifest(current); err != nil {
+				log.Printf("Transfer %s: failed to resend manifest: %v", current.progress.Manifest.TransferID, err)
+			}
+			polled = true
+			timer.Reset(t.config.AckTimeout)
+		}
+	}
+}
+
+// recordAck updates the progress of a receiver and returns the chunks to send again, none when
+// the receiver used up its retransmissions, and whether the receiver was still working
+func (t *Transfers) recordAck(current *transfer, received receivedAck) ([]int, bool, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	receiver := current.receiver(received.receiver)
+	if receiver.Status == ReceiverComplete || receiver.Status == ReceiverFailed {
+		return nil, false, false
+	}
+	receiver.Status = received.ack.Status
+	receiver.Received = received.ack.Received
+	receiver.Error = received.ack.Error
+	receiver.UpdatedAt = &now
+
+	// Keep valid indexes only, a receiver cannot make us read outside the file
+	receiver.Missing = receiver.Missing[:0]
+	for _, index := range received.ack.Missing {
+		if index >= 0 && index < current.progress.Manifest.ChunkCount {
+			receiver.Missing = append(receiver.Missing, index)
+		}
+	}
+	sort.Ints(receiver.Missing)
+
+	switch receiver.Status {
+	case ReceiverComplete, ReceiverFailed:
+		receiver.Missing = nil
+		return nil, false, true
+	case ReceiverReceiving:
+	default:
+		receiver.Status = ReceiverReceiving
+	}
+	if len(receiver.Missing) == 0 {
+		return nil, false, true
+	}
+	if receiver.Retransmissions >= t.config.MaxRetries {
+		receiver.Status = ReceiverFailed
+		receiver.Error = fmt.Sprintf("%d chunks still missing after %d retransmissions", len(receiver.Missing), receiver.Retransmissions)
+		return nil, true, true
+	}
+	receiver.Retransmissions++
+	return append([]int(nil), receiver.Missing...), false, true
+}
+
+// outcome decides whether a transfer is finished. With timedOut receivers that are not
+// finished yet have failed.
+func (t *Transfers) outcome(current *transfer, timedOut bool) (string, string, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	receivers := current.progress.Receivers
+	if len(receivers) == 0 {
+		if timedOut {
+			return TransferUnacknowledged, "", true
+		}
+		return "", "", false
+	}
+
+	var pending, failed []string
+	for i := range receivers {
+		switch receivers[i].Status {
+		case ReceiverComplete:
+		case ReceiverFailed:
+			failed = append(failed, receivers[i].ID)
+		default:
+			if !timedOut {
+				pending = append(pending, receivers[i].ID)
+				continue
+			}
+			receivers[i].Status = ReceiverFailed
+			receivers[i].Error = "no acknowledgement"
+			failed = append(failed, receivers[i].ID)
+		}
+	}
+	switch {
+	case len(pending) > 0:
+		return "", "", false
+	case len(failed) > 0:
+		return TransferFailed, "receivers failed: " + strings.Join(failed, ", "), true
+	default:
+		return TransferCompleted, "", true
+	}
+}
+
+// sendManifest publishes the manifest of a transfer
+func (t *Transfers) sendManifest(current *transfer) error {
+	manifest := current.progress.Manifest
+	payload, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if err := t.publish(t.config.Topic+"/"+manifest.TransferID+"/manifest", payload); err != nil {
+		return fmt.Errorf("failed to publish manifest: %w", err)
+	}
+	t.update(current, func() { current.progress.ManifestsSent++ })
+	return nil
+}
+
+// sendChunk reads one chunk from the file and publishes it
+func (t *Transfers) sendChunk(current *transfer, file *os.File, index int, resend bool) error {
+	manifest := current.progress.Manifest
+	chunk := make([]byte, manifest.ChunkSize)
+	n, err := file.ReadAt(chunk, int64(index)*int64(manifest.ChunkSize))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to read chunk %d: %w", index, err)
+	}
+	topic := t.config.Topic + "/" + manifest.TransferID + "/chunk/" + strconv.Itoa(index)
+	if err := t.publish(topic, chunk[:n]); err != nil {
+		return fmt.Errorf("failed to publish chunk %d: %w", index, err)
+	}
+	t.update(current, func() {
+		if resend {
+			current.progress.ChunksResent++
+		} else {
+			current.progress.ChunksSent++
+		}
+	})
+	return nil
+}
+
+// publish sends one message with QoS 1 and waits for the broker to acknowledge it
+func (t *Transfers) publish(topic string, payload []byte) error {
+	client := t.manager.Client()
+	if !client.IsConnected() {
+		return fmt.Errorf("MQTT client is not connected")
+	}
+	token := client.Publish(topic, 1, false, payload)
+	if !token.WaitTimeout(publishTimeout) {
+		return fmt.Errorf("timed out publishing to %s", topic)
+	}
+	return token.Error()
+}
+
+// update changes the progress of a transfer under the lock
+func (t *Transfers) update(current *transfer, change func()) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	change()
+}
+
+// finish records the final state of a transfer
+func (t *Transfers) finish(current *transfer, status, reason string) {
+	now := time.Now()
+	t.update(current, func() {
+		current.progress.Status = status
+		current.progress.Error = reason
+		current.progress.FinishedAt = &now
+	})
+	log.Printf("Transfer %s of %s finished: %s %s", current.progress.Manifest.TransferID, current.progress.Manifest.Filename, status, reason)
+}
@@ -0,0 +1,380 @@
+package mqtt_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"topgun-services/pkg/mqtt"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/gofiber/fiber/v2"
+)
+
+// fakeReceiver is a Raspberry PI assembling chunked transfers, it loses the chunks in drop the first time
+type fakeReceiver struct {
+	client paho.Client
+	id     string
+	drop   map[int]bool
+
+	mutex     sync.Mutex
+	manifests map[string]mqtt.TransferManifest
+	chunks    map[string]map[int][]byte
+	files     map[string][]byte // assembled files that matched their SHA-256
+}
+
+func newFakeReceiver(address, id, token string, drop map[int]bool) (*fakeReceiver, error) {
+	client, err := connectClient(address, id, id, token)
+	if err != nil {
+		return nil, err
+	}
+	r := &fakeReceiver{
+		client:    client,
+		id:        id,
+		drop:      drop,
+		manifests: make(map[string]mqtt.TransferManifest),
+		chunks:    make(map[string]map[int][]byte),
+		files:     make(map[string][]byte),
+	}
+	for topic, handler := range map[string]paho.MessageHandler{
+		"topgun/model/+/manifest": r.onManifest,
+		"topgun/model/+/chunk/+":  r.onChunk,
+	} {
+		if qos, err := subscribeResult(client, topic); err != nil || qos == 0x80 {
+			client.Disconnect(0)
+			return nil, fmt.Errorf("failed to subscribe to %s: %v", topic, err)
+		}
+		client.AddRoute(topic, handler)
+	}
+	return r, nil
+}
+
+func (r *fakeReceiver) onManifest(client paho.Client, msg paho.Message) {
+	var manifest mqtt.TransferManifest
+	if err := json.Unmarshal(msg.Payload(), &manifest); err != nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.manifests[manifest.TransferID]; !ok {
+		r.manifests[manifest.TransferID] = manifest
+		r.chunks[manifest.TransferID] = make(map[int][]byte)
+		return
+	}
+	// A manifest sent again asks for the progress
+	r.ackLocked(manifest.TransferID)
+}
+
+func (r *fakeReceiver) onChunk(client paho.Client, msg paho.Message) {
+	levels := strings.Split(msg.Topic(), "/")
+	id := levels[2]
+	index, err := strconv.Atoi(levels[4])
+	if err != nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	manifest, ok := r.manifests[id]
+	if !ok {
+		return
+	}
+	if r.drop[index] {
+		delete(r.drop, index)
+		return
+	}
+	r.chunks[id][index] = append([]byte(nil), msg.Payload()...)
+	if index == manifest.ChunkCount-1 || len(r.chunks[id]) == manifest.ChunkCount {
+		r.ackLocked(id)
+	}
+}
+
+// ackLocked reports the missing chunks, or assembles and verifies the file once none is missing
+func (r *fakeReceiver) ackLocked(id string) {
+	manifest := r.manifests[id]
+	ack := mqtt.TransferAck{Status: mqtt.ReceiverReceiving, Received: len(r.chunks[id])}
+	for index := 0; index < manifest.ChunkCount; index++ {
+		if _, ok := r.chunks[id][index]; !ok {
+			ack.Missing = append(ack.Missing, index)
+		}
+	}
+	if len(ack.Missing) == 0 {
+		var file []byte
+		for index := 0; index < manifest.ChunkCount; index++ {
+			file = append(file, r.chunks[id][index]...)
+		}
+		sum := sha256.Sum256(file)
+		if hex.EncodeToString(sum[:]) == manifest.SHA256 && int64(len(file)) == manifest.Size {
+			ack.Status = mqtt.ReceiverComplete
+			r.files[id] = file
+		} else {
+			ack.Status = mqtt.ReceiverFailed
+			ack.Error = "checksum mismatch"
+		}
+	}
+	payload, _ := json.Marshal(ack)
+	topic := strings.Replace(manifest.AckTopic, "{receiver_id}", r.id, 1)
+	// Publishing from a message handler must not wait for the token
+	go r.client.Publish(topic, 1, false, payload)
+}
+
+func (r *fakeReceiver) file(id string) []byte {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.files[id]
+}
+
+// waitFinished polls a transfer until it finished
+func waitFinished(transfers *mqtt.Transfers, id string, timeout time.Duration) (mqtt.TransferProgress, error) {
+	for deadline := time.Now().Add(timeout); ; time.Sleep(20 * time.Millisecond) {
+		progress, ok := transfers.Get(id)
+		if !ok {
+			return progress, fmt.Errorf("transfer %s not found", id)
+		}
+		if progress.FinishedAt != nil {
+			return progress, nil
+		}
+		if time.Now().After(deadline) {
+			return progress, fmt.Errorf("transfer %s still %s after %s", id, progress.Status, timeout)
+		}
+	}
+}
+
+func TestChunkedTransfer(t *testing.T) {
+	address, err := freeAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cameras := map[string]string{"pi-1": "token-1", "pi-2": "token-2"}
+	broker, err := mqtt.NewBroker(mqtt.BrokerConfig{
+		Listeners:       []mqtt.ListenerConfig{{ID: "tcp", Type: mqtt.ListenerTCP, Address: address}},
+		ServiceUsername: "topgun-services",
+		ServicePassword: "service-secret",
+		Cameras: func(cameraID, token string) bool {
+			return token != "" && cameras[cameraID] == token
+		},
+		ACL: mqtt.BrokerACL{
+			Publish:   []string{"topgun/model/+/ack/" + mqtt.CameraPlaceholder},
+			Subscribe: []string{"topgun/model/+/manifest", "topgun/model/+/chunk/+"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+
+	manager, err := mqtt.NewManager(mqtt.ConnectionConfig{
+		Broker:   "tcp://" + address,
+		ClientID: "service",
+		Username: "topgun-services",
+		Password: "service-secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Connect(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Disconnect()
+
+	dir := t.TempDir()
+	transfers, err := mqtt.NewTransfers(manager, mqtt.TransferConfig{
+		ChunkSize:  1000,
+		AckTimeout: 300 * time.Millisecond,
+		MaxRetries: 2,
+		Dir:        dir,
+		OneShotMax: 4000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receiver, err := newFakeReceiver(address, "pi-1", "token-1", map[int]bool{2: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.client.Disconnect(0)
+
+	writeFile := func(name string, size int) (string, []byte, error) {
+		content := make([]byte, size)
+		rand.Read(content)
+		path := filepath.Join(dir, name)
+		return path, content, os.WriteFile(path, content, 0644)
+	}
+
+	tests := []Test{
+		{
+			TestName: "MissingChunkIsRetransmitted",
+			Func: func() error {
+				path, content, err := writeFile("best.pt", 5500)
+				if err != nil {
+					return err
+				}
+				started, err := transfers.Send(path, "best.pt", []string{"pi-1"}, 0, true)
+				if err != nil {
+					return err
+				}
+				if started.Manifest.ChunkCount != 6 || started.Manifest.Size != 5500 {
+					return fmt.Errorf("expected 6 chunks of 5500 bytes, got %d of %d", started.Manifest.ChunkCount, started.Manifest.Size)
+				}
+
+				progress, err := waitFinished(transfers, started.Manifest.TransferID, 5*time.Second)
+				if err != nil {
+					return err
+				}
+				if progress.Status != mqtt.TransferCompleted {
+					return fmt.Errorf("expected completed, got %s: %s", progress.Status, progress.Error)
+				}
+				if progress.ChunksSent != 6 || progress.ChunksResent != 1 {
+					return fmt.Errorf("expected 6 chunks sent and 1 resent, got %d and %d", progress.ChunksSent, progress.ChunksResent)
+				}
+				if len(progress.Receivers) != 1 || progress.Receivers[0].Status != mqtt.ReceiverComplete || progress.Receivers[0].Retransmissions != 1 {
+					return fmt.Errorf("unexpected receivers %+v", progress.Receivers)
+				}
+				if !bytes.Equal(receiver.file(started.Manifest.TransferID), content) {
+					return fmt.Errorf("the receiver did not assemble the file")
+				}
+				if _, err := os.Stat(path); !os.IsNotExist(err) {
+					return fmt.Errorf("expected the transfer to remove its upload, got %v", err)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "SilentReceiverFailsAfterRetries",
+			Func: func() error {
+				path, _, err := writeFile("silent.pt", 1500)
+				if err != nil {
+					return err
+				}
+				started, err := transfers.Send(path, "silent.pt", []string{"pi-1", "pi-2"}, 0, false)
+				if err != nil {
+					return err
+				}
+				progress, err := waitFinished(transfers, started.Manifest.TransferID, 5*time.Second)
+				if err != nil {
+					return err
+				}
+				if progress.Status != mqtt.TransferFailed || !strings.Contains(progress.Error, "pi-2") {
+					return fmt.Errorf("expected failed because of pi-2, got %s: %s", progress.Status, progress.Error)
+				}
+				if progress.ManifestsSent != 3 {
+					return fmt.Errorf("expected the manifest sent 3 times, got %d", progress.ManifestsSent)
+				}
+				for _, r := range progress.Receivers {
+					if (r.ID == "pi-1") != (r.Status == mqtt.ReceiverComplete) {
+						return fmt.Errorf("expected only pi-1 complete, got %+v", progress.Receivers)
+					}
+				}
+				if _, err := os.Stat(path); err != nil {
+					return fmt.Errorf("expected a file sent by path to be kept, got %v", err)
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "UnlistedLateReceiverIsServed",
+			Func: func() error {
+				// pi-2 loses the last chunk, so it only reports once the manifest is sent again
+				late, err := newFakeReceiver(address, "pi-2", "token-2", map[int]bool{1: true})
+				if err != nil {
+					return err
+				}
+				defer late.client.Disconnect(0)
+
+				path, content, err := writeFile("unlisted.pt", 1500)
+				if err != nil {
+					return err
+				}
+				started, err := transfers.Send(path, "unlisted.pt", nil, 0, true)
+				if err != nil {
+					return err
+				}
+				progress, err := waitFinished(transfers, started.Manifest.TransferID, 5*time.Second)
+				if err != nil {
+					return err
+				}
+				if progress.Status != mqtt.TransferCompleted {
+					return fmt.Errorf("expected completed, got %s: %s", progress.Status, progress.Error)
+				}
+				if len(progress.Receivers) != 2 {
+					return fmt.Errorf("expected pi-1 and pi-2 tracked, got %+v", progress.Receivers)
+				}
+				for _, r := range progress.Receivers {
+					if r.Status != mqtt.ReceiverComplete {
+						return fmt.Errorf("expected every receiver complete, got %+v", progress.Receivers)
+					}
+				}
+				if !bytes.Equal(late.file(started.Manifest.TransferID), content) {
+					return fmt.Errorf("the late receiver did not assemble the file")
+				}
+				return nil
+			},
+		},
+		{
+			TestName: "UploadChoosesModeBySize",
+			Func: func() error {
+				app := fiber.New()
+				mqtt.SetupRoutes(app.Group("/mqtt"), mqtt.NewHandler(mqtt.NewService(manager.Client(), "topgun/command"), manager, transfers))
+
+				upload := func(size int, mode string) (int, map[string]interface{}, error) {
+					body := &bytes.Buffer{}
+					form := multipart.NewWriter(body)
+					part, _ := form.CreateFormFile("file", "model.pt")
+					part.Write(make([]byte, size))
+					if mode != "" {
+						form.WriteField("mode", mode)
+					}
+					form.Close()
+					req := httptest.NewRequest("POST", "/mqtt/upload-file", body)
+					req.Header.Set("Content-Type", form.FormDataContentType())
+					resp, err := app.Test(req, 5000)
+					if err != nil {
+						return 0, nil, err
+					}
+					var result map[string]interface{}
+					err = json.NewDecoder(resp.Body).Decode(&result)
+					return resp.StatusCode, result, err
+				}
+
+				if status, _, err := upload(2000, ""); err != nil || status != fiber.StatusOK {
+					return fmt.Errorf("expected a small file sent in one message, got %d %v", status, err)
+				}
+				status, result, err := upload(6000, "")
+				if err != nil || status != fiber.StatusAccepted {
+					return fmt.Errorf("expected a large file sent as a chunked transfer, got %d %v", status, err)
+				}
+				id := result["transfer"].(map[string]interface{})["manifest"].(map[string]interface{})["transfer_id"].(string)
+				if _, ok := transfers.Get(id); !ok {
+					return fmt.Errorf("transfer %s of the upload is not tracked", id)
+				}
+				if status, _, err := upload(6000, mqtt.FileModeOneShot); err != nil || status != fiber.StatusRequestEntityTooLarge {
+					return fmt.Errorf("expected one_shot refused above the limit, got %d %v", status, err)
+				}
+				if status, _, err := upload(100, "zip"); err != nil || status != fiber.StatusBadRequest {
+					return fmt.Errorf("expected an unknown mode refused, got %d %v", status, err)
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			if err := test.Func(); err != nil {
+				t.Errorf("Test %s failed with error: %v", test.TestName, err)
+			}
+		})
+	}
+}